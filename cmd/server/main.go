@@ -109,6 +109,9 @@ func main() {
 
 	syncSubscribeFilesToDatabase(repo, subscribeDir)
 
+	// 外部订阅拉取使用 ETag/Last-Modified 条件请求
+	handler.SetUpstreamFetchRepository(repo)
+
 	// 启动时初始化代理集合缓存
 	go handler.InitProxyProviderCacheOnStartup(repo)
 
@@ -116,6 +119,22 @@ func main() {
 	proxySyncCtx, stopProxySync := context.WithCancel(context.Background())
 	go handler.StartProxyProviderCacheSync(proxySyncCtx, repo)
 
+	// 启动规则集镜像同步器
+	ruleSetSyncCtx, stopRuleSetSync := context.WithCancel(context.Background())
+	go handler.StartRuleSetMirrorSync(ruleSetSyncCtx, repo, subscribeDir, ruleTemplatesDir, proxyGroupsStore)
+
+	// 启动外部订阅定时同步器（按每个外部订阅的同步间隔）
+	externalSyncCtx, stopExternalSync := context.WithCancel(context.Background())
+	go handler.StartExternalSubscriptionSync(externalSyncCtx, repo, subscribeDir)
+
+	// 启动妙妙屋代理集合节点健康检查（TCP/TLS 握手探测）
+	healthCheckCtx, stopHealthCheck := context.WithCancel(context.Background())
+	go handler.StartProxyProviderHealthCheck(healthCheckCtx, repo)
+
+	// 启动外部订阅告警评估器（到期、流量用量和同步状态）
+	alertCtx, stopAlerts := context.WithCancel(context.Background())
+	go handler.StartAlertEvaluator(alertCtx, repo)
+
 	trafficHandler := handler.NewTrafficSummaryHandler(repo)
 	userRepo := auth.NewRepositoryAdapter(repo)
 	loginRateLimiter := handler.NewLoginRateLimiter()
@@ -134,6 +153,7 @@ func main() {
 	mux.Handle("/api/admin/users/status", auth.RequireAdmin(tokenStore, userRepo, handler.NewUserStatusHandler(repo)))
 	mux.Handle("/api/admin/users/reset-password", auth.RequireAdmin(tokenStore, userRepo, handler.NewUserResetPasswordHandler(repo)))
 	mux.Handle("/api/admin/users/remark", auth.RequireAdmin(tokenStore, userRepo, handler.NewUserRemarkHandler(repo)))
+	mux.Handle("/api/admin/users/quota", auth.RequireAdmin(tokenStore, userRepo, handler.NewUserQuotaHandler(repo)))
 	mux.Handle("/api/admin/users/", auth.RequireAdmin(tokenStore, userRepo, handler.NewUserSubscriptionsHandler(repo)))
 	mux.Handle("/api/admin/subscriptions", auth.RequireAdmin(tokenStore, userRepo, handler.NewSubscriptionAdminHandler(subscribeDir, repo)))
 	mux.Handle("/api/admin/subscriptions/", auth.RequireAdmin(tokenStore, userRepo, handler.NewSubscriptionAdminHandler(subscribeDir, repo)))
@@ -159,10 +179,16 @@ func main() {
 	mux.Handle("/api/admin/templates/fetch-source", auth.RequireAdmin(tokenStore, userRepo, handler.NewTemplateFetchSourceHandler()))
 	mux.Handle("/api/admin/backup/download", auth.RequireAdmin(tokenStore, userRepo, handler.NewBackupDownloadHandler(repo)))
 	mux.Handle("/api/admin/backup/restore", auth.RequireAdmin(tokenStore, userRepo, handler.NewBackupRestoreHandler(repo)))
+	mux.Handle("/api/admin/traffic/export", auth.RequireAdmin(tokenStore, userRepo, handler.NewTrafficExportHandler(repo)))
+	mux.Handle("/api/admin/traffic/import", auth.RequireAdmin(tokenStore, userRepo, handler.NewTrafficImportHandler(repo)))
+	mux.Handle("/api/admin/traffic/collector", auth.RequireAdmin(tokenStore, userRepo, handler.NewTrafficCollectorHandler(repo)))
 	mux.Handle("/api/admin/update/check", auth.RequireAdmin(tokenStore, userRepo, handler.NewUpdateCheckHandler()))
 	mux.Handle("/api/admin/update/apply", auth.RequireAdmin(tokenStore, userRepo, handler.NewUpdateApplyHandler()))
 	mux.Handle("/api/admin/update/apply-sse", auth.RequireAdmin(tokenStore, userRepo, handler.NewUpdateApplySSEHandler()))
 	mux.Handle("/api/admin/proxy-groups/sync", auth.RequireAdmin(tokenStore, userRepo, handler.NewProxyGroupsSyncHandler(repo, proxyGroupsStore)))
+	mux.Handle("/api/admin/rule-set-mirrors", auth.RequireAdmin(tokenStore, userRepo, handler.NewRuleSetMirrorAdminHandler(repo)))
+	mux.Handle("/api/admin/rule-set-mirrors/", auth.RequireAdmin(tokenStore, userRepo, handler.NewRuleSetMirrorAdminHandler(repo)))
+	mux.Handle("/api/admin/alerts/", auth.RequireAdmin(tokenStore, userRepo, handler.NewAlertAdminHandler(repo)))
 
 	// TCPing endpoint (admin only)
 	mux.Handle("/api/admin/tcping", auth.RequireAdmin(tokenStore, userRepo, handler.NewTCPingHandler()))
@@ -178,16 +204,22 @@ func main() {
 	mux.Handle("/api/user/external-subscriptions", auth.RequireToken(tokenStore, handler.NewExternalSubscriptionsHandler(repo)))
 	mux.Handle("/api/user/external-subscriptions/nodes", auth.RequireToken(tokenStore, handler.NewExternalSubscriptionNodesHandler(repo)))
 	mux.Handle("/api/user/external-subscriptions/check-filter", auth.RequireToken(tokenStore, handler.NewExternalSubscriptionCheckFilterHandler(repo)))
+	mux.Handle("/api/user/node-transforms/preview", auth.RequireToken(tokenStore, handler.NewNodeTransformPreviewHandler(repo)))
+	mux.Handle("/api/user/external-subscriptions/changes", auth.RequireToken(tokenStore, handler.NewExternalSubscriptionChangesHandler(repo)))
 	mux.Handle("/api/user/proxy-provider-configs", auth.RequireToken(tokenStore, handler.NewProxyProviderConfigsHandler(repo)))
 	mux.Handle("/api/user/proxy-provider-cache/refresh", auth.RequireToken(tokenStore, handler.NewProxyProviderCacheRefreshHandler(repo)))
 	mux.Handle("/api/user/proxy-provider-cache/status", auth.RequireToken(tokenStore, handler.NewProxyProviderCacheStatusHandler(repo)))
 	mux.Handle("/api/user/proxy-provider-nodes", auth.RequireToken(tokenStore, handler.NewProxyProviderNodesHandler(repo)))
+	mux.Handle("/api/user/proxy-provider-health", auth.RequireToken(tokenStore, handler.NewProxyProviderHealthHandler(repo)))
 	mux.Handle("/api/proxy-provider/", handler.NewProxyProviderServeHandler(repo))
+	mux.Handle("/api/rule-set/", handler.NewRuleSetServeHandler(repo))
+	mux.Handle("/api/rule-set/convert", handler.NewRuleSetConvertHandler(repo))
 
 	// Debug日志相关endpoint
 	mux.Handle("/api/user/debug/", auth.RequireToken(tokenStore, handler.NewDebugHandler(repo)))
 
 	mux.Handle("/api/traffic/summary", auth.RequireToken(tokenStore, trafficHandler))
+	mux.Handle("/api/traffic/history", auth.RequireToken(tokenStore, handler.NewTrafficHistoryHandler(repo)))
 	mux.Handle("/api/subscriptions", auth.RequireToken(tokenStore, handler.NewSubscriptionListHandler(repo)))
 	mux.Handle("/api/dns/resolve", auth.RequireToken(tokenStore, handler.NewDNSHandler()))
 	mux.Handle("/api/subscribe-files", auth.RequireToken(tokenStore, handler.NewSubscribeFilesListHandler(repo)))
@@ -238,7 +270,7 @@ func main() {
 	}
 
 	collectorCtx, stopCollector := context.WithCancel(context.Background())
-	go startTrafficCollector(collectorCtx, trafficHandler)
+	go handler.StartTrafficCollector(collectorCtx, trafficHandler, repo)
 
 	go func() {
 		logger.Info("HTTP服务器启动", "version", version.Version, "address", addr)
@@ -248,7 +280,7 @@ func main() {
 		}
 	}()
 
-	waitForShutdown(srv, stopCollector, stopProxySync)
+	waitForShutdown(srv, stopCollector, stopProxySync, stopRuleSetSync, stopExternalSync, stopHealthCheck, stopAlerts)
 }
 
 func getAddr() string {
@@ -293,69 +325,6 @@ func waitForShutdown(srv *http.Server, cancels ...context.CancelFunc) {
 	}
 }
 
-func startTrafficCollector(ctx context.Context, trafficHandler *handler.TrafficSummaryHandler) {
-	if trafficHandler == nil {
-		return
-	}
-
-	// 带重试的流量收集函数
-	runWithRetry := func() {
-		logger.Info("[流量收集器] 开始每日流量收集", "start_time", time.Now().Format("2006-01-02 15:04:05"))
-
-		maxRetries := 3
-		retryDelay := 30 * time.Second
-
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			runCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-			err := trafficHandler.RecordDailyUsage(runCtx)
-			cancel()
-
-			if err == nil {
-				logger.Info("[流量收集器] 每日流量收集成功")
-				return
-			}
-
-			logger.Warn("[流量收集器] 每日流量收集失败", "attempt", attempt, "max_retries", maxRetries, "error", err)
-
-			// 如果是探针配置未找到错误，不需要重试
-			if errors.Is(err, storage.ErrProbeConfigNotFound) {
-				logger.Info("[流量收集器] 探针未配置，跳过重试")
-				return
-			}
-
-			if attempt < maxRetries {
-				logger.Info("[流量收集器] 准备重试", "delay", retryDelay)
-				select {
-				case <-ctx.Done():
-					logger.Info("[流量收集器] 重试已取消（服务器关闭）")
-					return
-				case <-time.After(retryDelay):
-					// 继续重试
-				}
-			}
-		}
-
-		logger.Error("[流量收集器] 达到最大重试次数后仍失败", "max_retries", maxRetries)
-	}
-
-	runWithRetry()
-
-	ticker := time.NewTicker(24 * time.Hour)
-	defer ticker.Stop()
-
-	logger.Info("[流量收集器] 定时调度器已启动", "interval", "24小时")
-
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("[流量收集器] 定时调度器已停止")
-			return
-		case <-ticker.C:
-			runWithRetry()
-		}
-	}
-}
-
 // syncSubscribeFilesToDatabase scans the subscribes directory and ensures
 // every YAML file has a corresponding record in the subscribe_files table.
 // This helps with backward compatibility when upgrading from older versions.
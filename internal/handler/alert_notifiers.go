@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"miaomiaowu/internal/storage"
+)
+
+// 告警通知渠道：webhook、Telegram Bot API 和 SMTP
+// 每种渠道的地址均可配置，便于指向本地测试桩或自建的 Bot API 服务
+
+const (
+	// 默认 Telegram Bot API 地址
+	defaultTelegramAPIBaseURL = "https://api.telegram.org"
+	// 单次通知发送超时时间
+	alertNotifyTimeout = 15 * time.Second
+	// 返回给前端时敏感配置项的占位符
+	alertSecretMask = "******"
+)
+
+// alertSecretKeys 通知渠道配置中的敏感项，列表接口中不返回明文
+var alertSecretKeys = []string{"password", "bot_token", "authorization"}
+
+// alertMessage 一条待发送的告警
+type alertMessage struct {
+	Title            string    `json:"title"`
+	Message          string    `json:"message"`
+	RuleID           int64     `json:"rule_id"`
+	RuleName         string    `json:"rule_name"`
+	RuleType         string    `json:"rule_type"`
+	SubscriptionID   int64     `json:"subscription_id"`
+	SubscriptionName string    `json:"subscription_name"`
+	Username         string    `json:"username"`
+	DedupeKey        string    `json:"dedupe_key"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// alertNotifier 告警通知渠道
+type alertNotifier interface {
+	Notify(ctx context.Context, msg alertMessage) error
+}
+
+// newAlertNotifier 根据配置创建通知渠道
+func newAlertNotifier(n storage.AlertNotifier, client *http.Client) (alertNotifier, error) {
+	switch n.Type {
+	case storage.AlertNotifierWebhook:
+		if err := validateProxyGroupsSourceURL(n.BaseURL); err != nil {
+			return nil, errors.New("webhook url must be an http or https address")
+		}
+		return &webhookAlertNotifier{url: n.BaseURL, authorization: n.Config["authorization"], client: client}, nil
+	case storage.AlertNotifierTelegram:
+		baseURL := strings.TrimRight(n.BaseURL, "/")
+		if baseURL == "" {
+			baseURL = defaultTelegramAPIBaseURL
+		} else if err := validateProxyGroupsSourceURL(baseURL); err != nil {
+			return nil, errors.New("telegram base url must be an http or https address")
+		}
+		token := strings.TrimSpace(n.Config["bot_token"])
+		chatID := strings.TrimSpace(n.Config["chat_id"])
+		if token == "" || chatID == "" {
+			return nil, errors.New("telegram notifier requires bot_token and chat_id")
+		}
+		return &telegramAlertNotifier{baseURL: baseURL, token: token, chatID: chatID, client: client}, nil
+	case storage.AlertNotifierSMTP:
+		return newSMTPAlertNotifier(n)
+	default:
+		return nil, fmt.Errorf("unsupported notifier type %q", n.Type)
+	}
+}
+
+// webhookAlertNotifier 以 JSON POST 告警内容
+type webhookAlertNotifier struct {
+	url           string
+	authorization string // 可选的 Authorization 请求头
+	client        *http.Client
+}
+
+func (n *webhookAlertNotifier) Notify(ctx context.Context, msg alertMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.authorization != "" {
+		req.Header.Set("Authorization", n.authorization)
+	}
+	return doAlertRequest(n.client, req)
+}
+
+// telegramAlertNotifier 通过 Bot API 的 sendMessage 发送告警
+type telegramAlertNotifier struct {
+	baseURL string
+	token   string
+	chatID  string
+	client  *http.Client
+}
+
+func (n *telegramAlertNotifier) Notify(ctx context.Context, msg alertMessage) error {
+	body, err := json.Marshal(map[string]string{
+		"chat_id": n.chatID,
+		"text":    msg.Title + "\n" + msg.Message,
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", n.baseURL, n.token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doAlertRequest(n.client, req)
+}
+
+// doAlertRequest 发送请求，非 2xx 响应视为失败
+func doAlertRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// smtpAlertNotifier 通过 SMTP 发送告警邮件
+// base_url 形如 smtp://host:587（支持 STARTTLS）或 smtps://host:465（隐式 TLS）
+type smtpAlertNotifier struct {
+	host     string
+	addr     string
+	implicit bool
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func newSMTPAlertNotifier(n storage.AlertNotifier) (*smtpAlertNotifier, error) {
+	raw := n.BaseURL
+	if !strings.Contains(raw, "://") {
+		raw = "smtp://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address: %w", err)
+	}
+	if u.Scheme != "smtp" && u.Scheme != "smtps" {
+		return nil, fmt.Errorf("unsupported smtp scheme %q", u.Scheme)
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "25"
+		if u.Scheme == "smtps" {
+			port = "465"
+		}
+	}
+
+	var to []string
+	for _, addr := range strings.Split(n.Config["to"], ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	from := strings.TrimSpace(n.Config["from"])
+	if host == "" || from == "" || len(to) == 0 {
+		return nil, errors.New("smtp notifier requires host, from and to")
+	}
+
+	return &smtpAlertNotifier{
+		host:     host,
+		addr:     net.JoinHostPort(host, port),
+		implicit: u.Scheme == "smtps",
+		username: n.Config["username"],
+		password: n.Config["password"],
+		from:     from,
+		to:       to,
+	}, nil
+}
+
+func (n *smtpAlertNotifier) Notify(ctx context.Context, msg alertMessage) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", n.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Title))
+	fmt.Fprintf(&buf, "Date: %s\r\n", msg.CreatedAt.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(msg.Message)
+	buf.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	dialer := &net.Dialer{Timeout: alertNotifyTimeout}
+	var conn net.Conn
+	var err error
+	if n.implicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", n.addr, &tls.Config{ServerName: n.host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", n.addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !n.implicit {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, addr := range n.to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// maskAlertNotifierConfig 返回隐藏敏感项后的配置副本
+func maskAlertNotifierConfig(config map[string]string) map[string]string {
+	masked := make(map[string]string, len(config))
+	for k, v := range config {
+		masked[k] = v
+	}
+	for _, key := range alertSecretKeys {
+		if masked[key] != "" {
+			masked[key] = alertSecretMask
+		}
+	}
+	return masked
+}
+
+// mergeAlertNotifierSecrets 更新时若敏感项仍为占位符，保留原有值
+func mergeAlertNotifierSecrets(config, existing map[string]string) map[string]string {
+	for _, key := range alertSecretKeys {
+		if config[key] == alertSecretMask {
+			config[key] = existing[key]
+		}
+	}
+	return config
+}
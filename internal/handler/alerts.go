@@ -0,0 +1,601 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 外部订阅告警相关常量
+const (
+	// 扫描周期：每10分钟评估一次告警规则
+	alertScanInterval = 10 * time.Minute
+	// 单次评估超时时间（包含发送通知）
+	alertEvaluateTimeout = 2 * time.Minute
+)
+
+// alertEvaluator 外部订阅告警评估器
+// 按告警规则检查外部订阅的到期时间、流量用量和同步状态，命中后去重并发送通知
+type alertEvaluator struct {
+	repo   *storage.TrafficRepository
+	client *http.Client
+}
+
+// StartAlertEvaluator 启动外部订阅告警评估
+// 该函数会阻塞，直到context被取消
+func StartAlertEvaluator(ctx context.Context, repo *storage.TrafficRepository) {
+	if repo == nil {
+		return
+	}
+
+	e := newAlertEvaluator(repo)
+	logger.Info("[订阅告警] 评估器启动", "scan_interval", alertScanInterval.String())
+	defer logger.Info("[订阅告警] 评估器已退出")
+
+	ticker := time.NewTicker(alertScanInterval)
+	defer ticker.Stop()
+
+	e.runOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.runOnce(ctx)
+		}
+	}
+}
+
+func newAlertEvaluator(repo *storage.TrafficRepository) *alertEvaluator {
+	return &alertEvaluator{
+		repo:   repo,
+		client: &http.Client{Timeout: alertNotifyTimeout},
+	}
+}
+
+func (e *alertEvaluator) runOnce(ctx context.Context) {
+	runCtx, cancel := context.WithTimeout(ctx, alertEvaluateTimeout)
+	defer cancel()
+
+	raised, err := e.evaluate(runCtx, time.Now())
+	if err != nil {
+		logger.Warn("[订阅告警] 评估失败", "error", err)
+		return
+	}
+	if raised > 0 {
+		logger.Info("[订阅告警] 评估完成", "raised", raised)
+	}
+}
+
+// evaluate 评估所有启用的规则，返回新产生的告警数量
+func (e *alertEvaluator) evaluate(ctx context.Context, now time.Time) (int, error) {
+	rules, err := e.repo.ListAlertRules(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var enabledRules []storage.AlertRule
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabledRules = append(enabledRules, rule)
+		}
+	}
+	if len(enabledRules) == 0 {
+		return 0, nil
+	}
+
+	subs, err := e.repo.ListAllExternalSubscriptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	notifiers, err := e.repo.ListAlertNotifiers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	raised := 0
+	var activeKeys []string
+	for _, rule := range enabledRules {
+		for _, sub := range subs {
+			if rule.SubscriptionID != 0 && rule.SubscriptionID != sub.ID {
+				continue
+			}
+			msg, ok := evaluateAlertRule(rule, sub, now)
+			if !ok {
+				continue
+			}
+			activeKeys = append(activeKeys, msg.DedupeKey)
+
+			id, claimed, err := e.repo.ClaimAlertEvent(ctx, storage.AlertEvent{
+				RuleID:           rule.ID,
+				RuleName:         rule.Name,
+				RuleType:         rule.Type,
+				SubscriptionID:   sub.ID,
+				SubscriptionName: sub.Name,
+				Username:         sub.Username,
+				DedupeKey:        msg.DedupeKey,
+				Message:          msg.Message,
+			}, now)
+			if err != nil {
+				logger.Warn("[订阅告警] 记录告警失败", "rule", rule.Name, "subscription", sub.Name, "error", err)
+			}
+			if !claimed {
+				continue
+			}
+
+			raised++
+			status, errMsg := e.deliver(ctx, rule, notifiers, msg)
+			if err := e.repo.RecordAlertEventResult(ctx, id, status, errMsg, now); err != nil {
+				logger.Warn("[订阅告警] 保存发送结果失败", "rule", rule.Name, "subscription", sub.Name, "error", err)
+			}
+			logger.Info("[订阅告警] 已触发告警", "rule", rule.Name, "subscription", sub.Name, "status", status, "error", errMsg)
+		}
+	}
+
+	// 只保留最近的告警记录；仍在持续的条件保留其记录，避免去重键被删后重复告警
+	if err := e.repo.PruneAlertEvents(ctx, activeKeys); err != nil {
+		logger.Warn("[订阅告警] 清理告警记录失败", "error", err)
+	}
+	return raised, nil
+}
+
+// deliver 将告警发送到规则指定的通知渠道（未指定时使用所有启用的渠道）
+func (e *alertEvaluator) deliver(ctx context.Context, rule storage.AlertRule, notifiers []storage.AlertNotifier, msg alertMessage) (string, string) {
+	selected := make(map[int64]bool, len(rule.NotifierIDs))
+	for _, id := range rule.NotifierIDs {
+		selected[id] = true
+	}
+
+	var errs []string
+	sent := 0
+	for _, n := range notifiers {
+		if !n.Enabled || (len(selected) > 0 && !selected[n.ID]) {
+			continue
+		}
+		if err := e.send(ctx, n, msg); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", n.Name, err))
+			continue
+		}
+		sent++
+	}
+
+	switch {
+	case sent == 0 && len(errs) == 0:
+		return storage.AlertStatusFailed, "no enabled notifier"
+	case sent == 0:
+		return storage.AlertStatusFailed, strings.Join(errs, "; ")
+	case len(errs) > 0:
+		return storage.AlertStatusPartial, strings.Join(errs, "; ")
+	default:
+		return storage.AlertStatusSent, ""
+	}
+}
+
+func (e *alertEvaluator) send(ctx context.Context, n storage.AlertNotifier, msg alertMessage) error {
+	notifier, err := newAlertNotifier(n, e.client)
+	if err != nil {
+		return err
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, alertNotifyTimeout)
+	defer cancel()
+	return notifier.Notify(sendCtx, msg)
+}
+
+// evaluateAlertRule 判断外部订阅是否命中告警规则
+// 去重键包含条件所处的周期：到期时间变化（续费）、流量总量变化、同步恢复后都会重新告警
+func evaluateAlertRule(rule storage.AlertRule, sub storage.ExternalSubscription, now time.Time) (alertMessage, bool) {
+	msg := alertMessage{
+		RuleID:           rule.ID,
+		RuleName:         rule.Name,
+		RuleType:         rule.Type,
+		SubscriptionID:   sub.ID,
+		SubscriptionName: sub.Name,
+		Username:         sub.Username,
+		CreatedAt:        now,
+	}
+	keyPrefix := fmt.Sprintf("rule:%d:sub:%d:%s", rule.ID, sub.ID, rule.Type)
+
+	switch rule.Type {
+	case storage.AlertRuleExpiry:
+		if sub.Expire == nil {
+			return msg, false
+		}
+		remaining := sub.Expire.Sub(now)
+		if remaining > time.Duration(rule.Threshold*float64(24*time.Hour)) {
+			return msg, false
+		}
+		msg.DedupeKey = fmt.Sprintf("%s:%d", keyPrefix, sub.Expire.Unix())
+		msg.Title = fmt.Sprintf("外部订阅「%s」即将到期", sub.Name)
+		if remaining <= 0 {
+			msg.Title = fmt.Sprintf("外部订阅「%s」已到期", sub.Name)
+		}
+		msg.Message = fmt.Sprintf("订阅「%s」（用户 %s）到期时间 %s，剩余 %.1f 天",
+			sub.Name, sub.Username, sub.Expire.Format("2006-01-02 15:04:05"), max(remaining.Hours()/24, 0))
+
+	case storage.AlertRuleQuota:
+		if sub.Total <= 0 {
+			return msg, false
+		}
+		used := externalSubscriptionUsedTraffic(sub)
+		percent := float64(used) / float64(sub.Total) * 100
+		if percent < rule.Threshold {
+			return msg, false
+		}
+		var expire int64
+		if sub.Expire != nil {
+			expire = sub.Expire.Unix()
+		}
+		msg.DedupeKey = fmt.Sprintf("%s:%d:%d", keyPrefix, sub.Total, expire)
+		msg.Title = fmt.Sprintf("外部订阅「%s」流量已用 %.1f%%", sub.Name, percent)
+		msg.Message = fmt.Sprintf("订阅「%s」（用户 %s）已用 %.2f GB / %.2f GB（%.1f%%），告警阈值 %.1f%%",
+			sub.Name, sub.Username, bytesToGB(used), bytesToGB(sub.Total), percent, rule.Threshold)
+
+	case storage.AlertRuleSyncStale:
+		last := sub.CreatedAt
+		if sub.LastSyncAt != nil {
+			last = *sub.LastSyncAt
+		}
+		if now.Sub(last) < time.Duration(rule.Threshold*float64(time.Hour)) {
+			return msg, false
+		}
+		msg.DedupeKey = fmt.Sprintf("%s:%d", keyPrefix, last.Unix())
+		msg.Title = fmt.Sprintf("外部订阅「%s」长时间未同步成功", sub.Name)
+		msg.Message = fmt.Sprintf("订阅「%s」（用户 %s）最近一次成功同步于 %s，已超过 %.0f 小时",
+			sub.Name, sub.Username, last.Format("2006-01-02 15:04:05"), rule.Threshold)
+		if sub.LastSyncError != "" {
+			msg.Message += "，最近错误：" + sub.LastSyncError
+		}
+
+	default:
+		return msg, false
+	}
+	return msg, true
+}
+
+// externalSubscriptionUsedTraffic 按流量统计方式计算外部订阅已用流量
+func externalSubscriptionUsedTraffic(sub storage.ExternalSubscription) int64 {
+	switch sub.TrafficMode {
+	case "download":
+		return sub.Download
+	case "upload":
+		return sub.Upload
+	default: // "both" 或空
+		return sub.Upload + sub.Download
+	}
+}
+
+func bytesToGB(b int64) float64 {
+	return float64(b) / (1024 * 1024 * 1024)
+}
+
+type alertNotifierPayload struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	BaseURL   string            `json:"base_url"`
+	Config    map[string]string `json:"config"`
+	Enabled   bool              `json:"enabled"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type alertRulePayload struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	Type           string    `json:"type"`
+	Threshold      float64   `json:"threshold"`
+	SubscriptionID int64     `json:"subscription_id"`
+	NotifierIDs    []int64   `json:"notifier_ids"`
+	Enabled        bool      `json:"enabled"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type alertEventResponse struct {
+	ID               int64      `json:"id"`
+	RuleID           int64      `json:"rule_id"`
+	RuleName         string     `json:"rule_name"`
+	RuleType         string     `json:"rule_type"`
+	SubscriptionID   int64      `json:"subscription_id"`
+	SubscriptionName string     `json:"subscription_name"`
+	Username         string     `json:"username"`
+	DedupeKey        string     `json:"dedupe_key"`
+	Message          string     `json:"message"`
+	Status           string     `json:"status"`
+	Error            string     `json:"error"`
+	CreatedAt        time.Time  `json:"created_at"`
+	SentAt           *time.Time `json:"sent_at"`
+}
+
+// NewAlertAdminHandler 外部订阅告警管理接口
+// GET/POST        /api/admin/alerts/rules               规则列表 / 新建规则
+// PUT/DELETE      /api/admin/alerts/rules/{id}          更新 / 删除规则
+// GET/POST        /api/admin/alerts/notifiers           通知渠道列表 / 新建渠道
+// PUT/DELETE      /api/admin/alerts/notifiers/{id}      更新 / 删除渠道
+// POST            /api/admin/alerts/notifiers/{id}/test 发送测试通知
+// GET             /api/admin/alerts/history[?limit=100] 告警历史
+// POST            /api/admin/alerts/evaluate            立即评估规则
+func NewAlertAdminHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("alert admin handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/alerts"), "/"), "/")
+
+		switch {
+		case parts[0] == "rules":
+			handleAlertRules(w, r, repo, parts[1:])
+		case parts[0] == "notifiers":
+			handleAlertNotifiers(w, r, repo, parts[1:])
+		case parts[0] == "history" && len(parts) == 1 && r.Method == http.MethodGet:
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			if limit <= 0 {
+				limit = 100
+			}
+			events, err := repo.ListAlertEvents(r.Context(), limit)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			items := make([]alertEventResponse, 0, len(events))
+			for _, e := range events {
+				items = append(items, alertEventResponse(e))
+			}
+			respondJSON(w, http.StatusOK, map[string]any{"events": items})
+		case parts[0] == "evaluate" && len(parts) == 1 && r.Method == http.MethodPost:
+			raised, err := newAlertEvaluator(repo).evaluate(r.Context(), time.Now())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]any{"raised": raised})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+}
+
+func handleAlertRules(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			rules, err := repo.ListAlertRules(r.Context())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			items := make([]alertRulePayload, 0, len(rules))
+			for _, rule := range rules {
+				items = append(items, toAlertRulePayload(rule))
+			}
+			respondJSON(w, http.StatusOK, map[string]any{"rules": items})
+		case http.MethodPost:
+			var payload alertRulePayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			rule := storage.AlertRule{
+				Name:           payload.Name,
+				Type:           payload.Type,
+				Threshold:      payload.Threshold,
+				SubscriptionID: payload.SubscriptionID,
+				NotifierIDs:    payload.NotifierIDs,
+				Enabled:        payload.Enabled,
+			}
+			id, err := repo.CreateAlertRule(r.Context(), rule)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			respondAlertRule(w, r, repo, id, http.StatusCreated)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 1 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid alert rule id"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var payload alertRulePayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		rule := storage.AlertRule{
+			ID:             id,
+			Name:           payload.Name,
+			Type:           payload.Type,
+			Threshold:      payload.Threshold,
+			SubscriptionID: payload.SubscriptionID,
+			NotifierIDs:    payload.NotifierIDs,
+			Enabled:        payload.Enabled,
+		}
+		if err := repo.UpdateAlertRule(r.Context(), rule); err != nil {
+			writeAlertError(w, err, storage.ErrAlertRuleNotFound)
+			return
+		}
+		respondAlertRule(w, r, repo, id, http.StatusOK)
+	case http.MethodDelete:
+		if err := repo.DeleteAlertRule(r.Context(), id); err != nil {
+			writeAlertError(w, err, storage.ErrAlertRuleNotFound)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"message": "已删除"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func handleAlertNotifiers(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			notifiers, err := repo.ListAlertNotifiers(r.Context())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			items := make([]alertNotifierPayload, 0, len(notifiers))
+			for _, n := range notifiers {
+				items = append(items, toAlertNotifierPayload(n))
+			}
+			respondJSON(w, http.StatusOK, map[string]any{"notifiers": items})
+		case http.MethodPost:
+			var payload alertNotifierPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			n := storage.AlertNotifier{
+				Name:    payload.Name,
+				Type:    payload.Type,
+				BaseURL: payload.BaseURL,
+				Config:  payload.Config,
+				Enabled: payload.Enabled,
+			}
+			if _, err := newAlertNotifier(n, nil); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			id, err := repo.CreateAlertNotifier(r.Context(), n)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			respondAlertNotifier(w, r, repo, id, http.StatusCreated)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid alert notifier id"))
+		return
+	}
+	existing, err := repo.GetAlertNotifier(r.Context(), id)
+	if err != nil {
+		writeAlertError(w, err, storage.ErrAlertNotifierNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "test" && r.Method == http.MethodPost:
+		e := newAlertEvaluator(repo)
+		msg := alertMessage{
+			Title:     "妙妙屋告警测试",
+			Message:   fmt.Sprintf("通知渠道「%s」配置正常", existing.Name),
+			DedupeKey: fmt.Sprintf("test:%d:%d", existing.ID, time.Now().Unix()),
+			CreatedAt: time.Now(),
+		}
+		if err := e.send(r.Context(), existing, msg); err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"message": "测试通知已发送"})
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		var payload alertNotifierPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if payload.Config == nil {
+			payload.Config = map[string]string{}
+		}
+		n := storage.AlertNotifier{
+			ID:      id,
+			Name:    payload.Name,
+			Type:    payload.Type,
+			BaseURL: payload.BaseURL,
+			Config:  mergeAlertNotifierSecrets(payload.Config, existing.Config),
+			Enabled: payload.Enabled,
+		}
+		if _, err := newAlertNotifier(n, nil); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := repo.UpdateAlertNotifier(r.Context(), n); err != nil {
+			writeAlertError(w, err, storage.ErrAlertNotifierNotFound)
+			return
+		}
+		respondAlertNotifier(w, r, repo, id, http.StatusOK)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if err := repo.DeleteAlertNotifier(r.Context(), id); err != nil {
+			writeAlertError(w, err, storage.ErrAlertNotifierNotFound)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"message": "已删除"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func respondAlertRule(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository, id int64, status int) {
+	rule, err := repo.GetAlertRule(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondJSON(w, status, toAlertRulePayload(rule))
+}
+
+func respondAlertNotifier(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository, id int64, status int) {
+	n, err := repo.GetAlertNotifier(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondJSON(w, status, toAlertNotifierPayload(n))
+}
+
+// writeAlertError 记录不存在时返回404，其他错误视为参数错误
+func writeAlertError(w http.ResponseWriter, err, notFound error) {
+	if errors.Is(err, notFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+func toAlertRulePayload(rule storage.AlertRule) alertRulePayload {
+	return alertRulePayload{
+		ID:             rule.ID,
+		Name:           rule.Name,
+		Type:           rule.Type,
+		Threshold:      rule.Threshold,
+		SubscriptionID: rule.SubscriptionID,
+		NotifierIDs:    rule.NotifierIDs,
+		Enabled:        rule.Enabled,
+		CreatedAt:      rule.CreatedAt,
+		UpdatedAt:      rule.UpdatedAt,
+	}
+}
+
+func toAlertNotifierPayload(n storage.AlertNotifier) alertNotifierPayload {
+	return alertNotifierPayload{
+		ID:        n.ID,
+		Name:      n.Name,
+		Type:      n.Type,
+		BaseURL:   n.BaseURL,
+		Config:    maskAlertNotifierConfig(n.Config),
+		Enabled:   n.Enabled,
+		CreatedAt: n.CreatedAt,
+		UpdatedAt: n.UpdatedAt,
+	}
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"miaomiaowu/internal/storage"
+)
+
+// alertStub 记录收到的通知请求，按 status 返回响应
+type alertStub struct {
+	mu       sync.Mutex
+	status   int
+	requests []alertStubRequest
+}
+
+type alertStubRequest struct {
+	Path          string
+	Authorization string
+	Body          map[string]any
+}
+
+func newAlertStub(t *testing.T) (*alertStub, *httptest.Server) {
+	t.Helper()
+	stub := &alertStub{status: http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("decode notification body %q: %v", data, err)
+		}
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.requests = append(stub.requests, alertStubRequest{Path: r.URL.Path, Authorization: r.Header.Get("Authorization"), Body: body})
+		w.WriteHeader(stub.status)
+	}))
+	t.Cleanup(srv.Close)
+	return stub, srv
+}
+
+func (s *alertStub) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *alertStub) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func TestAlertNotifierPayloads(t *testing.T) {
+	msg := alertMessage{
+		Title:            "外部订阅「airport」流量已用 95.0%",
+		Message:          "订阅「airport」已用 95.00 GB / 100.00 GB",
+		RuleID:           1,
+		RuleName:         "quota",
+		RuleType:         storage.AlertRuleQuota,
+		SubscriptionID:   2,
+		SubscriptionName: "airport",
+		Username:         "alice",
+		DedupeKey:        "rule:1:sub:2:quota:100:0",
+		CreatedAt:        time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name      string
+		notifier  storage.AlertNotifier
+		path      string // 相对测试桩地址的请求路径
+		status    int
+		wantAuth  string
+		wantBody  map[string]any
+		wantError bool
+	}{
+		{
+			name:     "webhook",
+			notifier: storage.AlertNotifier{Type: storage.AlertNotifierWebhook, Config: map[string]string{"authorization": "Bearer secret"}},
+			path:     "/hook",
+			status:   http.StatusNoContent,
+			wantAuth: "Bearer secret",
+			wantBody: map[string]any{
+				"title":             msg.Title,
+				"message":           msg.Message,
+				"rule_id":           float64(1),
+				"rule_name":         "quota",
+				"rule_type":         storage.AlertRuleQuota,
+				"subscription_id":   float64(2),
+				"subscription_name": "airport",
+				"username":          "alice",
+				"dedupe_key":        msg.DedupeKey,
+				"created_at":        "2026-05-10T12:00:00Z",
+			},
+		},
+		{
+			name:     "telegram",
+			notifier: storage.AlertNotifier{Type: storage.AlertNotifierTelegram, Config: map[string]string{"bot_token": "123:abc", "chat_id": "-100"}},
+			path:     "/bot123:abc/sendMessage",
+			status:   http.StatusOK,
+			wantBody: map[string]any{"chat_id": "-100", "text": msg.Title + "\n" + msg.Message},
+		},
+		{
+			name:      "non-2xx response",
+			notifier:  storage.AlertNotifier{Type: storage.AlertNotifierWebhook},
+			path:      "/hook",
+			status:    http.StatusBadGateway,
+			wantBody:  map[string]any{},
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, srv := newAlertStub(t)
+			stub.setStatus(tt.status)
+			n := tt.notifier
+			n.BaseURL = srv.URL
+			if n.Type == storage.AlertNotifierWebhook {
+				n.BaseURL += tt.path
+			}
+
+			notifier, err := newAlertNotifier(n, srv.Client())
+			if err != nil {
+				t.Fatalf("newAlertNotifier: %v", err)
+			}
+			err = notifier.Notify(context.Background(), msg)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Notify() error = %v, want error %v", err, tt.wantError)
+			}
+			if stub.count() != 1 {
+				t.Fatalf("requests = %d, want 1", stub.count())
+			}
+			req := stub.requests[0]
+			if req.Path != tt.path || req.Authorization != tt.wantAuth {
+				t.Fatalf("request path=%q auth=%q, want path=%q auth=%q", req.Path, req.Authorization, tt.path, tt.wantAuth)
+			}
+			for key, want := range tt.wantBody {
+				if got := req.Body[key]; got != want {
+					t.Errorf("body[%q] = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateAlertRuleQuotaDedupeKey(t *testing.T) {
+	rule := storage.AlertRule{ID: 1, Name: "quota", Type: storage.AlertRuleQuota, Threshold: 90}
+	expire := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	sub := storage.ExternalSubscription{ID: 2, Name: "airport", Upload: 50, Download: 45, Total: 100, Expire: &expire}
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	base, ok := evaluateAlertRule(rule, sub, now)
+	if !ok {
+		t.Fatal("quota rule not triggered at 95%")
+	}
+	tests := []struct {
+		name     string
+		sub      storage.ExternalSubscription
+		now      time.Time
+		wantSame bool
+	}{
+		{"next month", sub, now.AddDate(0, 1, 0), true},
+		{"total changed", func() storage.ExternalSubscription { s := sub; s.Total = 90; return s }(), now, false},
+		{"renewed", func() storage.ExternalSubscription { s := sub; e := expire.AddDate(1, 0, 0); s.Expire = &e; return s }(), now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, ok := evaluateAlertRule(rule, tt.sub, tt.now)
+			if !ok {
+				t.Fatal("quota rule not triggered")
+			}
+			if (msg.DedupeKey == base.DedupeKey) != tt.wantSame {
+				t.Fatalf("dedupe key %q vs %q, want same=%v", msg.DedupeKey, base.DedupeKey, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestAlertEvaluatorDedupeAndRetry(t *testing.T) {
+	ctx := context.Background()
+	stub, srv := newAlertStub(t)
+	repo, err := storage.NewTrafficRepository(filepath.Join(t.TempDir(), "traffic.db"))
+	if err != nil {
+		t.Fatalf("NewTrafficRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	if _, err := repo.CreateExternalSubscription(ctx, storage.ExternalSubscription{
+		Username: "alice", Name: "airport", URL: "https://example.com/sub", Upload: 50, Download: 45, Total: 100,
+	}); err != nil {
+		t.Fatalf("CreateExternalSubscription: %v", err)
+	}
+	notifierID, err := repo.CreateAlertNotifier(ctx, storage.AlertNotifier{Name: "hook", Type: storage.AlertNotifierWebhook, BaseURL: srv.URL, Enabled: true})
+	if err != nil {
+		t.Fatalf("CreateAlertNotifier: %v", err)
+	}
+	if _, err := repo.CreateAlertRule(ctx, storage.AlertRule{
+		Name: "quota", Type: storage.AlertRuleQuota, Threshold: 90, NotifierIDs: []int64{notifierID}, Enabled: true,
+	}); err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+
+	e := &alertEvaluator{repo: repo, client: srv.Client()}
+	start := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	// 每一步：是否更改测试桩状态、评估时间、期望新告警数、期望累计请求数和告警状态
+	steps := []struct {
+		name       string
+		status     int
+		at         time.Duration
+		wantRaised int
+		wantCalls  int
+		wantStatus string
+	}{
+		{"first delivery fails", http.StatusInternalServerError, 0, 1, 1, storage.AlertStatusFailed},
+		{"waits for backoff", 0, 4 * time.Minute, 0, 1, storage.AlertStatusFailed},
+		{"retried after 5m", 0, 6 * time.Minute, 1, 2, storage.AlertStatusFailed},
+		{"backoff doubles to 10m", 0, 15 * time.Minute, 0, 2, storage.AlertStatusFailed},
+		{"retry succeeds", http.StatusOK, 17 * time.Minute, 1, 3, storage.AlertStatusSent},
+		{"sent alert not resent", 0, time.Hour, 0, 3, storage.AlertStatusSent},
+		{"not resent next month", 0, 31 * 24 * time.Hour, 0, 3, storage.AlertStatusSent},
+	}
+	for _, step := range steps {
+		if step.status != 0 {
+			stub.setStatus(step.status)
+		}
+		raised, err := e.evaluate(ctx, start.Add(step.at))
+		if err != nil {
+			t.Fatalf("%s: evaluate: %v", step.name, err)
+		}
+		if raised != step.wantRaised || stub.count() != step.wantCalls {
+			t.Fatalf("%s: raised=%d calls=%d, want raised=%d calls=%d", step.name, raised, stub.count(), step.wantRaised, step.wantCalls)
+		}
+		events, err := repo.ListAlertEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("%s: ListAlertEvents: %v", step.name, err)
+		}
+		if len(events) != 1 || events[0].Status != step.wantStatus {
+			t.Fatalf("%s: events = %+v, want one %s event", step.name, events, step.wantStatus)
+		}
+	}
+}
+
+func TestClaimAlertEventLease(t *testing.T) {
+	ctx := context.Background()
+	repo, err := storage.NewTrafficRepository(filepath.Join(t.TempDir(), "traffic.db"))
+	if err != nil {
+		t.Fatalf("NewTrafficRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	event := storage.AlertEvent{RuleID: 1, DedupeKey: "rule:1:sub:1:expiry:0"}
+	start := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	// 认领后未记录结果（进程崩溃），租约到期前不重复认领，到期后重新认领
+	tests := []struct {
+		name string
+		at   time.Duration
+		want bool
+	}{
+		{"first claim", 0, true},
+		{"still leased", 5 * time.Minute, false},
+		{"lease expired", 11 * time.Minute, true},
+		{"renewed lease", 15 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		_, claimed, err := repo.ClaimAlertEvent(ctx, event, start.Add(tt.at))
+		if err != nil {
+			t.Fatalf("%s: ClaimAlertEvent: %v", tt.name, err)
+		}
+		if claimed != tt.want {
+			t.Fatalf("%s: claimed = %v, want %v", tt.name, claimed, tt.want)
+		}
+	}
+}
@@ -11,13 +11,19 @@ import (
 
 	"miaomiaowu/internal/auth"
 	"miaomiaowu/internal/storage"
+	"miaomiaowu/internal/substore"
 )
 
 type externalSubscriptionRequest struct {
-	Name        string `json:"name"`
-	URL         string `json:"url"`
-	UserAgent   string `json:"user_agent"`
-	TrafficMode string `json:"traffic_mode"` // 流量统计方式: "download", "upload", "both"
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	UserAgent    string `json:"user_agent"`
+	TrafficMode  string `json:"traffic_mode"`  // 流量统计方式: "download", "upload", "both"
+	SyncInterval *int   `json:"sync_interval"` // 自动同步间隔（分钟），0 表示关闭；为空时保留现有设置
+
+	upstreamSettingsRequest
+
+	NodeTransforms []substore.NodeOperator `json:"node_transforms"` // 节点处理流水线，为空时保留现有设置，传 [] 清空
 }
 
 type externalSubscriptionResponse struct {
@@ -34,6 +40,63 @@ type externalSubscriptionResponse struct {
 	TrafficMode string  `json:"traffic_mode"` // 流量统计方式: "download", "upload", "both"
 	CreatedAt   string  `json:"created_at"`
 	UpdatedAt   string  `json:"updated_at"`
+
+	SyncInterval      int     `json:"sync_interval"`        // 自动同步间隔（分钟）
+	LastSyncStatus    string  `json:"last_sync_status"`     // 最近一次同步结果: "success", "failed"
+	LastSyncError     string  `json:"last_sync_error"`      // 最近一次同步失败的错误信息
+	LastSyncDelta     int     `json:"last_sync_delta"`      // 最近一次同步的节点数变化
+	LastSyncAttemptAt *string `json:"last_sync_attempt_at"` // 最近一次尝试同步的时间
+
+	UpstreamProxy   string            `json:"upstream_proxy"`   // 拉取订阅使用的出站代理
+	FetchTimeout    int               `json:"fetch_timeout"`    // 拉取超时（秒），0 表示默认
+	CustomHeaders   map[string]string `json:"custom_headers"`   // 额外请求头
+	SkipCertVerify  bool              `json:"skip_cert_verify"` // 跳过 TLS 证书校验
+	CertFingerprint string            `json:"cert_fingerprint"` // 固定的证书 SHA-256 指纹
+
+	NodeTransforms json.RawMessage `json:"node_transforms"` // 节点处理流水线
+}
+
+// newExternalSubscriptionResponse 转换为 API 响应结构
+func newExternalSubscriptionResponse(sub storage.ExternalSubscription) externalSubscriptionResponse {
+	formatTime := func(t *time.Time) *string {
+		if t == nil {
+			return nil
+		}
+		formatted := t.Format(time.RFC3339)
+		return &formatted
+	}
+
+	customHeaders := sub.CustomHeaders
+	if customHeaders == nil {
+		customHeaders = map[string]string{}
+	}
+
+	return externalSubscriptionResponse{
+		ID:                sub.ID,
+		Name:              sub.Name,
+		URL:               sub.URL,
+		UserAgent:         sub.UserAgent,
+		NodeCount:         sub.NodeCount,
+		LastSyncAt:        formatTime(sub.LastSyncAt),
+		Upload:            sub.Upload,
+		Download:          sub.Download,
+		Total:             sub.Total,
+		Expire:            formatTime(sub.Expire),
+		TrafficMode:       sub.TrafficMode,
+		CreatedAt:         sub.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         sub.UpdatedAt.Format(time.RFC3339),
+		SyncInterval:      sub.SyncInterval,
+		LastSyncStatus:    sub.LastSyncStatus,
+		LastSyncError:     sub.LastSyncError,
+		LastSyncDelta:     sub.LastSyncDelta,
+		LastSyncAttemptAt: formatTime(sub.LastSyncAttemptAt),
+		UpstreamProxy:     sub.UpstreamProxy,
+		FetchTimeout:      sub.FetchTimeout,
+		CustomHeaders:     customHeaders,
+		SkipCertVerify:    sub.SkipCertVerify,
+		CertFingerprint:   sub.CertFingerprint,
+		NodeTransforms:    nodeTransformsJSON(sub.NodeTransforms),
+	}
 }
 
 func NewExternalSubscriptionsHandler(repo *storage.TrafficRepository) http.Handler {
@@ -72,33 +135,7 @@ func handleListExternalSubscriptions(w http.ResponseWriter, r *http.Request, rep
 
 	resp := make([]externalSubscriptionResponse, 0, len(subs))
 	for _, sub := range subs {
-		var lastSyncAt *string
-		if sub.LastSyncAt != nil {
-			formatted := sub.LastSyncAt.Format(time.RFC3339)
-			lastSyncAt = &formatted
-		}
-
-		var expire *string
-		if sub.Expire != nil {
-			formatted := sub.Expire.Format(time.RFC3339)
-			expire = &formatted
-		}
-
-		resp = append(resp, externalSubscriptionResponse{
-			ID:          sub.ID,
-			Name:        sub.Name,
-			URL:         sub.URL,
-			UserAgent:   sub.UserAgent,
-			NodeCount:   sub.NodeCount,
-			LastSyncAt:  lastSyncAt,
-			Upload:      sub.Upload,
-			Download:    sub.Download,
-			Total:       sub.Total,
-			Expire:      expire,
-			TrafficMode: sub.TrafficMode,
-			CreatedAt:   sub.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   sub.UpdatedAt.Format(time.RFC3339),
-		})
+		resp = append(resp, newExternalSubscriptionResponse(sub))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -125,6 +162,18 @@ func handleCreateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 		return
 	}
 
+	// 出站设置需要先校验，获取流量信息时也要使用
+	upstream := storage.ExternalSubscription{URL: url}
+	if err := payload.applyTo(&upstream); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	nodeTransforms, err := encodeNodeTransforms(payload.NodeTransforms)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	// Fetch subscription to get traffic info
 	var trafficUpload, trafficDownload, trafficTotal int64
 	var trafficExpire *time.Time
@@ -135,27 +184,15 @@ func handleCreateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 	}
 
 	client := &http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
-	if err != nil {
-		logger.Info("[外部订阅] 创建请求失败", "name", name, "error", err)
+	logger.Info("[外部订阅] 获取流量信息", "name", name, "user_agent", userAgent, "upstream_proxy", upstream.UpstreamProxy)
+	if userInfo, err := fetchUpstreamUserInfo(r.Context(), client, &upstream, userAgent); err != nil {
+		logger.Info("[外部订阅] 请求失败", "error", err)
 	} else {
-		req.Header.Set("User-Agent", userAgent)
-		logger.Info("[外部订阅] 获取流量信息", "name", name, "user_agent", userAgent)
-		resp, err := client.Do(req)
-		if err != nil {
-			logger.Info("[外部订阅] 请求失败", "error", err)
-		} else {
-			defer resp.Body.Close()
-			logger.Info("[外部订阅] 响应状态", "name", name, "status_code", resp.StatusCode)
-			if resp.StatusCode == http.StatusOK {
-				// Parse subscription-userinfo header for traffic info
-				userInfo := resp.Header.Get("subscription-userinfo")
-				logger.Info("[外部订阅] subscription-userinfo头", "name", name, "header", userInfo)
-				if userInfo != "" {
-					trafficUpload, trafficDownload, trafficTotal, trafficExpire = ParseTrafficInfoHeader(userInfo)
-					logger.Info("[外部订阅] 解析流量信息", "upload", trafficUpload, "download", trafficDownload, "total", trafficTotal)
-				}
-			}
+		// Parse subscription-userinfo header for traffic info
+		logger.Info("[外部订阅] subscription-userinfo头", "name", name, "header", userInfo)
+		if userInfo != "" {
+			trafficUpload, trafficDownload, trafficTotal, trafficExpire = ParseTrafficInfoHeader(userInfo)
+			logger.Info("[外部订阅] 解析流量信息", "upload", trafficUpload, "download", trafficDownload, "total", trafficTotal)
 		}
 	}
 
@@ -163,26 +200,26 @@ func handleCreateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 	clashMetaUA := "clash-meta/2.4.0"
 	if trafficTotal == 0 && !strings.Contains(strings.ToLower(userAgent), "clash") {
 		logger.Info("[外部订阅] 未获取到流量信息，尝试使用 clash-meta UA 重新获取", "name", name)
-		retryReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
-		if err == nil {
-			retryReq.Header.Set("User-Agent", clashMetaUA)
-			retryResp, err := client.Do(retryReq)
-			if err == nil {
-				defer retryResp.Body.Close()
-				if retryResp.StatusCode == http.StatusOK {
-					userInfo := retryResp.Header.Get("subscription-userinfo")
-					logger.Info("[外部订阅] clash-meta UA 获取到 subscription-userinfo", "name", name, "header", userInfo)
-					if userInfo != "" {
-						trafficUpload, trafficDownload, trafficTotal, trafficExpire = ParseTrafficInfoHeader(userInfo)
-						logger.Info("[外部订阅] clash-meta UA 解析流量信息成功", "upload", trafficUpload, "download", trafficDownload, "total", trafficTotal)
-					}
-				}
-			} else {
-				logger.Info("[外部订阅] clash-meta UA 请求失败", "error", err)
+		if userInfo, err := fetchUpstreamUserInfo(r.Context(), client, &upstream, clashMetaUA); err != nil {
+			logger.Info("[外部订阅] clash-meta UA 请求失败", "error", err)
+		} else {
+			logger.Info("[外部订阅] clash-meta UA 获取到 subscription-userinfo", "name", name, "header", userInfo)
+			if userInfo != "" {
+				trafficUpload, trafficDownload, trafficTotal, trafficExpire = ParseTrafficInfoHeader(userInfo)
+				logger.Info("[外部订阅] clash-meta UA 解析流量信息成功", "upload", trafficUpload, "download", trafficDownload, "total", trafficTotal)
 			}
 		}
 	}
 
+	syncInterval := 0
+	if payload.SyncInterval != nil {
+		syncInterval = *payload.SyncInterval
+	}
+	if syncInterval < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("sync_interval must not be negative"))
+		return
+	}
+
 	now := time.Now()
 	sub := storage.ExternalSubscription{
 		Username:    username,
@@ -196,6 +233,16 @@ func handleCreateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 		Download:    trafficDownload,
 		Total:       trafficTotal,
 		Expire:      trafficExpire,
+
+		SyncInterval: syncInterval,
+
+		UpstreamProxy:   upstream.UpstreamProxy,
+		FetchTimeout:    upstream.FetchTimeout,
+		CustomHeaders:   upstream.CustomHeaders,
+		SkipCertVerify:  upstream.SkipCertVerify,
+		CertFingerprint: upstream.CertFingerprint,
+
+		NodeTransforms: nodeTransforms,
 	}
 
 	id, err := repo.CreateExternalSubscription(r.Context(), sub)
@@ -214,33 +261,7 @@ func handleCreateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 		return
 	}
 
-	var lastSyncAt *string
-	if created.LastSyncAt != nil {
-		formatted := created.LastSyncAt.Format(time.RFC3339)
-		lastSyncAt = &formatted
-	}
-
-	var expire *string
-	if created.Expire != nil {
-		formatted := created.Expire.Format(time.RFC3339)
-		expire = &formatted
-	}
-
-	resp := externalSubscriptionResponse{
-		ID:          created.ID,
-		Name:        created.Name,
-		URL:         created.URL,
-		UserAgent:   created.UserAgent,
-		NodeCount:   created.NodeCount,
-		LastSyncAt:  lastSyncAt,
-		Upload:      created.Upload,
-		Download:    created.Download,
-		Total:       created.Total,
-		Expire:      expire,
-		TrafficMode: created.TrafficMode,
-		CreatedAt:   created.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:   created.UpdatedAt.Format(time.RFC3339),
-	}
+	resp := newExternalSubscriptionResponse(created)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -294,6 +315,16 @@ func handleUpdateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 		trafficMode = existing.TrafficMode
 	}
 
+	// 如果没有传 SyncInterval，保留现有的
+	syncInterval := existing.SyncInterval
+	if payload.SyncInterval != nil {
+		if *payload.SyncInterval < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("sync_interval must not be negative"))
+			return
+		}
+		syncInterval = *payload.SyncInterval
+	}
+
 	sub := storage.ExternalSubscription{
 		ID:          id,
 		Username:    username,
@@ -307,6 +338,26 @@ func handleUpdateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 		Download:    existing.Download,
 		Total:       existing.Total,
 		Expire:      existing.Expire,
+
+		SyncInterval: syncInterval,
+
+		UpstreamProxy:   existing.UpstreamProxy,
+		FetchTimeout:    existing.FetchTimeout,
+		CustomHeaders:   existing.CustomHeaders,
+		SkipCertVerify:  existing.SkipCertVerify,
+		CertFingerprint: existing.CertFingerprint,
+
+		NodeTransforms: existing.NodeTransforms,
+	}
+	if err := payload.applyTo(&sub); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.NodeTransforms != nil {
+		if sub.NodeTransforms, err = encodeNodeTransforms(payload.NodeTransforms); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 	}
 
 	if err := repo.UpdateExternalSubscription(r.Context(), sub); err != nil {
@@ -318,39 +369,26 @@ func handleUpdateExternalSubscription(w http.ResponseWriter, r *http.Request, re
 		return
 	}
 
+	// 地址或出站设置可能已变化，丢弃按旧设置拉取的订阅内容缓存
+	InvalidateSubscriptionContentCache(existing.URL)
+	InvalidateSubscriptionContentCache(sub.URL)
+
+	// 流水线变化后，基于该订阅的 MMW 代理集合需要重新生成
+	if sub.NodeTransforms != existing.NodeTransforms {
+		if configs, err := repo.ListProxyProviderConfigsBySubscription(r.Context(), id); err == nil {
+			for _, config := range configs {
+				GetProxyProviderCache().Invalidate(config.ID)
+			}
+		}
+	}
+
 	updated, err := repo.GetExternalSubscription(r.Context(), id, username)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	var lastSyncAt *string
-	if updated.LastSyncAt != nil {
-		formatted := updated.LastSyncAt.Format(time.RFC3339)
-		lastSyncAt = &formatted
-	}
-
-	var expire *string
-	if updated.Expire != nil {
-		formatted := updated.Expire.Format(time.RFC3339)
-		expire = &formatted
-	}
-
-	resp := externalSubscriptionResponse{
-		ID:          updated.ID,
-		Name:        updated.Name,
-		URL:         updated.URL,
-		UserAgent:   updated.UserAgent,
-		NodeCount:   updated.NodeCount,
-		LastSyncAt:  lastSyncAt,
-		Upload:      updated.Upload,
-		Download:    updated.Download,
-		Total:       updated.Total,
-		Expire:      expire,
-		TrafficMode: updated.TrafficMode,
-		CreatedAt:   updated.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:   updated.UpdatedAt.Format(time.RFC3339),
-	}
+	resp := newExternalSubscriptionResponse(updated)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
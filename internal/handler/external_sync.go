@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"miaomiaowu/internal/logger"
 	"net/http"
 	"os"
@@ -78,21 +77,13 @@ func syncExternalSubscriptionsManual(ctx context.Context, repo *storage.TrafficR
 
 	for i, sub := range externalSubs {
 		logger.Info("[外部订阅同步-手动] 开始同步订阅", "index", i+1, "total", len(externalSubs), "name", sub.Name)
-		nodeCount, updatedSub, err := syncSingleExternalSubscription(ctx, client, repo, subscribeDir, username, sub, userSettings)
+		nodeCount, _, err := syncAndRecordExternalSubscription(ctx, client, repo, subscribeDir, username, sub, userSettings)
 		if err != nil {
 			logger.Info("[外部订阅同步-手动] 同步订阅失败", "index", i+1, "total", len(externalSubs), "name", sub.Name, "error", err)
 			continue
 		}
 
 		totalNodesSynced += nodeCount
-
-		// Update last sync time and node count
-		now := time.Now()
-		updatedSub.LastSyncAt = &now
-		updatedSub.NodeCount = nodeCount
-		if err := repo.UpdateExternalSubscription(ctx, updatedSub); err != nil {
-			logger.Info("[外部订阅同步-手动] 更新订阅同步时间失败", "name", sub.Name, "error", err)
-		}
 		logger.Info("[外部订阅同步-手动] 订阅同步完成", "index", i+1, "total", len(externalSubs), "name", sub.Name, "node_count", nodeCount)
 	}
 
@@ -188,22 +179,13 @@ func syncExternalSubscriptions(ctx context.Context, repo *storage.TrafficReposit
 
 	for i, sub := range subsToSync {
 		logger.Info("[外部订阅同步-自动] 开始同步订阅", "index", i+1, "total", len(subsToSync), "name", sub.Name)
-		nodeCount, updatedSub, err := syncSingleExternalSubscription(ctx, client, repo, subscribeDir, username, sub, userSettings)
+		nodeCount, _, err := syncAndRecordExternalSubscription(ctx, client, repo, subscribeDir, username, sub, userSettings)
 		if err != nil {
 			logger.Info("[外部订阅同步-自动] 同步订阅失败", "index", i+1, "total", len(subsToSync), "name", sub.Name, "error", err)
 			continue
 		}
 
 		totalNodesSynced += nodeCount
-
-		// Update last sync time and node count
-		// Use updatedSub which contains traffic info from parseAndUpdateTrafficInfo
-		now := time.Now()
-		updatedSub.LastSyncAt = &now
-		updatedSub.NodeCount = nodeCount
-		if err := repo.UpdateExternalSubscription(ctx, updatedSub); err != nil {
-			logger.Info("[外部订阅同步-自动] 更新订阅 的同步时间失败", "name", sub.Name, "error", err)
-		}
 		logger.Info("[外部订阅同步-自动] 订阅同步完成", "index", i+1, "total", len(subsToSync), "name", sub.Name, "node_count", nodeCount)
 	}
 
@@ -260,45 +242,38 @@ func getUsedExternalSubscriptionURLs(ctx context.Context, repo *storage.TrafficR
 }
 
 // syncSingleExternalSubscription fetches and syncs nodes from a single external subscription
-// Returns: node count, updated subscription info, error
-func syncSingleExternalSubscription(ctx context.Context, client *http.Client, repo *storage.TrafficRepository, subscribeDir, username string, sub storage.ExternalSubscription, settings storage.UserSettings) (int, storage.ExternalSubscription, error) {
+// When dryRun is true nothing is written; only the change set is computed.
+// Returns: node count, updated subscription info, node change set, error
+func syncSingleExternalSubscription(ctx context.Context, client *http.Client, repo *storage.TrafficRepository, subscribeDir, username string, sub storage.ExternalSubscription, settings storage.UserSettings, dryRun bool) (int, storage.ExternalSubscription, storage.ExternalSubscriptionChangeSet, error) {
 	matchRule := settings.MatchRule
 	syncScope := settings.SyncScope
 	keepNodeName := settings.KeepNodeName
 
 	logger.Info("[外部订阅同步] 开始获取订阅内容", "name", sub.Name, "url", sub.URL)
 
-	// Fetch subscription content
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
-	if err != nil {
-		logger.Info("[外部订阅同步] 创建HTTP请求失败", "error", err)
-		return 0, sub, fmt.Errorf("create request: %w", err)
-	}
-
 	// 使用订阅保存的 User-Agent，如果为空则使用默认值
 	userAgent := sub.UserAgent
 	if userAgent == "" {
 		userAgent = "clash-meta/2.4.0"
 	}
-	req.Header.Set("User-Agent", userAgent)
 	logger.Info("[外部订阅同步] 使用 User-Agent", "user_agent", userAgent)
 
-	resp, err := client.Do(req)
+	// Fetch subscription content (conditional request, 304 reuses the cached body)
+	resp, err := fetchUpstreamSubscription(ctx, client, &sub, userAgent)
 	if err != nil {
 		logger.Info("[外部订阅同步] 请求订阅URL失败", "error", err)
-		return 0, sub, fmt.Errorf("fetch subscription: %w", err)
+		return 0, sub, storage.ExternalSubscriptionChangeSet{}, fmt.Errorf("fetch subscription: %w", err)
 	}
-	defer resp.Body.Close()
 
-	logger.Info("[外部订阅同步] HTTP响应状态码", "status_code", resp.StatusCode)
+	logger.Info("[外部订阅同步] HTTP响应状态码", "status_code", resp.StatusCode, "not_modified", resp.NotModified)
 
 	if resp.StatusCode != http.StatusOK {
 		logger.Info("[外部订阅同步] 订阅返回非200状态码", "status_code", resp.StatusCode)
-		return 0, sub, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return 0, sub, storage.ExternalSubscriptionChangeSet{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	// Parse subscription-userinfo header if sync_traffic is enabled
-	if settings.SyncTraffic {
+	// Parse subscription-userinfo header if sync_traffic is enabled (writes traffic info, skipped on dry run)
+	if settings.SyncTraffic && !dryRun {
 		userInfo := resp.Header.Get("subscription-userinfo")
 		if userInfo != "" {
 			logger.Info("[外部订阅同步] 发现流量信息头，开始解析...")
@@ -307,31 +282,17 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 			// 如果使用的不是 clash UA 且没有获取到流量信息，尝试用 clash-meta UA 再请求一次
 			logger.Info("[外部订阅同步] 未获取到流量信息，尝试使用 clash-meta UA 获取", "name", sub.Name)
 			clashMetaUA := "clash-meta/2.4.0"
-			trafficReq, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
-			if err == nil {
-				trafficReq.Header.Set("User-Agent", clashMetaUA)
-				trafficResp, err := client.Do(trafficReq)
-				if err == nil {
-					defer trafficResp.Body.Close()
-					if trafficResp.StatusCode == http.StatusOK {
-						trafficUserInfo := trafficResp.Header.Get("subscription-userinfo")
-						if trafficUserInfo != "" {
-							logger.Info("[外部订阅同步] clash-meta UA 获取流量信息成功", "name", sub.Name)
-							parseAndUpdateTrafficInfo(ctx, repo, &sub, trafficUserInfo)
-						}
-					}
-				} else {
-					logger.Info("[外部订阅同步] clash-meta UA 请求失败", "error", err)
-				}
+			trafficUserInfo, err := fetchUpstreamUserInfo(ctx, client, &sub, clashMetaUA)
+			if err != nil {
+				logger.Info("[外部订阅同步] clash-meta UA 请求失败", "error", err)
+			} else if trafficUserInfo != "" {
+				logger.Info("[外部订阅同步] clash-meta UA 获取流量信息成功", "name", sub.Name)
+				parseAndUpdateTrafficInfo(ctx, repo, &sub, trafficUserInfo)
 			}
 		}
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Info("[外部订阅同步] 读取响应内容失败", "error", err)
-		return 0, sub, fmt.Errorf("read response body: %w", err)
-	}
+	body := resp.Body
 
 	logger.Info("[外部订阅同步] 成功获取订阅内容", "size", len(body))
 
@@ -362,7 +323,7 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 
 	if len(proxies) == 0 {
 		logger.Info("[外部订阅同步] 订阅中未找到节点(proxies)数据")
-		return 0, sub, fmt.Errorf("no proxies found in subscription")
+		return 0, sub, storage.ExternalSubscriptionChangeSet{}, fmt.Errorf("no proxies found in subscription")
 	}
 
 	logger.Info("[外部订阅同步] 解析到节点", "name", sub.Name, "count", len(proxies))
@@ -395,6 +356,13 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 		}
 	}
 
+	// Apply the subscription's node transform pipeline (rename, flag, dedupe, sort, set fields, filter)
+	if pipeline := loadNodePipeline(sub.NodeTransforms, sub.Name); !pipeline.Empty() {
+		beforeCount := len(proxies)
+		proxies = applyNodePipelineToProxies(proxies, pipeline)
+		logger.Info("[外部订阅同步] 节点处理流水线完成", "name", sub.Name, "before_count", beforeCount, "after_count", len(proxies))
+	}
+
 	// Convert to storage.Node format
 	nodesToUpdate := make([]storage.Node, 0, len(proxies))
 
@@ -440,7 +408,7 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 
 	if len(nodesToUpdate) == 0 {
 		logger.Info("[外部订阅同步] 没有有效的节点可以同步")
-		return 0, sub, fmt.Errorf("no valid nodes to sync")
+		return 0, sub, storage.ExternalSubscriptionChangeSet{}, fmt.Errorf("no valid nodes to sync")
 	}
 
 	logger.Info("[外部订阅同步] 准备同步节点", "count", len(nodesToUpdate))
@@ -449,11 +417,17 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 	existingNodes, err := repo.ListNodes(ctx, username)
 	if err != nil {
 		logger.Info("[外部订阅同步] 获取已保存节点列表失败", "error", err)
-		return 0, sub, fmt.Errorf("list existing nodes: %w", err)
+		return 0, sub, storage.ExternalSubscriptionChangeSet{}, fmt.Errorf("list existing nodes: %w", err)
 	}
 
 	logger.Info("[外部订阅同步] 数据库中已有节点", "count", len(existingNodes))
 
+	changeSet := storage.ExternalSubscriptionChangeSet{
+		SubscriptionID: sub.ID,
+		Username:       username,
+	}
+	matchedNodeIDs := make(map[int64]struct{})
+
 	// Sync nodes to database (replace nodes based on match rule)
 	syncedCount := 0
 	updatedCount := 0
@@ -535,6 +509,34 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 		}
 
 		if existingNode != nil {
+			matchedNodeIDs[existingNode.ID] = struct{}{}
+
+			// 记录变更：字段差异不含 name，名称变化仅在不保留原名时算作重命名
+			change := storage.NodeChange{
+				NodeName: existingNode.NodeName,
+				Fields:   diffNodeClashConfig(existingNode.ClashConfig, node.ClashConfig),
+			}
+			if !keepNodeName && existingNode.NodeName != node.NodeName {
+				change.Action = storage.NodeChangeRenamed
+				change.OldName = existingNode.NodeName
+				change.NodeName = node.NodeName
+				changeSet.Renamed++
+			} else if len(change.Fields) > 0 {
+				change.Action = storage.NodeChangeUpdated
+				changeSet.Updated++
+			} else {
+				changeSet.Unchanged++
+			}
+			if change.Action != "" {
+				changeSet.Changes = append(changeSet.Changes, change)
+			}
+
+			if dryRun {
+				syncedCount++
+				updatedCount++
+				continue
+			}
+
 			// Update existing node
 			oldNodeName := existingNode.NodeName
 
@@ -592,6 +594,17 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 			// New node not found in existing nodes
 			// Check sync scope: only create new nodes if syncScope is "all"
 			if syncScope == "all" {
+				changeSet.Added++
+				changeSet.Changes = append(changeSet.Changes, storage.NodeChange{
+					Action:   storage.NodeChangeAdded,
+					NodeName: node.NodeName,
+				})
+				if dryRun {
+					syncedCount++
+					createdCount++
+					continue
+				}
+
 				_, err := repo.CreateNode(ctx, node)
 				if err != nil {
 					logger.Info("[外部订阅同步] 创建新节点 失败", "node_name", node.NodeName, "error", err)
@@ -603,19 +616,48 @@ func syncSingleExternalSubscription(ctx context.Context, client *http.Client, re
 			} else {
 				logger.Info("[外部订阅同步] 跳过新节点 (同步范围: 仅已保存节点)", "node_name", node.NodeName)
 				skippedCount++
+				changeSet.Skipped++
+				changeSet.Changes = append(changeSet.Changes, storage.NodeChange{
+					Action:   storage.NodeChangeSkipped,
+					NodeName: node.NodeName,
+				})
 			}
 		}
 	}
 
+	// 属于该订阅但上游已不存在的节点：不自动删除（可能被配置文件引用），仅记录为上游缺失
+	for _, existing := range existingNodes {
+		if existing.RawURL != sub.URL {
+			continue
+		}
+		if _, ok := matchedNodeIDs[existing.ID]; ok {
+			continue
+		}
+		changeSet.Missing++
+		changeSet.Changes = append(changeSet.Changes, storage.NodeChange{
+			Action:   storage.NodeChangeMissing,
+			NodeName: existing.NodeName,
+		})
+	}
+
+	if dryRun {
+		logger.Info("[外部订阅同步] 预览完成（未写入）", "name", sub.Name, "added", changeSet.Added, "missing", changeSet.Missing, "renamed", changeSet.Renamed, "updated", changeSet.Updated, "skipped", changeSet.Skipped)
+		return syncedCount, sub, changeSet, nil
+	}
+
 	logger.Info("[外部订阅同步] 订阅同步完成", "name", sub.Name, "synced_count", syncedCount, "total_count", len(nodesToUpdate), "updated", updatedCount, "created", createdCount, "skipped", skippedCount)
 
+	if updatedCount+createdCount > 0 {
+		invalidateNodeTagProxyProviders(ctx, repo, username)
+	}
+
 	// 同步代理集合节点到 YAML（仅处理 mmw 模式）
 	if err := syncProxyProviderNodesToYAML(ctx, repo, subscribeDir, username, sub); err != nil {
 		logger.Info("[外部订阅同步] 同步代理集合节点到YAML失败", "error", err)
 		// 不影响主流程，仅记录日志
 	}
 
-	return syncedCount, sub, nil
+	return syncedCount, sub, changeSet, nil
 }
 
 // ParseTrafficInfoHeader parses subscription-userinfo header and returns traffic info
@@ -850,7 +892,28 @@ func (h *SyncSingleExternalSubscriptionHandler) ServeHTTP(w http.ResponseWriter,
 		Timeout: 30 * time.Second,
 	}
 
-	nodeCount, updatedSub, err := syncSingleExternalSubscription(r.Context(), client, h.repo, h.subscribeDir, username, *targetSub, userSettings)
+	// 预览模式：只计算节点变更，不写入任何数据
+	if isTruthyQuery(r.URL.Query().Get("dry_run")) {
+		_, _, changeSet, err := syncSingleExternalSubscription(r.Context(), client, h.repo, h.subscribeDir, username, *targetSub, userSettings, true)
+		if err != nil {
+			logger.Info("[Sync API] 预览同步失败", "name", targetSub.Name, "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("预览失败: %v", err),
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"dry_run":    true,
+			"change_set": newChangeSetResponse(changeSet),
+		})
+		return
+	}
+
+	nodeCount, changeSet, err := syncAndRecordExternalSubscription(r.Context(), client, h.repo, h.subscribeDir, username, *targetSub, userSettings)
 	if err != nil {
 		logger.Info("[Sync API] Failed to sync subscription", "name", targetSub.Name, "error", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -861,20 +924,13 @@ func (h *SyncSingleExternalSubscriptionHandler) ServeHTTP(w http.ResponseWriter,
 		return
 	}
 
-	// Update last sync time and node count
-	now := time.Now()
-	updatedSub.LastSyncAt = &now
-	updatedSub.NodeCount = nodeCount
-	if err := h.repo.UpdateExternalSubscription(r.Context(), updatedSub); err != nil {
-		logger.Info("[Sync API] 更新订阅 的同步时间失败", "name", targetSub.Name, "error", err)
-	}
-
 	logger.Info("[Sync API] Successfully synced subscription , synced nodes", "name", targetSub.Name, "param", nodeCount)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]any{
 		"message":    fmt.Sprintf("订阅 %s 同步成功", targetSub.Name),
 		"node_count": nodeCount,
+		"change_set": newChangeSetResponse(changeSet),
 	})
 }
 
@@ -959,8 +1015,16 @@ func syncProxyProviderNodesToYAML(ctx context.Context, repo *storage.TrafficRepo
 			logger.Info("[代理集合同步] 使用缓存 ID=, 节点数", "id", config.ID, "node_count", entry.NodeCount)
 			proxiesRaw = entry.Nodes
 		} else {
-			// 缓存未命中或过期，刷新缓存
-			entry, err := RefreshProxyProviderCache(&sub, &config)
+			// 缓存未命中或过期，刷新缓存；组合代理集合可能以其他外部订阅为主订阅
+			primary := sub
+			if config.ExternalSubscriptionID != sub.ID {
+				var err error
+				if primary, err = repo.GetExternalSubscription(ctx, config.ExternalSubscriptionID, config.Username); err != nil {
+					logger.Info("[代理集合同步] 获取代理集合的主订阅失败", "name", config.Name, "error", err)
+					continue
+				}
+			}
+			entry, _, err := RefreshProxyProviderCacheOrStale(&primary, &config)
 			if err != nil {
 				logger.Info("[代理集合同步] 获取代理集合 的节点失败", "name", config.Name, "error", err)
 				continue
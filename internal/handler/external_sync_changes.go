@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"miaomiaowu/internal/auth"
+	"miaomiaowu/internal/storage"
+)
+
+type changeSetResponse struct {
+	ID             int64                `json:"id,omitempty"`
+	SubscriptionID int64                `json:"subscription_id"`
+	Added          int                  `json:"added"`
+	Missing        int                  `json:"missing"`
+	Renamed        int                  `json:"renamed"`
+	Updated        int                  `json:"updated"`
+	Skipped        int                  `json:"skipped"`
+	Unchanged      int                  `json:"unchanged"`
+	Changes        []storage.NodeChange `json:"changes"`
+	CreatedAt      string               `json:"created_at,omitempty"`
+}
+
+func newChangeSetResponse(cs storage.ExternalSubscriptionChangeSet) changeSetResponse {
+	resp := changeSetResponse{
+		ID:             cs.ID,
+		SubscriptionID: cs.SubscriptionID,
+		Added:          cs.Added,
+		Missing:        cs.Missing,
+		Renamed:        cs.Renamed,
+		Updated:        cs.Updated,
+		Skipped:        cs.Skipped,
+		Unchanged:      cs.Unchanged,
+		Changes:        cs.Changes,
+	}
+	if resp.Changes == nil {
+		resp.Changes = []storage.NodeChange{}
+	}
+	if !cs.CreatedAt.IsZero() {
+		resp.CreatedAt = cs.CreatedAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+// diffNodeClashConfig 比较两个节点的 Clash 配置（JSON），返回字段级差异，忽略 name 字段
+func diffNodeClashConfig(oldConfig, newConfig string) []storage.NodeFieldChange {
+	var oldMap, newMap map[string]any
+	if err := json.Unmarshal([]byte(oldConfig), &oldMap); err != nil {
+		oldMap = map[string]any{}
+	}
+	if err := json.Unmarshal([]byte(newConfig), &newMap); err != nil {
+		newMap = map[string]any{}
+	}
+
+	keys := make(map[string]struct{}, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		keys[k] = struct{}{}
+	}
+	for k := range newMap {
+		keys[k] = struct{}{}
+	}
+	delete(keys, "name")
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []storage.NodeFieldChange
+	for _, k := range sorted {
+		oldVal, newVal := oldMap[k], newMap[k]
+		if reflect.DeepEqual(oldVal, newVal) {
+			continue
+		}
+		changes = append(changes, storage.NodeFieldChange{Field: k, Old: oldVal, New: newVal})
+	}
+	return changes
+}
+
+// NewExternalSubscriptionChangesHandler 浏览外部订阅同步的节点变更记录
+// GET /api/user/external-subscriptions/changes?id={subscription_id}[&limit=20]
+// GET /api/user/external-subscriptions/changes?change_id={id}
+func NewExternalSubscriptionChangesHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("external subscription changes handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		username := auth.UsernameFromContext(r.Context())
+		if strings.TrimSpace(username) == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		query := r.URL.Query()
+		if changeIDStr := query.Get("change_id"); changeIDStr != "" {
+			changeID, err := strconv.ParseInt(changeIDStr, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errors.New("invalid change id"))
+				return
+			}
+			cs, err := repo.GetExternalSubscriptionChangeSet(r.Context(), changeID, username)
+			if err != nil {
+				if errors.Is(err, storage.ErrChangeSetNotFound) {
+					writeError(w, http.StatusNotFound, err)
+					return
+				}
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			respondJSON(w, http.StatusOK, newChangeSetResponse(cs))
+			return
+		}
+
+		id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("subscription id is required"))
+			return
+		}
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		sets, err := repo.ListExternalSubscriptionChangeSets(r.Context(), id, username, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		resp := make([]changeSetResponse, 0, len(sets))
+		for _, cs := range sets {
+			resp = append(resp, newChangeSetResponse(cs))
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"change_sets": resp})
+	})
+}
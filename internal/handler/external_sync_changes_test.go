@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"miaomiaowu/internal/storage"
+)
+
+func TestDiffNodeClashConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []storage.NodeFieldChange
+	}{
+		{
+			name: "identical",
+			old:  `{"name":"a","server":"1.1.1.1","port":443}`,
+			new:  `{"port":443,"server":"1.1.1.1","name":"a"}`,
+		},
+		{
+			name: "name is ignored",
+			old:  `{"name":"a","server":"1.1.1.1"}`,
+			new:  `{"name":"b","server":"1.1.1.1"}`,
+		},
+		{
+			name: "changed, added and removed fields sorted by name",
+			old:  `{"name":"a","server":"1.1.1.1","port":443,"udp":true}`,
+			new:  `{"name":"a","server":"2.2.2.2","port":443,"sni":"x.com"}`,
+			want: []storage.NodeFieldChange{
+				{Field: "server", Old: "1.1.1.1", New: "2.2.2.2"},
+				{Field: "sni", New: "x.com"},
+				{Field: "udp", Old: true},
+			},
+		},
+		{
+			name: "nested values compared deeply",
+			old:  `{"ws-opts":{"path":"/a","headers":{"Host":"x"}}}`,
+			new:  `{"ws-opts":{"path":"/b","headers":{"Host":"x"}}}`,
+			want: []storage.NodeFieldChange{
+				{
+					Field: "ws-opts",
+					Old:   map[string]any{"path": "/a", "headers": map[string]any{"Host": "x"}},
+					New:   map[string]any{"path": "/b", "headers": map[string]any{"Host": "x"}},
+				},
+			},
+		},
+		{
+			name: "invalid old config treated as empty",
+			old:  `not json`,
+			new:  `{"name":"a","port":443}`,
+			want: []storage.NodeFieldChange{
+				{Field: "port", New: float64(443)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffNodeClashConfig(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("diffNodeClashConfig() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeSetHasChanges(t *testing.T) {
+	tests := []struct {
+		name string
+		cs   storage.ExternalSubscriptionChangeSet
+		want bool
+	}{
+		{"empty", storage.ExternalSubscriptionChangeSet{Unchanged: 10}, false},
+		{"only skipped", storage.ExternalSubscriptionChangeSet{Skipped: 3, Unchanged: 10}, false},
+		{"added", storage.ExternalSubscriptionChangeSet{Added: 1}, true},
+		{"missing upstream", storage.ExternalSubscriptionChangeSet{Missing: 1}, true},
+		{"renamed", storage.ExternalSubscriptionChangeSet{Renamed: 1}, true},
+		{"updated", storage.ExternalSubscriptionChangeSet{Updated: 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cs.HasChanges(); got != tt.want {
+				t.Fatalf("HasChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
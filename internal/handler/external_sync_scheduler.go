@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 外部订阅定时同步相关常量
+const (
+	// 扫描周期：每分钟检查一次是否有外部订阅到达同步时间
+	externalSyncScanInterval = time.Minute
+	// 重试延迟基础值：首次失败后等待2分钟重试
+	externalSyncRetryBase = 2 * time.Minute
+	// 重试延迟最大值：最多等待1小时后重试（不超过订阅自身的同步间隔）
+	externalSyncRetryMax = time.Hour
+	// 并发同步worker数量
+	externalSyncWorkerLimit = 2
+	// 同步间隔抖动比例：避免大量订阅在同一时刻请求机场
+	externalSyncJitterRatio = 0.1
+	// 单次同步超时时间
+	externalSyncTimeout = 3 * time.Minute
+)
+
+// externalSyncState 记录单个外部订阅的调度状态
+type externalSyncState struct {
+	// nextRunAt 下一次计划同步时间（已包含抖动）
+	nextRunAt time.Time
+	// retryDelay 当前重试延迟，失败后会翻倍
+	retryDelay time.Duration
+}
+
+// externalSubscriptionSyncer 外部订阅定时同步器
+// 按每个外部订阅的 sync_interval 定时拉取节点，结果写回数据库
+type externalSubscriptionSyncer struct {
+	repo         *storage.TrafficRepository
+	subscribeDir string
+	client       *http.Client
+	mu           sync.Mutex
+	state        map[int64]*externalSyncState // key: external subscription ID
+	running      map[int64]struct{}
+	workers      chan struct{}
+	wg           sync.WaitGroup
+}
+
+// StartExternalSubscriptionSync 启动外部订阅定时同步
+// 该函数会阻塞，直到context被取消
+func StartExternalSubscriptionSync(ctx context.Context, repo *storage.TrafficRepository, subscribeDir string) {
+	if repo == nil {
+		return
+	}
+
+	s := &externalSubscriptionSyncer{
+		repo:         repo,
+		subscribeDir: subscribeDir,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		state:        make(map[int64]*externalSyncState),
+		running:      make(map[int64]struct{}),
+		workers:      make(chan struct{}, externalSyncWorkerLimit),
+	}
+	s.run(ctx)
+}
+
+func (s *externalSubscriptionSyncer) run(ctx context.Context) {
+	logger.Info("[外部订阅定时同步] 调度器启动",
+		"scan_interval", externalSyncScanInterval.String(),
+		"max_workers", externalSyncWorkerLimit)
+	defer logger.Info("[外部订阅定时同步] 调度器已退出")
+
+	ticker := time.NewTicker(externalSyncScanInterval)
+	defer ticker.Stop()
+
+	s.runSyncCycle(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			return
+		case <-ticker.C:
+			s.runSyncCycle(ctx)
+		}
+	}
+}
+
+// runSyncCycle 加载所有外部订阅，对到期的订阅启动worker
+func (s *externalSubscriptionSyncer) runSyncCycle(ctx context.Context) {
+	loadCtx, cancel := context.WithTimeout(ctx, configLoadTimeout)
+	subs, err := s.repo.ListAllExternalSubscriptions(loadCtx)
+	cancel()
+	if err != nil {
+		logger.Warn("[外部订阅定时同步] 加载外部订阅失败", "error", err)
+		return
+	}
+
+	for _, sub := range s.collectDue(subs, time.Now()) {
+		select {
+		case <-ctx.Done():
+			s.markFinished(sub.ID)
+			continue
+		case s.workers <- struct{}{}:
+		}
+
+		s.wg.Add(1)
+		go func(sub storage.ExternalSubscription) {
+			defer func() {
+				<-s.workers
+				s.wg.Done()
+				s.markFinished(sub.ID)
+			}()
+			s.syncOne(ctx, sub)
+		}(sub)
+	}
+}
+
+// collectDue 返回到达同步时间的外部订阅，并清理已删除或关闭自动同步的状态
+func (s *externalSubscriptionSyncer) collectDue(subs []storage.ExternalSubscription, now time.Time) []storage.ExternalSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := make(map[int64]struct{}, len(subs))
+	var due []storage.ExternalSubscription
+	for _, sub := range subs {
+		if sub.SyncInterval <= 0 {
+			continue
+		}
+		active[sub.ID] = struct{}{}
+		if _, busy := s.running[sub.ID]; busy {
+			continue
+		}
+
+		st, ok := s.state[sub.ID]
+		if !ok {
+			st = &externalSyncState{nextRunAt: nextExternalSyncAt(sub, now)}
+			s.state[sub.ID] = st
+		}
+		if now.Before(st.nextRunAt) {
+			continue
+		}
+
+		s.running[sub.ID] = struct{}{}
+		due = append(due, sub)
+	}
+
+	for id := range s.state {
+		if _, ok := active[id]; !ok {
+			delete(s.state, id)
+		}
+	}
+
+	if len(due) > 0 {
+		logger.Info("[外部订阅定时同步] 本次扫描发现需要同步的订阅", "count", len(due))
+	}
+	return due
+}
+
+// syncOne 同步单个外部订阅并安排下一次运行
+func (s *externalSubscriptionSyncer) syncOne(ctx context.Context, sub storage.ExternalSubscription) {
+	runCtx, cancel := context.WithTimeout(ctx, externalSyncTimeout)
+	defer cancel()
+
+	settings := loadExternalSyncSettings(runCtx, s.repo, sub.Username)
+	nodeCount, _, err := syncAndRecordExternalSubscription(runCtx, s.client, s.repo, s.subscribeDir, sub.Username, sub, settings)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.state[sub.ID]
+	if !ok {
+		st = &externalSyncState{}
+		s.state[sub.ID] = st
+	}
+
+	if err != nil {
+		delay := nextExternalSyncRetryDelay(st.retryDelay, sub.SyncInterval)
+		st.retryDelay = delay
+		st.nextRunAt = time.Now().Add(withJitter(delay))
+		logger.Warn("[外部订阅定时同步] 同步失败，已安排重试",
+			"name", sub.Name,
+			"username", sub.Username,
+			"retry_after", delay.String(),
+			"error", err)
+		return
+	}
+
+	st.retryDelay = 0
+	st.nextRunAt = time.Now().Add(withJitter(time.Duration(sub.SyncInterval) * time.Minute))
+	logger.Info("[外部订阅定时同步] 同步成功",
+		"name", sub.Name,
+		"username", sub.Username,
+		"node_count", nodeCount,
+		"next_run_at", st.nextRunAt.Format(time.RFC3339))
+}
+
+func (s *externalSubscriptionSyncer) markFinished(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, id)
+}
+
+// nextExternalSyncRetryDelay 计算失败后的重试延迟：指数退避，不超过1小时和订阅自身的同步间隔（分钟）
+func nextExternalSyncRetryDelay(prev time.Duration, syncInterval int) time.Duration {
+	delay := prev
+	if delay == 0 {
+		delay = externalSyncRetryBase
+	} else {
+		delay *= 2
+	}
+	limit := min(externalSyncRetryMax, time.Duration(syncInterval)*time.Minute)
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// nextExternalSyncAt 根据上次同步时间计算首次调度时间
+func nextExternalSyncAt(sub storage.ExternalSubscription, now time.Time) time.Time {
+	if sub.LastSyncAt == nil {
+		return now
+	}
+	next := sub.LastSyncAt.Add(withJitter(time.Duration(sub.SyncInterval) * time.Minute))
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// withJitter 在 d 的基础上增加 ±10% 的随机抖动
+func withJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	spread := float64(d) * externalSyncJitterRatio
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// loadExternalSyncSettings 读取用户的同步设置，失败时使用默认值
+func loadExternalSyncSettings(ctx context.Context, repo *storage.TrafficRepository, username string) storage.UserSettings {
+	settings, err := repo.GetUserSettings(ctx, username)
+	if err != nil {
+		logger.Info("[外部订阅同步] 获取用户设置失败，使用默认设置", "user", username, "error", err)
+		settings.MatchRule = "node_name"
+		settings.SyncScope = "saved_only"
+		settings.KeepNodeName = true
+	}
+	return settings
+}
+
+// externalSyncLocks 按用户串行化外部订阅同步
+// 定时同步、手动同步和获取订阅时触发的同步都会改写同一用户的节点表，必须共用这把锁
+var externalSyncLocks = newKeyedMutex()
+
+// keyedMutex 按 key 加锁的互斥锁，等待时可被 context 取消
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	ch   chan struct{}
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyedMutexEntry)}
+}
+
+// lock 获取 key 对应的锁，返回释放函数；context 取消时放弃等待
+func (m *keyedMutex) lock(ctx context.Context, key string) (func(), error) {
+	m.mu.Lock()
+	entry, ok := m.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{ch: make(chan struct{}, 1)}
+		m.locks[key] = entry
+	}
+	entry.refs++
+	m.mu.Unlock()
+
+	select {
+	case entry.ch <- struct{}{}:
+		return func() {
+			<-entry.ch
+			m.release(key, entry)
+		}, nil
+	case <-ctx.Done():
+		m.release(key, entry)
+		return nil, ctx.Err()
+	}
+}
+
+func (m *keyedMutex) release(key string, entry *keyedMutexEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry.refs--
+	if entry.refs == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// syncAndRecordExternalSubscription 同步单个外部订阅，更新同步时间和节点数，并记录同步结果和节点变更
+// 同一用户的同步会串行执行，拿到锁后重新读取订阅，避免基于过期的状态写回
+func syncAndRecordExternalSubscription(ctx context.Context, client *http.Client, repo *storage.TrafficRepository, subscribeDir, username string, sub storage.ExternalSubscription, settings storage.UserSettings) (int, storage.ExternalSubscriptionChangeSet, error) {
+	unlock, err := externalSyncLocks.lock(ctx, username)
+	if err != nil {
+		return 0, storage.ExternalSubscriptionChangeSet{}, fmt.Errorf("wait for external subscription sync: %w", err)
+	}
+	defer unlock()
+
+	latest, err := repo.GetExternalSubscription(ctx, sub.ID, username)
+	if err != nil {
+		return 0, storage.ExternalSubscriptionChangeSet{}, fmt.Errorf("reload external subscription: %w", err)
+	}
+	sub = latest
+
+	attemptAt := time.Now()
+	nodeCount, updatedSub, changeSet, err := syncSingleExternalSubscription(ctx, client, repo, subscribeDir, username, sub, settings, false)
+	if err != nil {
+		if recErr := repo.RecordExternalSubscriptionSyncResult(context.WithoutCancel(ctx), sub.ID, storage.ExternalSubscriptionSyncResult{
+			Error:     err.Error(),
+			AttemptAt: attemptAt,
+		}); recErr != nil {
+			logger.Info("[外部订阅同步] 记录同步结果失败", "name", sub.Name, "error", recErr)
+		}
+		return 0, changeSet, err
+	}
+
+	// Use updatedSub which contains traffic info from parseAndUpdateTrafficInfo
+	now := time.Now()
+	updatedSub.LastSyncAt = &now
+	updatedSub.NodeCount = nodeCount
+	if err := repo.UpdateExternalSubscription(ctx, updatedSub); err != nil {
+		logger.Info("[外部订阅同步] 更新订阅同步时间失败", "name", sub.Name, "error", err)
+	}
+	if err := repo.RecordExternalSubscriptionSyncResult(ctx, sub.ID, storage.ExternalSubscriptionSyncResult{
+		Success:   true,
+		NodeDelta: nodeCount - sub.NodeCount,
+		AttemptAt: attemptAt,
+	}); err != nil {
+		logger.Info("[外部订阅同步] 记录同步结果失败", "name", sub.Name, "error", err)
+	}
+	if !changeSet.HasChanges() {
+		return nodeCount, changeSet, nil
+	}
+	if id, err := repo.CreateExternalSubscriptionChangeSet(ctx, changeSet); err != nil {
+		logger.Info("[外部订阅同步] 保存节点变更记录失败", "name", sub.Name, "error", err)
+	} else {
+		changeSet.ID = id
+		changeSet.CreatedAt = time.Now()
+	}
+	return nodeCount, changeSet, nil
+}
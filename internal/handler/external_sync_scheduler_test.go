@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextExternalSyncRetryDelay(t *testing.T) {
+	tests := []struct {
+		name         string
+		prev         time.Duration
+		syncInterval int
+		want         time.Duration
+	}{
+		{"first failure", 0, 1440, externalSyncRetryBase},
+		{"doubles", 2 * time.Minute, 1440, 4 * time.Minute},
+		{"doubles again", 16 * time.Minute, 1440, 32 * time.Minute},
+		{"capped at max", 32 * time.Minute, 1440, externalSyncRetryMax},
+		{"stays at max", externalSyncRetryMax, 1440, externalSyncRetryMax},
+		{"capped by sync interval", 4 * time.Minute, 5, 5 * time.Minute},
+		{"first failure capped by short interval", 0, 1, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextExternalSyncRetryDelay(tt.prev, tt.syncInterval); got != tt.want {
+				t.Fatalf("nextExternalSyncRetryDelay(%v, %d) = %v, want %v", tt.prev, tt.syncInterval, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithJitterBounds(t *testing.T) {
+	d := time.Hour
+	spread := time.Duration(float64(d) * externalSyncJitterRatio)
+	for range 100 {
+		got := withJitter(d)
+		if got < d-spread || got > d+spread {
+			t.Fatalf("withJitter(%v) = %v, outside ±%v", d, got, spread)
+		}
+	}
+	if got := withJitter(0); got != 0 {
+		t.Fatalf("withJitter(0) = %v, want 0", got)
+	}
+}
+
+func TestKeyedMutexSerializesSameKey(t *testing.T) {
+	m := newKeyedMutex()
+	unlock, err := m.lock(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	// 其他 key 不受影响
+	unlockBob, err := m.lock(context.Background(), "bob")
+	if err != nil {
+		t.Fatalf("lock other key: %v", err)
+	}
+	unlockBob()
+
+	// 同一 key 在释放前无法获取
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.lock(ctx, "alice"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lock while held: err = %v, want deadline exceeded", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		next, err := m.lock(context.Background(), "alice")
+		if err != nil {
+			t.Errorf("lock after release: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- next
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second lock acquired before release")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	if next := <-acquired; next != nil {
+		next()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.locks) != 0 {
+		t.Fatalf("locks not cleaned up: %d entries left", len(m.locks))
+	}
+}
@@ -0,0 +1,317 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"miaomiaowu/internal/auth"
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+	"miaomiaowu/internal/substore"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nodeTransformPreviewLimit 预览最多返回的节点数
+const nodeTransformPreviewLimit = 500
+
+// encodeNodeTransforms 校验节点处理流水线并编码为存储用的 JSON，空流水线存为空字符串
+func encodeNodeTransforms(ops []substore.NodeOperator) (string, error) {
+	if len(ops) == 0 {
+		return "", nil
+	}
+	if _, err := substore.CompileNodeOperators(ops); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return "", fmt.Errorf("encode node transforms: %w", err)
+	}
+	return string(data), nil
+}
+
+// nodeTransformsJSON 返回给前端的流水线，未配置时为 []
+func nodeTransformsJSON(raw string) json.RawMessage {
+	if strings.TrimSpace(raw) == "" {
+		return json.RawMessage("[]")
+	}
+	return json.RawMessage(raw)
+}
+
+// loadNodePipeline 解析已保存的流水线；保存时已校验，解析失败只记录日志并跳过
+func loadNodePipeline(raw, source string) *substore.NodePipeline {
+	pipeline, err := substore.ParseNodePipeline(raw)
+	if err != nil {
+		logger.Info("[节点处理] 流水线无效，跳过", "source", source, "error", err)
+		return nil
+	}
+	return pipeline
+}
+
+// applyNodePipelineToProxies 对外部订阅解析出的节点列表应用流水线
+func applyNodePipelineToProxies(proxies []any, pipeline *substore.NodePipeline) []any {
+	if pipeline.Empty() {
+		return proxies
+	}
+	maps := make([]map[string]any, 0, len(proxies))
+	for _, proxy := range proxies {
+		if proxyMap, ok := proxy.(map[string]any); ok {
+			maps = append(maps, proxyMap)
+		}
+	}
+	maps = pipeline.Apply(maps)
+	result := make([]any, 0, len(maps))
+	for _, proxyMap := range maps {
+		result = append(result, proxyMap)
+	}
+	return result
+}
+
+// applyNodePipelinesToYAML 依次应用多个流水线到 proxies 序列节点
+// 只重写被修改的字段，其余字段保持原始顺序和格式
+func applyNodePipelinesToYAML(proxiesNode *yaml.Node, pipelines ...*substore.NodePipeline) error {
+	active := pipelines[:0:0]
+	for _, p := range pipelines {
+		if !p.Empty() {
+			active = append(active, p)
+		}
+	}
+	if len(active) == 0 || proxiesNode == nil || proxiesNode.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	type source struct {
+		node   *yaml.Node
+		before map[string]any
+	}
+	sources := make(map[uintptr]source, len(proxiesNode.Content))
+	proxies := make([]map[string]any, 0, len(proxiesNode.Content))
+	for _, item := range proxiesNode.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		var proxy, before map[string]any
+		if err := item.Decode(&proxy); err != nil {
+			return fmt.Errorf("decode proxy: %w", err)
+		}
+		if err := item.Decode(&before); err != nil {
+			return fmt.Errorf("decode proxy: %w", err)
+		}
+		sources[reflect.ValueOf(proxy).Pointer()] = source{node: item, before: before}
+		proxies = append(proxies, proxy)
+	}
+
+	for _, p := range active {
+		proxies = p.Apply(proxies)
+	}
+
+	content := make([]*yaml.Node, 0, len(proxies))
+	for _, proxy := range proxies {
+		src := sources[reflect.ValueOf(proxy).Pointer()]
+		if err := syncYAMLMapping(src.node, src.before, proxy); err != nil {
+			return err
+		}
+		content = append(content, src.node)
+	}
+	proxiesNode.Content = content
+	return nil
+}
+
+// syncYAMLMapping 把 after 中的修改写回映射节点：删除的字段移除、修改的字段重新编码、新字段追加到末尾
+func syncYAMLMapping(node *yaml.Node, before, after map[string]any) error {
+	kept := make([]*yaml.Node, 0, len(node.Content))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		newValue, ok := after[key.Value]
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(before[key.Value], newValue) {
+			encoded := &yaml.Node{}
+			if err := encoded.Encode(newValue); err != nil {
+				return fmt.Errorf("encode field %s: %w", key.Value, err)
+			}
+			value = encoded
+		}
+		kept = append(kept, key, value)
+	}
+
+	added := make([]string, 0)
+	for key := range after {
+		if _, existed := before[key]; !existed {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	for _, key := range added {
+		encoded := &yaml.Node{}
+		if err := encoded.Encode(after[key]); err != nil {
+			return fmt.Errorf("encode field %s: %w", key, err)
+		}
+		kept = append(kept, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, encoded)
+	}
+	node.Content = kept
+	return nil
+}
+
+// fetchSubscriptionProxiesNode 拉取外部订阅（带缓存）并返回 proxies 序列节点
+func fetchSubscriptionProxiesNode(sub *storage.ExternalSubscription) (*yaml.Node, error) {
+	body, err := fetchSubscriptionContent(sub)
+	if err != nil {
+		return nil, err
+	}
+	body, err = preprocessSubscriptionContent(body)
+	if err != nil {
+		return nil, fmt.Errorf("preprocess subscription content: %w", err)
+	}
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(body, &rootNode); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	proxiesNode := findProxiesNode(&rootNode)
+	if proxiesNode == nil || proxiesNode.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("no proxies found in subscription")
+	}
+	return proxiesNode, nil
+}
+
+type nodeTransformPreviewRequest struct {
+	Operators []substore.NodeOperator `json:"operators"`
+
+	// 节点来源（三选一）：直接提供节点、外部订阅，或代理集合（合并所有来源并应用外部订阅的流水线和代理集合的过滤条件）
+	Proxies                []map[string]any `json:"proxies"`
+	ExternalSubscriptionID int64            `json:"external_subscription_id"`
+	ProxyProviderID        int64            `json:"proxy_provider_id"`
+}
+
+type nodeRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// NewNodeTransformPreviewHandler 预览节点处理流水线的效果，不保存任何数据
+// POST /api/user/node-transforms/preview
+func NewNodeTransformPreviewHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("node transform preview handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		username := auth.UsernameFromContext(r.Context())
+		if strings.TrimSpace(username) == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		var req nodeTransformPreviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		pipeline, err := substore.CompileNodeOperators(req.Operators)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var proxies []map[string]any
+		switch {
+		case len(req.Proxies) > 0:
+			proxies = req.Proxies
+		case req.ProxyProviderID > 0:
+			config, err := repo.GetProxyProviderConfig(r.Context(), req.ProxyProviderID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if config == nil || config.Username != username {
+				writeError(w, http.StatusNotFound, errors.New("proxy provider config not found"))
+				return
+			}
+			sub, err := repo.GetExternalSubscription(r.Context(), config.ExternalSubscriptionID, username)
+			if err != nil {
+				writeError(w, http.StatusNotFound, errors.New("external subscription not found"))
+				return
+			}
+			proxiesNode, err := loadProxyProviderProxiesNode(r.Context(), &sub, config)
+			if err != nil {
+				writeError(w, http.StatusBadGateway, err)
+				return
+			}
+			proxiesNode = applyFiltersToNode(proxiesNode, config)
+			if err := proxiesNode.Decode(&proxies); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		case req.ExternalSubscriptionID > 0:
+			sub, err := repo.GetExternalSubscription(r.Context(), req.ExternalSubscriptionID, username)
+			if err != nil {
+				writeError(w, http.StatusNotFound, errors.New("external subscription not found"))
+				return
+			}
+			proxiesNode, err := fetchSubscriptionProxiesNode(&sub)
+			if err != nil {
+				writeError(w, http.StatusBadGateway, err)
+				return
+			}
+			if err := proxiesNode.Decode(&proxies); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		default:
+			writeError(w, http.StatusBadRequest, errors.New("proxies, external_subscription_id or proxy_provider_id is required"))
+			return
+		}
+
+		originalNames := make(map[uintptr]string, len(proxies))
+		for _, proxy := range proxies {
+			name, _ := proxy["name"].(string)
+			originalNames[reflect.ValueOf(proxy).Pointer()] = name
+		}
+		inputCount := len(proxies)
+
+		result := pipeline.Apply(proxies)
+
+		renamed := make([]nodeRename, 0)
+		remaining := make(map[uintptr]struct{}, len(result))
+		for _, proxy := range result {
+			ptr := reflect.ValueOf(proxy).Pointer()
+			remaining[ptr] = struct{}{}
+			if name, _ := proxy["name"].(string); name != originalNames[ptr] {
+				renamed = append(renamed, nodeRename{From: originalNames[ptr], To: name})
+			}
+		}
+		removed := make([]string, 0)
+		for _, proxy := range proxies {
+			ptr := reflect.ValueOf(proxy).Pointer()
+			if _, ok := remaining[ptr]; !ok {
+				removed = append(removed, originalNames[ptr])
+			}
+		}
+
+		truncated := len(result) > nodeTransformPreviewLimit
+		if truncated {
+			result = result[:nodeTransformPreviewLimit]
+		}
+
+		respondJSON(w, http.StatusOK, map[string]any{
+			"input_count":  inputCount,
+			"output_count": inputCount - len(removed),
+			"proxies":      result,
+			"truncated":    truncated,
+			"renamed":      renamed,
+			"removed":      removed,
+		})
+	})
+}
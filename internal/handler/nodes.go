@@ -5,16 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"miaomiaowu/internal/logger"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
 	"miaomiaowu/internal/auth"
 	"miaomiaowu/internal/storage"
+	"miaomiaowu/internal/substore"
 
 	"gopkg.in/yaml.v3"
 )
@@ -180,8 +181,11 @@ func (h *nodesHandler) handleList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dtos := convertNodes(nodes)
+	attachProbeStatus(r.Context(), h.repo, dtos)
+
 	respondJSON(w, http.StatusOK, map[string]any{
-		"nodes": convertNodes(nodes),
+		"nodes": dtos,
 	})
 }
 
@@ -257,6 +261,8 @@ func (h *nodesHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info("[节点创建] 成功 - ID, 节点名称", "id", created.ID, "node_name", created.NodeName)
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusCreated, map[string]any{
 		"node": convertNode(created),
 	})
@@ -270,7 +276,8 @@ func (h *nodesHandler) handleBatchCreate(w http.ResponseWriter, r *http.Request)
 	}
 
 	var req struct {
-		Nodes []nodeRequest `json:"nodes"`
+		Nodes          []nodeRequest           `json:"nodes"`
+		NodeTransforms []substore.NodeOperator `json:"node_transforms"` // 可选：保存前应用的节点处理流水线
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -283,6 +290,15 @@ func (h *nodesHandler) handleBatchCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	pipeline, err := substore.CompileNodeOperators(req.NodeTransforms)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !pipeline.Empty() {
+		req.Nodes = applyNodePipelineToNodeRequests(req.Nodes, pipeline)
+	}
+
 	nodes := make([]storage.Node, 0, len(req.Nodes))
 	for _, n := range req.Nodes {
 		// 允许 Clash 订阅节点没有 RawURL，但必须有 NodeName 和 ClashConfig
@@ -312,11 +328,51 @@ func (h *nodesHandler) handleBatchCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusCreated, map[string]any{
 		"nodes": convertNodes(created),
 	})
 }
 
+// applyNodePipelineToNodeRequests 对批量导入的节点应用流水线，同步更新节点名称和配置
+// 无法解析 ClashConfig 的节点不参与处理，原样保留在末尾
+func applyNodePipelineToNodeRequests(nodes []nodeRequest, pipeline *substore.NodePipeline) []nodeRequest {
+	proxies := make([]map[string]any, 0, len(nodes))
+	sources := make(map[uintptr]nodeRequest, len(nodes))
+	var untouched []nodeRequest
+	for _, n := range nodes {
+		var proxy map[string]any
+		if err := json.Unmarshal([]byte(n.ClashConfig), &proxy); err != nil || proxy == nil {
+			untouched = append(untouched, n)
+			continue
+		}
+		if _, ok := proxy["name"]; !ok {
+			proxy["name"] = n.NodeName
+		}
+		sources[reflect.ValueOf(proxy).Pointer()] = n
+		proxies = append(proxies, proxy)
+	}
+
+	result := make([]nodeRequest, 0, len(nodes))
+	for _, proxy := range pipeline.Apply(proxies) {
+		n := sources[reflect.ValueOf(proxy).Pointer()]
+		clashConfig, err := json.Marshal(proxy)
+		if err != nil {
+			continue
+		}
+		if n.ParsedConfig == n.ClashConfig {
+			n.ParsedConfig = string(clashConfig)
+		}
+		n.ClashConfig = string(clashConfig)
+		if name, _ := proxy["name"].(string); name != "" {
+			n.NodeName = name
+		}
+		result = append(result, n)
+	}
+	return append(result, untouched...)
+}
+
 func (h *nodesHandler) handleUpdate(w http.ResponseWriter, r *http.Request, idSegment string) {
 	username := auth.UsernameFromContext(r.Context())
 	if username == "" {
@@ -439,6 +495,8 @@ func (h *nodesHandler) handleUpdate(w http.ResponseWriter, r *http.Request, idSe
 		}
 	}
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]any{
 		"node": convertNode(updated),
 	})
@@ -527,6 +585,8 @@ func (h *nodesHandler) handleUpdateServer(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]any{
 		"node": convertNode(updated),
 	})
@@ -603,6 +663,8 @@ func (h *nodesHandler) handleRestoreServer(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]any{
 		"node": convertNode(updated),
 	})
@@ -685,6 +747,8 @@ func (h *nodesHandler) handleUpdateConfig(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]any{
 		"node": convertNode(updated),
 	})
@@ -733,6 +797,8 @@ func (h *nodesHandler) handleDelete(w http.ResponseWriter, r *http.Request, idSe
 	// 刷新所有绑定模板的订阅（异步执行）
 	go RefreshAllTemplateSubscriptions(h.repo, username)
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
@@ -751,6 +817,8 @@ func (h *nodesHandler) handleClearAll(w http.ResponseWriter, r *http.Request) {
 	// 刷新所有绑定模板的订阅（异步执行）
 	go RefreshAllTemplateSubscriptions(h.repo, username)
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]string{"status": "cleared"})
 }
 
@@ -811,6 +879,8 @@ func (h *nodesHandler) handleBatchDelete(w http.ResponseWriter, r *http.Request)
 		go RefreshAllTemplateSubscriptions(h.repo, username)
 	}
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]any{
 		"status":  "deleted",
 		"deleted": deletedCount,
@@ -912,6 +982,8 @@ func (h *nodesHandler) handleBatchRename(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	invalidateNodeTagProxyProviders(r.Context(), h.repo, username)
+
 	respondJSON(w, http.StatusOK, map[string]any{
 		"status":  "renamed",
 		"success": successCount,
@@ -944,6 +1016,9 @@ type nodeDTO struct {
 	ProbeServer    string    `json:"probe_server"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+
+	// 绑定探针服务器的实时状态，仅节点列表与绑定接口返回
+	ProbeStatus *probeServerStatus `json:"probe_status,omitempty"`
 }
 
 func convertNode(node storage.Node) nodeDTO {
@@ -982,6 +1057,9 @@ func (h *nodesHandler) handleFetchSubscription(w http.ResponseWriter, r *http.Re
 		URL            string `json:"url"`
 		UserAgent      string `json:"user_agent"`
 		SkipCertVerify bool   `json:"skip_cert_verify"`
+
+		// 未保存的订阅可直接指定出站代理、超时、请求头和证书指纹（skip_cert_verify 使用上面的字段）
+		upstreamSettingsRequest
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1012,39 +1090,39 @@ func (h *nodesHandler) handleFetchSubscription(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	httpReq, err := http.NewRequest("GET", req.URL, nil)
-	if err != nil {
+	if _, err := url.ParseRequestURI(req.URL); err != nil {
 		writeError(w, http.StatusBadRequest, errors.New("无效的订阅URL"))
 		return
 	}
 
-	// 添加User-Agent头
-	httpReq.Header.Set("User-Agent", userAgent)
+	// 已保存的外部订阅使用其出站设置和条件请求，未变化时复用缓存内容
+	upstream := &storage.ExternalSubscription{URL: req.URL}
+	if err := req.applyTo(upstream); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if h.repo != nil {
+		if saved, err := h.repo.GetExternalSubscriptionByURL(r.Context(), username, req.URL); err == nil {
+			upstream = &saved
+		}
+	}
 
 	logger.Info("[订阅获取] 开始请求外部订阅", "url", req.URL, "user_agent", userAgent, "skip_cert_verify", req.SkipCertVerify)
 
-	resp, err := client.Do(httpReq)
+	resp, err := fetchUpstreamSubscription(r.Context(), client, upstream, userAgent)
 	if err != nil {
 		logger.Info("[订阅获取] 请求失败", "url", req.URL, "error", err)
 		writeError(w, http.StatusBadRequest, errors.New("无法获取订阅内容: "+err.Error()))
 		return
 	}
-	defer resp.Body.Close()
 
 	logger.Info("[订阅获取] 收到响应",
 		"url", req.URL,
 		"status_code", resp.StatusCode,
-		"status", resp.Status,
-		"content_type", resp.Header.Get("Content-Type"),
-		"content_length", resp.ContentLength)
+		"not_modified", resp.NotModified,
+		"content_type", resp.Header.Get("Content-Type"))
 
-	// 读取响应内容（无论成功还是失败都需要读取以便记录日志）
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Info("[订阅获取] 读取响应体失败", "url", req.URL, "error", err)
-		writeError(w, http.StatusInternalServerError, errors.New("读取订阅内容失败"))
-		return
-	}
+	body := resp.Body
 
 	logger.Info("[订阅获取] 响应体大小", "url", req.URL, "size", len(body))
 
@@ -1057,9 +1135,8 @@ func (h *nodesHandler) handleFetchSubscription(w http.ResponseWriter, r *http.Re
 		logger.Info("[订阅获取] 服务器返回错误状态",
 			"url", req.URL,
 			"status_code", resp.StatusCode,
-			"status", resp.Status,
 			"response_preview", bodyPreview)
-		writeError(w, http.StatusBadRequest, fmt.Errorf("订阅服务器返回错误状态: %d %s", resp.StatusCode, resp.Status))
+		writeError(w, http.StatusBadRequest, fmt.Errorf("订阅服务器返回错误状态: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
 		return
 	}
 
@@ -1091,22 +1168,12 @@ func (h *nodesHandler) handleFetchSubscription(w http.ResponseWriter, r *http.Re
 		if trafficTotal == 0 {
 			logger.Info("[订阅获取] v2ray格式未获取到流量信息，尝试使用 clash-meta UA 获取")
 			clashMetaUA := "clash-meta/2.4.0"
-			trafficReq, err := http.NewRequest("GET", req.URL, nil)
-			if err == nil {
-				trafficReq.Header.Set("User-Agent", clashMetaUA)
-				trafficResp, err := client.Do(trafficReq)
-				if err == nil {
-					defer trafficResp.Body.Close()
-					if trafficResp.StatusCode == http.StatusOK {
-						trafficUserInfo := trafficResp.Header.Get("subscription-userinfo")
-						if trafficUserInfo != "" {
-							trafficUpload, trafficDownload, trafficTotal, trafficExpire = ParseTrafficInfoHeader(trafficUserInfo)
-							logger.Info("[订阅获取] clash-meta UA 获取流量信息成功", "upload", trafficUpload, "download", trafficDownload, "total", trafficTotal)
-						}
-					}
-				} else {
-					logger.Info("[订阅获取] clash-meta UA 请求失败", "error", err)
-				}
+			trafficUserInfo, err := fetchUpstreamUserInfo(r.Context(), client, upstream, clashMetaUA)
+			if err != nil {
+				logger.Info("[订阅获取] clash-meta UA 请求失败", "error", err)
+			} else if trafficUserInfo != "" {
+				trafficUpload, trafficDownload, trafficTotal, trafficExpire = ParseTrafficInfoHeader(trafficUserInfo)
+				logger.Info("[订阅获取] clash-meta UA 获取流量信息成功", "upload", trafficUpload, "download", trafficDownload, "total", trafficTotal)
 			}
 		}
 
@@ -1222,15 +1289,23 @@ func (h *nodesHandler) handleUpdateProbeBinding(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// probe_server 为 "探针名/服务器名"，也可分别传入 probe 与 server
 	var req struct {
 		ProbeServer string `json:"probe_server"`
+		Probe       string `json:"probe"`
+		Server      string `json:"server"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeBadRequest(w, "请求格式不正确")
 		return
 	}
 
-	if err := h.repo.UpdateNodeProbeServer(r.Context(), nodeID, username, req.ProbeServer); err != nil {
+	binding := strings.TrimSpace(req.ProbeServer)
+	if binding == "" && strings.TrimSpace(req.Server) != "" {
+		binding = storage.FormatProbeBinding(req.Probe, req.Server)
+	}
+
+	if err := h.repo.UpdateNodeProbeServer(r.Context(), nodeID, username, binding); err != nil {
 		if errors.Is(err, storage.ErrNodeNotFound) {
 			writeError(w, http.StatusNotFound, errors.New("节点不存在"))
 			return
@@ -1245,7 +1320,10 @@ func (h *nodesHandler) handleUpdateProbeBinding(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	dtos := []nodeDTO{convertNode(node)}
+	attachProbeStatus(r.Context(), h.repo, dtos)
+
 	respondJSON(w, http.StatusOK, map[string]any{
-		"node": convertNode(node),
+		"node": dtos[0],
 	})
 }
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"miaomiaowu/internal/probe"
 	"miaomiaowu/internal/storage"
 )
 
@@ -17,31 +18,44 @@ type probeConfigHandler struct {
 }
 
 type probeServerPayload struct {
-	ID                  int64   `json:"id"`
-	ServerID            string  `json:"server_id"`
-	Name                string  `json:"name"`
-	TrafficMethod       string  `json:"traffic_method"`
-	MonthlyTrafficGB    float64 `json:"monthly_traffic_gb"`
-	MonthlyTrafficBytes int64   `json:"monthly_traffic_bytes"`
-	Position            int     `json:"position"`
+	ID                  int64      `json:"id"`
+	ServerID            string     `json:"server_id"`
+	Name                string     `json:"name"`
+	TrafficMethod       string     `json:"traffic_method"`
+	MonthlyTrafficGB    float64    `json:"monthly_traffic_gb"`
+	MonthlyTrafficBytes int64      `json:"monthly_traffic_bytes"`
+	ResetDay            int        `json:"reset_day"`
+	TrafficMultiplier   float64    `json:"traffic_multiplier"`
+	Rollover            bool       `json:"rollover"`
+	NextReset           *time.Time `json:"next_reset,omitempty"`
+	Position            int        `json:"position"`
 }
 
 type probeConfigPayload struct {
+	ID        int64                `json:"id"`
+	Name      string               `json:"name"`
 	ProbeType string               `json:"probe_type"`
 	Address   string               `json:"address"`
+	Options   map[string]string    `json:"options,omitempty"`
 	Servers   []probeServerPayload `json:"servers"`
 	CreatedAt time.Time            `json:"created_at"`
 	UpdatedAt time.Time            `json:"updated_at"`
 }
 
 type probeConfigUpdateRequest struct {
+	Name      string `json:"name"`
 	ProbeType string `json:"probe_type"`
 	Address   string `json:"address"`
-	Servers   []struct {
-		ServerID         string  `json:"server_id"`
-		Name             string  `json:"name"`
-		TrafficMethod    string  `json:"traffic_method"`
-		MonthlyTrafficGB float64 `json:"monthly_traffic_gb"`
+	// Options 为通用探针（prometheus / json）的查询与字段映射
+	Options map[string]string `json:"options"`
+	Servers []struct {
+		ServerID          string  `json:"server_id"`
+		Name              string  `json:"name"`
+		TrafficMethod     string  `json:"traffic_method"`
+		MonthlyTrafficGB  float64 `json:"monthly_traffic_gb"`
+		ResetDay          int     `json:"reset_day"`
+		TrafficMultiplier float64 `json:"traffic_multiplier"`
+		Rollover          bool    `json:"rollover"`
 	} `json:"servers"`
 }
 
@@ -54,46 +68,142 @@ func NewProbeConfigHandler(repo *storage.TrafficRepository) http.Handler {
 }
 
 func (h *probeConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// ?id= 指定某个探针配置；不带 id 时 PUT/DELETE 作用于首个/全部配置以兼容旧版前端
+	id, hasID, ok := parseProbeConfigID(w, r)
+	if !ok {
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		h.handleGet(w, r)
+		h.handleGet(w, r, id, hasID)
+	case http.MethodPost:
+		h.handleCreate(w, r)
 	case http.MethodPut:
-		h.handleUpdate(w, r)
+		h.handleUpdate(w, r, id, hasID)
 	case http.MethodDelete:
-		h.handleDelete(w, r)
+		h.handleDelete(w, r, id, hasID)
 	default:
-		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+		methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
 	}
 }
 
-func (h *probeConfigHandler) handleGet(w http.ResponseWriter, r *http.Request) {
-	cfg, err := h.repo.GetProbeConfig(r.Context())
-	if err != nil {
-		if errors.Is(err, storage.ErrProbeConfigNotFound) {
-			// Return empty config instead of 404 when not configured yet
-			respondJSON(w, http.StatusOK, map[string]any{
-				"config": probeConfigPayload{
-					ProbeType: "nezha",
-					Address:   "",
-					Servers:   []probeServerPayload{},
-				},
-			})
+func parseProbeConfigID(w http.ResponseWriter, r *http.Request) (int64, bool, bool) {
+	raw := strings.TrimSpace(r.URL.Query().Get("id"))
+	if raw == "" {
+		return 0, false, true
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		writeBadRequest(w, "无效的探针配置ID")
+		return 0, false, false
+	}
+	return id, true, true
+}
+
+func (h *probeConfigHandler) handleGet(w http.ResponseWriter, r *http.Request, id int64, hasID bool) {
+	if hasID {
+		cfg, err := h.repo.GetProbeConfigByID(r.Context(), id)
+		if err != nil {
+			writeProbeConfigError(w, err)
 			return
 		}
+		respondJSON(w, http.StatusOK, map[string]any{
+			"config": convertProbeConfigResponse(cfg),
+		})
+		return
+	}
+
+	configs, err := h.repo.ListProbeConfigs(r.Context())
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	payloads := make([]probeConfigPayload, 0, len(configs))
+	for _, cfg := range configs {
+		payloads = append(payloads, convertProbeConfigResponse(cfg))
+	}
+
+	// Return empty config instead of 404 when not configured yet
+	first := probeConfigPayload{
+		ProbeType: "nezha",
+		Address:   "",
+		Servers:   []probeServerPayload{},
+	}
+	if len(payloads) > 0 {
+		first = payloads[0]
+	}
+
 	respondJSON(w, http.StatusOK, map[string]any{
-		"config": convertProbeConfigResponse(cfg),
+		"config":  first,
+		"configs": payloads,
+	})
+}
+
+func (h *probeConfigHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := decodeProbeConfigRequest(w, r)
+	if !ok {
+		return
+	}
+	if cfg.Name == "" {
+		writeBadRequest(w, "探针名称不能为空")
+		return
+	}
+
+	created, err := h.repo.CreateProbeConfig(r.Context(), cfg)
+	if err != nil {
+		writeProbeConfigError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"config": convertProbeConfigResponse(created),
+	})
+}
+
+func (h *probeConfigHandler) handleUpdate(w http.ResponseWriter, r *http.Request, id int64, hasID bool) {
+	cfg, ok := decodeProbeConfigRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		updated storage.ProbeConfig
+		err     error
+	)
+	if hasID {
+		if cfg.Name == "" {
+			writeBadRequest(w, "探针名称不能为空")
+			return
+		}
+		cfg.ID = id
+		updated, err = h.repo.UpdateProbeConfig(r.Context(), cfg)
+	} else {
+		updated, err = h.repo.UpsertProbeConfig(r.Context(), cfg)
+	}
+	if err != nil {
+		writeProbeConfigError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"config": convertProbeConfigResponse(updated),
 	})
 }
 
-func (h *probeConfigHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+// decodeProbeConfigRequest 解析并校验探针配置请求，校验失败时已写入响应
+func decodeProbeConfigRequest(w http.ResponseWriter, r *http.Request) (storage.ProbeConfig, bool) {
 	var payload probeConfigUpdateRequest
 	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&payload); err != nil {
 		writeBadRequest(w, "请求数据格式错误")
-		return
+		return storage.ProbeConfig{}, false
+	}
+
+	probeName := strings.TrimSpace(payload.Name)
+	if strings.Contains(probeName, storage.ProbeBindingSeparator) {
+		writeBadRequest(w, "探针名称不能包含 "+storage.ProbeBindingSeparator)
+		return storage.ProbeConfig{}, false
 	}
 
 	type sanitizedServer struct {
@@ -101,23 +211,32 @@ func (h *probeConfigHandler) handleUpdate(w http.ResponseWriter, r *http.Request
 		Name                string
 		TrafficMethod       string
 		MonthlyTrafficBytes int64
+		ResetDay            int
+		TrafficMultiplier   float64
+		Rollover            bool
 	}
 
 	probeType := strings.ToLower(strings.TrimSpace(payload.ProbeType))
 	if _, ok := getAllowedProbeTypes()[probeType]; !ok {
 		writeBadRequest(w, "不支持的探针类型")
-		return
+		return storage.ProbeConfig{}, false
 	}
 
 	address := strings.TrimSpace(payload.Address)
 	if address == "" {
 		writeBadRequest(w, "探针地址不能为空")
-		return
+		return storage.ProbeConfig{}, false
+	}
+
+	options := normalizeProbeOptions(payload.Options)
+	if message := validateProbeOptions(probeType, options); message != "" {
+		writeBadRequest(w, message)
+		return storage.ProbeConfig{}, false
 	}
 
 	if len(payload.Servers) == 0 {
 		writeBadRequest(w, "请至少配置一个服务器")
-		return
+		return storage.ProbeConfig{}, false
 	}
 
 	allowedMethods := getAllowedTrafficMethods()
@@ -126,24 +245,24 @@ func (h *probeConfigHandler) handleUpdate(w http.ResponseWriter, r *http.Request
 		serverID := strings.TrimSpace(srv.ServerID)
 		if serverID == "" {
 			writeBadRequest(w, formatServerError(idx, "服务器 ID 不能为空"))
-			return
+			return storage.ProbeConfig{}, false
 		}
 
 		name := strings.TrimSpace(srv.Name)
 		if name == "" {
 			writeBadRequest(w, formatServerError(idx, "服务器名称不能为空"))
-			return
+			return storage.ProbeConfig{}, false
 		}
 
 		method := strings.ToLower(strings.TrimSpace(srv.TrafficMethod))
 		if _, ok := allowedMethods[method]; !ok {
 			writeBadRequest(w, formatServerError(idx, "不支持的流量计算方式"))
-			return
+			return storage.ProbeConfig{}, false
 		}
 
 		if srv.MonthlyTrafficGB < 0 {
 			writeBadRequest(w, formatServerError(idx, "月流量不能为负数"))
-			return
+			return storage.ProbeConfig{}, false
 		}
 
 		monthlyBytes := int64(math.Round(srv.MonthlyTrafficGB * bytesPerGigabyte))
@@ -151,11 +270,28 @@ func (h *probeConfigHandler) handleUpdate(w http.ResponseWriter, r *http.Request
 			monthlyBytes = 0
 		}
 
+		if srv.ResetDay < 0 || srv.ResetDay > 31 {
+			writeBadRequest(w, formatServerError(idx, "重置日需在 1-31 之间，0 表示不按账期计算"))
+			return storage.ProbeConfig{}, false
+		}
+
+		multiplier := srv.TrafficMultiplier
+		if multiplier == 0 {
+			multiplier = 1
+		}
+		if multiplier < 0 {
+			writeBadRequest(w, formatServerError(idx, "计费倍率必须大于 0"))
+			return storage.ProbeConfig{}, false
+		}
+
 		sanitized = append(sanitized, sanitizedServer{
 			ServerID:            serverID,
 			Name:                name,
 			TrafficMethod:       method,
 			MonthlyTrafficBytes: monthlyBytes,
+			ResetDay:            srv.ResetDay,
+			TrafficMultiplier:   multiplier,
+			Rollover:            srv.Rollover,
 		})
 	}
 
@@ -166,27 +302,30 @@ func (h *probeConfigHandler) handleUpdate(w http.ResponseWriter, r *http.Request
 			Name:                srv.Name,
 			TrafficMethod:       srv.TrafficMethod,
 			MonthlyTrafficBytes: srv.MonthlyTrafficBytes,
+			ResetDay:            srv.ResetDay,
+			TrafficMultiplier:   srv.TrafficMultiplier,
+			Rollover:            srv.Rollover,
 		})
 	}
 
-	updated, err := h.repo.UpsertProbeConfig(r.Context(), storage.ProbeConfig{
+	return storage.ProbeConfig{
+		Name:      probeName,
 		ProbeType: probeType,
 		Address:   address,
+		Options:   options,
 		Servers:   servers,
-	})
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	respondJSON(w, http.StatusOK, map[string]any{
-		"config": convertProbeConfigResponse(updated),
-	})
+	}, true
 }
 
-func (h *probeConfigHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
-	if err := h.repo.DeleteProbeConfig(r.Context()); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+func (h *probeConfigHandler) handleDelete(w http.ResponseWriter, r *http.Request, id int64, hasID bool) {
+	var err error
+	if hasID {
+		err = h.repo.DeleteProbeConfigByID(r.Context(), id)
+	} else {
+		err = h.repo.DeleteProbeConfig(r.Context())
+	}
+	if err != nil {
+		writeProbeConfigError(w, err)
 		return
 	}
 
@@ -200,6 +339,11 @@ func convertProbeConfigResponse(cfg storage.ProbeConfig) probeConfigPayload {
 	for _, srv := range cfg.Servers {
 		gb := float64(srv.MonthlyTrafficBytes) / bytesPerGigabyte
 		gb = math.Round(gb*100) / 100
+		var nextReset *time.Time
+		if srv.ResetDay > 0 {
+			next := nextBillingReset(time.Now(), srv.ResetDay)
+			nextReset = &next
+		}
 		servers = append(servers, probeServerPayload{
 			ID:                  srv.ID,
 			ServerID:            srv.ServerID,
@@ -207,30 +351,75 @@ func convertProbeConfigResponse(cfg storage.ProbeConfig) probeConfigPayload {
 			TrafficMethod:       srv.TrafficMethod,
 			MonthlyTrafficGB:    gb,
 			MonthlyTrafficBytes: srv.MonthlyTrafficBytes,
+			ResetDay:            srv.ResetDay,
+			TrafficMultiplier:   srv.TrafficMultiplier,
+			Rollover:            srv.Rollover,
+			NextReset:           nextReset,
 			Position:            srv.Position,
 		})
 	}
 
 	return probeConfigPayload{
+		ID:        cfg.ID,
+		Name:      cfg.Name,
 		ProbeType: cfg.ProbeType,
 		Address:   cfg.Address,
+		Options:   cfg.Options,
 		Servers:   servers,
 		CreatedAt: cfg.CreatedAt,
 		UpdatedAt: cfg.UpdatedAt,
 	}
 }
 
+func writeProbeConfigError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrProbeConfigNotFound):
+		writeError(w, http.StatusNotFound, errors.New("探针配置不存在"))
+	case errors.Is(err, storage.ErrProbeConfigExists):
+		writeError(w, http.StatusConflict, errors.New("探针名称已存在"))
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
 func formatServerError(idx int, message string) string {
 	return message + " (行" + strconv.Itoa(idx+1) + ")"
 }
 
 func getAllowedProbeTypes() map[string]struct{} {
-	return map[string]struct{}{
-		storage.ProbeTypeNezha:   {},
-		storage.ProbeTypeNezhaV0: {},
-		storage.ProbeTypeDstatus: {},
-		storage.ProbeTypeKomari:  {},
+	types := make(map[string]struct{})
+	for _, probeType := range probe.GetDefaultFactory().GetSupportedTypes() {
+		types[probeType] = struct{}{}
+	}
+	return types
+}
+
+// normalizeProbeOptions 去除选项中的空白键值
+func normalizeProbeOptions(options map[string]string) map[string]string {
+	normalized := make(map[string]string, len(options))
+	for key, value := range options {
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if key != "" && value != "" {
+			normalized[key] = value
+		}
+	}
+	return normalized
+}
+
+// validateProbeOptions 校验通用探针（prometheus / json）的必填选项，返回错误提示，合法时返回空字符串
+func validateProbeOptions(probeType string, options map[string]string) string {
+	switch probeType {
+	case storage.ProbeTypePrometheus:
+		if options["up_query"] == "" && options["down_query"] == "" {
+			return "Prometheus 探针需配置 up_query 或 down_query"
+		}
+	case storage.ProbeTypeJSON:
+		if options["up_field"] == "" && options["down_field"] == "" {
+			return "JSON 探针需配置 up_field 或 down_field"
+		}
 	}
+	return ""
 }
 
 func getAllowedTrafficMethods() map[string]struct{} {
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 探针服务器按账期计费：按重置日划分周期，已用流量为周期开始以来探针计数的增量乘以计费倍率。
+// 周期内探针计数回落（如服务器重启）时，回落前的增量会被累计保留。
+// 首次观测时以账期开始前后的每日快照推算周期起点的计数；找不到可用快照时从当前计数开始，并标记为不完整周期。
+
+// probeBillingMu 串行化账期状态的读取与更新，避免并发请求按旧状态覆盖
+var probeBillingMu sync.Mutex
+
+// billingCycleStart 返回 now 所在账期的开始时间；当月天数不足重置日时在月末重置
+func billingCycleStart(now time.Time, resetDay int) time.Time {
+	start := billingResetDate(now.Year(), now.Month(), resetDay, now.Location())
+	if now.Before(start) {
+		start = billingResetDate(now.Year(), now.Month()-1, resetDay, now.Location())
+	}
+	return start
+}
+
+// nextBillingReset 返回 now 之后的下一个重置时间
+func nextBillingReset(now time.Time, resetDay int) time.Time {
+	start := billingCycleStart(now, resetDay)
+	return billingResetDate(start.Year(), start.Month()+1, resetDay, now.Location())
+}
+
+func billingResetDate(year int, month time.Month, resetDay int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+	if resetDay > daysInMonth {
+		resetDay = daysInMonth
+	}
+	return first.AddDate(0, 0, resetDay-1)
+}
+
+// applyProbeBilling 将探针返回的原始计数换算为计费流量
+func (h *TrafficSummaryHandler) applyProbeBilling(ctx context.Context, cfg storage.ProbeConfig, usages []probeServerUsage, now time.Time) []probeServerUsage {
+	probeBillingMu.Lock()
+	defer probeBillingMu.Unlock()
+
+	for i := range usages {
+		usage := &usages[i]
+		srv := usage.Server
+
+		multiplier := srv.TrafficMultiplier
+		if multiplier <= 0 {
+			multiplier = 1
+		}
+
+		if srv.ResetDay <= 0 {
+			usage.Used = scaleTraffic(usage.Used, multiplier)
+		} else {
+			used, carry, partial, err := h.advanceBillingCycle(ctx, cfg.ID, srv, usage.Binding(), usage.Used, usage.Limit, multiplier, now)
+			usage.PartialCycle = partial
+			if err != nil {
+				logger.Warn("[流量获取] 更新账期状态失败，使用原始计数", "probe", cfg.Name, "server_id", srv.ServerID, "error", err)
+				used = scaleTraffic(usage.Used, multiplier)
+			}
+			usage.Used = used
+			usage.Limit += carry
+			next := nextBillingReset(now, srv.ResetDay)
+			usage.NextReset = &next
+		}
+
+		if usage.Used < 0 {
+			usage.Used = 0
+		}
+		if usage.Limit > 0 && usage.Used > usage.Limit {
+			usage.Used = usage.Limit
+		}
+		usage.Remaining = usage.Limit - usage.Used
+	}
+
+	return usages
+}
+
+// advanceBillingCycle 根据本次观测到的原始计数推进账期状态，返回本周期计费流量、结转的流量以及周期是否不完整
+func (h *TrafficSummaryHandler) advanceBillingCycle(ctx context.Context, configID int64, srv storage.ProbeServer, binding string, raw, limit int64, multiplier float64, now time.Time) (int64, int64, bool, error) {
+	start := billingCycleStart(now, srv.ResetDay)
+
+	cycle, ok, err := h.repo.GetProbeServerCycle(ctx, configID, srv.ServerID)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	switch {
+	case !ok:
+		// 首次观测：优先用周期开始时的快照推算基准，否则从当前计数开始并标记为不完整周期
+		cycle = storage.ProbeServerCycle{ConfigID: configID, ServerID: srv.ServerID, CycleStart: start, Baseline: raw, LastRaw: raw, Partial: true}
+		if baseline, found := h.cycleStartBaseline(ctx, binding, start, raw, multiplier); found {
+			cycle.Baseline = baseline
+			cycle.Partial = false
+		} else {
+			logger.Info("[流量获取] 没有账期开始时的快照，本周期流量从首次观测开始统计", "binding", binding, "cycle_start", start.Format("2006-01-02"))
+		}
+	case !cycle.CycleStart.Equal(start):
+		// 进入新周期：以上次观测值作为基准，记录上一周期的计费流量用于结转
+		previousStart := cycle.CycleStart
+		cycle.PreviousCycleStart = &previousStart
+		cycle.PreviousUsed = scaleTraffic(cycle.Accumulated+cycle.LastRaw-cycle.Baseline, multiplier)
+		cycle.CycleStart = start
+		cycle.Accumulated = 0
+		cycle.Partial = false
+		cycle.Baseline = cycle.LastRaw
+		if raw < cycle.Baseline {
+			cycle.Baseline = 0
+		}
+		cycle.LastRaw = raw
+	default:
+		if raw < cycle.LastRaw {
+			// 探针计数回落，保留回落前的增量
+			cycle.Accumulated += cycle.LastRaw - cycle.Baseline
+			cycle.Baseline = 0
+		}
+		cycle.LastRaw = raw
+	}
+
+	if err := h.repo.SaveProbeServerCycle(ctx, cycle); err != nil {
+		return 0, 0, cycle.Partial, err
+	}
+
+	used := scaleTraffic(cycle.Accumulated+cycle.LastRaw-cycle.Baseline, multiplier)
+
+	var carry int64
+	if srv.Rollover && cycle.PreviousCycleStart != nil && limit > 0 {
+		// 仅结转紧邻的上一周期
+		expected := billingCycleStart(start.AddDate(0, 0, -1), srv.ResetDay)
+		if cycle.PreviousCycleStart.Equal(expected) && cycle.PreviousUsed < limit {
+			carry = limit - cycle.PreviousUsed
+		}
+	}
+
+	return used, carry, cycle.Partial, nil
+}
+
+// cycleStartBaseline 用账期开始前一天或当天的每日快照推算周期起点的原始计数
+// 快照记录的是按倍率换算后的流量；达到限额被截断、或晚于当前计数（探针计数已回落）的快照不可用
+func (h *TrafficSummaryHandler) cycleStartBaseline(ctx context.Context, binding string, start time.Time, raw int64, multiplier float64) (int64, bool) {
+	records, err := h.repo.ListTrafficSourceRecords(ctx, storage.TrafficSourceFilter{
+		SourceType: storage.TrafficSourceProbeServer,
+		SourceKey:  binding,
+		From:       start.AddDate(0, 0, -1),
+		To:         start,
+	})
+	if err != nil {
+		logger.Warn("[流量获取] 读取账期开始时的快照失败", "binding", binding, "error", err)
+		return 0, false
+	}
+	// 按日期升序，最早的一条最接近重置时刻
+	for _, record := range records {
+		if record.TotalLimit > 0 && record.TotalUsed >= record.TotalLimit {
+			continue
+		}
+		baseline := int64(math.Round(float64(record.TotalUsed) / multiplier))
+		if baseline < 0 || baseline > raw {
+			continue
+		}
+		return baseline, true
+	}
+	return 0, false
+}
+
+func scaleTraffic(bytes int64, multiplier float64) int64 {
+	if multiplier == 1 {
+		return bytes
+	}
+	return int64(math.Round(float64(bytes) * multiplier))
+}
+
+// earliestProbeReset 返回各服务器中最近的下一次重置时间
+func earliestProbeReset(usages []probeServerUsage) *time.Time {
+	var earliest *time.Time
+	for _, usage := range usages {
+		if usage.NextReset != nil && (earliest == nil || usage.NextReset.Before(*earliest)) {
+			earliest = usage.NextReset
+		}
+	}
+	return earliest
+}
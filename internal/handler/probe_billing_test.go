@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"miaomiaowu/internal/storage"
+)
+
+func TestBillingCycleStart(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name      string
+		now       time.Time
+		resetDay  int
+		wantStart time.Time
+		wantNext  time.Time
+	}{
+		{"after reset day", date(2026, 5, 20).Add(8 * time.Hour), 15, date(2026, 5, 15), date(2026, 6, 15)},
+		{"on reset day", date(2026, 5, 15), 15, date(2026, 5, 15), date(2026, 6, 15)},
+		{"before reset day", date(2026, 5, 10), 15, date(2026, 4, 15), date(2026, 5, 15)},
+		{"january wraps to december", date(2026, 1, 3), 10, date(2025, 12, 10), date(2026, 1, 10)},
+		{"day 31 in february", date(2026, 2, 28).Add(time.Hour), 31, date(2026, 2, 28), date(2026, 3, 31)},
+		{"day 31 before month end of february", date(2026, 2, 27), 31, date(2026, 1, 31), date(2026, 2, 28)},
+		{"day 29 in non-leap february", date(2026, 3, 1), 29, date(2026, 2, 28), date(2026, 3, 29)},
+		{"day 29 in leap february", date(2028, 2, 29), 29, date(2028, 2, 29), date(2028, 3, 29)},
+		{"day 31 in april", date(2026, 4, 30), 31, date(2026, 4, 30), date(2026, 5, 31)},
+		{"day 30 in april before reset", date(2026, 4, 29), 30, date(2026, 3, 30), date(2026, 4, 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := billingCycleStart(tt.now, tt.resetDay); !got.Equal(tt.wantStart) {
+				t.Errorf("billingCycleStart(%s, %d) = %s, want %s", tt.now, tt.resetDay, got, tt.wantStart)
+			}
+			if got := nextBillingReset(tt.now, tt.resetDay); !got.Equal(tt.wantNext) {
+				t.Errorf("nextBillingReset(%s, %d) = %s, want %s", tt.now, tt.resetDay, got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func newTestBillingHandler(t *testing.T) *TrafficSummaryHandler {
+	t.Helper()
+	repo, err := storage.NewTrafficRepository(filepath.Join(t.TempDir(), "traffic.db"))
+	if err != nil {
+		t.Fatalf("NewTrafficRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return &TrafficSummaryHandler{repo: repo}
+}
+
+type billingObservation struct {
+	now         time.Time
+	raw         int64
+	wantUsed    int64
+	wantCarry   int64
+	wantPartial bool
+}
+
+func runBillingObservations(t *testing.T, h *TrafficSummaryHandler, srv storage.ProbeServer, limit int64, multiplier float64, steps []billingObservation) {
+	t.Helper()
+	for i, step := range steps {
+		used, carry, partial, err := h.advanceBillingCycle(context.Background(), 1, srv, "probe/srv", step.raw, limit, multiplier, step.now)
+		if err != nil {
+			t.Fatalf("step %d: advanceBillingCycle: %v", i, err)
+		}
+		if used != step.wantUsed || carry != step.wantCarry || partial != step.wantPartial {
+			t.Fatalf("step %d (%s, raw %d): used=%d carry=%d partial=%v, want used=%d carry=%d partial=%v",
+				i, step.now.Format("2006-01-02"), step.raw, used, carry, partial, step.wantUsed, step.wantCarry, step.wantPartial)
+		}
+	}
+}
+
+func TestAdvanceBillingCycle(t *testing.T) {
+	day := func(m time.Month, d int) time.Time { return time.Date(2026, m, d, 12, 0, 0, 0, time.UTC) }
+
+	t.Run("first observation without snapshot is partial", func(t *testing.T) {
+		h := newTestBillingHandler(t)
+		srv := storage.ProbeServer{ServerID: "srv", ResetDay: 1}
+		runBillingObservations(t, h, srv, 1000, 1, []billingObservation{
+			{now: day(5, 10), raw: 500, wantUsed: 0, wantPartial: true},
+			{now: day(5, 11), raw: 700, wantUsed: 200, wantPartial: true},
+			// 新周期以上次观测为基准，不再是不完整周期
+			{now: day(6, 2), raw: 750, wantUsed: 50},
+		})
+	})
+
+	t.Run("first observation seeded from cycle start snapshot", func(t *testing.T) {
+		h := newTestBillingHandler(t)
+		if err := h.repo.RecordTrafficSources(context.Background(), time.Date(2026, 4, 30, 0, 0, 0, 0, time.UTC), []storage.TrafficSourceRecord{{
+			SourceType: storage.TrafficSourceProbeServer,
+			SourceKey:  "probe/srv",
+			TotalLimit: 10000,
+			TotalUsed:  600, // 按 2 倍换算后的流量
+		}}); err != nil {
+			t.Fatalf("RecordTrafficSources: %v", err)
+		}
+		srv := storage.ProbeServer{ServerID: "srv", ResetDay: 1}
+		runBillingObservations(t, h, srv, 10000, 2, []billingObservation{
+			{now: day(5, 10), raw: 500, wantUsed: 400}, // (500-300)*2
+		})
+	})
+
+	t.Run("snapshot capped at the limit is ignored", func(t *testing.T) {
+		h := newTestBillingHandler(t)
+		if err := h.repo.RecordTrafficSources(context.Background(), time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC), []storage.TrafficSourceRecord{{
+			SourceType: storage.TrafficSourceProbeServer,
+			SourceKey:  "probe/srv",
+			TotalLimit: 100,
+			TotalUsed:  100,
+		}}); err != nil {
+			t.Fatalf("RecordTrafficSources: %v", err)
+		}
+		srv := storage.ProbeServer{ServerID: "srv", ResetDay: 1}
+		runBillingObservations(t, h, srv, 100, 1, []billingObservation{
+			{now: day(5, 10), raw: 500, wantUsed: 0, wantPartial: true},
+		})
+	})
+
+	t.Run("multiplier and counter reset within cycle", func(t *testing.T) {
+		h := newTestBillingHandler(t)
+		srv := storage.ProbeServer{ServerID: "srv", ResetDay: 1}
+		runBillingObservations(t, h, srv, 0, 1.5, []billingObservation{
+			{now: day(5, 10), raw: 100, wantUsed: 0, wantPartial: true},
+			{now: day(5, 11), raw: 300, wantUsed: 300, wantPartial: true}, // 200*1.5
+			{now: day(5, 12), raw: 50, wantUsed: 375, wantPartial: true},  // 回落：保留 200，再加 50
+			{now: day(5, 13), raw: 150, wantUsed: 525, wantPartial: true}, // (200+150)*1.5
+		})
+	})
+
+	t.Run("rollover carries unused quota of the previous cycle", func(t *testing.T) {
+		h := newTestBillingHandler(t)
+		srv := storage.ProbeServer{ServerID: "srv", ResetDay: 31, Rollover: true}
+		runBillingObservations(t, h, srv, 1000, 1, []billingObservation{
+			{now: day(1, 31), raw: 0, wantUsed: 0, wantPartial: true},
+			{now: day(2, 27), raw: 400, wantUsed: 400, wantPartial: true},
+			// 2 月 28 日（当月最后一天）重置，上一周期用了 400，结转 600
+			{now: day(2, 28), raw: 450, wantUsed: 50, wantCarry: 600},
+			// 跳过一个周期后不再结转
+			{now: day(4, 30), raw: 500, wantUsed: 50},
+		})
+	})
+}
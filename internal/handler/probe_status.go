@@ -0,0 +1,362 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/probe"
+	"miaomiaowu/internal/storage"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 探针服务器实时状态：节点列表展示绑定服务器的在线状态与负载，
+// 开启相应设置后订阅剔除绑定服务器离线的节点。结果按探针配置短暂缓存，避免每次请求都访问面板。
+
+const (
+	probeStatusCacheTTL   = 30 * time.Second
+	probeStatusFetchLimit = 10 * time.Second
+)
+
+// probeServerStatus 绑定服务器的实时状态
+type probeServerStatus struct {
+	Probe         string     `json:"probe"`
+	Server        string     `json:"server"`
+	Online        bool       `json:"online"`
+	CPU           float64    `json:"cpu"` // 百分比
+	MemUsed       int64      `json:"mem_used"`
+	MemTotal      int64      `json:"mem_total"`
+	MemPercentage float64    `json:"mem_percentage"`
+	NetInSpeed    int64      `json:"net_in_speed"`  // 字节/秒
+	NetOutSpeed   int64      `json:"net_out_speed"` // 字节/秒
+	Uptime        int64      `json:"uptime"`        // 秒
+	LastActive    *time.Time `json:"last_active,omitempty"`
+}
+
+type probeStatusCacheEntry struct {
+	configUpdatedAt time.Time // 探针配置修改后缓存失效
+	fetchedAt       time.Time
+	statuses        map[string]probeServerStatus // key: 服务器名称；获取失败时为空，同样缓存以免面板故障时反复请求
+}
+
+// probeStatusCache 探针服务器状态缓存
+type probeStatusCache struct {
+	mu      sync.Mutex
+	entries map[int64]probeStatusCacheEntry // key: probe config ID
+	client  *http.Client
+}
+
+// 全局缓存实例，节点列表与订阅共用
+var probeStatuses = &probeStatusCache{
+	entries: make(map[int64]probeStatusCacheEntry),
+	client:  &http.Client{Timeout: probeStatusFetchLimit},
+}
+
+// probeStatusIndex 按绑定查找服务器状态
+type probeStatusIndex struct {
+	scoped map[string]probeServerStatus // key: "探针名/服务器名"
+	legacy map[string]probeServerStatus // key: 服务器名，兼容只记录服务器名的旧绑定
+}
+
+// lookup 返回绑定服务器的状态；探针不支持状态查询或获取失败时 ok 为 false
+func (idx probeStatusIndex) lookup(binding string) (probeServerStatus, bool) {
+	binding = strings.TrimSpace(binding)
+	if binding == "" {
+		return probeServerStatus{}, false
+	}
+	if status, ok := idx.scoped[binding]; ok {
+		return status, true
+	}
+	status, ok := idx.legacy[binding]
+	return status, ok
+}
+
+// offline 仅在确知绑定服务器离线时返回 true，状态未知时不剔除节点
+func (idx probeStatusIndex) offline(binding string) bool {
+	status, ok := idx.lookup(binding)
+	return ok && !status.Online
+}
+
+// load 返回所有支持状态查询的探针的服务器状态，过期的配置并发刷新
+func (c *probeStatusCache) load(ctx context.Context, repo *storage.TrafficRepository) (probeStatusIndex, error) {
+	idx := probeStatusIndex{
+		scoped: make(map[string]probeServerStatus),
+		legacy: make(map[string]probeServerStatus),
+	}
+
+	configs, err := repo.ListProbeConfigs(ctx)
+	if err != nil {
+		return idx, err
+	}
+
+	now := time.Now()
+	entries := make([]probeStatusCacheEntry, len(configs))
+	var stale []int
+
+	c.mu.Lock()
+	for i, cfg := range configs {
+		entry, ok := c.entries[cfg.ID]
+		if ok && entry.configUpdatedAt.Equal(cfg.UpdatedAt) && now.Sub(entry.fetchedAt) < probeStatusCacheTTL {
+			entries[i] = entry
+			continue
+		}
+		stale = append(stale, i)
+	}
+	c.mu.Unlock()
+
+	if len(stale) > 0 {
+		var wg sync.WaitGroup
+		for _, i := range stale {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				entries[i] = c.fetch(ctx, configs[i], now)
+			}(i)
+		}
+		wg.Wait()
+
+		c.mu.Lock()
+		for _, i := range stale {
+			c.entries[configs[i].ID] = entries[i]
+		}
+		c.mu.Unlock()
+	}
+
+	for i, cfg := range configs {
+		for name, status := range entries[i].statuses {
+			idx.scoped[storage.FormatProbeBinding(cfg.Name, name)] = status
+			if _, exists := idx.legacy[name]; !exists {
+				idx.legacy[name] = status
+			}
+		}
+	}
+	return idx, nil
+}
+
+// fetch 从面板获取单个探针配置下服务器的状态
+func (c *probeStatusCache) fetch(ctx context.Context, cfg storage.ProbeConfig, now time.Time) probeStatusCacheEntry {
+	entry := probeStatusCacheEntry{configUpdatedAt: cfg.UpdatedAt, fetchedAt: now}
+
+	adapter, err := probe.GetDefaultFactory().GetAdapter(cfg.ProbeType)
+	if err != nil {
+		return entry
+	}
+	statusAdapter, ok := adapter.(probe.StatusAdapter)
+	if !ok {
+		// Prometheus、通用 JSON 等探针不提供在线状态
+		return entry
+	}
+
+	serverIDs := make([]string, 0, len(cfg.Servers))
+	for _, srv := range cfg.Servers {
+		if id := strings.TrimSpace(srv.ServerID); id != "" {
+			serverIDs = append(serverIDs, id)
+		}
+	}
+	if len(serverIDs) == 0 {
+		return entry
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, probeStatusFetchLimit)
+	defer cancel()
+
+	reported, err := statusAdapter.FetchStatus(fetchCtx, probe.Target{
+		Address:   cfg.Address,
+		Options:   cfg.Options,
+		ServerIDs: serverIDs,
+		Client:    c.client,
+	})
+	if err != nil {
+		logger.Warn("[探针状态] 获取服务器状态失败", "probe", cfg.Name, "type", cfg.ProbeType, "error", err)
+		return entry
+	}
+
+	observed := make(map[string]probe.ServerStatus, len(reported))
+	for _, status := range reported {
+		observed[status.ServerID] = status
+	}
+
+	entry.statuses = make(map[string]probeServerStatus, len(cfg.Servers))
+	for _, srv := range cfg.Servers {
+		name := strings.TrimSpace(srv.Name)
+		if name == "" {
+			continue
+		}
+		// 面板未返回的服务器视为离线
+		status := observed[strings.TrimSpace(srv.ServerID)]
+		entry.statuses[name] = probeServerStatus{
+			Probe:         cfg.Name,
+			Server:        name,
+			Online:        status.Online,
+			CPU:           roundUpTwoDecimals(status.CPU),
+			MemUsed:       status.MemUsed,
+			MemTotal:      status.MemTotal,
+			MemPercentage: roundUpTwoDecimals(usagePercentage(status.MemUsed, status.MemTotal)),
+			NetInSpeed:    status.NetInSpeed,
+			NetOutSpeed:   status.NetOutSpeed,
+			Uptime:        status.Uptime,
+			LastActive:    status.LastActive,
+		}
+	}
+	return entry
+}
+
+// attachProbeStatus 为绑定了探针服务器的节点填充实时状态，获取失败时不影响节点列表
+func attachProbeStatus(ctx context.Context, repo *storage.TrafficRepository, nodes []nodeDTO) {
+	bound := false
+	for _, node := range nodes {
+		if strings.TrimSpace(node.ProbeServer) != "" {
+			bound = true
+			break
+		}
+	}
+	if !bound {
+		return
+	}
+
+	idx, err := probeStatuses.load(ctx, repo)
+	if err != nil {
+		logger.Warn("[探针状态] 加载探针配置失败", "error", err)
+		return
+	}
+	for i := range nodes {
+		if status, ok := idx.lookup(nodes[i].ProbeServer); ok {
+			nodes[i].ProbeStatus = &status
+		}
+	}
+}
+
+// offlineProbeNodeNames 返回用户节点中绑定服务器离线的节点名称；
+// 未开启该设置或状态获取失败时返回 nil
+func offlineProbeNodeNames(ctx context.Context, repo *storage.TrafficRepository, username string, settings storage.UserSettings) map[string]struct{} {
+	if repo == nil || !settings.ExcludeOfflineNodes {
+		return nil
+	}
+
+	nodes, err := repo.ListNodes(ctx, username)
+	if err != nil {
+		logger.Warn("[探针状态] 获取用户节点失败", "user", username, "error", err)
+		return nil
+	}
+
+	bound := false
+	for _, node := range nodes {
+		if strings.TrimSpace(node.ProbeServer) != "" {
+			bound = true
+			break
+		}
+	}
+	if !bound {
+		return nil
+	}
+
+	idx, err := probeStatuses.load(ctx, repo)
+	if err != nil {
+		logger.Warn("[探针状态] 加载探针配置失败", "error", err)
+		return nil
+	}
+
+	offline := make(map[string]struct{})
+	for _, node := range nodes {
+		if idx.offline(node.ProbeServer) {
+			offline[node.NodeName] = struct{}{}
+		}
+	}
+	if len(offline) > 0 {
+		logger.Info("[探针状态] 剔除绑定服务器离线的节点", "user", username, "count", len(offline))
+	}
+	return offline
+}
+
+// excludeOfflineProbeNodes 从订阅 YAML 中移除离线节点及其在代理组、规则中的引用。
+// 代理组因此为空且没有 use/include-all 时补充 DIRECT，保证配置仍可加载
+func excludeOfflineProbeNodes(data []byte, offline map[string]struct{}) []byte {
+	if len(offline) == 0 {
+		return data
+	}
+
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(data, &rootNode); err != nil {
+		return data
+	}
+	if len(rootNode.Content) == 0 || rootNode.Content[0].Kind != yaml.MappingNode {
+		return data
+	}
+	docNode := rootNode.Content[0]
+
+	var removed []string
+	for i := 0; i+1 < len(docNode.Content); i += 2 {
+		if docNode.Content[i].Value != "proxies" || docNode.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		proxiesNode := docNode.Content[i+1]
+		kept := make([]*yaml.Node, 0, len(proxiesNode.Content))
+		for _, proxyNode := range proxiesNode.Content {
+			if nameNode := yamlMappingValue(proxyNode, "name"); nameNode != nil {
+				if _, isOffline := offline[nameNode.Value]; isOffline {
+					removed = append(removed, nameNode.Value)
+					continue
+				}
+			}
+			kept = append(kept, proxyNode)
+		}
+		proxiesNode.Content = kept
+		break
+	}
+	if len(removed) == 0 {
+		return data
+	}
+
+	for i := 0; i+1 < len(docNode.Content); i += 2 {
+		switch docNode.Content[i].Value {
+		case "proxy-groups":
+			groupsNode := docNode.Content[i+1]
+			for _, name := range removed {
+				removeNodeFromProxyGroupsNode(groupsNode, name)
+			}
+			fillEmptyProxyGroups(groupsNode)
+		case "rules":
+			for _, name := range removed {
+				removeNodeFromRulesNode(docNode.Content[i+1], name)
+			}
+		}
+	}
+
+	output, err := MarshalYAMLWithIndent(&rootNode)
+	if err != nil {
+		return data
+	}
+	return []byte(RemoveUnicodeEscapeQuotes(string(output)))
+}
+
+// fillEmptyProxyGroups 为没有任何节点来源的代理组补充 DIRECT
+func fillEmptyProxyGroups(groupsNode *yaml.Node) {
+	if groupsNode.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for _, groupNode := range groupsNode.Content {
+		if groupNode.Kind != yaml.MappingNode {
+			continue
+		}
+
+		var proxiesNode *yaml.Node
+		hasSource := false
+		for i := 0; i+1 < len(groupNode.Content); i += 2 {
+			switch groupNode.Content[i].Value {
+			case "proxies":
+				proxiesNode = groupNode.Content[i+1]
+			case "use", "include-all", "include-all-proxies", "include-all-providers":
+				hasSource = true
+			}
+		}
+		if hasSource || proxiesNode == nil || proxiesNode.Kind != yaml.SequenceNode || len(proxiesNode.Content) > 0 {
+			continue
+		}
+		proxiesNode.Content = append(proxiesNode.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "DIRECT"})
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	"miaomiaowu/internal/auth"
 	"miaomiaowu/internal/storage"
+	"miaomiaowu/internal/substore"
 	"miaomiaowu/internal/util"
 	"miaomiaowu/internal/validator"
 
@@ -37,6 +38,10 @@ type proxyProviderConfigRequest struct {
 	HealthCheckLazy           bool   `json:"health_check_lazy"`
 	HealthCheckExpectedStatus int    `json:"health_check_expected_status"`
 
+	// 妙妙屋健康检查（仅 MMW 模式）：剔除连续失败 N 轮的节点
+	HealthCheckPrune         bool `json:"health_check_prune"`
+	HealthCheckPruneFailures int  `json:"health_check_prune_failures"`
+
 	Filter        string `json:"filter"`
 	ExcludeFilter string `json:"exclude_filter"`
 	ExcludeType   string `json:"exclude_type"`
@@ -44,6 +49,12 @@ type proxyProviderConfigRequest struct {
 	Override      string `json:"override"`      // JSON string
 
 	ProcessMode string `json:"process_mode"` // 'client' or 'mmw'
+
+	NodeTransforms []substore.NodeOperator `json:"node_transforms"` // 节点处理流水线（仅 MMW 模式生效），为空时保留现有设置
+
+	// 组合代理集合（仅 MMW 模式）：额外合并的外部订阅和自有节点标签，为空时保留现有设置
+	ExtraSubscriptionIDs []int64  `json:"extra_subscription_ids"`
+	NodeTags             []string `json:"node_tags"`
 }
 
 type proxyProviderConfigResponse struct {
@@ -69,6 +80,13 @@ type proxyProviderConfigResponse struct {
 	ProcessMode               string `json:"process_mode"`
 	CreatedAt                 string `json:"created_at"`
 	UpdatedAt                 string `json:"updated_at"`
+
+	NodeTransforms       json.RawMessage `json:"node_transforms"` // 节点处理流水线
+	ExtraSubscriptionIDs []int64         `json:"extra_subscription_ids"`
+	NodeTags             []string        `json:"node_tags"`
+
+	HealthCheckPrune         bool `json:"health_check_prune"`
+	HealthCheckPruneFailures int  `json:"health_check_prune_failures"`
 }
 
 func NewProxyProviderConfigsHandler(repo *storage.TrafficRepository) http.Handler {
@@ -189,10 +207,29 @@ func handleCreateProxyProviderConfig(w http.ResponseWriter, r *http.Request, rep
 	if healthCheckExpectedStatus <= 0 {
 		healthCheckExpectedStatus = 204
 	}
+	healthCheckPruneFailures := payload.HealthCheckPruneFailures
+	if healthCheckPruneFailures <= 0 {
+		healthCheckPruneFailures = defaultHealthCheckPruneFailures
+	}
 	processMode := payload.ProcessMode
 	if processMode == "" {
 		processMode = "client"
 	}
+	nodeTransforms, err := encodeNodeTransforms(payload.NodeTransforms)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	extraSubscriptionIDs := formatProxyProviderSubscriptionIDs(payload.ExtraSubscriptionIDs, payload.ExternalSubscriptionID)
+	nodeTags, err := formatProxyProviderNodeTags(payload.NodeTags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateProxyProviderSources(r.Context(), repo, username, extraSubscriptionIDs, nodeTags, processMode); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
 
 	config := &storage.ProxyProviderConfig{
 		Username:                  username,
@@ -215,6 +252,11 @@ func handleCreateProxyProviderConfig(w http.ResponseWriter, r *http.Request, rep
 		GeoIPFilter:               payload.GeoIPFilter,
 		Override:                  payload.Override,
 		ProcessMode:               processMode,
+		NodeTransforms:            nodeTransforms,
+		ExtraSubscriptionIDs:      extraSubscriptionIDs,
+		NodeTags:                  nodeTags,
+		HealthCheckPrune:          payload.HealthCheckPrune,
+		HealthCheckPruneFailures:  healthCheckPruneFailures,
 	}
 
 	id, err := repo.CreateProxyProviderConfig(r.Context(), config)
@@ -297,10 +339,36 @@ func handleUpdateProxyProviderConfig(w http.ResponseWriter, r *http.Request, rep
 	if healthCheckExpectedStatus <= 0 {
 		healthCheckExpectedStatus = 204
 	}
+	healthCheckPruneFailures := payload.HealthCheckPruneFailures
+	if healthCheckPruneFailures <= 0 {
+		healthCheckPruneFailures = defaultHealthCheckPruneFailures
+	}
 	processMode := payload.ProcessMode
 	if processMode == "" {
 		processMode = "client"
 	}
+	nodeTransforms := existing.NodeTransforms
+	if payload.NodeTransforms != nil {
+		if nodeTransforms, err = encodeNodeTransforms(payload.NodeTransforms); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	extraSubscriptionIDs := existing.ExtraSubscriptionIDs
+	if payload.ExtraSubscriptionIDs != nil {
+		extraSubscriptionIDs = formatProxyProviderSubscriptionIDs(payload.ExtraSubscriptionIDs, existing.ExternalSubscriptionID)
+	}
+	nodeTags := existing.NodeTags
+	if payload.NodeTags != nil {
+		if nodeTags, err = formatProxyProviderNodeTags(payload.NodeTags); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err := validateProxyProviderSources(r.Context(), repo, username, extraSubscriptionIDs, nodeTags, processMode); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
 
 	config := &storage.ProxyProviderConfig{
 		ID:                        id,
@@ -324,6 +392,11 @@ func handleUpdateProxyProviderConfig(w http.ResponseWriter, r *http.Request, rep
 		GeoIPFilter:               payload.GeoIPFilter,
 		Override:                  payload.Override,
 		ProcessMode:               processMode,
+		NodeTransforms:            nodeTransforms,
+		ExtraSubscriptionIDs:      extraSubscriptionIDs,
+		NodeTags:                  nodeTags,
+		HealthCheckPrune:          payload.HealthCheckPrune,
+		HealthCheckPruneFailures:  healthCheckPruneFailures,
 	}
 
 	if err := repo.UpdateProxyProviderConfig(r.Context(), config); err != nil {
@@ -331,6 +404,10 @@ func handleUpdateProxyProviderConfig(w http.ResponseWriter, r *http.Request, rep
 		return
 	}
 
+	if nodeTransforms != existing.NodeTransforms || extraSubscriptionIDs != existing.ExtraSubscriptionIDs || nodeTags != existing.NodeTags {
+		GetProxyProviderCache().Invalidate(id)
+	}
+
 	config.CreatedAt = existing.CreatedAt
 	config.UpdatedAt = time.Now()
 
@@ -399,6 +476,11 @@ func toProxyProviderConfigResponse(config storage.ProxyProviderConfig) proxyProv
 		GeoIPFilter:               config.GeoIPFilter,
 		Override:                  config.Override,
 		ProcessMode:               config.ProcessMode,
+		NodeTransforms:            nodeTransformsJSON(config.NodeTransforms),
+		ExtraSubscriptionIDs:      parseProxyProviderSubscriptionIDs(config.ExtraSubscriptionIDs),
+		NodeTags:                  parseProxyProviderNodeTags(config.NodeTags),
+		HealthCheckPrune:          config.HealthCheckPrune,
+		HealthCheckPruneFailures:  config.HealthCheckPruneFailures,
 		CreatedAt:                 config.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:                 config.UpdatedAt.Format(time.RFC3339),
 	}
@@ -471,6 +553,7 @@ func NewProxyProviderCacheRefreshHandler(repo *storage.TrafficRepository) http.H
 
 		// 刷新缓存
 		entry, err := RefreshProxyProviderCache(&sub, config)
+		recordProxyProviderRefreshResult(config.ID, err)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
@@ -550,11 +633,15 @@ func NewProxyProviderNodesHandler(repo *storage.TrafficRepository) http.Handler
 				writeError(w, http.StatusInternalServerError, errors.New("获取外部订阅失败"))
 				return
 			}
-			entry, err = RefreshProxyProviderCache(&sub, config)
+			var stale bool
+			entry, stale, err = RefreshProxyProviderCacheOrStale(&sub, config)
 			if err != nil {
 				writeError(w, http.StatusInternalServerError, err)
 				return
 			}
+			if stale {
+				w.Header().Set("X-Cache-Stale", "true")
+			}
 		}
 
 		// 计算前缀
@@ -866,7 +953,7 @@ func syncClientToMMW(ctx context.Context, repo *storage.TrafficRepository, confi
 	}
 
 	// 刷新缓存获取节点
-	entry, err := RefreshProxyProviderCache(&sub, config)
+	entry, _, err := RefreshProxyProviderCacheOrStale(&sub, config)
 	if err != nil {
 		return false, fmt.Errorf("刷新缓存失败: %v", err)
 	}
@@ -2,10 +2,14 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"miaomiaowu/internal/logger"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
 	"miaomiaowu/internal/storage"
 )
 
@@ -19,12 +23,15 @@ type CacheEntry struct {
 	FetchedAt  time.Time        // 拉取时间
 	Interval   int              // 配置的缓存间隔（秒）
 	NodeCount  int              // 节点数量
+
+	invalidated bool // 已被标记失效，下次访问时刷新，但刷新失败时仍可作为旧数据使用
 }
 
 // ProxyProviderCache 代理集合内存缓存
 type ProxyProviderCache struct {
 	mu      sync.RWMutex
 	entries map[int64]*CacheEntry // key: config ID
+	repo    *storage.TrafficRepository // 快照持久化，为空时仅使用内存
 }
 
 // 全局缓存实例
@@ -45,12 +52,53 @@ func (c *ProxyProviderCache) Get(configID int64) (*CacheEntry, bool) {
 	return entry, ok
 }
 
-// Set 设置缓存条目
-func (c *ProxyProviderCache) Set(configID int64, entry *CacheEntry) {
+// AttachRepository 启用缓存快照持久化
+func (c *ProxyProviderCache) AttachRepository(repo *storage.TrafficRepository) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.repo = repo
+}
+
+// repository 返回已挂载的数据库，未挂载时为 nil
+func (c *ProxyProviderCache) repository() *storage.TrafficRepository {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.repo
+}
+
+// Set 设置缓存条目，并将非空结果写入快照
+func (c *ProxyProviderCache) Set(configID int64, entry *CacheEntry) {
+	c.mu.Lock()
 	c.entries[configID] = entry
+	repo := c.repo
+	c.mu.Unlock()
 	logger.Info("[代理集合缓存] 更新缓存", "id", configID, "node_count", entry.NodeCount)
+
+	// 空结果不覆盖快照，保留最后一次有节点的数据用于上游故障时兜底
+	if repo == nil || entry.NodeCount == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configLoadTimeout)
+	defer cancel()
+	if err := repo.SaveProxyProviderCacheSnapshot(ctx, storage.ProxyProviderCacheSnapshot{
+		ConfigID:  configID,
+		YAMLData:  entry.YAMLData,
+		Prefix:    entry.Prefix,
+		NodeCount: entry.NodeCount,
+		Interval:  entry.Interval,
+		FetchedAt: entry.FetchedAt,
+	}); err != nil {
+		logger.Warn("[代理集合缓存] 保存缓存快照失败", "id", configID, "error", err)
+	}
+}
+
+// Invalidate 标记缓存失效，保留数据作为刷新失败时的旧数据
+func (c *ProxyProviderCache) Invalidate(configID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[configID]; ok {
+		entry.invalidated = true
+	}
 }
 
 // UpdateInterval 更新缓存条目的interval字段
@@ -63,12 +111,89 @@ func (c *ProxyProviderCache) UpdateInterval(configID int64, interval int) {
 	}
 }
 
-// Delete 删除缓存条目
+// Delete 删除缓存条目及其快照
 func (c *ProxyProviderCache) Delete(configID int64) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	delete(c.entries, configID)
+	repo := c.repo
+	c.mu.Unlock()
 	logger.Info("[代理集合缓存] 删除缓存", "id", configID)
+
+	if repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), configLoadTimeout)
+		defer cancel()
+		if err := repo.DeleteProxyProviderCacheSnapshot(ctx, configID); err != nil {
+			logger.Warn("[代理集合缓存] 删除缓存快照失败", "id", configID, "error", err)
+		}
+	}
+}
+
+// restoreSnapshots 从快照恢复缓存，已存在的条目不会被覆盖
+func (c *ProxyProviderCache) restoreSnapshots(ctx context.Context) int {
+	c.mu.RLock()
+	repo := c.repo
+	c.mu.RUnlock()
+	if repo == nil {
+		return 0
+	}
+
+	snaps, err := repo.ListProxyProviderCacheSnapshots(ctx)
+	if err != nil {
+		logger.Warn("[代理集合缓存] 读取缓存快照失败", "error", err)
+		return 0
+	}
+
+	restored := 0
+	for _, snap := range snaps {
+		entry, err := cacheEntryFromSnapshot(snap)
+		if err != nil {
+			logger.Warn("[代理集合缓存] 解析缓存快照失败", "id", snap.ConfigID, "error", err)
+			continue
+		}
+		c.mu.Lock()
+		if _, exists := c.entries[snap.ConfigID]; !exists {
+			c.entries[snap.ConfigID] = entry
+			restored++
+		}
+		c.mu.Unlock()
+	}
+	return restored
+}
+
+func cacheEntryFromSnapshot(snap storage.ProxyProviderCacheSnapshot) (*CacheEntry, error) {
+	var result map[string]any
+	if err := yaml.Unmarshal(snap.YAMLData, &result); err != nil {
+		return nil, err
+	}
+	proxiesRaw, ok := result["proxies"].([]any)
+	if !ok {
+		proxiesRaw = []any{}
+	}
+	nodeNames := make([]string, 0, len(proxiesRaw))
+	for _, p := range proxiesRaw {
+		if m, ok := p.(map[string]any); ok {
+			if name, ok := m["name"].(string); ok {
+				nodeNames = append(nodeNames, name)
+			}
+		}
+	}
+	return &CacheEntry{
+		ConfigID:  snap.ConfigID,
+		YAMLData:  snap.YAMLData,
+		Nodes:     proxiesRaw,
+		NodeNames: nodeNames,
+		Prefix:    snap.Prefix,
+		FetchedAt: snap.FetchedAt,
+		Interval:  snap.Interval,
+		NodeCount: len(proxiesRaw),
+	}, nil
+}
+
+// isInvalidated 返回条目是否已被标记失效；invalidated 与 Interval 可能被并发修改，需在锁内读取
+func (c *ProxyProviderCache) isInvalidated(entry *CacheEntry) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return entry.invalidated
 }
 
 // IsExpired 检查缓存是否过期
@@ -76,7 +201,12 @@ func (c *ProxyProviderCache) IsExpired(entry *CacheEntry) bool {
 	if entry == nil {
 		return true
 	}
-	interval := entry.Interval
+	c.mu.RLock()
+	invalidated, interval := entry.invalidated, entry.Interval
+	c.mu.RUnlock()
+	if invalidated {
+		return true
+	}
 	if interval <= 0 {
 		interval = 3600 // 默认 1 小时
 	}
@@ -85,41 +215,48 @@ func (c *ProxyProviderCache) IsExpired(entry *CacheEntry) bool {
 
 // GetCacheStatus 获取缓存状态（用于 API 返回）
 func (c *ProxyProviderCache) GetCacheStatus(configID int64) map[string]any {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	entry, ok := c.entries[configID]
+	// 同步状态需要获取同步器的锁，这里先释放缓存锁，避免与同步器的加锁顺序冲突
+	entry, ok := c.Get(configID)
 	if !ok {
-		return map[string]any{
+		status := map[string]any{
 			"cached":    false,
 			"expired":   true,
 			"node_count": 0,
 		}
+		addProxyProviderSyncStatus(status, configID, false)
+		return status
 	}
 
-	return map[string]any{
-		"cached":     true,
-		"expired":    c.IsExpired(entry),
-		"node_count": entry.NodeCount,
-		"fetched_at": entry.FetchedAt.Format(time.RFC3339),
-		"interval":   entry.Interval,
+	return c.entryStatus(entry)
+}
+
+// entryStatus 生成单个缓存条目的状态
+func (c *ProxyProviderCache) entryStatus(entry *CacheEntry) map[string]any {
+	expired := c.IsExpired(entry)
+	status := map[string]any{
+		"cached":      true,
+		"expired":     expired,
+		"node_count":  entry.NodeCount,
+		"fetched_at":  entry.FetchedAt.Format(time.RFC3339),
+		"interval":    entry.Interval,
+		"age_seconds": int64(time.Since(entry.FetchedAt).Seconds()),
 	}
+	addProxyProviderSyncStatus(status, entry.ConfigID, expired)
+	return status
 }
 
 // GetAllCacheStatus 获取所有缓存状态
 func (c *ProxyProviderCache) GetAllCacheStatus() map[int64]map[string]any {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	result := make(map[int64]map[string]any)
+	entries := make(map[int64]*CacheEntry, len(c.entries))
 	for id, entry := range c.entries {
-		result[id] = map[string]any{
-			"cached":     true,
-			"expired":    c.IsExpired(entry),
-			"node_count": entry.NodeCount,
-			"fetched_at": entry.FetchedAt.Format(time.RFC3339),
-			"interval":   entry.Interval,
-		}
+		entries[id] = entry
+	}
+	c.mu.RUnlock()
+
+	result := make(map[int64]map[string]any, len(entries))
+	for id, entry := range entries {
+		result[id] = c.entryStatus(entry)
 	}
 	return result
 }
@@ -140,6 +277,13 @@ func InitProxyProviderCacheOnStartup(repo *storage.TrafficRepository) {
 
 	ctx := context.Background()
 
+	// 先从快照恢复，上游不可用时也能立即提供节点
+	cache := GetProxyProviderCache()
+	cache.AttachRepository(repo)
+	if restored := cache.restoreSnapshots(ctx); restored > 0 {
+		logger.Info("[代理集合缓存] 已从快照恢复缓存", "count", restored)
+	}
+
 	// 获取所有用户
 	users, err := repo.ListUsers(ctx, 0) // 0 表示不限制数量
 	if err != nil {
@@ -172,12 +316,15 @@ func InitProxyProviderCacheOnStartup(repo *storage.TrafficRepository) {
 				continue
 			}
 
-			// 刷新缓存
-			_, err = RefreshProxyProviderCache(&sub, &config)
+			// 刷新缓存，失败时保留快照数据
+			_, stale, err := RefreshProxyProviderCacheOrStale(&sub, &config)
 			if err != nil {
 				logger.Info("[代理集合缓存] 刷新代理集合缓存失败", "config_name", config.Name, "error", err)
 				continue
 			}
+			if stale {
+				continue
+			}
 
 			successCount++
 		}
@@ -215,6 +362,14 @@ type proxyProviderSyncState struct {
 	blockUntil time.Time
 	// retryDelay 当前重试延迟，失败后会翻倍
 	retryDelay time.Duration
+	// failureCount 连续失败次数，成功后清零
+	failureCount int
+	// lastError 最近一次失败的错误信息
+	lastError string
+	// lastFailureAt 最近一次失败时间
+	lastFailureAt time.Time
+	// lastSuccessAt 最近一次成功时间
+	lastSuccessAt time.Time
 }
 
 // scheduledProxyConfig 待刷新的代理集合配置及原因
@@ -256,10 +411,85 @@ func StartProxyProviderCacheSync(ctx context.Context, repo *storage.TrafficRepos
 		return
 	}
 
+	GetProxyProviderCache().AttachRepository(repo)
 	syncer := newProxyProviderCacheSyncer(repo)
+	globalProxyProviderSyncer.Store(syncer)
 	syncer.run(ctx)
 }
 
+// globalProxyProviderSyncer 当前运行的同步器，用于记录按需刷新的结果和查询同步状态
+var globalProxyProviderSyncer atomic.Pointer[proxyProviderCacheSyncer]
+
+// RefreshProxyProviderCacheOrStale 刷新代理集合缓存，失败时返回已有的旧缓存
+// stale 为 true 表示返回的是旧数据；没有可用旧数据时返回错误
+func RefreshProxyProviderCacheOrStale(sub *storage.ExternalSubscription, config *storage.ProxyProviderConfig) (*CacheEntry, bool, error) {
+	entry, err := RefreshProxyProviderCache(sub, config)
+	recordProxyProviderRefreshResult(config.ID, err)
+	if err == nil {
+		return entry, false, nil
+	}
+
+	if stale, ok := GetProxyProviderCache().Get(config.ID); ok {
+		logger.Warn("[代理集合缓存] 刷新失败，使用旧缓存",
+			"config_id", config.ID,
+			"fetched_at", stale.FetchedAt.Format(time.RFC3339),
+			"error", err)
+		return stale, true, nil
+	}
+	return nil, false, err
+}
+
+// recordProxyProviderRefreshResult 记录同步器之外的刷新结果
+// 按需刷新的失败只用于状态展示，不影响定时同步的退避
+func recordProxyProviderRefreshResult(configID int64, err error) {
+	s := globalProxyProviderSyncer.Load()
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.recordRequestFailure(configID, err)
+	} else {
+		s.recordSuccess(configID)
+	}
+}
+
+// addProxyProviderSyncStatus 将同步状态（连续失败次数、最近错误等）合并到缓存状态
+func addProxyProviderSyncStatus(status map[string]any, configID int64, expired bool) {
+	cached, _ := status["cached"].(bool)
+	status["stale"] = cached && expired
+	status["failure_count"] = 0
+	status["last_error"] = ""
+
+	s := globalProxyProviderSyncer.Load()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	state, ok := s.state[configID]
+	var st proxyProviderSyncState
+	if ok {
+		st = *state
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	// 已过期或最近一次刷新失败，说明当前提供的是旧数据
+	status["stale"] = cached && (expired || st.failureCount > 0)
+	status["failure_count"] = st.failureCount
+	status["last_error"] = st.lastError
+	if !st.lastFailureAt.IsZero() {
+		status["last_failure_at"] = st.lastFailureAt.Format(time.RFC3339)
+	}
+	if !st.lastSuccessAt.IsZero() {
+		status["last_success_at"] = st.lastSuccessAt.Format(time.RFC3339)
+	}
+	if !st.blockUntil.IsZero() && time.Now().Before(st.blockUntil) {
+		status["next_retry_at"] = st.blockUntil.Format(time.RFC3339)
+	}
+}
+
 // newProxyProviderCacheSyncer 创建新的同步器实例
 func newProxyProviderCacheSyncer(repo *storage.TrafficRepository) *proxyProviderCacheSyncer {
 	return &proxyProviderCacheSyncer{
@@ -377,6 +607,14 @@ func (s *proxyProviderCacheSyncer) shouldRefreshLocked(cfg storage.ProxyProvider
 		}, true
 	}
 
+	// 被标记失效的缓存立即刷新
+	if s.cache.isInvalidated(entry) {
+		return scheduledProxyConfig{
+			cfg:    cfg,
+			reason: "缓存已失效，立即同步",
+		}, true
+	}
+
 	// 计算缓存过期时间
 	expireAt := entry.FetchedAt.Add(time.Duration(interval) * time.Second)
 	if now.Before(expireAt) {
@@ -440,7 +678,7 @@ func (s *proxyProviderCacheSyncer) refreshSingle(ctx context.Context, job schedu
 		logger.Warn("[代理集合定时同步] 获取外部订阅失败",
 			"config_id", cfg.ID,
 			"subscription_id", cfg.ExternalSubscriptionID)
-		s.recordFailure(cfg.ID)
+		s.recordFailure(cfg.ID, errors.New("获取外部订阅失败"))
 		return
 	}
 
@@ -457,7 +695,7 @@ func (s *proxyProviderCacheSyncer) refreshSingle(ctx context.Context, job schedu
 			"config_id", cfg.ID,
 			"name", cfg.Name,
 			"error", err)
-		s.recordFailure(cfg.ID)
+		s.recordFailure(cfg.ID, err)
 		return
 	}
 
@@ -473,7 +711,7 @@ func (s *proxyProviderCacheSyncer) refreshSingle(ctx context.Context, job schedu
 }
 
 // recordFailure 记录刷新失败，更新重试延迟（指数退避）
-func (s *proxyProviderCacheSyncer) recordFailure(configID int64) {
+func (s *proxyProviderCacheSyncer) recordFailure(configID int64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -492,12 +730,25 @@ func (s *proxyProviderCacheSyncer) recordFailure(configID int64) {
 
 	state.retryDelay = delay
 	state.blockUntil = time.Now().Add(delay)
+	state.failureCount++
+	state.lastError = err.Error()
+	state.lastFailureAt = time.Now()
 
 	logger.Info("[代理集合定时同步] 同步失败，已安排重试",
 		"config_id", configID,
 		"retry_after", delay.String())
 }
 
+// recordRequestFailure 记录按需刷新失败，只更新错误信息，不改变重试延迟
+func (s *proxyProviderCacheSyncer) recordRequestFailure(configID int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.ensureStateLocked(configID)
+	state.lastError = err.Error()
+	state.lastFailureAt = time.Now()
+}
+
 // recordSuccess 记录刷新成功，重置重试延迟
 func (s *proxyProviderCacheSyncer) recordSuccess(configID int64) {
 	s.mu.Lock()
@@ -506,6 +757,9 @@ func (s *proxyProviderCacheSyncer) recordSuccess(configID int64) {
 	state := s.ensureStateLocked(configID)
 	state.retryDelay = 0
 	state.blockUntil = time.Time{}
+	state.failureCount = 0
+	state.lastError = ""
+	state.lastSuccessAt = time.Now()
 }
 
 // markTaskFinished 标记任务完成，从running集合中移除
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 组合代理集合：在主外部订阅之外，合并额外的外部订阅和用户自有节点（按标签）
+// 合并后先去重、解决重名，再走代理集合原有的过滤、流水线和覆写流程
+
+// proxyProviderSource 组合代理集合的一个节点来源
+type proxyProviderSource struct {
+	name  string       // 来源名称，重名时作为节点名后缀
+	nodes []*yaml.Node // 节点映射
+}
+
+// isCompositeProxyProvider 是否为组合代理集合
+func isCompositeProxyProvider(config *storage.ProxyProviderConfig) bool {
+	return strings.TrimSpace(config.ExtraSubscriptionIDs) != "" || strings.TrimSpace(config.NodeTags) != ""
+}
+
+// parseProxyProviderSubscriptionIDs 解析逗号分隔的外部订阅 ID，忽略无效项
+func parseProxyProviderSubscriptionIDs(raw string) []int64 {
+	ids := make([]int64, 0)
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// formatProxyProviderSubscriptionIDs 去重后编码为逗号分隔的字符串，跳过主订阅
+func formatProxyProviderSubscriptionIDs(ids []int64, primaryID int64) string {
+	seen := map[int64]bool{primaryID: true}
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseProxyProviderNodeTags 解析逗号分隔的节点标签
+func parseProxyProviderNodeTags(raw string) []string {
+	tags := make([]string, 0)
+	for _, part := range strings.Split(raw, ",") {
+		if tag := strings.TrimSpace(part); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// formatProxyProviderNodeTags 去重后编码为逗号分隔的字符串
+func formatProxyProviderNodeTags(tags []string) (string, error) {
+	seen := make(map[string]bool, len(tags))
+	parts := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if strings.Contains(tag, ",") {
+			return "", fmt.Errorf("node tag %q must not contain commas", tag)
+		}
+		seen[tag] = true
+		parts = append(parts, tag)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// validateProxyProviderSources 校验组合代理集合的额外来源：仅支持 MMW 模式，额外的外部订阅必须属于当前用户
+func validateProxyProviderSources(ctx context.Context, repo *storage.TrafficRepository, username, extraSubscriptionIDs, nodeTags, processMode string) error {
+	if extraSubscriptionIDs == "" && nodeTags == "" {
+		return nil
+	}
+	if processMode != "mmw" {
+		return errors.New("extra_subscription_ids and node_tags require process_mode mmw")
+	}
+	for _, id := range parseProxyProviderSubscriptionIDs(extraSubscriptionIDs) {
+		if _, err := repo.GetExternalSubscription(ctx, id, username); err != nil {
+			if errors.Is(err, storage.ErrExternalSubscriptionNotFound) {
+				return fmt.Errorf("external subscription %d not found", id)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// proxyProviderSubscriptionIDs 返回代理集合引用的所有外部订阅 ID（主订阅在前）
+func proxyProviderSubscriptionIDs(config *storage.ProxyProviderConfig) []int64 {
+	return append([]int64{config.ExternalSubscriptionID}, parseProxyProviderSubscriptionIDs(config.ExtraSubscriptionIDs)...)
+}
+
+// loadProxyProviderProxiesNode 拉取代理集合的节点来源并返回 proxies 序列节点
+// 每个外部订阅先应用自身的节点处理流水线；组合代理集合再合并所有来源
+// 额外来源失败时记录日志并跳过，主订阅失败时返回错误
+func loadProxyProviderProxiesNode(ctx context.Context, sub *storage.ExternalSubscription, config *storage.ProxyProviderConfig) (*yaml.Node, error) {
+	primary, err := loadSubscriptionSourceNode(sub)
+	if err != nil {
+		return nil, err
+	}
+	if !isCompositeProxyProvider(config) {
+		return primary, nil
+	}
+
+	repo := GetProxyProviderCache().repository()
+	if repo == nil {
+		logger.Info("[组合代理集合] 未配置数据库，仅使用主订阅", "config_name", config.Name)
+		return primary, nil
+	}
+
+	sources := []proxyProviderSource{{name: sub.Name, nodes: primary.Content}}
+
+	for _, id := range parseProxyProviderSubscriptionIDs(config.ExtraSubscriptionIDs) {
+		if id == sub.ID {
+			continue
+		}
+		extra, err := repo.GetExternalSubscription(ctx, id, config.Username)
+		if err != nil {
+			logger.Info("[组合代理集合] 获取外部订阅失败，跳过", "config_name", config.Name, "subscription_id", id, "error", err)
+			continue
+		}
+		node, err := loadSubscriptionSourceNode(&extra)
+		if err != nil {
+			logger.Info("[组合代理集合] 拉取外部订阅失败，跳过", "config_name", config.Name, "subscription", extra.Name, "error", err)
+			continue
+		}
+		sources = append(sources, proxyProviderSource{name: extra.Name, nodes: node.Content})
+	}
+
+	if tags := parseProxyProviderNodeTags(config.NodeTags); len(tags) > 0 {
+		tagSources, err := loadNodeTagSources(ctx, repo, config.Username, tags)
+		if err != nil {
+			logger.Info("[组合代理集合] 获取节点失败，跳过节点标签", "config_name", config.Name, "error", err)
+		}
+		sources = append(sources, tagSources...)
+	}
+
+	merged := mergeProxyProviderSources(sources)
+	logger.Info("[组合代理集合] 合并节点来源", "config_name", config.Name, "sources", len(sources), "node_count", len(merged.Content))
+	return merged, nil
+}
+
+// loadSubscriptionSourceNode 拉取单个外部订阅的节点并应用其节点处理流水线
+func loadSubscriptionSourceNode(sub *storage.ExternalSubscription) (*yaml.Node, error) {
+	proxiesNode, err := fetchSubscriptionProxiesNode(sub)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyNodePipelinesToYAML(proxiesNode, loadNodePipeline(sub.NodeTransforms, sub.Name)); err != nil {
+		return nil, fmt.Errorf("apply node transforms: %w", err)
+	}
+	return proxiesNode, nil
+}
+
+// loadNodeTagSources 按标签读取用户已启用的节点，每个标签作为一个来源
+func loadNodeTagSources(ctx context.Context, repo *storage.TrafficRepository, username string, tags []string) ([]proxyProviderSource, error) {
+	nodes, err := repo.ListNodes(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	byTag := make(map[string][]*yaml.Node, len(tags))
+	for _, node := range nodes {
+		if !node.Enabled || strings.TrimSpace(node.ClashConfig) == "" {
+			continue
+		}
+		// ClashConfig 为 JSON，按 YAML 解析可保留字段顺序
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(node.ClashConfig), &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			logger.Info("[组合代理集合] 节点配置无效，跳过", "node_name", node.NodeName, "error", err)
+			continue
+		}
+		clearYAMLStyle(doc.Content[0])
+		byTag[node.Tag] = append(byTag[node.Tag], doc.Content[0])
+	}
+
+	sources := make([]proxyProviderSource, 0, len(tags))
+	for _, tag := range tags {
+		if len(byTag[tag]) > 0 {
+			sources = append(sources, proxyProviderSource{name: tag, nodes: byTag[tag]})
+		}
+	}
+	return sources, nil
+}
+
+// invalidateNodeTagProxyProviders 节点新增、修改、删除或改标签后，失效用户引用节点标签的组合代理集合
+func invalidateNodeTagProxyProviders(ctx context.Context, repo *storage.TrafficRepository, username string) {
+	configs, err := repo.ListProxyProviderConfigs(ctx, username)
+	if err != nil {
+		logger.Info("[组合代理集合] 读取代理集合配置失败，无法失效缓存", "user", username, "error", err)
+		return
+	}
+	cache := GetProxyProviderCache()
+	for _, config := range configs {
+		if strings.TrimSpace(config.NodeTags) != "" {
+			cache.Invalidate(config.ID)
+		}
+	}
+}
+
+// clearYAMLStyle 清除 JSON 解析带来的流式和引号风格，输出时与订阅节点格式一致
+// 标量保留 !!str 等标签，编码时仍会在需要时加引号
+func clearYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYAMLStyle(child)
+	}
+}
+
+// mergeProxyProviderSources 按来源顺序合并节点
+// 类型、服务器和端口相同的节点只保留第一个；重名节点追加 " - 来源名称" 后缀，仍冲突时再追加序号
+func mergeProxyProviderSources(sources []proxyProviderSource) *yaml.Node {
+	merged := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	seenEndpoints := make(map[string]bool)
+	usedNames := make(map[string]bool)
+
+	for _, source := range sources {
+		for _, node := range source.nodes {
+			if node.Kind != yaml.MappingNode {
+				continue
+			}
+			endpoint := strings.ToLower(mappingScalar(node, "type") + "|" + mappingScalar(node, "server") + ":" + mappingScalar(node, "port"))
+			if mappingScalar(node, "server") != "" {
+				if seenEndpoints[endpoint] {
+					continue
+				}
+				seenEndpoints[endpoint] = true
+			}
+
+			nameNode := yamlMappingValue(node, "name")
+			if nameNode == nil {
+				continue
+			}
+			name := nameNode.Value
+			if usedNames[name] {
+				base := fmt.Sprintf("%s - %s", name, source.name)
+				name = base
+				for i := 2; usedNames[name]; i++ {
+					name = fmt.Sprintf("%s %d", base, i)
+				}
+				nameNode.Value = name
+			}
+			usedNames[name] = true
+			merged.Content = append(merged.Content, node)
+		}
+	}
+	return merged
+}
+
+// mappingScalar 返回映射节点中指定键的标量值，不存在时为空
+func mappingScalar(node *yaml.Node, key string) string {
+	if value := yamlMappingValue(node, key); value != nil && value.Kind == yaml.ScalarNode {
+		return value.Value
+	}
+	return ""
+}
@@ -0,0 +1,468 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"miaomiaowu/internal/auth"
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 妙妙屋代理集合健康检查相关常量
+// 对 MMW 模式且开启健康检查的代理集合，定期对缓存中的节点做 TCP 连接或 TLS 握手探测
+const (
+	// 扫描周期：每分钟检查一次是否有代理集合到达探测时间
+	proxyProviderHealthScanInterval = time.Minute
+	// 最小探测间隔，避免过于频繁地连接机场节点
+	minProxyProviderHealthInterval = time.Minute
+	// 默认连续失败多少轮后剔除节点
+	defaultHealthCheckPruneFailures = 3
+	// 同时探测的代理集合数量
+	proxyProviderHealthWorkerLimit = 2
+	// 单个代理集合内同时探测的节点数量
+	proxyProviderHealthProbeLimit = 16
+)
+
+// udpOnlyProxyTypes 基于 UDP 的协议无法用 TCP/TLS 探测，跳过且不会被剔除
+var udpOnlyProxyTypes = map[string]bool{
+	"hysteria":  true,
+	"hysteria2": true,
+	"tuic":      true,
+	"wireguard": true,
+}
+
+// proxyProviderHealthChecker 代理集合节点健康检查器
+type proxyProviderHealthChecker struct {
+	repo    *storage.TrafficRepository
+	mu      sync.Mutex
+	lastRun map[int64]time.Time // key: config ID
+	running map[int64]struct{}
+	workers chan struct{}
+	wg      sync.WaitGroup
+}
+
+// StartProxyProviderHealthCheck 启动代理集合节点健康检查
+// 该函数会阻塞，直到context被取消
+func StartProxyProviderHealthCheck(ctx context.Context, repo *storage.TrafficRepository) {
+	if repo == nil {
+		return
+	}
+
+	c := &proxyProviderHealthChecker{
+		repo:    repo,
+		lastRun: make(map[int64]time.Time),
+		running: make(map[int64]struct{}),
+		workers: make(chan struct{}, proxyProviderHealthWorkerLimit),
+	}
+
+	logger.Info("[代理集合健康检查] 检查器启动", "scan_interval", proxyProviderHealthScanInterval.String())
+	defer logger.Info("[代理集合健康检查] 检查器已退出")
+
+	ticker := time.NewTicker(proxyProviderHealthScanInterval)
+	defer ticker.Stop()
+
+	c.runCycle(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			c.wg.Wait()
+			return
+		case <-ticker.C:
+			c.runCycle(ctx)
+		}
+	}
+}
+
+// runCycle 加载开启健康检查的 MMW 代理集合，对到期的启动探测
+func (c *proxyProviderHealthChecker) runCycle(ctx context.Context) {
+	loadCtx, cancel := context.WithTimeout(ctx, configLoadTimeout)
+	configs, err := c.repo.ListMMWProxyProviderConfigs(loadCtx)
+	cancel()
+	if err != nil {
+		logger.Warn("[代理集合健康检查] 加载代理集合配置失败", "error", err)
+		return
+	}
+
+	for _, config := range c.collectDue(configs, time.Now()) {
+		select {
+		case <-ctx.Done():
+			c.markFinished(config.ID)
+			continue
+		case c.workers <- struct{}{}:
+		}
+
+		c.wg.Add(1)
+		go func(config storage.ProxyProviderConfig) {
+			defer func() {
+				<-c.workers
+				c.wg.Done()
+				c.markFinished(config.ID)
+			}()
+			c.checkOne(ctx, config)
+		}(config)
+	}
+}
+
+// collectDue 返回到达探测时间的代理集合，并清理已删除或关闭健康检查的状态
+func (c *proxyProviderHealthChecker) collectDue(configs []storage.ProxyProviderConfig, now time.Time) []storage.ProxyProviderConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	active := make(map[int64]struct{}, len(configs))
+	var due []storage.ProxyProviderConfig
+	for _, config := range configs {
+		if !config.HealthCheckEnabled {
+			continue
+		}
+		active[config.ID] = struct{}{}
+		if _, busy := c.running[config.ID]; busy {
+			continue
+		}
+		interval := max(time.Duration(config.HealthCheckInterval)*time.Second, minProxyProviderHealthInterval)
+		if last, ok := c.lastRun[config.ID]; ok && now.Sub(last) < interval {
+			continue
+		}
+		c.lastRun[config.ID] = now
+		c.running[config.ID] = struct{}{}
+		due = append(due, config)
+	}
+
+	for id := range c.lastRun {
+		if _, ok := active[id]; !ok {
+			delete(c.lastRun, id)
+		}
+	}
+	return due
+}
+
+func (c *proxyProviderHealthChecker) markFinished(configID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, configID)
+}
+
+// checkOne 对代理集合缓存中的节点执行一轮探测并记录结果
+// 缓存尚未建立时跳过本轮，由代理集合同步器负责拉取
+func (c *proxyProviderHealthChecker) checkOne(ctx context.Context, config storage.ProxyProviderConfig) {
+	entry, ok := GetProxyProviderCache().Get(config.ID)
+	if !ok {
+		logger.Info("[代理集合健康检查] 缓存未建立，跳过本轮", "config_id", config.ID, "name", config.Name)
+		return
+	}
+
+	checkedAt := time.Now()
+	probes := probeProxyProviderNodes(ctx, entry.Nodes, config.HealthCheckTimeout)
+	if ctx.Err() != nil {
+		return
+	}
+
+	saveCtx, cancel := context.WithTimeout(ctx, configLoadTimeout)
+	defer cancel()
+	if err := c.repo.RecordProxyProviderHealthRound(saveCtx, config.ID, probes, checkedAt); err != nil {
+		logger.Warn("[代理集合健康检查] 保存探测结果失败", "config_id", config.ID, "error", err)
+		return
+	}
+
+	failed := 0
+	for _, probe := range probes {
+		if !probe.Success {
+			failed++
+		}
+	}
+	logger.Info("[代理集合健康检查] 探测完成", "config_id", config.ID, "name", config.Name,
+		"probed", len(probes), "failed", failed, "duration_ms", time.Since(checkedAt).Milliseconds())
+}
+
+// probeProxyProviderNodes 并发探测节点，同一服务器端口只探测一次
+func probeProxyProviderNodes(ctx context.Context, nodes []any, timeout int) []storage.ProxyProviderNodeProbe {
+	type target struct {
+		probe      storage.ProxyProviderNodeProbe
+		host       string
+		port       int
+		serverName string
+	}
+
+	seen := make(map[string]bool, len(nodes))
+	targets := make([]target, 0, len(nodes))
+	for _, raw := range nodes {
+		node, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		key, host, port, ok := proxyProviderNodeEndpoint(node)
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		name, _ := node["name"].(string)
+		t := target{
+			probe: storage.ProxyProviderNodeProbe{NodeKey: key, NodeName: name, Probe: "tcp"},
+			host:  host,
+			port:  port,
+		}
+		if proxyNodeUsesTLS(node) {
+			t.probe.Probe = "tls"
+			t.serverName = firstNonEmptyString(node, "servername", "sni")
+		}
+		targets = append(targets, t)
+	}
+
+	probes := make([]storage.ProxyProviderNodeProbe, len(targets))
+	sem := make(chan struct{}, proxyProviderHealthProbeLimit)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int, t target) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var resp TCPingResponse
+			if t.probe.Probe == "tls" {
+				resp = tlsPing(t.host, t.port, t.serverName, timeout)
+			} else {
+				resp = tcping(t.host, t.port, timeout)
+			}
+			probe := t.probe
+			probe.Success = resp.Success
+			probe.Latency = resp.Latency
+			probe.Error = resp.Error
+			probes[i] = probe
+		}(i, t)
+	}
+	wg.Wait()
+	return probes
+}
+
+// proxyProviderNodeEndpoint 返回节点的健康记录键（type|server:port）和探测地址
+// UDP 协议或缺少服务器端口的节点返回 false
+func proxyProviderNodeEndpoint(node map[string]any) (string, string, int, bool) {
+	proxyType := strings.ToLower(fmt.Sprint(node["type"]))
+	if udpOnlyProxyTypes[proxyType] {
+		return "", "", 0, false
+	}
+	server, _ := node["server"].(string)
+	server = strings.TrimSpace(server)
+	port, err := strconv.Atoi(strings.TrimSpace(fmt.Sprint(node["port"])))
+	if server == "" || err != nil || port <= 0 || port > 65535 {
+		return "", "", 0, false
+	}
+	return proxyProviderNodeKey(proxyType, server, strconv.Itoa(port)), server, port, true
+}
+
+// proxyProviderNodeKey 节点健康记录键，节点改名后仍能对应
+func proxyProviderNodeKey(proxyType, server, port string) string {
+	return strings.ToLower(proxyType + "|" + server + ":" + port)
+}
+
+// proxyNodeUsesTLS 节点是否在 TCP 之上使用 TLS（含 Reality），此类节点探测 TLS 握手
+func proxyNodeUsesTLS(node map[string]any) bool {
+	switch strings.ToLower(fmt.Sprint(node["type"])) {
+	case "trojan", "anytls":
+		return true
+	}
+	tls, _ := node["tls"].(bool)
+	return tls
+}
+
+func firstNonEmptyString(node map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := node[key].(string); ok && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// pruneUnhealthyProxyProviderEntry 返回剔除连续失败节点后的缓存条目副本
+// 未开启剔除、没有需要剔除的节点，或全部节点都将被剔除时，返回原条目
+func pruneUnhealthyProxyProviderEntry(ctx context.Context, repo *storage.TrafficRepository, config *storage.ProxyProviderConfig, entry *CacheEntry) *CacheEntry {
+	if !config.HealthCheckEnabled || !config.HealthCheckPrune {
+		return entry
+	}
+	threshold := config.HealthCheckPruneFailures
+	if threshold <= 0 {
+		threshold = defaultHealthCheckPruneFailures
+	}
+
+	states, err := repo.ListProxyProviderNodeHealth(ctx, config.ID)
+	if err != nil {
+		logger.Info("[代理集合健康检查] 读取节点健康状态失败，不剔除节点", "config_id", config.ID, "error", err)
+		return entry
+	}
+	unhealthy := make(map[string]bool)
+	for _, state := range states {
+		if state.ConsecutiveFailures >= threshold {
+			unhealthy[state.NodeKey] = true
+		}
+	}
+	if len(unhealthy) == 0 {
+		return entry
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(entry.YAMLData, &root); err != nil {
+		return entry
+	}
+	proxiesNode := findProxiesNode(&root)
+	if proxiesNode == nil || proxiesNode.Kind != yaml.SequenceNode {
+		return entry
+	}
+
+	kept := make([]*yaml.Node, 0, len(proxiesNode.Content))
+	for _, node := range proxiesNode.Content {
+		if node.Kind == yaml.MappingNode {
+			key := proxyProviderNodeKey(mappingScalar(node, "type"), mappingScalar(node, "server"), mappingScalar(node, "port"))
+			if unhealthy[key] {
+				continue
+			}
+		}
+		kept = append(kept, node)
+	}
+	pruned := len(proxiesNode.Content) - len(kept)
+	if pruned == 0 {
+		return entry
+	}
+	if len(kept) == 0 {
+		logger.Info("[代理集合健康检查] 所有节点均不可用，保留全部节点", "config_id", config.ID)
+		return entry
+	}
+	proxiesNode.Content = kept
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return entry
+	}
+	encoder.Close()
+
+	logger.Info("[代理集合健康检查] 剔除不可用节点", "config_id", config.ID, "pruned", pruned, "remaining", len(kept))
+	prunedEntry := *entry
+	prunedEntry.YAMLData = []byte(RemoveUnicodeEscapeQuotes(buf.String()))
+	prunedEntry.NodeCount = len(kept)
+	return &prunedEntry
+}
+
+type proxyProviderNodeHealthResponse struct {
+	NodeKey             string     `json:"node_key"`
+	NodeName            string     `json:"node_name"`
+	Probe               string     `json:"probe"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	TotalChecks         int        `json:"total_checks"`
+	TotalFailures       int        `json:"total_failures"`
+	LastLatency         float64    `json:"last_latency"`
+	LastError           string     `json:"last_error"`
+	LastCheckedAt       *time.Time `json:"last_checked_at"`
+	LastSuccessAt       *time.Time `json:"last_success_at"`
+	Pruned              bool       `json:"pruned"`
+}
+
+type proxyProviderNodeHealthSampleResponse struct {
+	Success   bool      `json:"success"`
+	Latency   float64   `json:"latency"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// NewProxyProviderHealthHandler 代理集合节点健康状态
+// GET /api/user/proxy-provider-health?id={config_id}                  节点当前状态
+// GET /api/user/proxy-provider-health?id={config_id}&node={node_key}  节点探测历史（延迟曲线）
+func NewProxyProviderHealthHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("proxy provider health handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := auth.UsernameFromContext(r.Context())
+		if strings.TrimSpace(username) == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		query := r.URL.Query()
+		id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid id"))
+			return
+		}
+		config, err := repo.GetProxyProviderConfig(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if config == nil || config.Username != username {
+			writeError(w, http.StatusNotFound, errors.New("proxy provider config not found"))
+			return
+		}
+
+		if nodeKey := strings.TrimSpace(query.Get("node")); nodeKey != "" {
+			limit, _ := strconv.Atoi(query.Get("limit"))
+			samples, err := repo.ListProxyProviderNodeHealthHistory(r.Context(), id, nodeKey, limit)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			items := make([]proxyProviderNodeHealthSampleResponse, 0, len(samples))
+			for _, s := range samples {
+				items = append(items, proxyProviderNodeHealthSampleResponse(s))
+			}
+			respondJSON(w, http.StatusOK, map[string]any{"node_key": nodeKey, "history": items})
+			return
+		}
+
+		states, err := repo.ListProxyProviderNodeHealth(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		threshold := config.HealthCheckPruneFailures
+		if threshold <= 0 {
+			threshold = defaultHealthCheckPruneFailures
+		}
+		items := make([]proxyProviderNodeHealthResponse, 0, len(states))
+		for _, s := range states {
+			items = append(items, proxyProviderNodeHealthResponse{
+				NodeKey:             s.NodeKey,
+				NodeName:            s.NodeName,
+				Probe:               s.Probe,
+				ConsecutiveFailures: s.ConsecutiveFailures,
+				TotalChecks:         s.TotalChecks,
+				TotalFailures:       s.TotalFailures,
+				LastLatency:         s.LastLatency,
+				LastError:           s.LastError,
+				LastCheckedAt:       s.LastCheckedAt,
+				LastSuccessAt:       s.LastSuccessAt,
+				Pruned:              config.HealthCheckEnabled && config.HealthCheckPrune && s.ConsecutiveFailures >= threshold,
+			})
+		}
+		respondJSON(w, http.StatusOK, map[string]any{
+			"enabled":        config.HealthCheckEnabled && config.ProcessMode == "mmw",
+			"prune":          config.HealthCheckPrune,
+			"prune_failures": threshold,
+			"nodes":          items,
+		})
+	})
+}
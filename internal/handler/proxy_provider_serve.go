@@ -2,11 +2,11 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"miaomiaowu/internal/logger"
 	"net"
 	"net/http"
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"miaomiaowu/internal/storage"
+	"miaomiaowu/internal/substore"
 	"miaomiaowu/internal/util"
 
 	"gopkg.in/yaml.v3"
@@ -97,7 +98,11 @@ func getGeoIPCountryCode(ipOrHost string) string {
 }
 
 // NewProxyProviderServeHandler handles serving filtered proxies for "妙妙屋处理" mode
-// URL: /api/proxy-provider/{config_id}?token={user_token}
+// URL: /api/proxy-provider/{config_id}?token={user_token}[&t={client_type}]
+// Non-Clash clients get a plain node list in their own format, usable as a Surge policy-path,
+// Loon [Remote Proxy] or QX server_remote resource. Generated configs only reference the provider
+// for clash-to-surge (policy-path) and sing-box (outbound_providers); Loon and QX configs are not
+// generated here, so the URL has to be added in those clients by hand.
 func NewProxyProviderServeHandler(repo *storage.TrafficRepository) http.Handler {
 	if repo == nil {
 		panic("proxy provider serve handler requires repository")
@@ -167,29 +172,135 @@ func NewProxyProviderServeHandler(repo *storage.TrafficRepository) http.Handler
 			return
 		}
 
+		// 客户端类型：t 参数优先，其次按 User-Agent 识别；Clash 系客户端直接输出 YAML
+		clientType := proxyProviderClientType(r)
+
 		// 检查缓存
 		cache := GetProxyProviderCache()
 		if entry, ok := cache.Get(configID); ok && !cache.IsExpired(entry) {
 			logger.Info("[ProxyProviderServe] 使用缓存", "id", configID, "node_count", entry.NodeCount)
-			w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(entry.YAMLData)
+			writeProxyProviderEntry(w, r, repo, pruneUnhealthyProxyProviderEntry(r.Context(), repo, config, entry), clientType)
 			return
 		}
 
-		// 缓存未命中或过期，拉取新数据
-		entry, err := RefreshProxyProviderCache(&sub, config)
+		// 缓存未命中或过期，拉取新数据；上游失败时使用旧缓存
+		entry, stale, err := RefreshProxyProviderCacheOrStale(&sub, config)
 		if err != nil {
 			logger.Info("[ProxyProviderServe] 拉取代理节点失败", "config_id", configID, "error", err)
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
+		if stale {
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+			w.Header().Set("X-Cache-Stale", "true")
+			w.Header().Set("X-Cache-Fetched-At", entry.FetchedAt.UTC().Format(http.TimeFormat))
+		}
 
 		// Output directly without download
+		writeProxyProviderEntry(w, r, repo, pruneUnhealthyProxyProviderEntry(r.Context(), repo, config, entry), clientType)
+	})
+}
+
+// proxyProviderClientType 返回代理集合请求的客户端类型（substore 生成器类型）
+// 优先使用 t 参数，否则按 User-Agent 识别；Clash 系客户端返回空，直接输出 Clash YAML
+func proxyProviderClientType(r *http.Request) string {
+	clientType := strings.TrimSpace(r.URL.Query().Get("t"))
+	if clientType == "" {
+		clientType = substore.DetectClientType(r.Header.Get("User-Agent"))
+	}
+	switch clientType {
+	case "clash", "clashmeta", "stash":
+		return ""
+	case "clash-to-surge":
+		return "surge"
+	}
+	return clientType
+}
+
+// mmwProxyProviderURLFunc 返回把代理集合地址改写为指定客户端格式的函数
+// 仅妙妙屋处理模式的代理集合（/api/proxy-provider/{id}）可按客户端格式输出，其余返回空
+func mmwProxyProviderURLFunc(ctx context.Context, repo *storage.TrafficRepository, clientType string) substore.ProxyProviderURLFunc {
+	return func(name string, provider substore.ClashProxyProvider) string {
+		u, err := url.Parse(provider.URL)
+		if err != nil {
+			return ""
+		}
+		configIDStr, found := strings.CutPrefix(u.Path, "/api/proxy-provider/")
+		if !found {
+			return ""
+		}
+		configID, err := strconv.ParseInt(configIDStr, 10, 64)
+		if err != nil {
+			return ""
+		}
+		config, err := repo.GetProxyProviderConfig(ctx, configID)
+		if err != nil || config == nil || config.ProcessMode != "mmw" {
+			return ""
+		}
+		return substore.ProxyProviderURLForClient(provider.URL, clientType)
+	}
+}
+
+// writeProxyProviderEntry 按客户端格式输出代理集合节点
+func writeProxyProviderEntry(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository, entry *CacheEntry, clientType string) {
+	if clientType == "" {
 		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(entry.YAMLData)
+		return
+	}
+
+	data, err := convertProxyProviderYAML(r.Context(), repo, entry.YAMLData, clientType)
+	if err != nil {
+		logger.Info("[ProxyProviderServe] 格式转换失败", "config_id", entry.ConfigID, "client_type", clientType, "error", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	contentType, _ := clientContentType(clientType)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// convertProxyProviderYAML 使用 substore 生成器把代理集合的 Clash YAML 转换为指定客户端的节点列表
+// 每次从 YAML 重新解析，避免生成器修改缓存中共享的节点
+func convertProxyProviderYAML(ctx context.Context, repo *storage.TrafficRepository, yamlData []byte, clientType string) ([]byte, error) {
+	producer, err := substore.GetDefaultFactory().GetProducer(clientType)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported client type '%s': %w", clientType, err)
+	}
+
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(yamlData, &rootNode); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	config, err := yamlNodeToMap(&rootNode)
+	if err != nil {
+		return nil, fmt.Errorf("convert yaml node: %w", err)
+	}
+	proxiesArray, _ := config["proxies"].([]interface{})
+	proxies := make([]substore.Proxy, 0, len(proxiesArray))
+	for _, p := range proxiesArray {
+		if proxyMap, ok := p.(map[string]interface{}); ok {
+			proxies = append(proxies, substore.Proxy(proxyMap))
+		}
+	}
+
+	systemConfig, _ := repo.GetSystemConfig(ctx)
+	result, err := producer.Produce(proxies, "", &substore.ProduceOptions{
+		ClientCompatibilityMode: systemConfig.ClientCompatibilityMode,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("produce proxies: %w", err)
+	}
+	switch v := result.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unexpected result type from producer: %T", result)
+	}
 }
 
 // fetchSubscriptionContent fetches subscription content with caching (5 min TTL)
@@ -209,33 +320,22 @@ func fetchSubscriptionContent(sub *storage.ExternalSubscription) ([]byte, error)
 
 	logger.Info("[SubscriptionCache] 缓存未命中，正在拉取", "url", sub.URL)
 
-	// 拉取订阅内容
+	// 拉取订阅内容（条件请求，304 时复用缓存内容）
 	client := &http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequest(http.MethodGet, sub.URL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-
 	userAgent := sub.UserAgent
 	if userAgent == "" {
 		userAgent = "clash-meta/2.4.0"
 	}
-	req.Header.Set("User-Agent", userAgent)
 
-	resp, err := client.Do(req)
+	resp, err := fetchUpstreamSubscription(context.Background(), client, sub, userAgent)
 	if err != nil {
 		return nil, fmt.Errorf("fetch subscription: %w", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read response body: %w", err)
-	}
+	body := resp.Body
 
 	// 存入缓存
 	subscriptionCache.Store(cacheKey, &subscriptionCacheEntry{
@@ -1581,33 +1681,21 @@ func getIntValue(m map[string]interface{}, key string) int {
 // FetchAndFilterProxiesYAML fetches proxies from external subscription and applies filters
 // Returns YAML bytes preserving original field order with 2-space indentation
 func FetchAndFilterProxiesYAML(sub *storage.ExternalSubscription, config *storage.ProxyProviderConfig) ([]byte, error) {
-	// Fetch subscription content (with caching)
-	body, err := fetchSubscriptionContent(sub)
+	// Fetch proxies from the subscription (merging extra sources for composite providers),
+	// with each subscription's own node transform pipeline applied
+	proxiesNode, err := loadProxyProviderProxiesNode(context.Background(), sub, config)
 	if err != nil {
 		return nil, err
 	}
 
-	// Preprocess content (handle base64 encoding and URI list conversion)
-	body, err = preprocessSubscriptionContent(body)
-	if err != nil {
-		return nil, fmt.Errorf("preprocess subscription content: %w", err)
-	}
-
-	// Parse YAML content using yaml.Node to preserve order
-	var rootNode yaml.Node
-	if err := yaml.Unmarshal(body, &rootNode); err != nil {
-		return nil, fmt.Errorf("parse yaml: %w", err)
-	}
-
-	// Find proxies node
-	proxiesNode := findProxiesNode(&rootNode)
-	if proxiesNode == nil || proxiesNode.Kind != yaml.SequenceNode {
-		return nil, fmt.Errorf("no proxies found in subscription")
-	}
-
 	// Apply filters to proxies node
 	filteredProxiesNode := applyFiltersToNode(proxiesNode, config)
 
+	// Apply the proxy provider's own node transform pipeline
+	if err := applyNodePipelinesToYAML(filteredProxiesNode, loadNodePipeline(config.NodeTransforms, config.Name)); err != nil {
+		return nil, fmt.Errorf("apply node transforms: %w", err)
+	}
+
 	// Apply overrides to proxies node
 	if config.Override != "" {
 		applyOverridesToNode(filteredProxiesNode, config.Override)
@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+	"miaomiaowu/internal/substore"
+)
+
+const (
+	ruleSetConvertCacheTTL   = time.Hour
+	ruleSetConvertCacheLimit = 512 // 最多缓存的转换结果数量
+)
+
+// ruleSetConvertCacheEntry 规则集转换结果缓存条目
+type ruleSetConvertCacheEntry struct {
+	content     []byte
+	contentType string
+	skipped     int
+	createdAt   time.Time
+}
+
+// ruleSetConvertCache 规则集转换结果缓存 (key: target|behavior|format|policy|url)
+var ruleSetConvertCache = struct {
+	mu      sync.Mutex
+	entries map[string]*ruleSetConvertCacheEntry
+}{entries: make(map[string]*ruleSetConvertCacheEntry)}
+
+// ruleSetConvertClient 只连接公网地址：转换接口对持有 token 的用户开放，
+// 防止借此访问本机、内网或云厂商元数据地址
+var ruleSetConvertClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         publicAddressDialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        16,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// publicAddressDialer 在 DNS 解析后检查实际连接的地址，重定向同样经过该检查
+var publicAddressDialer = &net.Dialer{
+	Timeout:   10 * time.Second,
+	KeepAlive: 30 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+			return fmt.Errorf("refusing to connect to non-public address %s", host)
+		}
+		return nil
+	},
+}
+
+// nonPublicIPRanges 标准库未归类但同样不应访问的地址段
+var nonPublicIPRanges = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"), // 运营商级 NAT
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// isPublicIP 判断地址是否为可访问的公网地址
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicIPRanges {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleSetConvertSignature 对转换接口的源地址签名，只有服务端生成的地址才能被转换
+func ruleSetConvertSignature(key []byte, sourceURL string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("rule-set-convert\n" + sourceURL))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ruleSetConvertAllowed 源地址须带有效签名，或是已登记的规则集镜像
+func ruleSetConvertAllowed(ctx context.Context, repo *storage.TrafficRepository, sourceURL, signature string) bool {
+	if signature != "" {
+		if key, err := repo.GetOrCreateURLSigningKey(ctx); err == nil &&
+			hmac.Equal([]byte(signature), []byte(ruleSetConvertSignature(key, sourceURL))) {
+			return true
+		}
+	}
+	_, err := repo.GetRuleSetMirrorByURL(ctx, sourceURL)
+	return err == nil
+}
+
+// ruleListTargetForClient 返回客户端类型对应的规则列表格式，不需要转换时返回空字符串
+func ruleListTargetForClient(clientType string) string {
+	switch clientType {
+	case "surge", "surgemac", "clash-to-surge", "shadowrocket", "egern", "surfboard":
+		return substore.RuleListTargetSurge
+	case "loon":
+		return substore.RuleListTargetLoon
+	case "qx":
+		return substore.RuleListTargetQX
+	case "sing-box", "singbox":
+		return substore.RuleListTargetSingbox
+	}
+	return ""
+}
+
+// ruleSetConvertURLFunc 构造将 RULE-SET 地址改写到转换接口的函数
+// 无法确定用户 token 或客户端不需要转换时返回 nil
+func ruleSetConvertURLFunc(r *http.Request, repo *storage.TrafficRepository, username, clientType string) substore.RuleSetURLFunc {
+	target := ruleListTargetForClient(clientType)
+	if target == "" || repo == nil || username == "" {
+		return nil
+	}
+	cfg, err := repo.GetSystemConfig(r.Context())
+	if err != nil {
+		return nil
+	}
+	token, err := repo.GetOrCreateUserToken(r.Context(), username)
+	if err != nil || token == "" {
+		return nil
+	}
+	key, err := repo.GetOrCreateURLSigningKey(r.Context())
+	if err != nil {
+		logger.Warn("[规则集转换] 读取签名密钥失败", "error", err)
+		return nil
+	}
+	base := requestBaseURL(r, cfg)
+
+	return func(name string, provider substore.ClashRuleProvider) string {
+		if !strings.HasPrefix(provider.URL, "http://") && !strings.HasPrefix(provider.URL, "https://") {
+			return ""
+		}
+		query := url.Values{}
+		query.Set("target", target)
+		query.Set("url", provider.URL)
+		if provider.Behavior != "" {
+			query.Set("behavior", provider.Behavior)
+		}
+		if provider.Format != "" {
+			query.Set("format", provider.Format)
+		}
+		query.Set("sig", ruleSetConvertSignature(key, provider.URL))
+		query.Set("token", token)
+		return base + "/api/rule-set/convert?" + query.Encode()
+	}
+}
+
+// NewRuleSetConvertHandler 将 rule-provider 内容转换为 Surge/Loon/QX 规则列表或 sing-box source 规则集
+// URL: /api/rule-set/convert?token=&url=&sig=&target=surge|loon|qx|singbox[&behavior=&format=&policy=]
+// url 须带有订阅生成时的签名 sig，或是已登记的规则集镜像
+func NewRuleSetConvertHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("rule set convert handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		query := r.URL.Query()
+		token := query.Get("token")
+		if token == "" {
+			token = r.Header.Get("Authorization")
+			if after, ok := strings.CutPrefix(token, "Bearer "); ok {
+				token = after
+			}
+		}
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("token required"))
+			return
+		}
+		if username, err := repo.ValidateUserToken(r.Context(), token); err != nil || username == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		sourceURL := strings.TrimSpace(query.Get("url"))
+		parsed, err := url.Parse(sourceURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			writeError(w, http.StatusBadRequest, errors.New("url must be an http(s) address"))
+			return
+		}
+		if !ruleSetConvertAllowed(r.Context(), repo, sourceURL, query.Get("sig")) {
+			writeError(w, http.StatusForbidden, errors.New("url is not a known rule provider"))
+			return
+		}
+
+		target := strings.ToLower(strings.TrimSpace(query.Get("target")))
+		if target == "sing-box" {
+			target = substore.RuleListTargetSingbox
+		}
+		switch target {
+		case substore.RuleListTargetSurge, substore.RuleListTargetLoon, substore.RuleListTargetQX, substore.RuleListTargetSingbox:
+		default:
+			writeError(w, http.StatusBadRequest, errors.New("target must be surge, loon, qx or singbox"))
+			return
+		}
+
+		behavior := strings.ToLower(strings.TrimSpace(query.Get("behavior")))
+		if behavior == "" {
+			behavior = "classical"
+		}
+		format := substore.DetectRuleProviderFormat(sourceURL, query.Get("format"))
+		policy := strings.TrimSpace(query.Get("policy"))
+
+		cacheKey := strings.Join([]string{target, behavior, format, policy, sourceURL}, "|")
+		entry, ok := getRuleSetConvertCache(cacheKey)
+		if !ok {
+			entry, err = convertRuleProvider(r.Context(), repo, sourceURL, behavior, format, target, policy)
+			if err != nil {
+				status := http.StatusBadGateway
+				if errors.Is(err, substore.ErrBinaryRuleProvider) {
+					status = http.StatusUnprocessableEntity
+				}
+				logger.Info("[规则集转换] 转换失败", "url", sourceURL, "target", target, "error", err)
+				writeError(w, status, err)
+				return
+			}
+			setRuleSetConvertCache(cacheKey, entry)
+		}
+
+		w.Header().Set("Content-Type", entry.contentType)
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(ruleSetConvertCacheTTL.Seconds())))
+		w.Header().Set("X-Rule-Skipped", strconv.Itoa(entry.skipped))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(entry.content)
+		}
+	})
+}
+
+// convertRuleProvider 读取 rule-provider 内容并转换为目标格式
+func convertRuleProvider(ctx context.Context, repo *storage.TrafficRepository, sourceURL, behavior, format, target, policy string) (*ruleSetConvertCacheEntry, error) {
+	entries, err := loadRuleProviderEntries(ctx, repo, sourceURL, behavior, format)
+	if err != nil {
+		return nil, err
+	}
+
+	content, skipped, err := substore.ConvertRuleProviderEntries(entries, target, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if target == substore.RuleListTargetSingbox {
+		contentType = "application/json; charset=utf-8"
+	}
+	return &ruleSetConvertCacheEntry{
+		content:     []byte(content),
+		contentType: contentType,
+		skipped:     len(skipped),
+		createdAt:   time.Now(),
+	}, nil
+}
+
+// loadRuleProviderEntries 读取 rule-provider 并解析为不带策略的规则条目
+// mrs 二进制规则集会尝试同目录下的 .list 文本版本
+func loadRuleProviderEntries(ctx context.Context, repo *storage.TrafficRepository, sourceURL, behavior, format string) ([]string, error) {
+	if format == substore.RuleProviderFormatMRS {
+		fallback, ok := substore.RuleProviderTextFallbackURL(sourceURL)
+		if !ok {
+			return nil, substore.ErrBinaryRuleProvider
+		}
+		sourceURL = fallback
+		format = substore.RuleProviderFormatText
+	}
+
+	data, err := loadRuleProviderContent(ctx, repo, sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	return substore.ParseRuleProviderPayload(data, behavior, format)
+}
+
+// loadRuleProviderContent 优先读取本地镜像，否则从上游下载
+func loadRuleProviderContent(ctx context.Context, repo *storage.TrafficRepository, sourceURL string) ([]byte, error) {
+	if mirror, err := repo.GetRuleSetMirrorByURL(ctx, sourceURL); err == nil && mirror.LocalPath != "" {
+		if data, err := os.ReadFile(mirror.LocalPath); err == nil {
+			return data, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("User-Agent", "clash.meta")
+
+	resp, err := ruleSetConvertClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch rule provider: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch rule provider: unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, ruleSetMirrorMaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read rule provider: %w", err)
+	}
+	if len(data) > ruleSetMirrorMaxSize {
+		return nil, fmt.Errorf("rule provider exceeds %d bytes", ruleSetMirrorMaxSize)
+	}
+	return data, nil
+}
+
+func getRuleSetConvertCache(key string) (*ruleSetConvertCacheEntry, bool) {
+	ruleSetConvertCache.mu.Lock()
+	defer ruleSetConvertCache.mu.Unlock()
+
+	entry, ok := ruleSetConvertCache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(entry.createdAt) > ruleSetConvertCacheTTL {
+		delete(ruleSetConvertCache.entries, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func setRuleSetConvertCache(key string, entry *ruleSetConvertCacheEntry) {
+	ruleSetConvertCache.mu.Lock()
+	defer ruleSetConvertCache.mu.Unlock()
+
+	if len(ruleSetConvertCache.entries) >= ruleSetConvertCacheLimit {
+		// 淘汰最旧的条目
+		var oldestKey string
+		var oldest time.Time
+		for k, e := range ruleSetConvertCache.entries {
+			if oldestKey == "" || e.createdAt.Before(oldest) {
+				oldestKey, oldest = k, e.createdAt
+			}
+		}
+		delete(ruleSetConvertCache.entries, oldestKey)
+	}
+	ruleSetConvertCache.entries[key] = entry
+}
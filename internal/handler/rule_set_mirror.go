@@ -0,0 +1,895 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/proxygroups"
+	"miaomiaowu/internal/storage"
+	"miaomiaowu/internal/substore"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ruleSetMirrorDir          = "data/rule_sets"
+	ruleSetMirrorScanInterval = 10 * time.Minute // 检查是否到达刷新时间的间隔
+	ruleSetMirrorWorkerLimit  = 4                // 同时下载的规则集数量
+	ruleSetMirrorFetchTimeout = 60 * time.Second
+	ruleSetMirrorMaxSize      = 32 << 20 // 单个规则集最大 32MB
+)
+
+// ruleSetSource 被模板/配置引用的规则集
+type ruleSetSource struct {
+	Name     string
+	URL      string
+	Behavior string
+	Format   string
+}
+
+// ruleSetMirrorSyncer 规则集镜像同步器
+// 定时收集 rule_templates、subscribes 和代理组配置中引用的 rule-provider，下载到本地
+type ruleSetMirrorSyncer struct {
+	repo         *storage.TrafficRepository
+	subscribeDir string
+	templatesDir string
+	groups       *proxygroups.Store
+	client       *http.Client
+	trigger      chan struct{}
+
+	mu      sync.Mutex
+	lastRun time.Time
+	running bool
+	pending map[string]ruleSetSource // 订阅请求中发现的未登记规则集，下次同步时登记，key: URL
+}
+
+// ruleSetMirrorPendingLimit 待登记规则集的数量上限，避免异常订阅撑大内存
+const ruleSetMirrorPendingLimit = 1024
+
+var globalRuleSetMirrorSyncer atomic.Pointer[ruleSetMirrorSyncer]
+
+// StartRuleSetMirrorSync 启动规则集镜像定时同步
+// 该函数会阻塞，直到context被取消
+func StartRuleSetMirrorSync(ctx context.Context, repo *storage.TrafficRepository, subscribeDir, templatesDir string, groups *proxygroups.Store) {
+	if repo == nil {
+		return
+	}
+
+	s := &ruleSetMirrorSyncer{
+		repo:         repo,
+		subscribeDir: subscribeDir,
+		templatesDir: templatesDir,
+		groups:       groups,
+		client:       &http.Client{Timeout: ruleSetMirrorFetchTimeout},
+		trigger:      make(chan struct{}, 1),
+		pending:      make(map[string]ruleSetSource),
+	}
+	globalRuleSetMirrorSyncer.Store(s)
+	s.run(ctx)
+}
+
+// noteRuleSetSource 记录订阅请求中发现的未镜像规则集，由同步器在下次同步时登记，
+// 避免在订阅请求中写数据库
+func noteRuleSetSource(src ruleSetSource) {
+	s := globalRuleSetMirrorSyncer.Load()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[src.URL]; ok || len(s.pending) >= ruleSetMirrorPendingLimit {
+		return
+	}
+	s.pending[src.URL] = src
+}
+
+// TriggerRuleSetMirrorSync 手动触发一次规则集镜像同步（忽略启用开关和刷新间隔）
+// 同步器未启动时返回 false
+func TriggerRuleSetMirrorSync() bool {
+	s := globalRuleSetMirrorSyncer.Load()
+	if s == nil {
+		return false
+	}
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// 已有待执行的触发
+	}
+	return true
+}
+
+func (s *ruleSetMirrorSyncer) run(ctx context.Context) {
+	logger.Info("[规则集镜像] 调度器启动", "scan_interval", ruleSetMirrorScanInterval.String(), "max_workers", ruleSetMirrorWorkerLimit)
+	defer logger.Info("[规则集镜像] 调度器已退出")
+
+	s.runIfDue(ctx)
+
+	ticker := time.NewTicker(ruleSetMirrorScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.trigger:
+			s.runCycle(ctx)
+		case <-ticker.C:
+			s.runIfDue(ctx)
+		}
+	}
+}
+
+// runIfDue 在启用镜像且距离上次同步超过配置间隔时执行同步
+func (s *ruleSetMirrorSyncer) runIfDue(ctx context.Context) {
+	cfg, err := s.repo.GetSystemConfig(ctx)
+	if err != nil {
+		logger.Warn("[规则集镜像] 读取系统配置失败", "error", err)
+		return
+	}
+	if !cfg.RuleSetMirrorEnabled {
+		return
+	}
+
+	s.mu.Lock()
+	lastRun := s.lastRun
+	s.mu.Unlock()
+
+	interval := time.Duration(cfg.RuleSetMirrorInterval) * time.Hour
+	if !lastRun.IsZero() && time.Since(lastRun) < interval {
+		return
+	}
+	s.runCycle(ctx)
+}
+
+// runCycle 执行一次完整同步：登记所有引用的规则集，然后并发刷新
+func (s *ruleSetMirrorSyncer) runCycle(ctx context.Context) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.lastRun = time.Now()
+		s.mu.Unlock()
+	}()
+
+	start := time.Now()
+	sources := collectRuleSetSources(s.templatesDir, s.subscribeDir, s.groups)
+	s.mu.Lock()
+	for _, src := range s.pending {
+		sources = append(sources, src)
+	}
+	s.pending = make(map[string]ruleSetSource)
+	s.mu.Unlock()
+	for _, src := range sources {
+		if _, err := s.repo.UpsertRuleSetMirror(ctx, storage.RuleSetMirror{
+			URL:      src.URL,
+			Name:     src.Name,
+			Behavior: src.Behavior,
+			Format:   src.Format,
+		}); err != nil {
+			logger.Warn("[规则集镜像] 登记规则集失败", "url", src.URL, "error", err)
+		}
+	}
+
+	mirrors, err := s.repo.ListRuleSetMirrors(ctx)
+	if err != nil {
+		logger.Warn("[规则集镜像] 读取镜像列表失败", "error", err)
+		return
+	}
+
+	if err := os.MkdirAll(ruleSetMirrorDir, 0o755); err != nil {
+		logger.Warn("[规则集镜像] 创建镜像目录失败", "dir", ruleSetMirrorDir, "error", err)
+		return
+	}
+
+	var (
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, ruleSetMirrorWorkerLimit)
+		mu      sync.Mutex
+		updated int
+		failed  int
+	)
+	for _, m := range mirrors {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case workers <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(m storage.RuleSetMirror) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			result := s.fetchMirror(ctx, m)
+			if err := s.repo.UpdateRuleSetMirrorFetchResult(ctx, m.ID, result); err != nil {
+				logger.Warn("[规则集镜像] 保存同步结果失败", "id", m.ID, "error", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if result.Err != nil {
+				failed++
+				logger.Warn("[规则集镜像] 同步失败", "id", m.ID, "url", m.URL, "error", result.Err)
+			} else if !result.NotModified {
+				updated++
+			}
+		}(m)
+	}
+	wg.Wait()
+
+	logger.Info("[规则集镜像] 同步完成",
+		"total", len(mirrors),
+		"updated", updated,
+		"failed", failed,
+		"duration_ms", time.Since(start).Milliseconds())
+}
+
+// fetchMirror 下载单个规则集，支持 ETag / Last-Modified 条件请求
+func (s *ruleSetMirrorSyncer) fetchMirror(ctx context.Context, m storage.RuleSetMirror) storage.RuleSetFetchResult {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
+	if err != nil {
+		return storage.RuleSetFetchResult{Err: fmt.Errorf("build request: %w", err)}
+	}
+	req.Header.Set("User-Agent", "clash.meta")
+
+	hasLocal := false
+	if m.LocalPath != "" {
+		if _, err := os.Stat(m.LocalPath); err == nil {
+			hasLocal = true
+		}
+	}
+	if hasLocal {
+		if m.ETag != "" {
+			req.Header.Set("If-None-Match", m.ETag)
+		}
+		if m.LastModified != "" {
+			req.Header.Set("If-Modified-Since", m.LastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return storage.RuleSetFetchResult{Err: fmt.Errorf("fetch: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && hasLocal {
+		return storage.RuleSetFetchResult{NotModified: true}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return storage.RuleSetFetchResult{Err: fmt.Errorf("unexpected status %d", resp.StatusCode)}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, ruleSetMirrorMaxSize+1))
+	if err != nil {
+		return storage.RuleSetFetchResult{Err: fmt.Errorf("read body: %w", err)}
+	}
+	if len(data) > ruleSetMirrorMaxSize {
+		return storage.RuleSetFetchResult{Err: fmt.Errorf("rule set exceeds %d bytes", ruleSetMirrorMaxSize)}
+	}
+	if len(data) == 0 {
+		return storage.RuleSetFetchResult{Err: errors.New("empty response")}
+	}
+
+	format := substore.DetectRuleProviderFormat(m.URL, m.Format)
+	ruleCount := -1
+	if entries, err := substore.ParseRuleProviderPayload(data, m.Behavior, format); err == nil {
+		ruleCount = len(entries)
+	}
+
+	localPath := ruleSetMirrorPath(m.URL, format)
+	tmpPath := localPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return storage.RuleSetFetchResult{Err: fmt.Errorf("write file: %w", err)}
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return storage.RuleSetFetchResult{Err: fmt.Errorf("rename file: %w", err)}
+	}
+
+	sum := sha256.Sum256(data)
+	return storage.RuleSetFetchResult{
+		LocalPath:    localPath,
+		Size:         int64(len(data)),
+		RuleCount:    ruleCount,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentHash:  hex.EncodeToString(sum[:]),
+	}
+}
+
+// ruleSetMirrorPath 根据 URL 生成本地镜像文件路径
+func ruleSetMirrorPath(rawURL, format string) string {
+	sum := sha1.Sum([]byte(rawURL))
+	ext := ".yaml"
+	switch format {
+	case substore.RuleProviderFormatMRS:
+		ext = ".mrs"
+	case substore.RuleProviderFormatText:
+		ext = ".list"
+	}
+	return filepath.Join(ruleSetMirrorDir, hex.EncodeToString(sum[:])+ext)
+}
+
+// collectRuleSetSources 收集所有被引用的 http rule-provider（按 URL 去重）
+func collectRuleSetSources(templatesDir, subscribeDir string, groups *proxygroups.Store) []ruleSetSource {
+	seen := make(map[string]bool)
+	var sources []ruleSetSource
+	add := func(src ruleSetSource) {
+		src.URL = strings.TrimSpace(src.URL)
+		if !strings.HasPrefix(src.URL, "http://") && !strings.HasPrefix(src.URL, "https://") {
+			return
+		}
+		if seen[src.URL] {
+			return
+		}
+		seen[src.URL] = true
+		if src.Behavior == "" {
+			src.Behavior = "classical"
+		}
+		src.Format = substore.DetectRuleProviderFormat(src.URL, src.Format)
+		sources = append(sources, src)
+	}
+
+	for _, dir := range []string{templatesDir, subscribeDir} {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if ext != ".yaml" && ext != ".yml" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			for _, src := range ruleProvidersFromYAML(data) {
+				add(src)
+			}
+		}
+	}
+
+	if groups != nil {
+		type ruleRef struct {
+			Key      string `json:"key"`
+			Behavior string `json:"behavior"`
+			Format   string `json:"format"`
+			URL      string `json:"url"`
+		}
+		var categories []struct {
+			SiteRules []ruleRef `json:"site_rules"`
+			IPRules   []ruleRef `json:"ip_rules"`
+		}
+		if err := groups.Unmarshal(&categories); err == nil {
+			for _, c := range categories {
+				for _, ref := range append(c.SiteRules, c.IPRules...) {
+					add(ruleSetSource{Name: ref.Key, URL: ref.URL, Behavior: ref.Behavior, Format: ref.Format})
+				}
+			}
+		}
+	}
+
+	return sources
+}
+
+// ruleProvidersFromYAML 解析 YAML 中 rule-providers 的 http 类型条目
+func ruleProvidersFromYAML(data []byte) []ruleSetSource {
+	var doc struct {
+		RuleProviders map[string]struct {
+			Type     string `yaml:"type"`
+			Behavior string `yaml:"behavior"`
+			Format   string `yaml:"format"`
+			URL      string `yaml:"url"`
+		} `yaml:"rule-providers"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+
+	sources := make([]ruleSetSource, 0, len(doc.RuleProviders))
+	for name, p := range doc.RuleProviders {
+		if p.Type != "" && p.Type != "http" {
+			continue
+		}
+		sources = append(sources, ruleSetSource{Name: name, URL: p.URL, Behavior: p.Behavior, Format: p.Format})
+	}
+	return sources
+}
+
+// NewRuleSetServeHandler 提供镜像规则集下载
+// URL: /api/rule-set/{id}?token={user_token}
+func NewRuleSetServeHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("rule set serve handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/rule-set/"), "/")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid rule set id"))
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			token = r.Header.Get("Authorization")
+			if after, ok := strings.CutPrefix(token, "Bearer "); ok {
+				token = after
+			}
+		}
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("token required"))
+			return
+		}
+		if username, err := repo.ValidateUserToken(r.Context(), token); err != nil || username == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		mirror, err := repo.GetRuleSetMirror(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, storage.ErrRuleSetMirrorNotFound) {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if mirror.LocalPath == "" {
+			writeError(w, http.StatusServiceUnavailable, errors.New("rule set not mirrored yet"))
+			return
+		}
+
+		file, err := os.Open(mirror.LocalPath)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("open mirrored rule set: %w", err))
+			return
+		}
+		defer file.Close()
+
+		stat, err := file.Stat()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		switch substore.DetectRuleProviderFormat(mirror.URL, mirror.Format) {
+		case substore.RuleProviderFormatMRS:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		case substore.RuleProviderFormatText:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		default:
+			w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+		}
+		if mirror.ContentHash != "" {
+			w.Header().Set("ETag", `"`+mirror.ContentHash+`"`)
+		}
+		http.ServeContent(w, r, filepath.Base(mirror.LocalPath), stat.ModTime(), file)
+	})
+}
+
+type ruleSetMirrorConfigPayload struct {
+	Enabled        bool   `json:"enabled"`
+	Interval       int    `json:"interval"` // 小时
+	RewriteURL     bool   `json:"rewrite_url"`
+	InlineMaxRules int    `json:"inline_max_rules"`
+	PublicBaseURL  string `json:"public_base_url"`
+}
+
+type ruleSetMirrorResponse struct {
+	ID            int64      `json:"id"`
+	URL           string     `json:"url"`
+	Name          string     `json:"name"`
+	Behavior      string     `json:"behavior"`
+	Format        string     `json:"format"`
+	Mirrored      bool       `json:"mirrored"`
+	Size          int64      `json:"size"`
+	RuleCount     int        `json:"rule_count"`
+	LastFetchedAt *time.Time `json:"last_fetched_at,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// NewRuleSetMirrorAdminHandler 规则集镜像管理接口
+// GET    /api/admin/rule-set-mirrors        镜像列表和配置
+// PUT    /api/admin/rule-set-mirrors        更新镜像配置
+// POST   /api/admin/rule-set-mirrors/sync   立即同步
+// DELETE /api/admin/rule-set-mirrors/{id}   删除镜像记录和本地文件
+func NewRuleSetMirrorAdminHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("rule set mirror admin handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/rule-set-mirrors"), "/")
+
+		switch {
+		case sub == "" && r.Method == http.MethodGet:
+			handleListRuleSetMirrors(w, r, repo)
+		case sub == "" && r.Method == http.MethodPut:
+			handleUpdateRuleSetMirrorConfig(w, r, repo)
+		case sub == "sync" && r.Method == http.MethodPost:
+			if !TriggerRuleSetMirrorSync() {
+				writeError(w, http.StatusServiceUnavailable, errors.New("rule set mirror syncer not running"))
+				return
+			}
+			respondJSON(w, http.StatusAccepted, map[string]any{"message": "同步已开始"})
+		case sub != "" && r.Method == http.MethodDelete:
+			id, err := strconv.ParseInt(sub, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errors.New("invalid rule set id"))
+				return
+			}
+			mirror, err := repo.GetRuleSetMirror(r.Context(), id)
+			if err != nil {
+				if errors.Is(err, storage.ErrRuleSetMirrorNotFound) {
+					writeError(w, http.StatusNotFound, err)
+					return
+				}
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if err := repo.DeleteRuleSetMirror(r.Context(), id); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if mirror.LocalPath != "" {
+				_ = os.Remove(mirror.LocalPath)
+			}
+			respondJSON(w, http.StatusOK, map[string]any{"message": "已删除"})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+}
+
+func handleListRuleSetMirrors(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository) {
+	cfg, err := repo.GetSystemConfig(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	mirrors, err := repo.ListRuleSetMirrors(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	items := make([]ruleSetMirrorResponse, 0, len(mirrors))
+	for _, m := range mirrors {
+		items = append(items, ruleSetMirrorResponse{
+			ID:            m.ID,
+			URL:           m.URL,
+			Name:          m.Name,
+			Behavior:      m.Behavior,
+			Format:        m.Format,
+			Mirrored:      m.LocalPath != "",
+			Size:          m.Size,
+			RuleCount:     m.RuleCount,
+			LastFetchedAt: m.LastFetchedAt,
+			LastCheckedAt: m.LastCheckedAt,
+			LastError:     m.LastError,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"config": ruleSetMirrorConfigPayload{
+			Enabled:        cfg.RuleSetMirrorEnabled,
+			Interval:       cfg.RuleSetMirrorInterval,
+			RewriteURL:     cfg.RuleSetRewriteURL,
+			InlineMaxRules: cfg.RuleSetInlineMaxRules,
+			PublicBaseURL:  cfg.PublicBaseURL,
+		},
+		"mirrors": items,
+	})
+}
+
+func handleUpdateRuleSetMirrorConfig(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository) {
+	var payload ruleSetMirrorConfigPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.InlineMaxRules < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("inline_max_rules must be >= 0"))
+		return
+	}
+	publicBaseURL := strings.TrimSpace(payload.PublicBaseURL)
+	if publicBaseURL != "" {
+		if err := validateProxyGroupsSourceURL(publicBaseURL); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("public_base_url 仅支持 http 或 https 地址"))
+			return
+		}
+	}
+
+	cfg, err := repo.GetSystemConfig(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	cfg.RuleSetMirrorEnabled = payload.Enabled
+	cfg.RuleSetMirrorInterval = payload.Interval
+	cfg.RuleSetRewriteURL = payload.RewriteURL
+	cfg.RuleSetInlineMaxRules = payload.InlineMaxRules
+	cfg.PublicBaseURL = publicBaseURL
+	if err := repo.UpdateSystemConfig(r.Context(), cfg); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if cfg.RuleSetMirrorEnabled {
+		TriggerRuleSetMirrorSync()
+	}
+	handleListRuleSetMirrors(w, r, repo)
+}
+
+// requestBaseURL 返回生成绝对链接使用的基础地址
+// 优先使用系统配置的 public_base_url，否则根据请求推断（支持反向代理头）
+func requestBaseURL(r *http.Request, cfg storage.SystemConfig) string {
+	if base := strings.TrimRight(strings.TrimSpace(cfg.PublicBaseURL), "/"); base != "" {
+		return base
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if fwdHost := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0]); fwdHost != "" {
+		host = fwdHost
+	}
+	return scheme + "://" + host
+}
+
+// applyRuleSetMirror 将订阅中的 rule-providers 地址改写为本地镜像，并可选地内联小规则集
+// 仅处理已成功镜像的规则集；未镜像的保持原地址
+func applyRuleSetMirror(r *http.Request, repo *storage.TrafficRepository, username string, data []byte) []byte {
+	if repo == nil || username == "" {
+		return data
+	}
+	cfg, err := repo.GetSystemConfig(r.Context())
+	if err != nil || !cfg.RuleSetMirrorEnabled {
+		return data
+	}
+	inline := cfg.RuleSetInlineMaxRules > 0 && isTruthyQuery(r.URL.Query().Get("inline_rules"))
+	if !cfg.RuleSetRewriteURL && !inline {
+		return data
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return data
+	}
+	rootMap := root.Content[0]
+	providersNode := yamlMappingValue(rootMap, "rule-providers")
+	if providersNode == nil || providersNode.Kind != yaml.MappingNode {
+		return data
+	}
+
+	// 收集已镜像的 provider
+	mirrors := make(map[string]storage.RuleSetMirror) // provider name -> mirror
+	for i := 0; i+1 < len(providersNode.Content); i += 2 {
+		name := providersNode.Content[i].Value
+		urlNode := yamlMappingValue(providersNode.Content[i+1], "url")
+		if urlNode == nil {
+			continue
+		}
+		mirror, err := repo.GetRuleSetMirrorByURL(r.Context(), urlNode.Value)
+		if err != nil {
+			if errors.Is(err, storage.ErrRuleSetMirrorNotFound) {
+				// 新出现的规则集在下次同步时登记并下载
+				behavior := ""
+				if b := yamlMappingValue(providersNode.Content[i+1], "behavior"); b != nil {
+					behavior = b.Value
+				}
+				noteRuleSetSource(ruleSetSource{
+					URL:      urlNode.Value,
+					Name:     name,
+					Behavior: behavior,
+					Format:   substore.DetectRuleProviderFormat(urlNode.Value, ""),
+				})
+			}
+			continue
+		}
+		if mirror.LocalPath == "" {
+			continue
+		}
+		mirrors[name] = mirror
+	}
+	if len(mirrors) == 0 {
+		return data
+	}
+
+	changed := false
+	if inline {
+		if inlineRuleSets(rootMap, providersNode, mirrors, cfg.RuleSetInlineMaxRules) {
+			changed = true
+		}
+	}
+
+	if cfg.RuleSetRewriteURL {
+		token, err := repo.GetOrCreateUserToken(r.Context(), username)
+		if err == nil && token != "" {
+			base := requestBaseURL(r, cfg)
+			for i := 0; i+1 < len(providersNode.Content); i += 2 {
+				mirror, ok := mirrors[providersNode.Content[i].Value]
+				if !ok {
+					continue
+				}
+				if urlNode := yamlMappingValue(providersNode.Content[i+1], "url"); urlNode != nil {
+					urlNode.Value = fmt.Sprintf("%s/api/rule-set/%d?token=%s", base, mirror.ID, token)
+					changed = true
+				}
+			}
+		}
+	}
+
+	if !changed {
+		return data
+	}
+	out, err := MarshalYAMLWithIndent(&root)
+	if err != nil {
+		logger.Info("[规则集镜像] 序列化订阅失败", "error", err)
+		return data
+	}
+	return []byte(RemoveUnicodeEscapeQuotes(string(out)))
+}
+
+// inlineRuleSets 将规则数不超过 maxRules 的镜像规则集展开到 rules 中，并移除不再被引用的 provider
+func inlineRuleSets(rootMap, providersNode *yaml.Node, mirrors map[string]storage.RuleSetMirror, maxRules int) bool {
+	rulesNode := yamlMappingValue(rootMap, "rules")
+	if rulesNode == nil || rulesNode.Kind != yaml.SequenceNode {
+		return false
+	}
+
+	entriesCache := make(map[string][]string)
+	inlined := make(map[string]bool)
+	stillReferenced := make(map[string]bool)
+	newRules := make([]*yaml.Node, 0, len(rulesNode.Content))
+
+	// AND/OR/NOT 逻辑规则和 sub-rules 中引用的规则集无法展开，provider 需要保留
+	markNestedRuleSets(stillReferenced, rulesNode, false)
+	if subRules := yamlMappingValue(rootMap, "sub-rules"); subRules != nil && subRules.Kind == yaml.MappingNode {
+		for i := 1; i < len(subRules.Content); i += 2 {
+			markNestedRuleSets(stillReferenced, subRules.Content[i], true)
+		}
+	}
+
+	for _, ruleNode := range rulesNode.Content {
+		name, policy, noResolve, err := substore.ParseRuleSetReference(ruleNode.Value)
+		if err != nil {
+			newRules = append(newRules, ruleNode)
+			continue
+		}
+
+		mirror, ok := mirrors[name]
+		if !ok || mirror.RuleCount < 0 || mirror.RuleCount > maxRules {
+			stillReferenced[name] = true
+			newRules = append(newRules, ruleNode)
+			continue
+		}
+
+		entries, cached := entriesCache[name]
+		if !cached {
+			content, err := os.ReadFile(mirror.LocalPath)
+			if err == nil {
+				entries, err = substore.ParseRuleProviderPayload(content, mirror.Behavior, substore.DetectRuleProviderFormat(mirror.URL, mirror.Format))
+			}
+			if err != nil {
+				entries = nil
+			}
+			entriesCache[name] = entries
+		}
+		if entries == nil {
+			stillReferenced[name] = true
+			newRules = append(newRules, ruleNode)
+			continue
+		}
+
+		for _, rule := range substore.ExpandRuleSetEntries(entries, policy, noResolve) {
+			newRules = append(newRules, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: rule})
+		}
+		inlined[name] = true
+	}
+
+	if len(inlined) == 0 {
+		return false
+	}
+	rulesNode.Content = newRules
+
+	kept := make([]*yaml.Node, 0, len(providersNode.Content))
+	for i := 0; i+1 < len(providersNode.Content); i += 2 {
+		name := providersNode.Content[i].Value
+		if inlined[name] && !stillReferenced[name] {
+			continue
+		}
+		kept = append(kept, providersNode.Content[i], providersNode.Content[i+1])
+	}
+	providersNode.Content = kept
+	return true
+}
+
+// nestedRuleSetPattern 匹配逻辑规则中嵌套的 RULE-SET,<name>，如 AND,((RULE-SET,x),(NETWORK,UDP)),REJECT
+var nestedRuleSetPattern = regexp.MustCompile(`(?i)\(\s*RULE-SET\s*,\s*([^,()]+?)\s*[,)]`)
+
+// markNestedRuleSets 将逻辑规则中嵌套引用的规则集标记为仍被引用；
+// includeDirect 为 true 时（sub-rules）直接的 RULE-SET 规则也会被标记
+func markNestedRuleSets(referenced map[string]bool, rules *yaml.Node, includeDirect bool) {
+	if rules == nil || rules.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, ruleNode := range rules.Content {
+		rule := ruleNode.Value
+		if name, _, _, err := substore.ParseRuleSetReference(rule); err == nil {
+			if includeDirect {
+				referenced[name] = true
+			}
+			continue
+		}
+		for _, m := range nestedRuleSetPattern.FindAllStringSubmatch(rule, -1) {
+			referenced[m[1]] = true
+		}
+	}
+}
+
+// yamlMappingValue 返回映射节点中指定键的值节点
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func isTruthyQuery(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"miaomiaowu/internal/storage"
+)
+
+func TestInlineRuleSetsKeepsNestedReferences(t *testing.T) {
+	dir := t.TempDir()
+	mirrors := make(map[string]storage.RuleSetMirror)
+	for _, name := range []string{"ads", "logic", "sub", "plain"} {
+		path := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(path, []byte("payload:\n  - '+."+name+".example.com'\n"), 0o644); err != nil {
+			t.Fatalf("write mirror: %v", err)
+		}
+		mirrors[name] = storage.RuleSetMirror{Name: name, URL: "https://example.com/" + name + ".yaml", Behavior: "domain", Format: "yaml", LocalPath: path, RuleCount: 1}
+	}
+
+	const config = `rule-providers:
+  ads: {type: http, behavior: domain, url: https://example.com/ads.yaml}
+  logic: {type: http, behavior: domain, url: https://example.com/logic.yaml}
+  sub: {type: http, behavior: domain, url: https://example.com/sub.yaml}
+  plain: {type: http, behavior: domain, url: https://example.com/plain.yaml}
+rules:
+  - RULE-SET,ads,REJECT
+  - RULE-SET,logic,Proxy
+  - RULE-SET,plain,DIRECT
+  - AND,((RULE-SET,logic),(NETWORK,UDP)),REJECT
+  - SUB-RULE,(NETWORK,TCP),streaming
+  - MATCH,Proxy
+sub-rules:
+  streaming:
+    - RULE-SET,sub,Proxy
+    - NOT,((RULE-SET,ads)),DIRECT
+`
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(config), &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	root := doc.Content[0]
+	providers := yamlMappingValue(root, "rule-providers")
+	if !inlineRuleSets(root, providers, mirrors, 10) {
+		t.Fatal("inlineRuleSets reported no change")
+	}
+
+	var kept []string
+	for i := 0; i < len(providers.Content); i += 2 {
+		kept = append(kept, providers.Content[i].Value)
+	}
+	// plain 只被顶层 RULE-SET 引用，展开后移除；其余仍被逻辑规则或 sub-rules 引用
+	if want := []string{"ads", "logic", "sub"}; !reflect.DeepEqual(kept, want) {
+		t.Fatalf("kept providers = %v, want %v", kept, want)
+	}
+
+	var rules []string
+	for _, node := range yamlMappingValue(root, "rules").Content {
+		rules = append(rules, node.Value)
+	}
+	want := []string{
+		"DOMAIN-SUFFIX,ads.example.com,REJECT",
+		"DOMAIN-SUFFIX,logic.example.com,Proxy",
+		"DOMAIN-SUFFIX,plain.example.com,DIRECT",
+		"AND,((RULE-SET,logic),(NETWORK,UDP)),REJECT",
+		"SUB-RULE,(NETWORK,TCP),streaming",
+		"MATCH,Proxy",
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("rules = %v, want %v", rules, want)
+	}
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"miaomiaowu/internal/substore"
+)
+
+const (
+	ruleSimulateMaxQueries = 100
+	ruleSimulateTimeout    = 60 * time.Second
+)
+
+type ruleSimulateRequest struct {
+	Content string               `json:"content"` // 可选，未保存的 YAML 内容；为空时使用文件当前内容
+	Targets []string             `json:"targets"` // 简写：域名、IP、host:port
+	Queries []substore.RuleQuery `json:"queries"`
+	Resolve bool                 `json:"resolve"` // 仅提供域名时解析 IP，用于匹配 IP 类规则
+}
+
+// handleSimulate 模拟请求在订阅规则中的匹配结果
+// POST /api/admin/rules/{file}/simulate
+func (h *RuleEditorHandler) handleSimulate(w http.ResponseWriter, r *http.Request, filename string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.readLimit))
+	if err != nil {
+		writeBadRequest(w, "读取请求体失败")
+		return
+	}
+
+	var payload ruleSimulateRequest
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeBadRequest(w, "请求数据格式错误")
+		return
+	}
+
+	content := []byte(payload.Content)
+	if len(content) == 0 {
+		resolved, err := h.resolveFilename(filename)
+		if err != nil {
+			writeBadRequest(w, err.Error())
+			return
+		}
+		content, err = os.ReadFile(resolved)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "读取规则文件失败", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	queries := payload.Queries
+	for _, target := range payload.Targets {
+		if q, ok := parseRuleSimulateTarget(target); ok {
+			queries = append(queries, q)
+		}
+	}
+	if len(queries) == 0 {
+		writeBadRequest(w, "至少需要一个查询目标")
+		return
+	}
+	if len(queries) > ruleSimulateMaxQueries {
+		writeBadRequest(w, fmt.Sprintf("查询目标不能超过 %d 个", ruleSimulateMaxQueries))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), ruleSimulateTimeout)
+	defer cancel()
+
+	loader := func(name string, provider substore.ClashRuleProvider) ([]string, error) {
+		if h.repo == nil {
+			return nil, errors.New("repository not initialized")
+		}
+		if provider.URL == "" {
+			return nil, fmt.Errorf("仅支持 http 类型的 rule-provider")
+		}
+		behavior := provider.Behavior
+		if behavior == "" {
+			behavior = "classical"
+		}
+		return loadRuleProviderEntries(ctx, h.repo, provider.URL, behavior, substore.DetectRuleProviderFormat(provider.URL, provider.Format))
+	}
+
+	simulator, err := substore.NewRuleSimulator(content, loader)
+	if err != nil {
+		writeBadRequest(w, "YAML 解析失败: "+err.Error())
+		return
+	}
+
+	results := make([]substore.RuleSimulation, 0, len(queries))
+	for _, q := range queries {
+		if payload.Resolve && q.IP == "" && q.Domain != "" {
+			if ips, err := net.DefaultResolver.LookupIPAddr(ctx, q.Domain); err == nil && len(ips) > 0 {
+				q.IP = ips[0].IP.String()
+				q.IPResolved = true
+			}
+		}
+		results = append(results, simulator.Simulate(q))
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// parseRuleSimulateTarget 解析简写目标：example.com、1.2.3.4、example.com:443、[2001:db8::1]:443
+func parseRuleSimulateTarget(target string) (substore.RuleQuery, bool) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return substore.RuleQuery{}, false
+	}
+
+	var q substore.RuleQuery
+	host := target
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return substore.RuleQuery{}, false
+		}
+		host = h
+		q.Port = port
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		q.IP = ip.String()
+	} else {
+		q.Domain = strings.ToLower(host)
+	}
+	return q, true
+}
@@ -0,0 +1,439 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"miaomiaowu/internal/auth"
+	"miaomiaowu/internal/storage"
+)
+
+const (
+	ruleDiffContextLines = 3
+	ruleDiffMaxCells     = 4_000_000 // LCS 矩阵上限，超过时退化为整体替换
+	noNewlineMarker      = "\n\\ No newline at end of file"
+)
+
+// ruleNamedDiff 按名称比较的列表差异（节点、代理组、规则集）
+type ruleNamedDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// ruleLineDiff 规则行差异（按出现次数比较）
+type ruleLineDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type ruleStructuralDiff struct {
+	Proxies       ruleNamedDiff `json:"proxies"`
+	ProxyGroups   ruleNamedDiff `json:"proxy_groups"`
+	RuleProviders ruleNamedDiff `json:"rule_providers"`
+	Rules         ruleLineDiff  `json:"rules"`
+}
+
+// handleDiff 比较两个版本
+// GET /api/admin/rules/{file}/diff?from=3&to=5 （to 为空或 current 时与当前文件比较）
+func (h *RuleEditorHandler) handleDiff(w http.ResponseWriter, r *http.Request, filename string) {
+	resolved, err := h.resolveFilename(filename)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	if h.repo == nil {
+		http.Error(w, "历史版本不可用", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	fromContent, fromLabel, ok := h.loadVersionContent(w, r, filename, resolved, query.Get("from"))
+	if !ok {
+		return
+	}
+	toContent, toLabel, ok := h.loadVersionContent(w, r, filename, resolved, query.Get("to"))
+	if !ok {
+		return
+	}
+
+	resp := map[string]any{
+		"from":    fromLabel,
+		"to":      toLabel,
+		"unified": unifiedDiff(fromContent, toContent, filename+"@"+fromLabel, filename+"@"+toLabel),
+	}
+	if structural, err := structuralYAMLDiff([]byte(fromContent), []byte(toContent)); err == nil {
+		resp["structural"] = structural
+	} else {
+		resp["structural_error"] = err.Error()
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// handleRestore 将历史版本写回文件，并保存为新版本
+// POST /api/admin/rules/{file}/restore/{version}
+func (h *RuleEditorHandler) handleRestore(w http.ResponseWriter, r *http.Request, filename, versionStr string) {
+	resolved, err := h.resolveFilename(filename)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	if h.repo == nil {
+		http.Error(w, "历史版本不可用", http.StatusServiceUnavailable)
+		return
+	}
+
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version <= 0 {
+		writeBadRequest(w, "版本号无效")
+		return
+	}
+
+	if _, err := os.Stat(resolved); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "读取规则文件失败", http.StatusInternalServerError)
+		return
+	}
+
+	var payload struct {
+		Note string `json:"note"`
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.readLimit))
+	if err != nil {
+		writeBadRequest(w, "读取请求体失败")
+		return
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			writeBadRequest(w, "请求数据格式错误")
+			return
+		}
+	}
+
+	old, err := h.repo.GetRuleVersion(r.Context(), filename, version)
+	if err != nil {
+		if errors.Is(err, storage.ErrRuleVersionNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "获取历史版本失败", http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.WriteFile(resolved, []byte(old.Content), 0o644); err != nil {
+		http.Error(w, "写入规则文件失败", http.StatusInternalServerError)
+		return
+	}
+
+	note := fmt.Sprintf("恢复自版本 %d", version)
+	if extra := strings.TrimSpace(payload.Note); extra != "" {
+		note += ": " + extra
+	}
+	username := auth.UsernameOrDefault(r.Context(), "unknown")
+	newVersion, err := h.repo.SaveRuleVersionWithNote(r.Context(), filename, old.Content, username, note)
+	if err != nil {
+		http.Error(w, "保存历史版本失败", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"version":       newVersion,
+		"restored_from": version,
+		"note":          note,
+	})
+}
+
+// loadVersionContent 读取指定版本内容；空值或 current 表示当前文件
+func (h *RuleEditorHandler) loadVersionContent(w http.ResponseWriter, r *http.Request, filename, resolved, raw string) (string, string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "current" {
+		content, err := os.ReadFile(resolved)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				http.NotFound(w, r)
+				return "", "", false
+			}
+			http.Error(w, "读取规则文件失败", http.StatusInternalServerError)
+			return "", "", false
+		}
+		return string(content), "current", true
+	}
+
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || version <= 0 {
+		writeBadRequest(w, "版本号无效: "+raw)
+		return "", "", false
+	}
+	rv, err := h.repo.GetRuleVersion(r.Context(), filename, version)
+	if err != nil {
+		if errors.Is(err, storage.ErrRuleVersionNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "版本不存在: " + raw})
+			return "", "", false
+		}
+		http.Error(w, "获取历史版本失败", http.StatusInternalServerError)
+		return "", "", false
+	}
+	return rv.Content, "v" + raw, true
+}
+
+// unifiedDiff 生成 unified 格式的行差异，忽略换行符风格，末尾缺少换行按 diff 的方式标注
+func unifiedDiff(from, to, fromLabel, toLabel string) string {
+	from = strings.ReplaceAll(from, "\r\n", "\n")
+	to = strings.ReplaceAll(to, "\r\n", "\n")
+	if from == to {
+		return ""
+	}
+	a := splitDiffLines(from)
+	b := splitDiffLines(to)
+	ops := diffLines(a, b)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromLabel, toLabel)
+
+	// 按上下文行数切分 hunk
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-ruleDiffContextLines, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			// 连续的相同行超过 2*context 时结束 hunk
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*ruleDiffContextLines {
+				end = min(end+ruleDiffContextLines, len(ops))
+				break
+			}
+			end = run
+		}
+
+		aStart, bStart, aCount, bCount := 0, 0, 0, 0
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				aStart++
+			}
+			if op.kind != '-' {
+				bStart++
+			}
+		}
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		// 空范围按 diff 约定写作其前一行的行号
+		aLine, bLine := aStart+1, bStart+1
+		if aCount == 0 {
+			aLine--
+		}
+		if bCount == 0 {
+			bLine--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+type diffOp struct {
+	kind byte // ' ', '-', '+'
+	line string
+}
+
+// diffLines 基于 LCS 计算行差异，先去掉公共前后缀以减少计算量
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	if len(midA)*len(midB) > ruleDiffMaxCells {
+		for _, line := range midA {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range midB {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		n, m := len(midA), len(midB)
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n && j < m {
+			switch {
+			case midA[i] == midB[j]:
+				ops = append(ops, diffOp{' ', midA[i]})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				ops = append(ops, diffOp{'-', midA[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', midB[j]})
+				j++
+			}
+		}
+		for ; i < n; i++ {
+			ops = append(ops, diffOp{'-', midA[i]})
+		}
+		for ; j < m; j++ {
+			ops = append(ops, diffOp{'+', midB[j]})
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// splitDiffLines 按行切分；末尾没有换行时最后一行带上标注，与有换行的同名行视为不同
+func splitDiffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if !strings.HasSuffix(s, "\n") {
+		lines[len(lines)-1] += noNewlineMarker
+	}
+	return lines
+}
+
+// structuralYAMLDiff 比较节点、代理组、规则集和规则的增删改
+func structuralYAMLDiff(from, to []byte) (ruleStructuralDiff, error) {
+	type config struct {
+		Proxies       []map[string]any          `yaml:"proxies"`
+		ProxyGroups   []map[string]any          `yaml:"proxy-groups"`
+		RuleProviders map[string]map[string]any `yaml:"rule-providers"`
+		Rules         []string                  `yaml:"rules"`
+	}
+
+	var a, b config
+	if err := yaml.Unmarshal(from, &a); err != nil {
+		return ruleStructuralDiff{}, fmt.Errorf("parse from: %w", err)
+	}
+	if err := yaml.Unmarshal(to, &b); err != nil {
+		return ruleStructuralDiff{}, fmt.Errorf("parse to: %w", err)
+	}
+
+	byName := func(items []map[string]any) map[string]map[string]any {
+		result := make(map[string]map[string]any, len(items))
+		for _, item := range items {
+			if name, ok := item["name"].(string); ok {
+				result[name] = item
+			}
+		}
+		return result
+	}
+
+	return ruleStructuralDiff{
+		Proxies:       diffNamed(byName(a.Proxies), byName(b.Proxies)),
+		ProxyGroups:   diffNamed(byName(a.ProxyGroups), byName(b.ProxyGroups)),
+		RuleProviders: diffNamed(a.RuleProviders, b.RuleProviders),
+		Rules:         diffRuleLines(a.Rules, b.Rules),
+	}, nil
+}
+
+func diffNamed(a, b map[string]map[string]any) ruleNamedDiff {
+	diff := ruleNamedDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for name, item := range b {
+		old, ok := a[name]
+		if !ok {
+			diff.Added = append(diff.Added, name)
+		} else if !reflect.DeepEqual(old, item) {
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// diffRuleLines 按出现次数比较规则，保持各自原有顺序
+func diffRuleLines(a, b []string) ruleLineDiff {
+	diff := ruleLineDiff{Added: []string{}, Removed: []string{}}
+	countA := make(map[string]int, len(a))
+	for _, rule := range a {
+		countA[strings.TrimSpace(rule)]++
+	}
+	countB := make(map[string]int, len(b))
+	for _, rule := range b {
+		countB[strings.TrimSpace(rule)]++
+	}
+
+	seenB := make(map[string]int)
+	for _, rule := range b {
+		key := strings.TrimSpace(rule)
+		seenB[key]++
+		if seenB[key] > countA[key] {
+			diff.Added = append(diff.Added, rule)
+		}
+	}
+	seenA := make(map[string]int)
+	for _, rule := range a {
+		key := strings.TrimSpace(rule)
+		seenA[key]++
+		if seenA[key] > countB[key] {
+			diff.Removed = append(diff.Removed, rule)
+		}
+	}
+	return diff
+}
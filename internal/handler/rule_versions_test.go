@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{"both empty", nil, nil, ""},
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, " a| b"},
+		{"insert into empty", nil, []string{"a", "b"}, "+a|+b"},
+		{"delete all", []string{"a", "b"}, nil, "-a|-b"},
+		{"insert in middle", []string{"a", "c"}, []string{"a", "b", "c"}, " a|+b| c"},
+		{"delete in middle", []string{"a", "b", "c"}, []string{"a", "c"}, " a|-b| c"},
+		{"replace", []string{"a", "b", "c"}, []string{"a", "x", "c"}, " a|-b|+x| c"},
+		{"replace prefers delete first", []string{"a", "b"}, []string{"x", "y"}, "-a|-b|+x|+y"},
+		{"move", []string{"a", "b", "c"}, []string{"b", "c", "a"}, "-a| b| c|+a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parts []string
+			for _, op := range diffLines(tt.a, tt.b) {
+				parts = append(parts, string(op.kind)+op.line)
+			}
+			if got := strings.Join(parts, "|"); got != tt.want {
+				t.Fatalf("diffLines(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "identical", from: "a\nb\n", to: "a\nb\n", want: ""},
+		{name: "both empty", from: "", to: "", want: ""},
+		{name: "line endings only", from: "a\r\nb\r\n", to: "a\nb\n", want: ""},
+		{
+			name: "from empty",
+			from: "", to: "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "to empty",
+			from: "a\nb\n", to: "",
+			want: "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "insert",
+			from: "a\nb\nc\n", to: "a\nb\nx\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,4 @@\n a\n b\n+x\n c\n",
+		},
+		{
+			name: "delete",
+			from: "a\nb\nc\n", to: "a\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		},
+		{
+			name: "replace",
+			from: "a\nb\nc\n", to: "a\nx\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "trailing newline removed",
+			from: "a\nb\n", to: "a\nb",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "trailing newline added",
+			from: "a\nb", to: "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			// 相隔超过 2*context 行的修改拆成两个 hunk
+			name: "separate hunks",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", to: "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			from: "1\n2\n3\n4\n5\n6\n7\n", to: "x\n2\n3\n4\n5\n6\ny\n",
+			want: "--- old\n+++ new\n@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff(tt.from, tt.to, "old", "new"); got != tt.want {
+				t.Fatalf("unifiedDiff(%q, %q) =\n%s\nwant\n%s", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffRuleLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        []string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{name: "both empty", wantAdded: []string{}, wantRemoved: []string{}},
+		{
+			name:      "insert",
+			a:         []string{"DOMAIN,a.com,DIRECT"},
+			b:         []string{"DOMAIN,a.com,DIRECT", "DOMAIN,b.com,Proxy"},
+			wantAdded: []string{"DOMAIN,b.com,Proxy"}, wantRemoved: []string{},
+		},
+		{
+			name:      "delete",
+			a:         []string{"DOMAIN,a.com,DIRECT", "MATCH,Proxy"},
+			b:         []string{"MATCH,Proxy"},
+			wantAdded: []string{}, wantRemoved: []string{"DOMAIN,a.com,DIRECT"},
+		},
+		{
+			name:      "replace",
+			a:         []string{"DOMAIN,a.com,DIRECT", "MATCH,Proxy"},
+			b:         []string{"DOMAIN,a.com,Proxy", "MATCH,Proxy"},
+			wantAdded: []string{"DOMAIN,a.com,Proxy"}, wantRemoved: []string{"DOMAIN,a.com,DIRECT"},
+		},
+		{
+			name:      "reorder is not a change",
+			a:         []string{"DOMAIN,a.com,DIRECT", "MATCH,Proxy"},
+			b:         []string{"MATCH,Proxy", " DOMAIN,a.com,DIRECT "},
+			wantAdded: []string{}, wantRemoved: []string{},
+		},
+		{
+			name:      "duplicate counted",
+			a:         []string{"DOMAIN,a.com,DIRECT"},
+			b:         []string{"DOMAIN,a.com,DIRECT", "DOMAIN,a.com,DIRECT"},
+			wantAdded: []string{"DOMAIN,a.com,DIRECT"}, wantRemoved: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffRuleLines(tt.a, tt.b)
+			if !reflect.DeepEqual(got.Added, tt.wantAdded) || !reflect.DeepEqual(got.Removed, tt.wantRemoved) {
+				t.Fatalf("diffRuleLines() = %+v, want added %q removed %q", got, tt.wantAdded, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestStructuralYAMLDiff(t *testing.T) {
+	const from = `proxies:
+  - {name: HK, type: ss, server: 1.1.1.1, port: 443}
+  - {name: JP, type: ss, server: 2.2.2.2, port: 443}
+proxy-groups:
+  - {name: Proxy, type: select, proxies: [HK, JP]}
+rule-providers:
+  ads: {type: http, behavior: domain, url: https://example.com/ads.yaml}
+rules:
+  - RULE-SET,ads,REJECT
+  - MATCH,Proxy
+`
+	const to = `proxies:
+  - {name: HK, type: ss, server: 1.1.1.1, port: 8443}
+  - {name: US, type: ss, server: 3.3.3.3, port: 443}
+proxy-groups:
+  - {name: Proxy, type: select, proxies: [HK, JP]}
+rules:
+  - MATCH,Proxy
+`
+	got, err := structuralYAMLDiff([]byte(from), []byte(to))
+	if err != nil {
+		t.Fatalf("structuralYAMLDiff: %v", err)
+	}
+	want := ruleStructuralDiff{
+		Proxies:       ruleNamedDiff{Added: []string{"US"}, Removed: []string{"JP"}, Changed: []string{"HK"}},
+		ProxyGroups:   ruleNamedDiff{Added: []string{}, Removed: []string{}, Changed: []string{}},
+		RuleProviders: ruleNamedDiff{Added: []string{}, Removed: []string{"ads"}, Changed: []string{}},
+		Rules:         ruleLineDiff{Added: []string{}, Removed: []string{"RULE-SET,ads,REJECT"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("structuralYAMLDiff() = %+v, want %+v", got, want)
+	}
+
+	empty, err := structuralYAMLDiff(nil, nil)
+	if err != nil {
+		t.Fatalf("structuralYAMLDiff(empty): %v", err)
+	}
+	if len(empty.Proxies.Added)+len(empty.Rules.Added)+len(empty.Rules.Removed) != 0 || empty.Proxies.Added == nil {
+		t.Fatalf("structuralYAMLDiff(empty) = %+v, want empty non-nil lists", empty)
+	}
+
+	if _, err := structuralYAMLDiff([]byte("proxies: [\n"), nil); err == nil || !strings.HasPrefix(err.Error(), "parse from:") {
+		t.Fatalf("invalid from error = %v, want parse from", err)
+	}
+}
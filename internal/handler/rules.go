@@ -63,6 +63,33 @@ func (h *RuleEditorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(segments) == 2 && segments[1] == "simulate" {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.handleSimulate(w, r, filename)
+		return
+	}
+
+	if len(segments) == 2 && segments[1] == "diff" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.handleDiff(w, r, filename)
+		return
+	}
+
+	if len(segments) == 3 && segments[1] == "restore" {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.handleRestore(w, r, filename, segments[2])
+		return
+	}
+
 	if len(segments) > 1 {
 		http.NotFound(w, r)
 		return
@@ -127,6 +127,7 @@ func isAllowedPath(path string) bool {
 	allowedPrefixes := []string{
 		"/api/clash/subscribe",
 		"/api/proxy-provider/",
+		"/api/rule-set/",
 		"/t/", // 临时订阅
 	}
 
@@ -1158,7 +1158,7 @@ func SyncMMWProxyProvidersToFile(repo *storage.TrafficRepository, subscribeDir,
 				logger.Info("[MMW同步] 获取代理集合的外部订阅失败", "provider_name", providerName, "error", err)
 				continue
 			}
-			entry, err = RefreshProxyProviderCache(&sub, config)
+			entry, _, err = RefreshProxyProviderCacheOrStale(&sub, config)
 			if err != nil {
 				logger.Info("[MMW同步] 刷新代理集合缓存失败", "provider_name", providerName, "error", err)
 				continue
@@ -82,6 +82,12 @@ socks-port: 7891
 
 const tokenInvalidFilename = "token_invalid.yaml"
 
+// 流量配额用尽时返回的YAML内容，与Token失效提示仅节点名称不同
+var quotaExceededYAML = strings.NewReplacer(
+	"⚠️ 订阅已过期", "⚠️ 本月流量已用尽",
+	"⚠️ 请联系管理员", "⚠️ 请联系管理员调整配额",
+).Replace(tokenInvalidYAML)
+
 // Context key for token invalid flag
 type ContextKey string
 
@@ -272,6 +278,38 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 	logger.Info("[⏱️ 耗时监测] 文件查找完成", "step", "file_lookup", "duration_ms", time.Since(stepStart).Milliseconds(), "filename", filename)
 
+	if hasSubscribeFile && subscribeFile.ExpireAt != nil {
+		now := time.Now()
+		if !subscribeFile.ExpireAt.After(now) {
+			logger.Info("[Subscription] 订阅已过期", "filename", filename, "expire_at", subscribeFile.ExpireAt.Format("2006-01-02 15:04:05"))
+			h.serveTokenInvalidResponse(w, r)
+			return
+		}
+	}
+
+	// 用户流量配额已用尽：返回提示订阅，或改为输出降级订阅文件（保留原订阅名称）
+	var degradedQuota *storage.UserTrafficQuota
+	var degradedQuotaUsed int64
+	quotaCheck := h.checkTrafficQuota(r.Context(), username)
+	if quota, used := quotaCheck.quota, quotaCheck.used; quotaCheck.exceeded {
+		if quota.ExceededAction != storage.QuotaActionSubscribeFile {
+			h.serveQuotaExceededResponse(w, r, quota, used)
+			return
+		}
+		degradedFile, err := h.repo.GetSubscribeFileByFilename(r.Context(), quota.DegradedFilename)
+		if err != nil {
+			logger.Warn("[用户配额] 降级订阅文件不可用，返回流量用尽提示", "user", username, "filename", quota.DegradedFilename, "error", err)
+			h.serveQuotaExceededResponse(w, r, quota, used)
+			return
+		}
+		logger.Info("[用户配额] 用户流量配额已用尽，输出降级订阅", "user", username, "filename", degradedFile.Filename)
+		subscribeFile = degradedFile
+		filename = degradedFile.Filename
+		hasSubscribeFile = true
+		degradedQuota = &quota
+		degradedQuotaUsed = used
+	}
+
 	cleanedName := filepath.Clean(filename)
 	if strings.HasPrefix(cleanedName, "..") || filepath.IsAbs(cleanedName) {
 		writeError(w, http.StatusBadRequest, errors.New("invalid rule filename"))
@@ -296,15 +334,6 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if hasSubscribeFile && subscribeFile.ExpireAt != nil {
-		now := time.Now()
-		if !subscribeFile.ExpireAt.After(now) {
-			logger.Info("[Subscription] 订阅已过期", "filename", filename, "expire_at", subscribeFile.ExpireAt.Format("2006-01-02 15:04:05"))
-			h.serveTokenInvalidResponse(w, r)
-			return
-		}
-	}
-
 	// 模板生成逻辑：如果订阅绑定了 V3 模板，使用模板生成配置
 	var data []byte
 	if hasSubscribeFile && subscribeFile.TemplateFilename != "" {
@@ -554,6 +583,15 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 	logger.Info("[⏱️ 耗时监测] 流量信息收集完成", "step", "traffic_info", "duration_ms", time.Since(stepStart).Milliseconds())
 
+	// 剔除绑定探针服务器离线的节点（需开启相应设置，状态未知时保留节点）
+	if username != "" && h.repo != nil {
+		if settings, err := h.repo.GetUserSettings(r.Context(), username); err == nil {
+			if offline := offlineProbeNodeNames(r.Context(), h.repo, username, settings); len(offline) > 0 {
+				data = excludeOfflineProbeNodes(data, offline)
+			}
+		}
+	}
+
 	// 节点排序
 	stepStart = time.Now()
 	// 获取用户的节点排序配置，需要在转换之前使用
@@ -602,6 +640,11 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 	logger.Info("[⏱️ 耗时监测] 节点排序完成", "step", "node_order", "duration_ms", time.Since(stepStart).Milliseconds())
 
+	// 规则集镜像：改写 rule-providers 地址，按需内联小规则集
+	stepStart = time.Now()
+	data = applyRuleSetMirror(r, h.repo, username, data)
+	logger.Info("[⏱️ 耗时监测] 规则集镜像处理完成", "step", "rule_set_mirror", "duration_ms", time.Since(stepStart).Milliseconds())
+
 	// 格式转换
 	stepStart = time.Now()
 	// 根据参数t的类型调用substore的转换代码
@@ -616,7 +659,8 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	// clash 和 clashmeta 类型直接输出源文件, 不需要转换
 	if clientType != "" && clientType != "clash" && clientType != "clashmeta" {
 		// Convert subscription using substore producers
-		convertedData, err := h.convertSubscription(r.Context(), data, clientType)
+		ruleSetURL := ruleSetConvertURLFunc(r, h.repo, username, clientType)
+		convertedData, err := h.convertSubscription(r.Context(), data, clientType, ruleSetURL)
 		if err != nil {
 			writeError(w, http.StatusBadRequest, fmt.Errorf("failed to convert subscription for client %s: %w", clientType, err))
 			return
@@ -624,28 +668,7 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		data = convertedData
 
 		// Set content type and extension based on client type
-		switch clientType {
-		case "surge", "surgemac", "loon", "qx", "surfboard", "shadowrocket", "clash-to-surge":
-			// Text-based formats
-			contentType = "text/plain; charset=utf-8"
-			ext = ".txt"
-		case "sing-box":
-			// JSON format
-			contentType = "application/json; charset=utf-8"
-			ext = ".json"
-		case "v2ray":
-			// Base64 format
-			contentType = "text/plain; charset=utf-8"
-			ext = ".txt"
-		case "uri":
-			// URI format
-			contentType = "text/plain; charset=utf-8"
-			ext = ".txt"
-		default:
-			// YAML-based formats (clash, clashmeta, stash, shadowrocket, egern)
-			contentType = "text/yaml; charset=utf-8"
-			ext = ".yaml"
-		}
+		contentType, ext = clientContentType(clientType)
 	}
 	logger.Info("[⏱️ 耗时监测] 格式转换完成", "step", "format_convert", "duration_ms", time.Since(stepStart).Milliseconds(), "client_type", clientType)
 
@@ -653,8 +676,15 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	stepStart = time.Now()
 	// 尝试获取流量信息，如果探针报错则跳过流量统计，不影响订阅输出
 	// 如果开启了探针绑定，只统计订阅文件中使用的节点绑定的探针服务器流量
-	totalLimit, _, totalUsed, err := h.summary.fetchTotals(r.Context(), username, usedProbeServers)
+	// 配额检查已获取过绑定服务器的用量时直接复用，不再重复查询探针
+	var probeUsages []probeServerUsage
+	if quotaCheck.usagesFetched {
+		probeUsages, err = quotaCheck.probeUsages(usedProbeServers)
+	} else {
+		probeUsages, err = h.summary.fetchServerUsages(r.Context(), username, usedProbeServers)
+	}
 	hasTrafficInfo := err == nil
+	totalLimit, _, totalUsed := sumProbeServerUsages(probeUsages)
 	logger.Info("[⏱️ 耗时监测] 流量统计获取完成", "step", "traffic_fetch", "duration_ms", time.Since(stepStart).Milliseconds())
 
 	// 使用订阅名称
@@ -734,8 +764,10 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	logger.Info("[⏱️ 耗时监测] YAML 重排序完成", "step", "yaml_reorder", "duration_ms", time.Since(stepStart).Milliseconds())
 
 	w.Header().Set("Content-Type", contentType)
-	// 只有在有流量信息时才添加 subscription-userinfo 头
-	if hasTrafficInfo || externalTrafficLimit > 0 {
+	// 只有在有流量信息时才添加 subscription-userinfo 头；输出降级订阅时展示用户配额
+	if degradedQuota != nil {
+		w.Header().Set("subscription-userinfo", buildSubscriptionHeader(degradedQuota.QuotaBytes, degradedQuotaUsed, nil, nil))
+	} else if hasTrafficInfo || externalTrafficLimit > 0 {
 		var finalLimit, finalUsed int64
 
 		// 判断是否需要包含探针流量：
@@ -763,7 +795,12 @@ func (h *SubscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		if hasSubscribeFile {
 			expireAt = subscribeFile.ExpireAt
 		}
-		headerValue := buildSubscriptionHeader(finalLimit, finalUsed, expireAt)
+		// 探针服务器按账期计费时附带最近的重置时间
+		var nextReset *time.Time
+		if includeProbeTraffic && hasTrafficInfo {
+			nextReset = earliestProbeReset(probeUsages)
+		}
+		headerValue := buildSubscriptionHeader(finalLimit, finalUsed, expireAt, nextReset)
 		w.Header().Set("subscription-userinfo", headerValue)
 		logger.Info("[Subscription] 设置订阅用户信息头", "header", headerValue)
 	}
@@ -822,14 +859,18 @@ func (h *SubscriptionHandler) resolveSubscription(ctx context.Context, name stri
 	return h.repo.GetFirstSubscriptionLink(ctx)
 }
 
-func buildSubscriptionHeader(totalLimit, totalUsed int64, expireAt *time.Time) string {
+func buildSubscriptionHeader(totalLimit, totalUsed int64, expireAt, nextReset *time.Time) string {
 	download := strconv.FormatInt(totalUsed, 10)
 	total := strconv.FormatInt(totalLimit, 10)
 	expire := ""
 	if expireAt != nil {
 		expire = strconv.FormatInt(expireAt.Unix(), 10)
 	}
-	return "upload=0; download=" + download + "; total=" + total + "; expire=" + expire
+	header := "upload=0; download=" + download + "; total=" + total + "; expire=" + expire
+	if nextReset != nil {
+		header += "; next_reset=" + strconv.FormatInt(nextReset.Unix(), 10)
+	}
+	return header
 }
 
 // getKeys returns the keys of a map as a slice
@@ -976,11 +1017,14 @@ func GetExternalSubscriptionsFromFile(ctx context.Context, data []byte, username
 					for _, config := range configs {
 						logger.Info("[Subscription] 检查配置", "config_name", config.Name, "external_sub_id", config.ExternalSubscriptionID, "process_mode", config.ProcessMode)
 						if allNames[config.Name] {
-							if url, ok := subIDToURL[config.ExternalSubscriptionID]; ok {
-								usedURLs[url] = true
-								logger.Info("[Subscription] 从代理集合配置找到外部订阅URL", "config_name", config.Name, "mode", config.ProcessMode, "url", url)
-							} else {
-								logger.Info("[Subscription] 配置的外部订阅ID未找到对应URL", "config_name", config.Name, "external_sub_id", config.ExternalSubscriptionID)
+							// 组合代理集合的额外外部订阅同样需要同步
+							for _, subID := range proxyProviderSubscriptionIDs(&config) {
+								if url, ok := subIDToURL[subID]; ok {
+									usedURLs[url] = true
+									logger.Info("[Subscription] 从代理集合配置找到外部订阅URL", "config_name", config.Name, "mode", config.ProcessMode, "url", url)
+								} else {
+									logger.Info("[Subscription] 配置的外部订阅ID未找到对应URL", "config_name", config.Name, "external_sub_id", subID)
+								}
 							}
 						}
 					}
@@ -1065,22 +1109,13 @@ func syncReferencedExternalSubscriptions(ctx context.Context, repo *storage.Traf
 
 	for _, sub := range subsToSync {
 		subSyncStart := time.Now()
-		nodeCount, updatedSub, err := syncSingleExternalSubscription(ctx, client, repo, subscribeDir, username, sub, userSettings)
+		nodeCount, _, err := syncAndRecordExternalSubscription(ctx, client, repo, subscribeDir, username, sub, userSettings)
 		if err != nil {
 			logger.Info("[⏱️ 耗时监测] 同步订阅失败", "name", sub.Name, "url", sub.URL, "error", err, "duration_ms", time.Since(subSyncStart).Milliseconds())
 			continue
 		}
 
 		totalNodesSynced += nodeCount
-
-		// Update last sync time and node count
-		// Use updatedSub which contains traffic info from parseAndUpdateTrafficInfo
-		now := time.Now()
-		updatedSub.LastSyncAt = &now
-		updatedSub.NodeCount = nodeCount
-		if err := repo.UpdateExternalSubscription(ctx, updatedSub); err != nil {
-			logger.Info("[Subscription] 更新订阅同步时间失败", "name", sub.Name, "error", err)
-		}
 		logger.Info("[⏱️ 耗时监测] 外部订阅同步完成", "name", sub.Name, "node_count", nodeCount, "duration_ms", time.Since(subSyncStart).Milliseconds())
 	}
 
@@ -1109,11 +1144,14 @@ func syncReferencedExternalSubscriptions(ctx context.Context, repo *storage.Traf
 		cache := GetProxyProviderCache()
 		invalidatedCount := 0
 		for _, config := range configs {
-			// 检查是否引用了刚刚同步的外部订阅
-			if syncedSubIDs[config.ExternalSubscriptionID] {
-				cache.Delete(config.ID)
-				invalidatedCount++
-				logger.Info("[Subscription] 失效代理集合缓存", "config_name", config.Name, "config_id", config.ID)
+			// 检查是否引用了刚刚同步的外部订阅（包括组合代理集合的额外来源）
+			for _, subID := range proxyProviderSubscriptionIDs(&config) {
+				if syncedSubIDs[subID] {
+					cache.Invalidate(config.ID)
+					invalidatedCount++
+					logger.Info("[Subscription] 失效代理集合缓存", "config_name", config.Name, "config_id", config.ID)
+					break
+				}
 			}
 		}
 		if invalidatedCount > 0 {
@@ -1126,25 +1164,33 @@ func syncReferencedExternalSubscriptions(ctx context.Context, repo *storage.Traf
 	return nil
 }
 
-func (h *SubscriptionHandler) loadTokenInvalidContent() []byte {
-	tokenPath := filepath.Join("data", tokenInvalidFilename)
-	data, err := os.ReadFile(tokenPath)
+// loadNoticeContent 读取 data 目录下自定义的提示订阅，不存在或为空时使用内置默认内容
+func loadNoticeContent(filename, fallback string) []byte {
+	path := filepath.Join("data", filename)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		logger.Info("[Token Invalid] 读取data/token_invalid.yaml失败，使用内置默认内容", "path", tokenPath, "error", err)
-		return []byte(tokenInvalidYAML)
+		logger.Info("[Subscription Notice] 读取自定义提示订阅失败，使用内置默认内容", "path", path, "error", err)
+		return []byte(fallback)
 	}
 	if len(data) == 0 {
-		logger.Info("[Token Invalid] data/token_invalid.yaml为空，使用内置默认内容", "path", tokenPath)
-		return []byte(tokenInvalidYAML)
+		logger.Info("[Subscription Notice] 自定义提示订阅为空，使用内置默认内容", "path", path)
+		return []byte(fallback)
 	}
-	logger.Info("[Token Invalid] 使用自定义token_invalid.yaml", "path", tokenPath)
+	logger.Info("[Subscription Notice] 使用自定义提示订阅", "path", path)
 	return data
 }
 
 // serveTokenInvalidResponse serves the token invalid YAML content with client type conversion
 func (h *SubscriptionHandler) serveTokenInvalidResponse(w http.ResponseWriter, r *http.Request) {
-	data := h.loadTokenInvalidContent()
+	data := loadNoticeContent(tokenInvalidFilename, tokenInvalidYAML)
+	h.serveNoticeSubscription(w, r, data, "Token已失效")
+
+	// ⚠️ Token失效日志 - 方便管理员追踪无效访问
+	logger.Info("⚠️⚠️⚠️ [SUB_INVALID] Token失效或过期访问", "client_type", strings.TrimSpace(r.URL.Query().Get("t")))
+}
 
+// serveNoticeSubscription serves a notice subscription (token invalid, quota exceeded) with client type conversion
+func (h *SubscriptionHandler) serveNoticeSubscription(w http.ResponseWriter, r *http.Request, data []byte, attachmentBase string) {
 	// 根据参数t的类型调用substore的转换代码
 	clientType := strings.TrimSpace(r.URL.Query().Get("t"))
 	contentType := "text/yaml; charset=utf-8"
@@ -1152,32 +1198,19 @@ func (h *SubscriptionHandler) serveTokenInvalidResponse(w http.ResponseWriter, r
 
 	// 如果指定了客户端类型且不是clash/clashmeta，进行转换
 	if clientType != "" && clientType != "clash" && clientType != "clashmeta" {
-		convertedData, err := h.convertSubscription(r.Context(), data, clientType)
+		convertedData, err := h.convertSubscription(r.Context(), data, clientType, nil)
 		if err != nil {
 			// 转换失败，记录日志但继续返回YAML
-			logger.Info("[Token Invalid] 转换失败", "client_type", clientType, "error", err)
+			logger.Info("[Subscription Notice] 转换失败", "client_type", clientType, "error", err)
 		} else {
 			data = convertedData
 
 			// 根据客户端类型设置content type和扩展名
-			switch clientType {
-			case "surge", "surgemac", "loon", "qx", "surfboard", "shadowrocket", "clash-to-surge":
-				contentType = "text/plain; charset=utf-8"
-				ext = ".txt"
-			case "sing-box":
-				contentType = "application/json; charset=utf-8"
-				ext = ".json"
-			case "v2ray", "uri":
-				contentType = "text/plain; charset=utf-8"
-				ext = ".txt"
-			default:
-				contentType = "text/yaml; charset=utf-8"
-				ext = ".yaml"
-			}
+			contentType, ext = clientContentType(clientType)
 		}
 	}
 
-	attachmentName := url.PathEscape("Token已失效" + ext)
+	attachmentName := url.PathEscape(attachmentBase + ext)
 
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("profile-update-interval", "24")
@@ -1186,13 +1219,29 @@ func (h *SubscriptionHandler) serveTokenInvalidResponse(w http.ResponseWriter, r
 	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(data)
+}
 
-	// ⚠️ Token失效日志 - 方便管理员追踪无效访问
-	logger.Info("⚠️⚠️⚠️ [SUB_INVALID] Token失效或过期访问", "client_type", clientType)
+// clientContentType returns the content type and file extension of a converted client format
+func clientContentType(clientType string) (string, string) {
+	switch clientType {
+	case "surge", "surgemac", "loon", "qx", "surfboard", "shadowrocket", "clash-to-surge":
+		// Text-based formats
+		return "text/plain; charset=utf-8", ".txt"
+	case "sing-box":
+		// JSON format
+		return "application/json; charset=utf-8", ".json"
+	case "v2ray", "uri":
+		// Base64 / URI format
+		return "text/plain; charset=utf-8", ".txt"
+	default:
+		// YAML-based formats (clash, clashmeta, stash, egern)
+		return "text/yaml; charset=utf-8", ".yaml"
+	}
 }
 
-// convertSubscription converts a YAML subscription file to the specified client format
-func (h *SubscriptionHandler) convertSubscription(ctx context.Context, yamlData []byte, clientType string) ([]byte, error) {
+// convertSubscription converts a YAML subscription file to the specified client format.
+// ruleSetURL (optional) rewrites RULE-SET provider URLs for clients that cannot read mihomo rule-providers.
+func (h *SubscriptionHandler) convertSubscription(ctx context.Context, yamlData []byte, clientType string, ruleSetURL substore.RuleSetURLFunc) ([]byte, error) {
 	// 使用 yaml.Node 解析, 解决值前导零的问题
 	var rootNode yaml.Node
 	if err := yaml.Unmarshal(yamlData, &rootNode); err != nil {
@@ -1231,7 +1280,13 @@ func (h *SubscriptionHandler) convertSubscription(ctx context.Context, yamlData
 
 	// clash-to-surge 类型使用 BuildCompleteSurgeConfig 生成完整的 Surge 配置
 	if clientType == "clash-to-surge" {
-		return h.convertClashToSurge(config, proxies)
+		return h.convertClashToSurge(ctx, config, proxies, ruleSetURL)
+	}
+
+	// 节点列表格式（surge、loon、qx 等）不输出代理组，use 引用的代理集合节点不会包含在内；
+	// 需要 policy-path 引用代理集合时使用 clash-to-surge
+	if providers := countProxyGroupUses(config); providers > 0 && nodeListClientTypes[clientType] {
+		logger.Info("[Subscription] 节点列表格式不包含代理组，忽略 use 引用的代理集合", "client_type", clientType, "use_count", providers)
 	}
 
 	factory := substore.GetDefaultFactory()
@@ -1248,6 +1303,7 @@ func (h *SubscriptionHandler) convertSubscription(ctx context.Context, yamlData
 	opts := &substore.ProduceOptions{
 		FullConfig:              config,
 		ClientCompatibilityMode: systemConfig.ClientCompatibilityMode,
+		RuleSetURL:              ruleSetURL,
 	}
 	result, err := producer.Produce(proxies, "", opts)
 	if err != nil {
@@ -1263,8 +1319,27 @@ func (h *SubscriptionHandler) convertSubscription(ctx context.Context, yamlData
 	}
 }
 
+// nodeListClientTypes 只输出节点列表、不包含代理组的客户端格式
+var nodeListClientTypes = map[string]bool{
+	"surge": true, "surgemac": true, "loon": true, "qx": true, "surfboard": true, "v2ray": true, "uri": true,
+}
+
+// countProxyGroupUses 统计 proxy-groups 中 use 引用代理集合的次数
+func countProxyGroupUses(config map[string]interface{}) int {
+	groups, _ := config["proxy-groups"].([]interface{})
+	count := 0
+	for _, g := range groups {
+		if gMap, ok := g.(map[string]interface{}); ok {
+			if use, ok := gMap["use"].([]interface{}); ok {
+				count += len(use)
+			}
+		}
+	}
+	return count
+}
+
 // convertClashToSurge converts Clash config to Surge format with rules
-func (h *SubscriptionHandler) convertClashToSurge(config map[string]interface{}, proxies []substore.Proxy) ([]byte, error) {
+func (h *SubscriptionHandler) convertClashToSurge(ctx context.Context, config map[string]interface{}, proxies []substore.Proxy, ruleSetURL substore.RuleSetURLFunc) ([]byte, error) {
 	// 解析 Clash 配置结构
 	clashConfig := &substore.ClashConfig{}
 
@@ -1342,6 +1417,13 @@ func (h *SubscriptionHandler) convertClashToSurge(config map[string]interface{},
 						}
 					}
 				}
+				if useArr, ok := gMap["use"].([]interface{}); ok {
+					for _, u := range useArr {
+						if uStr, ok := u.(string); ok {
+							group.Use = append(group.Use, uStr)
+						}
+					}
+				}
 				clashConfig.ProxyGroups = append(clashConfig.ProxyGroups, group)
 			}
 		}
@@ -1385,8 +1467,34 @@ func (h *SubscriptionHandler) convertClashToSurge(config map[string]interface{},
 		}
 	}
 
+	// 解析 proxy-providers，妙妙屋处理模式的代理集合可通过 policy-path 以 Surge 格式引用
+	if providersRaw, ok := config["proxy-providers"].(map[string]interface{}); ok {
+		clashConfig.ProxyProviders = make(map[string]substore.ClashProxyProvider)
+		for name, p := range providersRaw {
+			if pMap, ok := p.(map[string]interface{}); ok {
+				provider := substore.ClashProxyProvider{}
+				if pType, ok := pMap["type"].(string); ok {
+					provider.Type = pType
+				}
+				if url, ok := pMap["url"].(string); ok {
+					provider.URL = url
+				}
+				if path, ok := pMap["path"].(string); ok {
+					provider.Path = path
+				}
+				if interval, ok := pMap["interval"].(int); ok {
+					provider.Interval = interval
+				}
+				clashConfig.ProxyProviders[name] = provider
+			}
+		}
+	}
+
 	// 使用 BuildCompleteSurgeConfig 生成完整 Surge 配置
-	surgeConfig, err := substore.BuildCompleteSurgeConfig(clashConfig, proxies, nil, false)
+	templateOpts := substore.DefaultSurgeTemplateConfig()
+	templateOpts.RuleSetURL = ruleSetURL
+	templateOpts.ProxyProviderURL = mmwProxyProviderURLFunc(ctx, h.repo, "surge")
+	surgeConfig, err := substore.BuildCompleteSurgeConfig(clashConfig, proxies, templateOpts, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build Surge config: %w", err)
 	}
@@ -1748,12 +1856,21 @@ func (h *SubscriptionHandler) generateFromTemplate(ctx context.Context, username
 		return nil, fmt.Errorf("获取节点列表失败: %w", err)
 	}
 
+	// 开启相应设置时跳过绑定探针服务器离线的节点
+	var offline map[string]struct{}
+	if settings, err := h.repo.GetUserSettings(ctx, username); err == nil {
+		offline = offlineProbeNodeNames(ctx, h.repo, username, settings)
+	}
+
 	// 将节点转换为 proxies 格式（[]map[string]any）
 	var proxies []map[string]any
 	for _, node := range nodes {
 		if !node.Enabled {
 			continue // 跳过禁用的节点
 		}
+		if _, isOffline := offline[node.NodeName]; isOffline {
+			continue
+		}
 		// ClashConfig 是 JSON 格式的字符串，需要解析
 		var proxyConfig map[string]any
 		if err := json.Unmarshal([]byte(node.ClashConfig), &proxyConfig); err != nil {
@@ -1780,6 +1897,9 @@ func (h *SubscriptionHandler) generateFromTemplate(ctx context.Context, username
 		// 通过 tag 字段匹配：节点的 tag 等于代理集合的名称
 		var providerProxyNames []string
 		for _, node := range nodes {
+			if _, isOffline := offline[node.NodeName]; isOffline {
+				continue
+			}
 			if node.Enabled && node.Tag == config.Name {
 				providerProxyNames = append(providerProxyNames, node.NodeName)
 			}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -48,34 +49,14 @@ func NewTCPingHandler() http.Handler {
 			return
 		}
 
-		timeout := req.Timeout
-		if timeout <= 0 {
-			timeout = 5000
-		}
-		if timeout > 30000 {
-			timeout = 30000
-		}
-
 		address := net.JoinHostPort(req.Host, fmt.Sprintf("%d", req.Port))
-		timeoutDuration := time.Duration(timeout) * time.Millisecond
-
-		logger.Debug("[TCPing] 开始测试", "address", address, "timeout", timeout)
-
-		start := time.Now()
-		conn, err := net.DialTimeout("tcp", address, timeoutDuration)
-		latency := float64(time.Since(start).Microseconds()) / 1000.0
+		logger.Debug("[TCPing] 开始测试", "address", address, "timeout", req.Timeout)
 
-		resp := TCPingResponse{}
-
-		if err != nil {
-			logger.Debug("[TCPing] 连接失败", "address", address, "error", err)
-			resp.Success = false
-			resp.Error = err.Error()
+		resp := tcping(req.Host, req.Port, req.Timeout)
+		if resp.Success {
+			logger.Debug("[TCPing] 连接成功", "address", address, "latency", resp.Latency)
 		} else {
-			conn.Close()
-			logger.Debug("[TCPing] 连接成功", "address", address, "latency", latency)
-			resp.Success = true
-			resp.Latency = latency
+			logger.Debug("[TCPing] 连接失败", "address", address, "error", resp.Error)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -120,27 +101,7 @@ func NewTCPingBatchHandler() http.Handler {
 					return
 				}
 
-				timeout := r.Timeout
-				if timeout <= 0 {
-					timeout = 5000
-				}
-				if timeout > 30000 {
-					timeout = 30000
-				}
-
-				address := net.JoinHostPort(r.Host, fmt.Sprintf("%d", r.Port))
-				timeoutDuration := time.Duration(timeout) * time.Millisecond
-
-				start := time.Now()
-				conn, err := net.DialTimeout("tcp", address, timeoutDuration)
-				latency := float64(time.Since(start).Microseconds()) / 1000.0
-
-				if err != nil {
-					results[idx] = TCPingResponse{Success: false, Error: err.Error()}
-				} else {
-					conn.Close()
-					results[idx] = TCPingResponse{Success: true, Latency: latency}
-				}
+				results[idx] = tcping(r.Host, r.Port, r.Timeout)
 			}(i, req)
 		}
 
@@ -154,6 +115,56 @@ func NewTCPingBatchHandler() http.Handler {
 	})
 }
 
+// tcpingTimeout 返回探测超时，默认 5 秒，最长 30 秒
+func tcpingTimeout(timeout int) time.Duration {
+	if timeout <= 0 {
+		timeout = 5000
+	}
+	if timeout > 30000 {
+		timeout = 30000
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// tcping 建立一次 TCP 连接，返回连接耗时（毫秒）
+func tcping(host string, port int, timeout int) TCPingResponse {
+	address := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, tcpingTimeout(timeout))
+	latency := float64(time.Since(start).Microseconds()) / 1000.0
+	if err != nil {
+		return TCPingResponse{Success: false, Error: err.Error()}
+	}
+	conn.Close()
+	return TCPingResponse{Success: true, Latency: latency}
+}
+
+// tlsPing 建立 TCP 连接并完成 TLS 握手，返回总耗时（毫秒）
+// 只验证握手能否完成，不校验证书
+func tlsPing(host string, port int, serverName string, timeout int) TCPingResponse {
+	address := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	if serverName == "" {
+		serverName = host
+	}
+	timeoutDuration := tcpingTimeout(timeout)
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeoutDuration)
+	if err != nil {
+		return TCPingResponse{Success: false, Error: err.Error()}
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(start.Add(timeoutDuration))
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		return TCPingResponse{Success: false, Error: "tls handshake: " + err.Error()}
+	}
+	latency := float64(time.Since(start).Microseconds()) / 1000.0
+	return TCPingResponse{Success: true, Latency: latency}
+}
+
 func writeJSONError(w http.ResponseWriter, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -59,6 +59,12 @@ func (h *TemplateV3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.handleConvertV2Template(w, r)
+	case path == "/convert-singbox" || path == "/convert-singbox/":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleConvertSingboxTemplate(w, r)
 	case path == "/analyze-subscription" || path == "/analyze-subscription/":
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -483,11 +489,121 @@ func (h *TemplateV3Handler) handleConvertV2Template(w http.ResponseWriter, r *ht
 		return
 	}
 
+	// 同时生成 sing-box 模板，失败不影响 Clash 结果；规则集与 /convert-singbox 一样经转换接口引用
+	var singbox any
+	if tpl, err := substore.ConvertV3ToSingbox(result, &substore.SingboxTemplateOptions{RuleSetURL: h.singboxRuleSetURLFunc(r)}); err == nil {
+		singbox = tpl
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
 		"proxy_groups":   result.ProxyGroups,
 		"rules":          result.Rules,
 		"rule_providers": result.RuleProviders,
+		"singbox":        singbox,
+	})
+}
+
+// singboxRuleSetURLFunc 让 Clash yaml/text 规则集经转换接口输出 sing-box source 规则集，无法生成地址时返回 nil
+func (h *TemplateV3Handler) singboxRuleSetURLFunc(r *http.Request) func(string, substore.RuleProviderConfig) (string, string) {
+	if h.repo == nil {
+		return nil
+	}
+	convertURL := ruleSetConvertURLFunc(r, h.repo, auth.UsernameFromContext(r.Context()), "sing-box")
+	if convertURL == nil {
+		return nil
+	}
+	return func(name string, provider substore.RuleProviderConfig) (string, string) {
+		return convertURL(name, substore.ClashRuleProvider{
+			Type:     provider.Type,
+			Behavior: provider.Behavior,
+			URL:      provider.URL,
+			Path:     provider.Path,
+			Interval: provider.Interval,
+			Format:   provider.Format,
+		}), substore.SingboxRuleSetFormatSource
+	}
+}
+
+// convertSingboxRequest represents the request body for converting a template to sing-box
+type convertSingboxRequest struct {
+	TemplateName    string   `json:"template_name"`    // Name of template file in rule_templates/
+	TemplateContent string   `json:"template_content"` // Or raw v3 template content
+	ACLContent      string   `json:"acl_content"`      // Or v2 template content (ACL4SSR format)
+	NodeTags        []string `json:"node_tags"`        // Optional node tags to expand into groups
+
+	// ProxyProviders maps proxy-provider names used by groups (use:) to their URLs;
+	// MMW proxy providers are emitted as sing-box outbound_providers
+	ProxyProviders map[string]string `json:"proxy_providers"`
+}
+
+// handleConvertSingboxTemplate converts a v3 (or v2 ACL) template to sing-box outbounds and route
+func (h *TemplateV3Handler) handleConvertSingboxTemplate(w http.ResponseWriter, r *http.Request) {
+	var req convertSingboxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "无效的请求格式")
+		return
+	}
+
+	var (
+		content *substore.TemplateV3Content
+		err     error
+	)
+	switch {
+	case strings.TrimSpace(req.ACLContent) != "":
+		content, err = substore.ConvertACLToV3(req.ACLContent)
+	case strings.TrimSpace(req.TemplateContent) != "":
+		content, err = substore.ParseTemplateV3Content(req.TemplateContent)
+	case strings.TrimSpace(req.TemplateName) != "":
+		templateName := strings.TrimSpace(req.TemplateName)
+		// Security: Prevent directory traversal
+		if strings.Contains(templateName, "..") || strings.Contains(templateName, "/") || strings.Contains(templateName, "\\") {
+			writeJSONError(w, http.StatusBadRequest, "无效的模板名称")
+			return
+		}
+		data, readErr := os.ReadFile(filepath.Join("rule_templates", templateName))
+		if readErr != nil {
+			if os.IsNotExist(readErr) {
+				writeJSONError(w, http.StatusNotFound, "模板文件不存在")
+			} else {
+				writeJSONError(w, http.StatusInternalServerError, "读取模板文件失败")
+			}
+			return
+		}
+		content, err = substore.ParseTemplateV3Content(string(data))
+	default:
+		writeJSONError(w, http.StatusBadRequest, "请提供模板名称或模板内容")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "解析模板失败: "+err.Error())
+		return
+	}
+
+	opts := &substore.SingboxTemplateOptions{}
+	if len(req.ProxyProviders) > 0 && h.repo != nil {
+		providerURL := mmwProxyProviderURLFunc(r.Context(), h.repo, "sing-box")
+		opts.ProxyProviderURL = func(name string, _ substore.ClashProxyProvider) string {
+			return providerURL(name, substore.ClashProxyProvider{URL: req.ProxyProviders[name]})
+		}
+	}
+	opts.RuleSetURL = h.singboxRuleSetURLFunc(r)
+
+	tpl, err := substore.ConvertV3ToSingbox(content, opts)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "转换失败: "+err.Error())
+		return
+	}
+	if len(req.NodeTags) > 0 {
+		tpl.ExpandNodes(req.NodeTags)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"outbounds":          tpl.Outbounds,
+		"outbound_providers": tpl.OutboundProviders,
+		"route":              tpl.Route,
+		"skipped":            tpl.Skipped,
 	})
 }
 
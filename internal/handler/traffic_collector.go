@@ -0,0 +1,426 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 每日流量采集调度：按系统配置中的时间点（如 23:55，可指定时区）采集快照，
+// 服务停机错过计划时间时在启动后补采一次，运行结果写入数据库供管理接口查看。
+// 探针与订阅只提供当前的累计用量，无法还原过去某天的数值，停机跨越多天时只补采
+// 最近一次计划日期，更早的日期留空；历史汇总与流量预测按日期间隔均摊这段用量。
+
+const (
+	trafficCollectAttempts   = 3
+	trafficCollectTimeout    = 30 * time.Second
+	trafficCollectRetryDelay = 30 * time.Second
+	// 单次等待上限：定期重新计算下次运行时间，避免系统时钟调整或休眠导致错过计划
+	trafficCollectMaxWait = time.Hour
+)
+
+// trafficCollectSchedule 每日固定时间点的采集计划
+type trafficCollectSchedule struct {
+	hour     int
+	minute   int
+	location *time.Location
+}
+
+// parseTrafficCollectSchedule 解析采集时间与时区。
+// 时间支持 "HH:MM" 或只指定分、时的 cron 表达式（如 "55 23 * * *"）；时区为空或 "Local" 时使用服务器本地时区
+func parseTrafficCollectSchedule(spec, timezone string) (trafficCollectSchedule, error) {
+	var schedule trafficCollectSchedule
+
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = storage.DefaultTrafficCollectTime
+	}
+	if fields := strings.Fields(spec); len(fields) == 5 {
+		for _, field := range fields[2:] {
+			if field != "*" {
+				return schedule, fmt.Errorf("invalid collect time %q: only daily cron expressions are supported", spec)
+			}
+		}
+		minute, errMinute := strconv.Atoi(fields[0])
+		hour, errHour := strconv.Atoi(fields[1])
+		if errMinute != nil || errHour != nil || minute < 0 || minute > 59 || hour < 0 || hour > 23 {
+			return schedule, fmt.Errorf("invalid collect time %q", spec)
+		}
+		schedule.hour, schedule.minute = hour, minute
+	} else {
+		parsed, err := time.Parse("15:04", spec)
+		if err != nil {
+			return schedule, fmt.Errorf("invalid collect time %q: expected HH:MM", spec)
+		}
+		schedule.hour, schedule.minute = parsed.Hour(), parsed.Minute()
+	}
+
+	timezone = strings.TrimSpace(timezone)
+	if timezone == "" || strings.EqualFold(timezone, "Local") {
+		schedule.location = time.Local
+		return schedule, nil
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return schedule, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+	schedule.location = location
+	return schedule, nil
+}
+
+// String 返回规范化的 "HH:MM"
+func (s trafficCollectSchedule) String() string {
+	return fmt.Sprintf("%02d:%02d", s.hour, s.minute)
+}
+
+// at 返回 t 所在日期（计划时区）的计划时间
+func (s trafficCollectSchedule) at(t time.Time) time.Time {
+	local := t.In(s.location)
+	return time.Date(local.Year(), local.Month(), local.Day(), s.hour, s.minute, 0, 0, s.location)
+}
+
+// next 返回晚于 after 的下一次计划时间
+func (s trafficCollectSchedule) next(after time.Time) time.Time {
+	candidate := s.at(after)
+	if !candidate.After(after) {
+		candidate = s.at(candidate.AddDate(0, 0, 1))
+	}
+	return candidate
+}
+
+// prev 返回不晚于 now 的最近一次计划时间
+func (s trafficCollectSchedule) prev(now time.Time) time.Time {
+	candidate := s.at(now)
+	if candidate.After(now) {
+		candidate = s.at(candidate.AddDate(0, 0, -1))
+	}
+	return candidate
+}
+
+// snapshotDate 返回计划时间在计划时区的日期，作为快照日期保存
+func (s trafficCollectSchedule) snapshotDate(scheduledAt time.Time) time.Time {
+	local := scheduledAt.In(s.location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// loadTrafficCollectSchedule 读取系统配置中的采集计划，配置无效时回退到默认计划
+func loadTrafficCollectSchedule(ctx context.Context, repo *storage.TrafficRepository) trafficCollectSchedule {
+	cfg, err := repo.GetSystemConfig(ctx)
+	if err != nil {
+		logger.Warn("[流量收集器] 读取采集计划失败，使用默认计划", "error", err)
+	} else if schedule, err := parseTrafficCollectSchedule(cfg.TrafficCollectTime, cfg.TrafficCollectTimezone); err == nil {
+		return schedule
+	} else {
+		logger.Warn("[流量收集器] 采集计划无效，使用默认计划", "time", cfg.TrafficCollectTime, "timezone", cfg.TrafficCollectTimezone, "error", err)
+	}
+	schedule, _ := parseTrafficCollectSchedule(storage.DefaultTrafficCollectTime, "")
+	return schedule
+}
+
+// trafficCollector 每日流量采集调度器
+type trafficCollector struct {
+	handler *TrafficSummaryHandler
+	repo    *storage.TrafficRepository
+	reload  chan struct{}
+
+	mu      sync.Mutex
+	running bool
+}
+
+var globalTrafficCollector atomic.Pointer[trafficCollector]
+
+// StartTrafficCollector 启动每日流量采集调度
+// 该函数会阻塞，直到context被取消
+func StartTrafficCollector(ctx context.Context, trafficHandler *TrafficSummaryHandler, repo *storage.TrafficRepository) {
+	if trafficHandler == nil || repo == nil {
+		return
+	}
+
+	c := &trafficCollector{
+		handler: trafficHandler,
+		repo:    repo,
+		reload:  make(chan struct{}, 1),
+	}
+	globalTrafficCollector.Store(c)
+	c.run(ctx)
+}
+
+// reloadTrafficCollectSchedule 通知调度器按新的配置重新计算下次运行时间
+func reloadTrafficCollectSchedule() {
+	c := globalTrafficCollector.Load()
+	if c == nil {
+		return
+	}
+	select {
+	case c.reload <- struct{}{}:
+	default:
+	}
+}
+
+func (c *trafficCollector) run(ctx context.Context) {
+	schedule := loadTrafficCollectSchedule(ctx, c.repo)
+	logger.Info("[流量收集器] 定时调度器已启动", "time", schedule.String(), "timezone", schedule.location.String())
+	defer logger.Info("[流量收集器] 定时调度器已停止")
+
+	// 最近一次计划时间之后没有成功采集（包括从未采集）时立即补采
+	if due := schedule.prev(time.Now()); c.missed(ctx, due) {
+		logger.Info("[流量收集器] 错过计划采集，启动后补采", "scheduled_at", due.Format(time.RFC3339))
+		if skipped := c.skippedDays(ctx, schedule, due); skipped > 0 {
+			logger.Warn("[流量收集器] 停机期间错过多次采集，更早的日期无法补采", "skipped_days", skipped)
+		}
+		c.collect(ctx, schedule, due)
+	}
+
+	for {
+		schedule = loadTrafficCollectSchedule(ctx, c.repo)
+		next := schedule.next(time.Now())
+
+		wait := time.Until(next)
+		if wait > trafficCollectMaxWait {
+			wait = trafficCollectMaxWait
+		}
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-c.reload:
+			timer.Stop()
+			logger.Info("[流量收集器] 采集计划已更新", "time", schedule.String())
+		case <-timer.C:
+			if !time.Now().Before(next) {
+				c.collect(ctx, schedule, next)
+			}
+		}
+	}
+}
+
+// missed 判断计划时间 due 之后是否还没有成功采集
+func (c *trafficCollector) missed(ctx context.Context, due time.Time) bool {
+	run, err := c.repo.GetTrafficCollectRun(ctx)
+	if err != nil {
+		logger.Warn("[流量收集器] 读取上次运行记录失败", "error", err)
+		return true
+	}
+	return run.SucceededAt == nil || run.SucceededAt.Before(due)
+}
+
+// skippedDays 返回上次成功采集与 due 之间除 due 以外错过的计划次数，从未采集时为 0
+func (c *trafficCollector) skippedDays(ctx context.Context, schedule trafficCollectSchedule, due time.Time) int {
+	run, err := c.repo.GetTrafficCollectRun(ctx)
+	if err != nil || run.SucceededAt == nil {
+		return 0
+	}
+	return missedScheduleCount(schedule, *run.SucceededAt, due) - 1
+}
+
+// missedScheduleCount 返回 (since, due] 内的计划次数
+func missedScheduleCount(schedule trafficCollectSchedule, since, due time.Time) int {
+	count := 0
+	for at := schedule.next(since); !at.After(due); at = schedule.next(at) {
+		count++
+	}
+	return count
+}
+
+func (c *trafficCollector) setRunning(running bool) {
+	c.mu.Lock()
+	c.running = running
+	c.mu.Unlock()
+}
+
+func (c *trafficCollector) isRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// collect 带重试地采集 scheduledAt 对应日期的快照，并记录运行结果
+func (c *trafficCollector) collect(ctx context.Context, schedule trafficCollectSchedule, scheduledAt time.Time) {
+	c.setRunning(true)
+	defer c.setRunning(false)
+
+	run, err := c.repo.GetTrafficCollectRun(ctx)
+	if err != nil {
+		logger.Warn("[流量收集器] 读取上次运行记录失败", "error", err)
+	}
+	startedAt := time.Now()
+	run.ScheduledAt = &scheduledAt
+	run.StartedAt = &startedAt
+	run.FinishedAt = nil
+	run.LastError = ""
+	c.saveRun(run)
+
+	date := schedule.snapshotDate(scheduledAt)
+	logger.Info("[流量收集器] 开始每日流量收集",
+		"start_time", startedAt.Format("2006-01-02 15:04:05"),
+		"snapshot_date", date.Format("2006-01-02"))
+
+	err = c.collectWithRetry(ctx, date)
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	if err != nil {
+		run.LastError = err.Error()
+	} else {
+		run.SucceededAt = &finishedAt
+	}
+	c.saveRun(run)
+}
+
+func (c *trafficCollector) collectWithRetry(ctx context.Context, date time.Time) error {
+	var err error
+	for attempt := 1; attempt <= trafficCollectAttempts; attempt++ {
+		runCtx, cancel := context.WithTimeout(ctx, trafficCollectTimeout)
+		err = c.handler.RecordDailyUsageOn(runCtx, date)
+		cancel()
+
+		if err == nil {
+			logger.Info("[流量收集器] 每日流量收集成功")
+			return nil
+		}
+
+		logger.Warn("[流量收集器] 每日流量收集失败", "attempt", attempt, "max_retries", trafficCollectAttempts, "error", err)
+
+		// 如果是探针配置未找到错误，不需要重试
+		if errors.Is(err, storage.ErrProbeConfigNotFound) {
+			logger.Info("[流量收集器] 探针未配置，跳过重试")
+			return err
+		}
+
+		if attempt < trafficCollectAttempts {
+			logger.Info("[流量收集器] 准备重试", "delay", trafficCollectRetryDelay)
+			select {
+			case <-ctx.Done():
+				logger.Info("[流量收集器] 重试已取消（服务器关闭）")
+				return ctx.Err()
+			case <-time.After(trafficCollectRetryDelay):
+				// 继续重试
+			}
+		}
+	}
+
+	logger.Error("[流量收集器] 达到最大重试次数后仍失败", "max_retries", trafficCollectAttempts)
+	return err
+}
+
+// saveRun 保存运行记录；服务关闭时使用独立的context，保证结果能够写入
+func (c *trafficCollector) saveRun(run storage.TrafficCollectRun) {
+	saveCtx, cancel := context.WithTimeout(context.Background(), configLoadTimeout)
+	defer cancel()
+	if err := c.repo.SaveTrafficCollectRun(saveCtx, run); err != nil {
+		logger.Warn("[流量收集器] 保存运行记录失败", "error", err)
+	}
+}
+
+// trafficCollectorStatus 采集计划与运行状态
+type trafficCollectorStatus struct {
+	Time            string     `json:"time"`
+	Timezone        string     `json:"timezone"`
+	Running         bool       `json:"running"`
+	NextRunAt       *time.Time `json:"next_run_at,omitempty"`
+	LastScheduledAt *time.Time `json:"last_scheduled_at,omitempty"`
+	LastRunAt       *time.Time `json:"last_run_at,omitempty"`
+	LastFinishedAt  *time.Time `json:"last_finished_at,omitempty"`
+	LastSuccessAt   *time.Time `json:"last_success_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+type trafficCollectorConfigRequest struct {
+	Time     string `json:"time"`
+	Timezone string `json:"timezone"`
+}
+
+// NewTrafficCollectorHandler 查看与修改每日流量采集计划
+func NewTrafficCollectorHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("traffic collector handler requires repository")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			respondTrafficCollectorStatus(w, r, repo)
+		case http.MethodPut:
+			var payload trafficCollectorConfigRequest
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				writeBadRequest(w, "invalid JSON payload")
+				return
+			}
+			schedule, err := parseTrafficCollectSchedule(payload.Time, payload.Timezone)
+			if err != nil {
+				writeBadRequest(w, err.Error())
+				return
+			}
+
+			cfg, err := repo.GetSystemConfig(r.Context())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			cfg.TrafficCollectTime = schedule.String()
+			cfg.TrafficCollectTimezone = strings.TrimSpace(payload.Timezone)
+			if strings.EqualFold(cfg.TrafficCollectTimezone, "Local") {
+				cfg.TrafficCollectTimezone = ""
+			}
+			if err := repo.UpdateSystemConfig(r.Context(), cfg); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			logger.Info("[流量收集器] 采集计划已修改", "time", cfg.TrafficCollectTime, "timezone", schedule.location.String())
+			reloadTrafficCollectSchedule()
+			respondTrafficCollectorStatus(w, r, repo)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		}
+	})
+}
+
+func respondTrafficCollectorStatus(w http.ResponseWriter, r *http.Request, repo *storage.TrafficRepository) {
+	cfg, err := repo.GetSystemConfig(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	run, err := repo.GetTrafficCollectRun(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	schedule, err := parseTrafficCollectSchedule(cfg.TrafficCollectTime, cfg.TrafficCollectTimezone)
+	if err != nil {
+		schedule = loadTrafficCollectSchedule(r.Context(), repo)
+	}
+
+	status := trafficCollectorStatus{
+		Time:            schedule.String(),
+		Timezone:        schedule.location.String(),
+		LastScheduledAt: run.ScheduledAt,
+		LastRunAt:       run.StartedAt,
+		LastFinishedAt:  run.FinishedAt,
+		LastSuccessAt:   run.SucceededAt,
+		LastError:       run.LastError,
+	}
+
+	// 按当前配置计算，修改计划后无需等待调度器重新加载
+	next := schedule.next(time.Now())
+	status.NextRunAt = &next
+	if c := globalTrafficCollector.Load(); c != nil {
+		status.Running = c.isRunning()
+	}
+
+	respondJSON(w, http.StatusOK, status)
+}
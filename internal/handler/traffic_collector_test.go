@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseTrafficCollectSchedule(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         string
+		timezone     string
+		wantTime     string
+		wantLocation string
+		wantErr      bool
+	}{
+		{name: "default", wantTime: "23:55", wantLocation: "Local"},
+		{name: "hh:mm", spec: "08:30", wantTime: "08:30", wantLocation: "Local"},
+		{name: "daily cron", spec: "5 3 * * *", wantTime: "03:05", wantLocation: "Local"},
+		{name: "timezone", spec: "00:10", timezone: "Asia/Shanghai", wantTime: "00:10", wantLocation: "Asia/Shanghai"},
+		{name: "local keyword", spec: "23:00", timezone: "local", wantTime: "23:00", wantLocation: "Local"},
+		{name: "weekly cron rejected", spec: "55 23 * * 1", wantErr: true},
+		{name: "cron minute out of range", spec: "60 23 * * *", wantErr: true},
+		{name: "cron hour out of range", spec: "0 24 * * *", wantErr: true},
+		{name: "invalid hh:mm", spec: "25:00", wantErr: true},
+		{name: "unknown timezone", spec: "23:55", timezone: "Mars/Olympus", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseTrafficCollectSchedule(tt.spec, tt.timezone)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTrafficCollectSchedule(%q, %q) = %s, want error", tt.spec, tt.timezone, schedule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTrafficCollectSchedule(%q, %q): %v", tt.spec, tt.timezone, err)
+			}
+			if schedule.String() != tt.wantTime || schedule.location.String() != tt.wantLocation {
+				t.Fatalf("schedule = %s %s, want %s %s", schedule, schedule.location, tt.wantTime, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func mustSchedule(t *testing.T, spec, timezone string) trafficCollectSchedule {
+	t.Helper()
+	schedule, err := parseTrafficCollectSchedule(spec, timezone)
+	if err != nil {
+		t.Fatalf("parseTrafficCollectSchedule(%q, %q): %v", spec, timezone, err)
+	}
+	return schedule
+}
+
+func TestTrafficCollectScheduleNextPrev(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	schedule := mustSchedule(t, "23:55", "America/New_York")
+	at := func(y int, m time.Month, d, hh, mm int) time.Time { return time.Date(y, m, d, hh, mm, 0, 0, newYork) }
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		wantNext time.Time
+		wantPrev time.Time
+	}{
+		{"before today's run", at(2026, 5, 10, 10, 0), at(2026, 5, 10, 23, 55), at(2026, 5, 9, 23, 55)},
+		{"exactly at run time", at(2026, 5, 10, 23, 55), at(2026, 5, 11, 23, 55), at(2026, 5, 10, 23, 55)},
+		{"after today's run", at(2026, 5, 10, 23, 56), at(2026, 5, 11, 23, 55), at(2026, 5, 10, 23, 55)},
+		{"month boundary", at(2026, 5, 31, 23, 59), at(2026, 6, 1, 23, 55), at(2026, 5, 31, 23, 55)},
+		// 夏令时开始：3 月 8 日只有 23 小时
+		{"spring forward", at(2026, 3, 7, 23, 56), at(2026, 3, 8, 23, 55), at(2026, 3, 7, 23, 55)},
+		// 夏令时结束：11 月 1 日有 25 小时
+		{"fall back", at(2026, 11, 1, 12, 0), at(2026, 11, 1, 23, 55), at(2026, 10, 31, 23, 55)},
+		{"utc instant in other zone", time.Date(2026, 5, 11, 2, 0, 0, 0, time.UTC), at(2026, 5, 10, 23, 55), at(2026, 5, 9, 23, 55)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schedule.next(tt.now); !got.Equal(tt.wantNext) {
+				t.Errorf("next(%s) = %s, want %s", tt.now, got, tt.wantNext)
+			}
+			if got := schedule.prev(tt.now); !got.Equal(tt.wantPrev) {
+				t.Errorf("prev(%s) = %s, want %s", tt.now, got, tt.wantPrev)
+			}
+		})
+	}
+
+	if gap := schedule.next(at(2026, 3, 7, 23, 55)).Sub(at(2026, 3, 7, 23, 55)); gap != 23*time.Hour {
+		t.Errorf("gap across spring forward = %s, want 23h", gap)
+	}
+	if gap := schedule.next(at(2026, 10, 31, 23, 55)).Sub(at(2026, 10, 31, 23, 55)); gap != 25*time.Hour {
+		t.Errorf("gap across fall back = %s, want 25h", gap)
+	}
+}
+
+func TestTrafficCollectScheduleNonexistentLocalTime(t *testing.T) {
+	// 02:30 在夏令时开始当天不存在，仍然每天运行一次
+	schedule := mustSchedule(t, "02:30", "America/New_York")
+	start := time.Date(2026, 3, 7, 12, 0, 0, 0, schedule.location)
+	seen := make(map[string]bool)
+	for at, i := schedule.next(start), 0; i < 3; at, i = schedule.next(at), i+1 {
+		if !at.After(start) {
+			t.Fatalf("next = %s, not after %s", at, start)
+		}
+		day := at.In(schedule.location).Format("2006-01-02")
+		if seen[day] {
+			t.Fatalf("ran twice on %s", day)
+		}
+		seen[day] = true
+		start = at
+	}
+	for _, day := range []string{"2026-03-08", "2026-03-09", "2026-03-10"} {
+		if !seen[day] {
+			t.Errorf("no run on %s, got %v", day, seen)
+		}
+	}
+}
+
+func TestTrafficCollectSnapshotDate(t *testing.T) {
+	tests := []struct {
+		name      string
+		timezone  string
+		scheduled time.Time
+		want      string
+	}{
+		// 23:55 PDT 已是 UTC 次日，快照仍记在计划时区的当天
+		{"behind utc", "America/Los_Angeles", time.Date(2026, 5, 11, 6, 55, 0, 0, time.UTC), "2026-05-10"},
+		// 00:10 CST 仍是 UTC 前一天，快照记在计划时区的当天
+		{"ahead of utc", "Asia/Shanghai", time.Date(2026, 5, 10, 16, 10, 0, 0, time.UTC), "2026-05-11"},
+		{"utc", "UTC", time.Date(2026, 5, 10, 23, 55, 0, 0, time.UTC), "2026-05-10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := mustSchedule(t, "23:55", tt.timezone)
+			got := schedule.snapshotDate(tt.scheduled)
+			if got.Format("2006-01-02") != tt.want || got.Location() != time.UTC || got.Hour() != 0 {
+				t.Fatalf("snapshotDate(%s) = %s, want %s UTC midnight", tt.scheduled, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissedScheduleCount(t *testing.T) {
+	schedule := mustSchedule(t, "23:55", "America/New_York")
+	at := func(m time.Month, d, hh, mm int) time.Time {
+		return time.Date(2026, m, d, hh, mm, 0, 0, schedule.location)
+	}
+	tests := []struct {
+		name  string
+		since time.Time
+		due   time.Time
+		want  int
+	}{
+		{"only the due run", at(5, 9, 23, 56), at(5, 10, 23, 55), 1},
+		{"three days down", at(5, 7, 23, 56), at(5, 10, 23, 55), 3},
+		{"succeeded after due", at(5, 10, 23, 56), at(5, 10, 23, 55), 0},
+		{"across spring forward", at(3, 6, 23, 56), at(3, 9, 23, 55), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missedScheduleCount(schedule, tt.since, tt.due); got != tt.want {
+				t.Fatalf("missedScheduleCount(%s, %s) = %d, want %d", tt.since, tt.due, got, tt.want)
+			}
+		})
+	}
+}
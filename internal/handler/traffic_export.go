@@ -0,0 +1,542 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 流量历史导出与导入：全局快照（traffic_records）与按来源拆分的快照统一为一行一条记录，
+// 支持 CSV 与 JSON。导入可来自其他妙妙屋实例的导出文件或表格，按日期合并。
+
+const (
+	trafficExportFormatCSV  = "csv"
+	trafficExportFormatJSON = "json"
+
+	// 导出全部范围：全局快照及所有来源
+	trafficExportScopeAll = "all"
+
+	trafficExportVersion = 1
+
+	// 导入文件大小上限
+	maxTrafficImportBytes = 20 << 20
+)
+
+// trafficExportColumns CSV 表头，导入时按列名匹配，顺序不限
+var trafficExportColumns = []string{"date", "scope", "key", "name", "username", "limit_bytes", "used_bytes", "remaining_bytes"}
+
+type trafficExportRecord struct {
+	Date           string `json:"date"`
+	Scope          string `json:"scope"` // total 或 storage.TrafficSource*
+	Key            string `json:"key"`
+	Name           string `json:"name,omitempty"`
+	Username       string `json:"username,omitempty"`
+	LimitBytes     int64  `json:"limit_bytes"`
+	UsedBytes      int64  `json:"used_bytes"`
+	RemainingBytes int64  `json:"remaining_bytes"`
+}
+
+type trafficExportDocument struct {
+	Version    int                   `json:"version"`
+	ExportedAt time.Time             `json:"exported_at"`
+	From       string                `json:"from,omitempty"`
+	To         string                `json:"to"`
+	Records    []trafficExportRecord `json:"records"`
+}
+
+type trafficImportResponse struct {
+	Policy   string `json:"policy"`
+	Records  int    `json:"records"`
+	Inserted int    `json:"inserted"`
+	Updated  int    `json:"updated"`
+	Skipped  int    `json:"skipped"`
+}
+
+type trafficExportHandler struct {
+	repo *storage.TrafficRepository
+}
+
+// NewTrafficExportHandler exports traffic history as CSV or JSON for a date range.
+func NewTrafficExportHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("traffic export handler requires repository")
+	}
+
+	return &trafficExportHandler{repo: repo}
+}
+
+func (h *trafficExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	query := r.URL.Query()
+
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	switch format {
+	case "":
+		format = trafficExportFormatCSV
+	case trafficExportFormatCSV, trafficExportFormatJSON:
+	default:
+		writeBadRequest(w, "format 仅支持 csv、json")
+		return
+	}
+
+	// 未指定 from 时导出全部历史
+	var err error
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if raw := strings.TrimSpace(query.Get("to")); raw != "" {
+		if to, err = time.Parse("2006-01-02", raw); err != nil {
+			writeBadRequest(w, "to 日期格式应为 YYYY-MM-DD")
+			return
+		}
+	}
+	var from time.Time
+	if raw := strings.TrimSpace(query.Get("from")); raw != "" {
+		if from, err = time.Parse("2006-01-02", raw); err != nil {
+			writeBadRequest(w, "from 日期格式应为 YYYY-MM-DD")
+			return
+		}
+		if from.After(to) {
+			writeBadRequest(w, "from 不能晚于 to")
+			return
+		}
+	}
+
+	scope := strings.ToLower(strings.TrimSpace(query.Get("scope")))
+	if scope == "" {
+		scope = trafficExportScopeAll
+	}
+	if scope != trafficExportScopeAll && scope != trafficScopeTotal && !storage.IsValidTrafficSource(scope) {
+		writeBadRequest(w, "scope 仅支持 all、total、probe_server、external_subscription、user")
+		return
+	}
+	key := strings.TrimSpace(query.Get("key"))
+
+	var records []trafficExportRecord
+	if scope == trafficExportScopeAll || scope == trafficScopeTotal {
+		totals, err := h.repo.ListTrafficRecordsBetween(r.Context(), from, to)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, record := range totals {
+			records = append(records, trafficExportRecord{
+				Date:           record.Date.Format("2006-01-02"),
+				Scope:          trafficScopeTotal,
+				Key:            trafficScopeTotal,
+				LimitBytes:     record.TotalLimit,
+				UsedBytes:      record.TotalUsed,
+				RemainingBytes: record.TotalRemaining,
+			})
+		}
+	}
+	if scope != trafficScopeTotal {
+		filter := storage.TrafficSourceFilter{SourceKey: key, From: from, To: to}
+		if scope != trafficExportScopeAll {
+			filter.SourceType = scope
+		}
+		sources, err := h.repo.ListTrafficSourceRecords(r.Context(), filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, record := range sources {
+			records = append(records, trafficExportRecord{
+				Date:           record.Date.Format("2006-01-02"),
+				Scope:          record.SourceType,
+				Key:            record.SourceKey,
+				Name:           record.SourceName,
+				Username:       record.Username,
+				LimitBytes:     record.TotalLimit,
+				UsedBytes:      record.TotalUsed,
+				RemainingBytes: record.TotalRemaining,
+			})
+		}
+	}
+
+	filename := fmt.Sprintf("miaomiaowu-traffic-%s.%s", time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+	if format == trafficExportFormatJSON {
+		doc := trafficExportDocument{
+			Version:    trafficExportVersion,
+			ExportedAt: time.Now().UTC(),
+			To:         to.Format("2006-01-02"),
+			Records:    records,
+		}
+		if !from.IsZero() {
+			doc.From = from.Format("2006-01-02")
+		}
+		if doc.Records == nil {
+			doc.Records = []trafficExportRecord{}
+		}
+		respondJSON(w, http.StatusOK, doc)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(w)
+	_ = writer.Write(trafficExportColumns)
+	for _, record := range records {
+		_ = writer.Write([]string{
+			record.Date,
+			csvSafeCell(record.Scope),
+			csvSafeCell(record.Key),
+			csvSafeCell(record.Name),
+			csvSafeCell(record.Username),
+			strconv.FormatInt(record.LimitBytes, 10),
+			strconv.FormatInt(record.UsedBytes, 10),
+			strconv.FormatInt(record.RemainingBytes, 10),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logger.Warn("[流量导出] 写入 CSV 失败", "error", err)
+	}
+}
+
+type trafficImportHandler struct {
+	repo *storage.TrafficRepository
+}
+
+// NewTrafficImportHandler imports traffic history exported by another instance or
+// prepared in a spreadsheet, merging by date with a conflict policy.
+func NewTrafficImportHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("traffic import handler requires repository")
+	}
+
+	return &trafficImportHandler{repo: repo}
+}
+
+func (h *trafficImportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	query := r.URL.Query()
+	policy := strings.ToLower(strings.TrimSpace(query.Get("policy")))
+	if policy == "" {
+		policy = storage.TrafficConflictKeep
+	}
+	if !storage.IsValidTrafficConflictPolicy(policy) {
+		writeBadRequest(w, "policy 仅支持 keep、overwrite、max")
+		return
+	}
+
+	// 支持 multipart 上传（字段 file）或直接提交文件内容
+	r.Body = http.MaxBytesReader(w, r.Body, maxTrafficImportBytes)
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	var body []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeBadRequest(w, "读取上传文件失败")
+			return
+		}
+		defer file.Close()
+		if body, err = io.ReadAll(file); err != nil {
+			writeBadRequest(w, "读取上传文件失败")
+			return
+		}
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+		}
+	} else {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			writeBadRequest(w, "读取请求内容失败")
+			return
+		}
+		if format == "" && strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			format = trafficExportFormatCSV
+		}
+	}
+
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")) // 表格软件导出的 UTF-8 BOM
+	if format != trafficExportFormatCSV && format != trafficExportFormatJSON {
+		format = trafficExportFormatCSV
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+			format = trafficExportFormatJSON
+		}
+	}
+
+	var (
+		records []trafficExportRecord
+		err     error
+	)
+	if format == trafficExportFormatJSON {
+		records, err = parseTrafficImportJSON(body)
+	} else {
+		records, err = parseTrafficImportCSV(body)
+	}
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	if len(records) == 0 {
+		writeBadRequest(w, "导入文件中没有流量记录")
+		return
+	}
+
+	subs, err := h.repo.ListAllExternalSubscriptions(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	totals, sources, err := splitTrafficImportRecords(records, subs)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	result, err := h.repo.ImportTrafficHistory(r.Context(), totals, sources, policy)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	logger.Info("[流量导入] 导入流量历史", "format", format, "policy", policy, "records", len(records),
+		"inserted", result.Inserted, "updated", result.Updated, "skipped", result.Skipped)
+
+	respondJSON(w, http.StatusOK, trafficImportResponse{
+		Policy:   policy,
+		Records:  len(records),
+		Inserted: result.Inserted,
+		Updated:  result.Updated,
+		Skipped:  result.Skipped,
+	})
+}
+
+// parseTrafficImportJSON 解析导出文档，也接受直接的记录数组
+func parseTrafficImportJSON(body []byte) ([]trafficExportRecord, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var records []trafficExportRecord
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, fmt.Errorf("JSON 格式不正确: %v", err)
+		}
+		return records, nil
+	}
+
+	var doc trafficExportDocument
+	if err := json.Unmarshal(trimmed, &doc); err != nil {
+		return nil, fmt.Errorf("JSON 格式不正确: %v", err)
+	}
+	return doc.Records, nil
+}
+
+// parseTrafficImportCSV 按表头解析 CSV。流量列可用 *_bytes 或 *_gb，
+// 缺少 scope 时视为全局快照，缺少剩余流量时按总量与已用计算
+func parseTrafficImportCSV(body []byte) ([]trafficExportRecord, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV 格式不正确: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["date"]; !ok {
+		return nil, errors.New("CSV 缺少 date 列")
+	}
+	_, hasUsedBytes := columns["used_bytes"]
+	_, hasUsedGB := columns["used_gb"]
+	if !hasUsedBytes && !hasUsedGB {
+		return nil, errors.New("CSV 缺少 used_bytes 或 used_gb 列")
+	}
+
+	cell := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	amount := func(row []string, name string) (int64, bool, error) {
+		if raw := cell(row, name+"_bytes"); raw != "" {
+			value, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return 0, false, fmt.Errorf("%s_bytes 不是整数: %s", name, raw)
+			}
+			return value, true, nil
+		}
+		if raw := cell(row, name+"_gb"); raw != "" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				return 0, false, fmt.Errorf("%s_gb 不是数字: %s", name, raw)
+			}
+			return int64(math.Round(value * bytesPerGigabyte)), true, nil
+		}
+		return 0, false, nil
+	}
+
+	records := make([]trafficExportRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		line := i + 2
+		if len(strings.TrimSpace(strings.Join(row, ""))) == 0 {
+			continue
+		}
+
+		record := trafficExportRecord{
+			Date:     cell(row, "date"),
+			Scope:    csvUnescapeCell(cell(row, "scope")),
+			Key:      csvUnescapeCell(cell(row, "key")),
+			Name:     csvUnescapeCell(cell(row, "name")),
+			Username: csvUnescapeCell(cell(row, "username")),
+		}
+		limit, _, err := amount(row, "limit")
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", line, err)
+		}
+		used, ok, err := amount(row, "used")
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", line, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("第 %d 行: 缺少已用流量", line)
+		}
+		remaining, hasRemaining, err := amount(row, "remaining")
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", line, err)
+		}
+		if !hasRemaining {
+			remaining = limit - used
+			if remaining < 0 {
+				remaining = 0
+			}
+		}
+		record.LimitBytes = limit
+		record.UsedBytes = used
+		record.RemainingBytes = remaining
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// splitTrafficImportRecords 校验导入记录并拆分为全局快照和来源快照。
+// 外部订阅的 key 是导出实例的订阅 ID，与本实例无关，按用户名和订阅名称映射到本地订阅，
+// 找不到同名订阅时拒绝导入，避免历史记到无关的订阅上
+func splitTrafficImportRecords(records []trafficExportRecord, subs []storage.ExternalSubscription) ([]storage.TrafficRecord, []storage.TrafficSourceRecord, error) {
+	var (
+		totals  []storage.TrafficRecord
+		sources []storage.TrafficSourceRecord
+	)
+	for i, record := range records {
+		index := i + 1
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(record.Date))
+		if err != nil {
+			return nil, nil, fmt.Errorf("第 %d 条记录: date 格式应为 YYYY-MM-DD", index)
+		}
+		if record.LimitBytes < 0 || record.UsedBytes < 0 || record.RemainingBytes < 0 {
+			return nil, nil, fmt.Errorf("第 %d 条记录: 流量不能为负数", index)
+		}
+
+		scope := strings.ToLower(strings.TrimSpace(record.Scope))
+		switch {
+		case scope == "" || scope == trafficScopeTotal:
+			totals = append(totals, storage.TrafficRecord{
+				Date:           date,
+				TotalLimit:     record.LimitBytes,
+				TotalUsed:      record.UsedBytes,
+				TotalRemaining: record.RemainingBytes,
+			})
+		case storage.IsValidTrafficSource(scope):
+			key := strings.TrimSpace(record.Key)
+			if key == "" {
+				return nil, nil, fmt.Errorf("第 %d 条记录: %s 需要 key", index, scope)
+			}
+			name := strings.TrimSpace(record.Name)
+			username := strings.TrimSpace(record.Username)
+			if scope == storage.TrafficSourceExternalSubscription {
+				sub, err := matchImportedExternalSubscription(subs, username, name)
+				if err != nil {
+					return nil, nil, fmt.Errorf("第 %d 条记录: %v", index, err)
+				}
+				key = formatExternalSubscriptionSourceKey(sub.ID)
+				username = sub.Username
+			}
+			if name == "" {
+				name = key
+			}
+			sources = append(sources, storage.TrafficSourceRecord{
+				Date:           date,
+				SourceType:     scope,
+				SourceKey:      key,
+				SourceName:     name,
+				Username:       username,
+				TotalLimit:     record.LimitBytes,
+				TotalUsed:      record.UsedBytes,
+				TotalRemaining: record.RemainingBytes,
+			})
+		default:
+			return nil, nil, fmt.Errorf("第 %d 条记录: 不支持的 scope %s", index, record.Scope)
+		}
+	}
+	return totals, sources, nil
+}
+
+// matchImportedExternalSubscription 按订阅名称查找本地外部订阅；未提供用户名时名称须唯一
+func matchImportedExternalSubscription(subs []storage.ExternalSubscription, username, name string) (storage.ExternalSubscription, error) {
+	if name == "" {
+		return storage.ExternalSubscription{}, errors.New("外部订阅记录需要 name")
+	}
+
+	var matches []storage.ExternalSubscription
+	for _, sub := range subs {
+		if sub.Name == name && (username == "" || sub.Username == username) {
+			matches = append(matches, sub)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		if username != "" {
+			return storage.ExternalSubscription{}, fmt.Errorf("用户 %s 没有名为 %s 的外部订阅", username, name)
+		}
+		return storage.ExternalSubscription{}, fmt.Errorf("没有名为 %s 的外部订阅", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return storage.ExternalSubscription{}, fmt.Errorf("多个用户都有名为 %s 的外部订阅，请填写 username", name)
+	}
+}
+
+// csvSafeCell 为以公式字符开头的单元格加上单引号，防止表格软件打开导出文件时执行公式
+func csvSafeCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// csvUnescapeCell 去掉 csvSafeCell 加上的单引号
+func csvUnescapeCell(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"miaomiaowu/internal/storage"
+)
+
+func TestSplitTrafficImportRecordsExternalSubscription(t *testing.T) {
+	subs := []storage.ExternalSubscription{
+		{ID: 3, Username: "alice", Name: "airport"},
+		{ID: 7, Username: "bob", Name: "airport"},
+		{ID: 9, Username: "bob", Name: "backup"},
+	}
+	tests := []struct {
+		name     string
+		record   trafficExportRecord
+		wantKey  string
+		wantUser string
+		wantErr  string
+	}{
+		{
+			name:     "remapped by username and name",
+			record:   trafficExportRecord{Key: "sub:1", Name: "airport", Username: "bob"},
+			wantKey:  "sub:7",
+			wantUser: "bob",
+		},
+		{
+			name:     "unique name without username",
+			record:   trafficExportRecord{Key: "sub:7", Name: "backup"},
+			wantKey:  "sub:9",
+			wantUser: "bob",
+		},
+		{
+			name:    "ambiguous name without username",
+			record:  trafficExportRecord{Key: "sub:3", Name: "airport"},
+			wantErr: "请填写 username",
+		},
+		{
+			// key 在本实例对应 alice 的订阅，但名称不同，不能沿用
+			name:    "key of unrelated subscription",
+			record:  trafficExportRecord{Key: "sub:3", Name: "other", Username: "alice"},
+			wantErr: "没有名为 other 的外部订阅",
+		},
+		{
+			name:    "missing name",
+			record:  trafficExportRecord{Key: "sub:3", Username: "alice"},
+			wantErr: "需要 name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := tt.record
+			record.Date = "2026-05-01"
+			record.Scope = storage.TrafficSourceExternalSubscription
+			_, sources, err := splitTrafficImportRecords([]trafficExportRecord{record}, subs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitTrafficImportRecords: %v", err)
+			}
+			if len(sources) != 1 || sources[0].SourceKey != tt.wantKey || sources[0].Username != tt.wantUser {
+				t.Fatalf("sources = %+v, want key %s user %s", sources, tt.wantKey, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestCSVSafeCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"airport", "airport"},
+		{"", ""},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1", "'+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"'quoted", "'quoted"},
+	}
+	for _, tt := range tests {
+		got := csvSafeCell(tt.value)
+		if got != tt.want {
+			t.Errorf("csvSafeCell(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if back := csvUnescapeCell(got); back != tt.value {
+			t.Errorf("csvUnescapeCell(%q) = %q, want %q", got, back, tt.value)
+		}
+	}
+}
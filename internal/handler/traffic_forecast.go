@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 流量预测：基于最近 N 天的每日快照，用指数加权移动平均（EWMA）估算日均用量，
+// 推算账期结束时的用量及流量耗尽日期。外部订阅结合到期时间给出续费先后。
+
+const (
+	trafficForecastMethod = "ewma"
+
+	// 默认取最近 14 天的快照拟合日均用量
+	defaultTrafficForecastDays = 14
+	minTrafficForecastDays     = 2
+	maxTrafficForecastDays     = 90
+
+	trafficForecastTypeTotal = "total"
+)
+
+type trafficForecast struct {
+	Method  string                 `json:"method"`
+	Days    int                    `json:"days"`
+	Total   trafficForecastEntry   `json:"total"`
+	Sources []trafficForecastEntry `json:"sources"`
+}
+
+type trafficForecastEntry struct {
+	Type     string `json:"type"` // total、probe_server 或 external_subscription
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	Username string `json:"username,omitempty"`
+
+	LimitGB      float64 `json:"limit_gb"`
+	UsedGB       float64 `json:"used_gb"`
+	DailyUsageGB float64 `json:"daily_usage_gb"`
+	SampleDays   int     `json:"sample_days"`             // 参与拟合的快照间隔数，0 表示历史不足无法预测
+	PartialCycle bool    `json:"partial_cycle,omitempty"` // 探针账期从首次观测开始统计，已用流量偏少
+
+	CycleEnd                 *time.Time `json:"cycle_end,omitempty"`
+	ProjectedUsedGB          float64    `json:"projected_used_gb"`
+	ProjectedUsagePercentage float64    `json:"projected_usage_percentage"`
+
+	// 仅在账期结束前会耗尽时返回
+	ExhaustsAt         *time.Time `json:"exhausts_at,omitempty"`
+	DaysUntilExhausted *int       `json:"days_until_exhausted,omitempty"`
+
+	ExpireAt *time.Time `json:"expire_at,omitempty"`
+	// RenewBy 为耗尽日期与到期时间中较早者，sources 按此排序
+	RenewBy *time.Time `json:"renew_by,omitempty"`
+}
+
+// buildTrafficForecast 预测总流量及各探针服务器、外部订阅的用量。
+// 管理员的总流量使用全局快照，普通用户使用其自身的用户快照。
+func (h *TrafficSummaryHandler) buildTrafficForecast(ctx context.Context, username string, days int, now time.Time, usages []probeServerUsage, subs []storage.ExternalSubscription, totalLimit, totalUsed int64) *trafficForecast {
+	if h.repo == nil {
+		return nil
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -days)
+
+	isAdmin := true
+	if username != "" {
+		user, err := h.repo.GetUser(ctx, username)
+		if err != nil {
+			logger.Warn("[流量预测] 获取用户失败", "user", username, "error", err)
+			return nil
+		}
+		isAdmin = user.Role == storage.RoleAdmin
+	}
+
+	records, err := h.repo.ListTrafficSourceRecords(ctx, storage.TrafficSourceFilter{From: from, To: today})
+	if err != nil {
+		logger.Warn("[流量预测] 加载分来源快照失败", "error", err)
+	}
+	sourceSnapshots := make(map[string][]trafficSnapshot)
+	for _, record := range records {
+		key := record.SourceType + "|" + record.SourceKey
+		sourceSnapshots[key] = append(sourceSnapshots[key], trafficSnapshot{
+			Date:      record.Date,
+			Limit:     record.TotalLimit,
+			Used:      record.TotalUsed,
+			Remaining: record.TotalRemaining,
+		})
+	}
+
+	var totalSnapshots []trafficSnapshot
+	if isAdmin {
+		globalRecords, err := h.repo.ListTrafficRecordsBetween(ctx, from, today)
+		if err != nil {
+			logger.Warn("[流量预测] 加载全局快照失败", "error", err)
+		}
+		for _, record := range globalRecords {
+			totalSnapshots = append(totalSnapshots, trafficSnapshot{Date: record.Date, Limit: record.TotalLimit, Used: record.TotalUsed, Remaining: record.TotalRemaining})
+		}
+	} else {
+		totalSnapshots = sourceSnapshots[storage.TrafficSourceUser+"|"+username]
+	}
+
+	monthEnd := nextMonthStart(now)
+
+	totalCycleEnd := monthEnd
+	if reset := earliestProbeReset(usages); reset != nil {
+		totalCycleEnd = *reset
+	}
+
+	forecast := &trafficForecast{
+		Method: trafficForecastMethod,
+		Days:   days,
+		Total: forecastTrafficEntry(trafficForecastEntry{
+			Type: trafficForecastTypeTotal,
+			Key:  trafficForecastTypeTotal,
+			Name: "总流量",
+		}, totalSnapshots, totalLimit, totalUsed, now, totalCycleEnd),
+		Sources: make([]trafficForecastEntry, 0, len(usages)+len(subs)),
+	}
+
+	for _, usage := range usages {
+		cycleEnd := monthEnd
+		if usage.NextReset != nil {
+			cycleEnd = *usage.NextReset
+		}
+		binding := usage.Binding()
+		entry := forecastTrafficEntry(trafficForecastEntry{
+			Type: storage.TrafficSourceProbeServer,
+			Key:  binding,
+			Name: binding,
+		}, sourceSnapshots[storage.TrafficSourceProbeServer+"|"+binding], usage.Limit, usage.Used, now, cycleEnd)
+		entry.PartialCycle = usage.PartialCycle
+		entry.RenewBy = entry.ExhaustsAt
+		forecast.Sources = append(forecast.Sources, entry)
+	}
+
+	for _, sub := range subs {
+		// 机场流量通常按月重置，到期早于月末时以到期时间为准
+		cycleEnd := monthEnd
+		if sub.Expire != nil && sub.Expire.Before(cycleEnd) {
+			cycleEnd = *sub.Expire
+		}
+		key := formatExternalSubscriptionSourceKey(sub.ID)
+		entry := forecastTrafficEntry(trafficForecastEntry{
+			Type:     storage.TrafficSourceExternalSubscription,
+			Key:      key,
+			Name:     sub.Name,
+			Username: sub.Username,
+			ExpireAt: sub.Expire,
+		}, sourceSnapshots[storage.TrafficSourceExternalSubscription+"|"+key], sub.Total, sub.Upload+sub.Download, now, cycleEnd)
+		entry.RenewBy = earlierTime(entry.ExhaustsAt, sub.Expire)
+		forecast.Sources = append(forecast.Sources, entry)
+	}
+
+	// 最先需要续费的排在前面，其余按预测用量占比从高到低
+	sort.SliceStable(forecast.Sources, func(i, j int) bool {
+		a, b := forecast.Sources[i], forecast.Sources[j]
+		switch {
+		case a.RenewBy != nil && b.RenewBy != nil:
+			return a.RenewBy.Before(*b.RenewBy)
+		case a.RenewBy != nil || b.RenewBy != nil:
+			return a.RenewBy != nil
+		default:
+			return a.ProjectedUsagePercentage > b.ProjectedUsagePercentage
+		}
+	})
+
+	return forecast
+}
+
+// forecastTrafficEntry 用历史快照拟合日均用量，结合当前实时用量填充预测字段。
+// 快照按采集计划每天一次，间隔均为整天；实时用量的采集时刻不固定，与当天零点
+// 之间的间隔会被误算为整天，因此不参与拟合，只作为预测的起点。
+func forecastTrafficEntry(entry trafficForecastEntry, snapshots []trafficSnapshot, limit, used int64, now, cycleEnd time.Time) trafficForecastEntry {
+	daily, samples := ewmaDailyUsage(snapshots)
+
+	entry.LimitGB = roundUpTwoDecimals(bytesToGigabytes(limit))
+	entry.UsedGB = roundUpTwoDecimals(bytesToGigabytes(used))
+	entry.DailyUsageGB = roundUpTwoDecimals(bytesToGigabytes(int64(daily)))
+	entry.SampleDays = samples
+
+	end := cycleEnd
+	entry.CycleEnd = &end
+
+	remainingDays := cycleEnd.Sub(now).Hours() / 24
+	if remainingDays < 0 {
+		remainingDays = 0
+	}
+	projected := used + int64(daily*remainingDays)
+	entry.ProjectedUsedGB = roundUpTwoDecimals(bytesToGigabytes(projected))
+	entry.ProjectedUsagePercentage = roundUpTwoDecimals(usagePercentage(projected, limit))
+
+	if limit <= 0 {
+		return entry
+	}
+
+	remaining := limit - used
+	var untilExhausted float64
+	switch {
+	case remaining <= 0:
+		untilExhausted = 0
+	case daily > 0:
+		untilExhausted = float64(remaining) / daily
+	default:
+		return entry
+	}
+
+	exhaustsAt := now.Add(time.Duration(untilExhausted * float64(24*time.Hour)))
+	if !exhaustsAt.Before(cycleEnd) {
+		return entry
+	}
+	daysLeft := int(math.Floor(untilExhausted))
+	entry.ExhaustsAt = &exhaustsAt
+	entry.DaysUntilExhausted = &daysLeft
+	return entry
+}
+
+// ewmaDailyUsage 按日期排序的累计快照计算每日用量的指数加权移动平均（字节/天），
+// 返回参与计算的间隔数。累计值下降视为账期重置，与历史汇总的处理一致。
+func ewmaDailyUsage(snapshots []trafficSnapshot) (float64, int) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Date.Before(snapshots[j].Date)
+	})
+
+	rates := make([]float64, 0, len(snapshots))
+	for i := 1; i < len(snapshots); i++ {
+		prev, cur := snapshots[i-1], snapshots[i]
+		gap := cur.Date.Sub(prev.Date).Hours() / 24
+		if gap <= 0 {
+			continue
+		}
+		delta := cur.Used
+		if cur.Used >= prev.Used {
+			delta = cur.Used - prev.Used
+		}
+		if delta < 0 {
+			delta = 0
+		}
+		rates = append(rates, float64(delta)/gap)
+	}
+	if len(rates) == 0 {
+		return 0, 0
+	}
+
+	alpha := 2 / (float64(len(rates)) + 1)
+	average := rates[0]
+	for _, rate := range rates[1:] {
+		average = alpha*rate + (1-alpha)*average
+	}
+	return average, len(rates)
+}
+
+// nextMonthStart 返回下个自然月的第一天，作为未设置重置日时的账期结束
+func nextMonthStart(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+}
+
+func earlierTime(a, b *time.Time) *time.Time {
+	switch {
+	case a == nil:
+		return b
+	case b == nil || a.Before(*b):
+		return a
+	default:
+		return b
+	}
+}
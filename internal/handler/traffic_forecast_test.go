@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEWMADailyUsage(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 5, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name        string
+		snapshots   []trafficSnapshot
+		wantDaily   float64
+		wantSamples int
+	}{
+		{"no snapshots", nil, 0, 0},
+		{"single snapshot", []trafficSnapshot{{Date: day(1), Used: 100}}, 0, 0},
+		{
+			name:        "steady usage",
+			snapshots:   []trafficSnapshot{{Date: day(1), Used: 0}, {Date: day(2), Used: 10}, {Date: day(3), Used: 20}},
+			wantDaily:   10,
+			wantSamples: 2,
+		},
+		{
+			name:        "unsorted input",
+			snapshots:   []trafficSnapshot{{Date: day(3), Used: 20}, {Date: day(1), Used: 0}, {Date: day(2), Used: 10}},
+			wantDaily:   10,
+			wantSamples: 2,
+		},
+		{
+			name:        "missing day spreads delta over the gap",
+			snapshots:   []trafficSnapshot{{Date: day(1), Used: 0}, {Date: day(3), Used: 40}},
+			wantDaily:   20,
+			wantSamples: 1,
+		},
+		{
+			name:        "same day snapshots skipped",
+			snapshots:   []trafficSnapshot{{Date: day(1), Used: 0}, {Date: day(1), Used: 5}, {Date: day(2), Used: 15}},
+			wantDaily:   10,
+			wantSamples: 1,
+		},
+		{
+			// 速率 100、50、20，alpha = 0.5：100 → 75 → 47.5
+			name:        "drop treated as cycle reset",
+			snapshots:   []trafficSnapshot{{Date: day(1), Used: 0}, {Date: day(2), Used: 100}, {Date: day(3), Used: 150}, {Date: day(4), Used: 20}},
+			wantDaily:   47.5,
+			wantSamples: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daily, samples := ewmaDailyUsage(tt.snapshots)
+			if math.Abs(daily-tt.wantDaily) > 1e-9 || samples != tt.wantSamples {
+				t.Fatalf("ewmaDailyUsage() = (%v, %d), want (%v, %d)", daily, samples, tt.wantDaily, tt.wantSamples)
+			}
+		})
+	}
+}
+
+func TestForecastTrafficEntry(t *testing.T) {
+	const gb = int64(bytesPerGigabyte)
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	cycleEnd := now.AddDate(0, 0, 10)
+	// 每天 1 GB
+	history := []trafficSnapshot{
+		{Date: time.Date(2026, 5, 7, 0, 0, 0, 0, time.UTC), Used: 7 * gb},
+		{Date: time.Date(2026, 5, 8, 0, 0, 0, 0, time.UTC), Used: 8 * gb},
+		{Date: time.Date(2026, 5, 9, 0, 0, 0, 0, time.UTC), Used: 9 * gb},
+	}
+
+	tests := []struct {
+		name          string
+		snapshots     []trafficSnapshot
+		limit, used   int64
+		cycleEnd      time.Time
+		wantDaily     float64
+		wantSamples   int
+		wantProjected float64
+		wantPercent   float64
+		wantExhausts  *time.Time
+		wantDaysLeft  int
+	}{
+		{
+			// 实时用量比昨天快照多 1 GB，但不参与拟合
+			name:      "projection within limit",
+			snapshots: history, limit: 100 * gb, used: 10 * gb, cycleEnd: cycleEnd,
+			wantDaily: 1, wantSamples: 2, wantProjected: 20, wantPercent: 20,
+		},
+		{
+			name:      "exhausts before cycle end",
+			snapshots: history, limit: 15 * gb, used: 10 * gb, cycleEnd: cycleEnd,
+			wantDaily: 1, wantSamples: 2, wantProjected: 20, wantPercent: 133.34,
+			wantExhausts: ptrTime(now.AddDate(0, 0, 5)), wantDaysLeft: 5,
+		},
+		{
+			name:      "exhausts after cycle end",
+			snapshots: history, limit: 25 * gb, used: 10 * gb, cycleEnd: cycleEnd,
+			wantDaily: 1, wantSamples: 2, wantProjected: 20, wantPercent: 80,
+		},
+		{
+			name:      "already exhausted",
+			snapshots: history, limit: 10 * gb, used: 12 * gb, cycleEnd: cycleEnd,
+			wantDaily: 1, wantSamples: 2, wantProjected: 22, wantPercent: 220.01, // 百分比向上取整
+			wantExhausts: ptrTime(now), wantDaysLeft: 0,
+		},
+		{
+			name:      "unlimited",
+			snapshots: history, limit: 0, used: 10 * gb, cycleEnd: cycleEnd,
+			wantDaily: 1, wantSamples: 2, wantProjected: 20, wantPercent: 0,
+		},
+		{
+			name:      "no history",
+			snapshots: nil, limit: 100 * gb, used: 10 * gb, cycleEnd: cycleEnd,
+			wantDaily: 0, wantSamples: 0, wantProjected: 10, wantPercent: 10,
+		},
+		{
+			name:      "cycle already ended",
+			snapshots: history, limit: 100 * gb, used: 10 * gb, cycleEnd: now.Add(-time.Hour),
+			wantDaily: 1, wantSamples: 2, wantProjected: 10, wantPercent: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := forecastTrafficEntry(trafficForecastEntry{}, tt.snapshots, tt.limit, tt.used, now, tt.cycleEnd)
+			if got.DailyUsageGB != tt.wantDaily || got.SampleDays != tt.wantSamples {
+				t.Errorf("daily = (%v, %d), want (%v, %d)", got.DailyUsageGB, got.SampleDays, tt.wantDaily, tt.wantSamples)
+			}
+			if got.ProjectedUsedGB != tt.wantProjected || got.ProjectedUsagePercentage != tt.wantPercent {
+				t.Errorf("projected = (%v GB, %v%%), want (%v GB, %v%%)", got.ProjectedUsedGB, got.ProjectedUsagePercentage, tt.wantProjected, tt.wantPercent)
+			}
+			switch {
+			case tt.wantExhausts == nil:
+				if got.ExhaustsAt != nil || got.DaysUntilExhausted != nil {
+					t.Errorf("exhausts at %v, want none", got.ExhaustsAt)
+				}
+			case got.ExhaustsAt == nil || got.DaysUntilExhausted == nil:
+				t.Errorf("exhausts at none, want %v", tt.wantExhausts)
+			default:
+				if !got.ExhaustsAt.Equal(*tt.wantExhausts) || *got.DaysUntilExhausted != tt.wantDaysLeft {
+					t.Errorf("exhausts at %v (%d days), want %v (%d days)", got.ExhaustsAt, *got.DaysUntilExhausted, tt.wantExhausts, tt.wantDaysLeft)
+				}
+			}
+		})
+	}
+}
+
+func ptrTime(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,475 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"miaomiaowu/internal/auth"
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 流量历史：按探针服务器、外部订阅和用户拆分的每日快照，支持任意日期范围及按周/按月汇总
+
+const (
+	trafficGranularityDay   = "day"
+	trafficGranularityWeek  = "week"
+	trafficGranularityMonth = "month"
+
+	// 汇总范围：total 为全局快照，其余与 storage.TrafficSource* 一致
+	trafficScopeTotal = "total"
+
+	// 默认查询最近 30 天
+	defaultTrafficHistoryDays = 30
+	// 计算区间首日用量时向前查找基准快照的天数
+	trafficHistoryBaselineDays = 31
+)
+
+type trafficHistoryPoint struct {
+	Period      string  `json:"period"`
+	UsedGB      float64 `json:"used_gb"`
+	LimitGB     float64 `json:"limit_gb"`
+	RemainingGB float64 `json:"remaining_gb"`
+}
+
+type trafficHistorySeries struct {
+	Key      string                `json:"key"`
+	Name     string                `json:"name"`
+	Username string                `json:"username,omitempty"`
+	UsedGB   float64               `json:"used_gb"`
+	Points   []trafficHistoryPoint `json:"points"`
+}
+
+type trafficHistoryResponse struct {
+	Scope       string                 `json:"scope"`
+	Granularity string                 `json:"granularity"`
+	From        string                 `json:"from"`
+	To          string                 `json:"to"`
+	Series      []trafficHistorySeries `json:"series"`
+}
+
+// trafficSnapshot 某一来源某一天的累计流量
+type trafficSnapshot struct {
+	Date      time.Time
+	Limit     int64
+	Used      int64
+	Remaining int64
+}
+
+type trafficHistoryHandler struct {
+	repo *storage.TrafficRepository
+}
+
+// NewTrafficHistoryHandler serves traffic history with per-source breakdowns.
+// Admins may query any scope; other users only see their own usage and external subscriptions.
+func NewTrafficHistoryHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("traffic history handler requires repository")
+	}
+
+	return &trafficHistoryHandler{repo: repo}
+}
+
+func (h *trafficHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	username := auth.UsernameFromContext(r.Context())
+	if username == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("用户未认证"))
+		return
+	}
+
+	user, err := h.repo.GetUser(r.Context(), username)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	isAdmin := user.Role == storage.RoleAdmin
+
+	query := r.URL.Query()
+
+	granularity := strings.ToLower(strings.TrimSpace(query.Get("granularity")))
+	switch granularity {
+	case "":
+		granularity = trafficGranularityDay
+	case trafficGranularityDay, trafficGranularityWeek, trafficGranularityMonth:
+	default:
+		writeBadRequest(w, "granularity 仅支持 day、week、month")
+		return
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if raw := strings.TrimSpace(query.Get("to")); raw != "" {
+		if to, err = time.Parse("2006-01-02", raw); err != nil {
+			writeBadRequest(w, "to 日期格式应为 YYYY-MM-DD")
+			return
+		}
+	}
+	from := to.AddDate(0, 0, -(defaultTrafficHistoryDays - 1))
+	if raw := strings.TrimSpace(query.Get("from")); raw != "" {
+		if from, err = time.Parse("2006-01-02", raw); err != nil {
+			writeBadRequest(w, "from 日期格式应为 YYYY-MM-DD")
+			return
+		}
+	}
+	if from.After(to) {
+		writeBadRequest(w, "from 不能晚于 to")
+		return
+	}
+
+	scope := strings.ToLower(strings.TrimSpace(query.Get("scope")))
+	if scope == "" {
+		scope = trafficScopeTotal
+	}
+	key := strings.TrimSpace(query.Get("key"))
+
+	filter := storage.TrafficSourceFilter{
+		SourceType: scope,
+		SourceKey:  key,
+		From:       from.AddDate(0, 0, -trafficHistoryBaselineDays),
+		To:         to,
+	}
+
+	if !isAdmin {
+		// 普通用户的全局流量即其自身的用量
+		switch scope {
+		case trafficScopeTotal, storage.TrafficSourceUser:
+			scope = storage.TrafficSourceUser
+			filter.SourceType = scope
+			filter.SourceKey = username
+		case storage.TrafficSourceExternalSubscription:
+			filter.Username = username
+		default:
+			writeError(w, http.StatusForbidden, errors.New("仅管理员可查看该流量明细"))
+			return
+		}
+	}
+
+	var series []trafficHistorySeries
+	switch {
+	case scope == trafficScopeTotal:
+		records, err := h.repo.ListTrafficRecordsBetween(r.Context(), filter.From, to)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		snapshots := make([]trafficSnapshot, 0, len(records))
+		for _, record := range records {
+			snapshots = append(snapshots, trafficSnapshot{Date: record.Date, Limit: record.TotalLimit, Used: record.TotalUsed, Remaining: record.TotalRemaining})
+		}
+		series = append(series, buildTrafficHistorySeries(trafficScopeTotal, "总流量", "", snapshots, from, granularity))
+	case storage.IsValidTrafficSource(scope):
+		records, err := h.repo.ListTrafficSourceRecords(r.Context(), filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		series = groupTrafficSourceSeries(records, from, granularity)
+	default:
+		writeBadRequest(w, "不支持的 scope")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, trafficHistoryResponse{
+		Scope:       scope,
+		Granularity: granularity,
+		From:        from.Format("2006-01-02"),
+		To:          to.Format("2006-01-02"),
+		Series:      series,
+	})
+}
+
+// groupTrafficSourceSeries 按来源拆分快照，用量多的排在前面
+func groupTrafficSourceSeries(records []storage.TrafficSourceRecord, from time.Time, granularity string) []trafficHistorySeries {
+	type group struct {
+		name      string
+		username  string
+		snapshots []trafficSnapshot
+	}
+
+	groups := make(map[string]*group)
+	var keys []string
+	for _, record := range records {
+		g, ok := groups[record.SourceKey]
+		if !ok {
+			g = &group{}
+			groups[record.SourceKey] = g
+			keys = append(keys, record.SourceKey)
+		}
+		// 名称以最新快照为准
+		g.name = record.SourceName
+		g.username = record.Username
+		g.snapshots = append(g.snapshots, trafficSnapshot{Date: record.Date, Limit: record.TotalLimit, Used: record.TotalUsed, Remaining: record.TotalRemaining})
+	}
+
+	series := make([]trafficHistorySeries, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		s := buildTrafficHistorySeries(key, g.name, g.username, g.snapshots, from, granularity)
+		if len(s.Points) == 0 {
+			continue
+		}
+		series = append(series, s)
+	}
+
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].UsedGB > series[j].UsedGB
+	})
+	return series
+}
+
+func buildTrafficHistorySeries(key, name, username string, snapshots []trafficSnapshot, from time.Time, granularity string) trafficHistorySeries {
+	points, used := rollupTrafficSnapshots(snapshots, from, granularity)
+	return trafficHistorySeries{
+		Key:      key,
+		Name:     name,
+		Username: username,
+		UsedGB:   roundUpTwoDecimals(bytesToGigabytes(used)),
+		Points:   points,
+	}
+}
+
+// rollupTrafficSnapshots 将累计快照转换为各周期的用量。
+// 快照在 from 之前的部分只作为首日的基准；累计值下降视为账期重置，当日用量即为新的累计值。
+func rollupTrafficSnapshots(snapshots []trafficSnapshot, from time.Time, granularity string) ([]trafficHistoryPoint, int64) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Date.Before(snapshots[j].Date)
+	})
+
+	var (
+		points     []trafficHistoryPoint
+		total      int64
+		prevUsed   int64
+		hasPrev    bool
+		current    string
+		periodUsed int64
+		last       trafficSnapshot
+	)
+
+	flush := func() {
+		if current == "" {
+			return
+		}
+		points = append(points, trafficHistoryPoint{
+			Period:      current,
+			UsedGB:      roundUpTwoDecimals(bytesToGigabytes(periodUsed)),
+			LimitGB:     roundUpTwoDecimals(bytesToGigabytes(last.Limit)),
+			RemainingGB: roundUpTwoDecimals(bytesToGigabytes(last.Remaining)),
+		})
+	}
+
+	for _, snapshot := range snapshots {
+		delta := snapshot.Used
+		if hasPrev && snapshot.Used >= prevUsed {
+			delta = snapshot.Used - prevUsed
+		}
+		if delta < 0 {
+			delta = 0
+		}
+		prevUsed = snapshot.Used
+		hasPrev = true
+
+		if snapshot.Date.Before(from) {
+			continue
+		}
+
+		period := trafficPeriod(snapshot.Date, granularity)
+		if period != current {
+			flush()
+			current = period
+			periodUsed = 0
+		}
+		periodUsed += delta
+		total += delta
+		last = snapshot
+	}
+	flush()
+
+	return points, total
+}
+
+// trafficPeriod 返回日期所属周期：日期本身、所在周的周一或所在月份
+func trafficPeriod(date time.Time, granularity string) string {
+	switch granularity {
+	case trafficGranularityWeek:
+		offset := (int(date.Weekday()) + 6) % 7
+		return date.AddDate(0, 0, -offset).Format("2006-01-02")
+	case trafficGranularityMonth:
+		return date.Format("2006-01")
+	default:
+		return date.Format("2006-01-02")
+	}
+}
+
+// recordSourceSnapshots 保存探针服务器、外部订阅及各用户的每日流量快照
+func (h *TrafficSummaryHandler) recordSourceSnapshots(ctx context.Context, date time.Time, usages []probeServerUsage, subs []storage.ExternalSubscription) error {
+	if h.repo == nil {
+		return nil
+	}
+
+	records := make([]storage.TrafficSourceRecord, 0, len(usages)+len(subs))
+	for _, usage := range usages {
+		remaining := usage.Remaining
+		if remaining < 0 {
+			remaining = 0
+		}
+		records = append(records, storage.TrafficSourceRecord{
+			SourceType:     storage.TrafficSourceProbeServer,
+			SourceKey:      usage.Binding(),
+			SourceName:     usage.Binding(),
+			TotalLimit:     usage.Limit,
+			TotalUsed:      usage.Used,
+			TotalRemaining: remaining,
+		})
+	}
+	for _, sub := range subs {
+		used := sub.Upload + sub.Download
+		remaining := sub.Total - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		records = append(records, storage.TrafficSourceRecord{
+			SourceType:     storage.TrafficSourceExternalSubscription,
+			SourceKey:      formatExternalSubscriptionSourceKey(sub.ID),
+			SourceName:     sub.Name,
+			Username:       sub.Username,
+			TotalLimit:     sub.Total,
+			TotalUsed:      used,
+			TotalRemaining: remaining,
+		})
+	}
+
+	users, err := h.repo.ListUsers(ctx, 1000)
+	if err != nil {
+		logger.Warn("[流量记录] 获取用户列表失败，跳过用户流量快照", "error", err)
+	}
+	for _, user := range users {
+		record, ok := h.userTrafficSnapshot(ctx, user.Username, usages, subs)
+		if ok {
+			records = append(records, record)
+		}
+	}
+
+	return h.repo.RecordTrafficSources(ctx, date, records)
+}
+
+// userTrafficSnapshot 根据用户订阅文件中使用的节点，汇总其绑定的探针服务器和来源外部订阅的流量
+func (h *TrafficSummaryHandler) userTrafficSnapshot(ctx context.Context, username string, usages []probeServerUsage, subs []storage.ExternalSubscription) (storage.TrafficSourceRecord, bool) {
+	files, err := h.repo.GetUserSubscriptions(ctx, username)
+	if err != nil {
+		logger.Warn("[流量记录] 获取用户订阅失败", "user", username, "error", err)
+		return storage.TrafficSourceRecord{}, false
+	}
+	if len(files) == 0 {
+		// 未分配订阅时与流量汇总一致，按全部订阅文件统计
+		if files, err = h.repo.ListSubscribeFiles(ctx); err != nil {
+			logger.Warn("[流量记录] 获取订阅文件列表失败", "error", err)
+			return storage.TrafficSourceRecord{}, false
+		}
+	}
+
+	usedNodeNames := make(map[string]bool)
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join("subscribes", file.Filename))
+		if err != nil {
+			continue
+		}
+		for name := range subscribeFileProxyNames(data) {
+			usedNodeNames[name] = true
+		}
+	}
+	if len(usedNodeNames) == 0 {
+		return storage.TrafficSourceRecord{}, false
+	}
+
+	nodes, err := h.repo.ListNodes(ctx, username)
+	if err != nil {
+		logger.Warn("[流量记录] 获取用户节点失败", "user", username, "error", err)
+		return storage.TrafficSourceRecord{}, false
+	}
+
+	bindings := make(map[string]struct{})
+	tags := make(map[string]bool)
+	for _, node := range nodes {
+		if !usedNodeNames[node.NodeName] {
+			continue
+		}
+		if binding := strings.TrimSpace(node.ProbeServer); binding != "" {
+			bindings[binding] = struct{}{}
+		}
+		if node.Tag != "" && node.Tag != "手动输入" {
+			tags[node.Tag] = true
+		}
+	}
+
+	var limit, used int64
+	matched := false
+	for _, usage := range usages {
+		_, scoped := bindings[usage.Binding()]
+		_, legacy := bindings[usage.Server.Name]
+		if scoped || legacy {
+			limit += usage.Limit
+			used += usage.Used
+			matched = true
+		}
+	}
+	for _, sub := range subs {
+		if sub.Username == username && tags[sub.Name] {
+			limit += sub.Total
+			used += sub.Upload + sub.Download
+			matched = true
+		}
+	}
+	if !matched {
+		return storage.TrafficSourceRecord{}, false
+	}
+
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return storage.TrafficSourceRecord{
+		SourceType:     storage.TrafficSourceUser,
+		SourceKey:      username,
+		SourceName:     username,
+		Username:       username,
+		TotalLimit:     limit,
+		TotalUsed:      used,
+		TotalRemaining: remaining,
+	}, true
+}
+
+// subscribeFileProxyNames 返回订阅文件 proxies 中的节点名称
+func subscribeFileProxyNames(data []byte) map[string]bool {
+	names := make(map[string]bool)
+
+	var content map[string]any
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return names
+	}
+	proxies, _ := content["proxies"].([]any)
+	for _, proxy := range proxies {
+		if proxyMap, ok := proxy.(map[string]any); ok {
+			if name, ok := proxyMap["name"].(string); ok && name != "" {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+func formatExternalSubscriptionSourceKey(id int64) string {
+	return "sub:" + strconv.FormatInt(id, 10)
+}
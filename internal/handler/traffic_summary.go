@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,17 +8,16 @@ import (
 	"miaomiaowu/internal/logger"
 	"math"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
-
 	"miaomiaowu/internal/auth"
+	"miaomiaowu/internal/probe"
 	"miaomiaowu/internal/storage"
 )
 
@@ -31,8 +29,9 @@ type TrafficSummaryHandler struct {
 }
 
 type trafficSummaryResponse struct {
-	Metrics trafficSummaryMetrics `json:"metrics"`
-	History []trafficDailyUsage   `json:"history"`
+	Metrics  trafficSummaryMetrics `json:"metrics"`
+	History  []trafficDailyUsage   `json:"history"`
+	Forecast *trafficForecast      `json:"forecast,omitempty"`
 }
 
 type trafficSummaryMetrics struct {
@@ -47,18 +46,6 @@ type trafficDailyUsage struct {
 	UsedGB float64 `json:"used_gb"`
 }
 
-type batchTrafficResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Data    map[string]struct {
-		Monthly struct {
-			Limit     json.Number `json:"limit"`
-			Remaining json.Number `json:"remaining"`
-			Used      json.Number `json:"used"`
-		} `json:"monthly"`
-	} `json:"data"`
-}
-
 func NewTrafficSummaryHandler(repo *storage.TrafficRepository) *TrafficSummaryHandler {
 	if repo == nil {
 		panic("traffic summary handler requires repository")
@@ -85,11 +72,22 @@ func (h *TrafficSummaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	ctx := r.Context()
 	username := auth.UsernameFromContext(ctx)
 
+	forecastDays := defaultTrafficForecastDays
+	if raw := strings.TrimSpace(r.URL.Query().Get("forecast_days")); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days < minTrafficForecastDays || days > maxTrafficForecastDays {
+			writeBadRequest(w, fmt.Sprintf("forecast_days 应为 %d-%d 之间的整数", minTrafficForecastDays, maxTrafficForecastDays))
+			return
+		}
+		forecastDays = days
+	}
+
 	var totalLimit, totalRemaining, totalUsed int64
-	var probeErr error
 
-	totalLimit, totalRemaining, totalUsed, probeErr = h.fetchTotals(ctx, username, nil)
-	if probeErr != nil {
+	usages, probeErr := h.fetchServerUsages(ctx, username, nil)
+	if probeErr == nil {
+		totalLimit, totalRemaining, totalUsed = sumProbeServerUsages(usages)
+	} else {
 		// Log the error but continue to try external subscription traffic
 		if errors.Is(probeErr, storage.ErrProbeConfigNotFound) {
 			logger.Info("[Traffic] Probe not configured, will use external subscription traffic only")
@@ -97,12 +95,14 @@ func (h *TrafficSummaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 			logger.Info("[流量] 获取探针流量失败", "error", probeErr)
 		}
 		// Reset values in case of error
-		totalLimit, totalRemaining, totalUsed = 0, 0, 0
+		usages = nil
 	}
 
 	// Add external subscription traffic if sync_traffic is enabled
+	var externalSubs []storage.ExternalSubscription
 	if username != "" {
-		externalLimit, externalUsed := h.fetchExternalSubscriptionTraffic(ctx, username)
+		var externalLimit, externalUsed int64
+		externalLimit, externalUsed, externalSubs = h.fetchExternalSubscriptionTraffic(ctx, username)
 		totalLimit += externalLimit
 		totalUsed += externalUsed
 		// Recalculate remaining
@@ -116,10 +116,7 @@ func (h *TrafficSummaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := h.recordSnapshot(ctx, totalLimit, totalUsed, totalRemaining); err != nil {
-		logger.Info("[流量] 记录快照失败", "error", err)
-	}
-
+	// 快照只由每日采集写入，查看汇总不会覆盖当天的计划快照
 	history, err := h.loadHistory(ctx, 30)
 	if err != nil {
 		logger.Info("[流量] 加载历史记录失败", "error", err)
@@ -133,8 +130,9 @@ func (h *TrafficSummaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	}
 
 	response := trafficSummaryResponse{
-		Metrics: metrics,
-		History: history,
+		Metrics:  metrics,
+		History:  history,
+		Forecast: h.buildTrafficForecast(ctx, username, forecastDays, time.Now(), usages, externalSubs, totalLimit, totalUsed),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -142,20 +140,21 @@ func (h *TrafficSummaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	_ = json.NewEncoder(w).Encode(response)
 }
 
-// RecordDailyUsage fetches the latest traffic summary and persists the snapshot.
-func (h *TrafficSummaryHandler) RecordDailyUsage(ctx context.Context) error {
+// RecordDailyUsageOn fetches the latest traffic summary and persists it as the snapshot of date's UTC day.
+func (h *TrafficSummaryHandler) RecordDailyUsageOn(ctx context.Context, date time.Time) error {
 	var totalLimit, totalRemaining, totalUsed int64
-	var probeErr error
 
-	totalLimit, totalRemaining, totalUsed, probeErr = h.fetchTotals(ctx, "", nil)
+	usages, probeErr := h.fetchServerUsages(ctx, "", nil)
 	if probeErr != nil {
 		if errors.Is(probeErr, storage.ErrProbeConfigNotFound) {
 			logger.Info("[流量记录] 探针未配置，仅使用外部订阅流量")
 		} else {
 			logger.Warn("[流量记录] 获取探针流量失败", "error", probeErr)
 		}
-		totalLimit, totalRemaining, totalUsed = 0, 0, 0
+		usages = nil
 	} else {
+		totalLimit, totalRemaining, totalUsed = sumProbeServerUsages(usages)
+
 		// Log fetched probe data
 		limitGB := roundUpTwoDecimals(bytesToGigabytes(totalLimit))
 		usedGB := roundUpTwoDecimals(bytesToGigabytes(totalUsed))
@@ -170,7 +169,7 @@ func (h *TrafficSummaryHandler) RecordDailyUsage(ctx context.Context) error {
 	}
 
 	// Sync and add external subscription traffic
-	externalLimit, externalUsed := h.syncAndFetchExternalSubscriptionTraffic(ctx)
+	externalLimit, externalUsed, externalSubs := h.syncAndFetchExternalSubscriptionTraffic(ctx)
 	if externalLimit > 0 || externalUsed > 0 {
 		totalLimit += externalLimit
 		totalUsed += externalUsed
@@ -201,49 +200,55 @@ func (h *TrafficSummaryHandler) RecordDailyUsage(ctx context.Context) error {
 		"remaining_gb", remainingGB,
 		"usage_percent", usagePercent)
 
-	if err := h.recordSnapshot(ctx, totalLimit, totalUsed, totalRemaining); err != nil {
+	if err := h.recordSnapshot(ctx, date, totalLimit, totalUsed, totalRemaining); err != nil {
 		logger.Error("[流量记录] 保存快照到数据库失败", "error", err)
 		return err
 	}
 
+	// 按探针服务器、外部订阅和用户拆分的快照失败不影响全局快照
+	if err := h.recordSourceSnapshots(ctx, date, usages, externalSubs); err != nil {
+		logger.Warn("[流量记录] 保存分来源快照失败", "error", err)
+	}
+
 	logger.Info("[流量记录] 快照已成功保存到数据库")
 	return nil
 }
 
 // syncAndFetchExternalSubscriptionTraffic syncs traffic info from external subscriptions when sync_traffic is enabled (system-level setting)
-// Returns totalLimit and totalUsed from non-expired subscriptions
-func (h *TrafficSummaryHandler) syncAndFetchExternalSubscriptionTraffic(ctx context.Context) (int64, int64) {
+// Returns totalLimit and totalUsed from non-expired subscriptions, along with those subscriptions
+func (h *TrafficSummaryHandler) syncAndFetchExternalSubscriptionTraffic(ctx context.Context) (int64, int64, []storage.ExternalSubscription) {
 	if h.repo == nil {
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	// Check if sync_traffic is enabled (system-level setting)
 	enabled, err := h.repo.IsSyncTrafficEnabled(ctx)
 	if err != nil {
 		logger.Warn("[流量记录] 检查sync_traffic设置失败", "error", err)
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	if !enabled {
 		logger.Info("[流量记录] sync_traffic未启用，跳过外部订阅同步")
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	// Get all external subscriptions from all users
 	subs, err := h.repo.ListAllExternalSubscriptions(ctx)
 	if err != nil {
 		logger.Warn("[流量记录] 获取外部订阅失败", "error", err)
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	if len(subs) == 0 {
 		logger.Info("[Traffic Record] No external subscriptions found")
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	logger.Info("[流量记录] 同步外部订阅", "count", len(subs))
 
 	var totalLimit, totalUsed int64
+	var active []storage.ExternalSubscription
 	now := time.Now()
 
 	for _, sub := range subs {
@@ -267,6 +272,7 @@ func (h *TrafficSummaryHandler) syncAndFetchExternalSubscriptionTraffic(ctx cont
 		}
 
 		// Add traffic from this subscription
+		active = append(active, updatedSub)
 		totalLimit += updatedSub.Total
 		totalUsed += updatedSub.Upload + updatedSub.Download
 
@@ -288,34 +294,23 @@ func (h *TrafficSummaryHandler) syncAndFetchExternalSubscriptionTraffic(ctx cont
 		"limit_gb", bytesToGigabytes(totalLimit),
 		"used_gb", bytesToGigabytes(totalUsed))
 
-	return totalLimit, totalUsed
+	return totalLimit, totalUsed, active
 }
 
 // fetchExternalSubscriptionTrafficInfo fetches traffic info from external subscription URL
 func (h *TrafficSummaryHandler) fetchExternalSubscriptionTrafficInfo(ctx context.Context, sub storage.ExternalSubscription) (storage.ExternalSubscription, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
-	if err != nil {
-		return sub, fmt.Errorf("create request: %w", err)
-	}
-
 	userAgent := sub.UserAgent
 	if userAgent == "" {
 		userAgent = "clash-meta/2.4.0"
 	}
-	req.Header.Set("User-Agent", userAgent)
 
-	resp, err := h.client.Do(req)
+	// 使用订阅自身的出站代理、超时和 TLS 设置
+	userInfo, err := fetchUpstreamUserInfo(ctx, h.client, &sub, userAgent)
 	if err != nil {
 		return sub, fmt.Errorf("fetch subscription: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return sub, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
 
 	// Parse subscription-userinfo header
-	userInfo := resp.Header.Get("subscription-userinfo")
 	if userInfo == "" {
 		return sub, nil // No traffic info available
 	}
@@ -337,9 +332,112 @@ func (h *TrafficSummaryHandler) fetchExternalSubscriptionTrafficInfo(ctx context
 	return sub, nil
 }
 
-func (h *TrafficSummaryHandler) fetchTotals(ctx context.Context, username string, allowedProbeServers map[string]struct{}) (int64, int64, int64, error) {
+// probeServerUsage 单个探针服务器的流量
+type probeServerUsage struct {
+	Probe     string
+	Server    storage.ProbeServer
+	Limit     int64
+	Used      int64
+	Remaining int64
+	NextReset *time.Time // 按账期计费时的下一次重置时间
+	// PartialCycle 为 true 表示本周期从首次观测开始统计，早于首次观测的流量未计入
+	PartialCycle bool
+}
+
+// Binding returns the node probe binding ("probe/server") naming this server.
+func (u probeServerUsage) Binding() string {
+	return storage.FormatProbeBinding(u.Probe, u.Server.Name)
+}
+
+func newProbeServerUsage(cfg storage.ProbeConfig, srv storage.ProbeServer, used int64) probeServerUsage {
+	return probeServerUsage{
+		Probe:     cfg.Name,
+		Server:    srv,
+		Limit:     srv.MonthlyTrafficBytes,
+		Used:      used,
+		Remaining: srv.MonthlyTrafficBytes - used,
+	}
+}
+
+// probeUsagesFromTraffic 按服务器配置的流量计算方式换算探针返回的原始流量
+func probeUsagesFromTraffic(cfg storage.ProbeConfig, traffic []probe.Traffic) []probeServerUsage {
+	observed := make(map[string]probe.Traffic, len(traffic))
+	for _, entry := range traffic {
+		observed[entry.ServerID] = entry
+	}
+
+	usages := make([]probeServerUsage, 0, len(cfg.Servers))
+	for _, srv := range cfg.Servers {
+		id := strings.TrimSpace(srv.ServerID)
+		if id == "" {
+			continue
+		}
+
+		entry, ok := observed[id]
+		if !ok {
+			logger.Info("[流量获取] 服务器未在探针数据中找到", "probe", cfg.Name, "server_id", id)
+			usages = append(usages, newProbeServerUsage(cfg, srv, 0))
+			continue
+		}
+
+		// 面板自行统计月流量时直接使用其结果
+		if entry.Monthly != nil {
+			logger.Info("[流量获取] 服务器流量",
+				"probe", cfg.Name,
+				"server_id", id,
+				"limit_gb", bytesToGigabytes(entry.Monthly.Limit),
+				"used_gb", bytesToGigabytes(entry.Monthly.Used),
+				"remaining_gb", bytesToGigabytes(entry.Monthly.Remaining))
+			usages = append(usages, probeServerUsage{Probe: cfg.Name, Server: srv, Limit: entry.Monthly.Limit, Used: entry.Monthly.Used, Remaining: entry.Monthly.Remaining})
+			continue
+		}
+
+		var used int64
+		switch strings.ToLower(strings.TrimSpace(srv.TrafficMethod)) {
+		case storage.TrafficMethodUp:
+			used = entry.Up
+		case storage.TrafficMethodDown:
+			used = entry.Down
+		default:
+			used = entry.Up + entry.Down
+		}
+		if used < 0 {
+			used = 0
+		}
+
+		logger.Info("[流量获取] 服务器流量",
+			"probe", cfg.Name,
+			"server_id", id,
+			"up_gb", bytesToGigabytes(entry.Up),
+			"down_gb", bytesToGigabytes(entry.Down),
+			"method", srv.TrafficMethod,
+			"used_gb", bytesToGigabytes(used),
+			"limit_gb", bytesToGigabytes(srv.MonthlyTrafficBytes))
+
+		usages = append(usages, newProbeServerUsage(cfg, srv, used))
+	}
+
+	return usages
+}
+
+// sumProbeServerUsages 汇总服务器流量，剩余流量不小于 0
+func sumProbeServerUsages(usages []probeServerUsage) (int64, int64, int64) {
+	var totalLimit, totalRemaining, totalUsed int64
+	for _, usage := range usages {
+		totalLimit += usage.Limit
+		totalRemaining += usage.Remaining
+		totalUsed += usage.Used
+	}
+	if totalRemaining < 0 {
+		totalRemaining = 0
+	}
+	return totalLimit, totalRemaining, totalUsed
+}
+
+// fetchServerUsages fetches the traffic of every probe server visible to the user, one entry per server.
+func (h *TrafficSummaryHandler) fetchServerUsages(ctx context.Context, username string, allowedProbeServers map[string]struct{}) ([]probeServerUsage, error) {
 	if h.repo == nil {
-		return 0, 0, 0, errors.New("traffic repository not configured")
+		return nil, errors.New("traffic repository not configured")
 	}
 
 	// Determine which probe servers to include
@@ -358,7 +456,7 @@ func (h *TrafficSummaryHandler) fetchTotals(ctx context.Context, username string
 		// If filter is provided but empty after trimming, return zero traffic
 		if len(probeFilter) == 0 {
 			logger.Info("[Traffic Fetch] Probe filter provided but no valid servers referenced, returning zero traffic")
-			return 0, 0, 0, nil
+			return nil, nil
 		}
 	} else if username != "" {
 		// No explicit filter provided, check if probe binding is enabled for this user
@@ -380,653 +478,138 @@ func (h *TrafficSummaryHandler) fetchTotals(ctx context.Context, username string
 					probeFilter = boundProbeServers
 				} else {
 					logger.Info("[Traffic Fetch] Probe binding enabled but no nodes have bound servers, returning zero traffic")
-					return 0, 0, 0, nil
+					return nil, nil
 				}
 			}
 		}
 	}
 
-	cfg, err := h.repo.GetProbeConfig(ctx)
+	configs, err := h.repo.ListProbeConfigs(ctx)
 	if err != nil {
-		return 0, 0, 0, err
-	}
-
-	if len(cfg.Servers) == 0 {
-		return 0, 0, 0, errors.New("no probe servers configured")
-	}
-
-	// Apply probe filter if one was determined
-	if probeFilter != nil {
-		filteredServers := make([]storage.ProbeServer, 0, len(cfg.Servers))
-		for _, srv := range cfg.Servers {
-			name := strings.TrimSpace(srv.Name)
-			if name == "" {
-				continue
-			}
-			if _, ok := probeFilter[name]; ok {
-				filteredServers = append(filteredServers, srv)
-			}
-		}
-
-		if len(filteredServers) == 0 {
-			logger.Info("[Traffic Fetch] Probe filter applied but no matching servers found, returning zero traffic")
-			return 0, 0, 0, nil
-		}
-
-		cfg.Servers = filteredServers
-		logger.Info("[流量获取] 根据绑定过滤探针服务器", "count", len(cfg.Servers))
-	}
-
-	serverIDs := make([]string, 0, len(cfg.Servers))
-	for _, srv := range cfg.Servers {
-		id := strings.TrimSpace(srv.ServerID)
-		if id == "" {
-			continue
-		}
-		serverIDs = append(serverIDs, id)
-	}
-
-	if len(serverIDs) == 0 {
-		return 0, 0, 0, errors.New("no server ids configured")
-	}
-
-	logger.Info("[流量获取] 探针信息",
-		"type", cfg.ProbeType,
-		"address", cfg.Address,
-		"server_count", len(cfg.Servers),
-		"server_ids", serverIDs)
-
-	switch cfg.ProbeType {
-	case storage.ProbeTypeNezha:
-		return h.fetchNezhaTotals(ctx, cfg)
-	case storage.ProbeTypeNezhaV0:
-		return h.fetchNezhaV0Totals(ctx, cfg)
-	case storage.ProbeTypeDstatus:
-		return h.fetchBatchSummary(ctx, cfg.Address, serverIDs)
-	case storage.ProbeTypeKomari:
-		return h.fetchKomariTotals(ctx, cfg)
-	default:
-		return 0, 0, 0, fmt.Errorf("unsupported probe type: %s", cfg.ProbeType)
-	}
-}
-
-func (h *TrafficSummaryHandler) fetchNezhaTotals(ctx context.Context, cfg storage.ProbeConfig) (int64, int64, int64, error) {
-	baseAddress := strings.TrimSpace(cfg.Address)
-	if baseAddress == "" {
-		return 0, 0, 0, errors.New("invalid probe address")
-	}
-
-	base, err := url.Parse(baseAddress)
-	if err != nil {
-		return 0, 0, 0, fmt.Errorf("invalid probe address: %w", err)
-	}
-
-	switch strings.ToLower(base.Scheme) {
-	case "", "http":
-		base.Scheme = "ws"
-	case "https":
-		base.Scheme = "wss"
-	case "ws", "wss":
-		// keep as is
-	default:
-		base.Scheme = "wss"
-	}
-
-	endpoint := &url.URL{Path: "/api/v1/ws/server"}
-	target := base.ResolveReference(endpoint)
-
-	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-
-	conn, resp, err := websocket.DefaultDialer.DialContext(dialCtx, target.String(), nil)
-	if err != nil {
-		if resp != nil {
-			resp.Body.Close()
-		}
-		return 0, 0, 0, fmt.Errorf("connect probe websocket: %w", err)
-	}
-	defer conn.Close()
-
-	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		return 0, 0, 0, fmt.Errorf("set websocket deadline: %w", err)
-	}
-
-	_, message, err := conn.ReadMessage()
-	if err != nil {
-		return 0, 0, 0, fmt.Errorf("read probe websocket: %w", err)
-	}
-	message = bytes.TrimSpace(message)
-	if len(message) == 0 {
-		return 0, 0, 0, errors.New("empty probe websocket payload")
-	}
-
-	type nezhaServer struct {
-		ID    json.Number `json:"id"`
-		State struct {
-			NetInTransfer  json.Number `json:"net_in_transfer"`
-			NetOutTransfer json.Number `json:"net_out_transfer"`
-		} `json:"state"`
-	}
-
-	type nezhaSnapshot struct {
-		Servers []nezhaServer `json:"servers"`
+		return nil, err
 	}
 
-	decoder := json.NewDecoder(bytes.NewReader(message))
-	decoder.UseNumber()
-
-	var snapshot nezhaSnapshot
-
-	if message[0] == '[' {
-		var frames []nezhaSnapshot
-		if err := decoder.Decode(&frames); err != nil {
-			return 0, 0, 0, fmt.Errorf("parse probe websocket payload: %w", err)
-		}
-		if len(frames) == 0 {
-			return 0, 0, 0, errors.New("probe websocket payload missing frames")
-		}
-		snapshot = frames[len(frames)-1]
-	} else {
-		if err := decoder.Decode(&snapshot); err != nil {
-			return 0, 0, 0, fmt.Errorf("parse probe websocket payload: %w", err)
-		}
+	if len(configs) == 0 {
+		return nil, storage.ErrProbeConfigNotFound
 	}
 
-	observed := make(map[string]struct {
-		NetIn  int64
-		NetOut int64
-	})
-	for _, entry := range snapshot.Servers {
-		var id string
-		if v, err := entry.ID.Int64(); err == nil {
-			id = strconv.FormatInt(v, 10)
-		} else {
-			raw := strings.TrimSpace(entry.ID.String())
-			if raw != "" {
-				if strings.ContainsAny(raw, ".eE") {
-					if f, err := entry.ID.Float64(); err == nil {
-						id = strconv.FormatInt(int64(math.Round(f)), 10)
-					} else {
-						id = raw
-					}
-				} else {
-					id = raw
+	// Apply probe filter if one was determined; bindings name "probe/server",
+	// legacy bindings with only the server name match that server on any probe
+	targets := make([]storage.ProbeConfig, 0, len(configs))
+	for _, cfg := range configs {
+		if probeFilter != nil {
+			filteredServers := make([]storage.ProbeServer, 0, len(cfg.Servers))
+			for _, srv := range cfg.Servers {
+				name := strings.TrimSpace(srv.Name)
+				if name == "" {
+					continue
+				}
+				_, scoped := probeFilter[storage.FormatProbeBinding(cfg.Name, name)]
+				_, legacy := probeFilter[name]
+				if scoped || legacy {
+					filteredServers = append(filteredServers, srv)
 				}
-			} else if f, err := entry.ID.Float64(); err == nil {
-				id = strconv.FormatInt(int64(math.Round(f)), 10)
 			}
+			cfg.Servers = filteredServers
 		}
 
-		id = strings.TrimSpace(id)
-		if id == "" {
+		if len(cfg.Servers) == 0 {
 			continue
 		}
-
-		netIn := jsonNumberToInt64(entry.State.NetInTransfer)
-		netOut := jsonNumberToInt64(entry.State.NetOutTransfer)
-		observed[id] = struct {
-			NetIn  int64
-			NetOut int64
-		}{NetIn: netIn, NetOut: netOut}
-	}
-
-	var totalLimit int64
-	var totalUsed int64
-
-	logger.Info("[Nezha] 处理服务器流量", "count", len(cfg.Servers))
-
-	for _, srv := range cfg.Servers {
-		id := strings.TrimSpace(srv.ServerID)
-		if id == "" {
-			continue
-		}
-
-		totalLimit += srv.MonthlyTrafficBytes
-
-		wsEntry, ok := observed[id]
-		if !ok {
-			logger.Info("[Nezha] 服务器未在探针数据中找到", "server_id", id)
-			continue
-		}
-
-		var used int64
-		switch strings.ToLower(strings.TrimSpace(srv.TrafficMethod)) {
-		case storage.TrafficMethodUp:
-			used = wsEntry.NetOut
-		case storage.TrafficMethodDown:
-			used = wsEntry.NetIn
-		default:
-			used = wsEntry.NetIn + wsEntry.NetOut
-		}
-
-		if used < 0 {
-			used = 0
-		}
-		if srv.MonthlyTrafficBytes > 0 && used > srv.MonthlyTrafficBytes {
-			used = srv.MonthlyTrafficBytes
-		}
-
-		logger.Info("[Nezha] 服务器流量",
-			"server_id", id,
-			"net_in_gb", bytesToGigabytes(wsEntry.NetIn),
-			"net_out_gb", bytesToGigabytes(wsEntry.NetOut),
-			"method", srv.TrafficMethod,
-			"used_gb", bytesToGigabytes(used),
-			"limit_gb", bytesToGigabytes(srv.MonthlyTrafficBytes))
-
-		totalUsed += used
-	}
-
-	totalRemaining := totalLimit - totalUsed
-	if totalRemaining < 0 {
-		totalRemaining = 0
-	}
-
-	logger.Info("[Nezha] 总计流量",
-		"limit_gb", bytesToGigabytes(totalLimit),
-		"used_gb", bytesToGigabytes(totalUsed),
-		"remaining_gb", bytesToGigabytes(totalRemaining))
-
-	return totalLimit, totalRemaining, totalUsed, nil
-}
-
-func (h *TrafficSummaryHandler) fetchNezhaV0Totals(ctx context.Context, cfg storage.ProbeConfig) (int64, int64, int64, error) {
-	baseAddress := strings.TrimSpace(cfg.Address)
-	if baseAddress == "" {
-		return 0, 0, 0, errors.New("invalid probe address")
-	}
-
-	base, err := url.Parse(baseAddress)
-	if err != nil {
-		return 0, 0, 0, fmt.Errorf("invalid probe address: %w", err)
-	}
-
-	endpoint := &url.URL{Path: "/api/server"}
-	target := base.ResolveReference(endpoint)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
-	if err != nil {
-		return 0, 0, 0, err
-	}
-
-	type nezhaV0Server struct {
-		ID     json.Number `json:"id"`
-		Status struct {
-			NetInTransfer  json.Number `json:"NetInTransfer"`
-			NetOutTransfer json.Number `json:"NetOutTransfer"`
-		} `json:"status"`
-	}
-
-	type nezhaV0Response struct {
-		Result []nezhaV0Server `json:"result"`
-	}
-
-	observed := make(map[string]struct {
-		NetIn  int64
-		NetOut int64
-	})
-
-	httpSuccess := false
-	resp, httpErr := h.client.Do(req)
-	if httpErr == nil {
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK {
-			decoder := json.NewDecoder(resp.Body)
-			decoder.UseNumber()
-
-			var payload nezhaV0Response
-			if err := decoder.Decode(&payload); err == nil && len(payload.Result) > 0 {
-				httpSuccess = true
-				for _, entry := range payload.Result {
-					var id string
-					if v, err := entry.ID.Int64(); err == nil {
-						id = strconv.FormatInt(v, 10)
-					} else {
-						raw := strings.TrimSpace(entry.ID.String())
-						if raw != "" {
-							if strings.ContainsAny(raw, ".eE") {
-								if f, err := entry.ID.Float64(); err == nil {
-									id = strconv.FormatInt(int64(math.Round(f)), 10)
-								} else {
-									id = raw
-								}
-							} else {
-								id = raw
-							}
-						} else if f, err := entry.ID.Float64(); err == nil {
-							id = strconv.FormatInt(int64(math.Round(f)), 10)
-						}
-					}
-
-					id = strings.TrimSpace(id)
-					if id == "" {
-						continue
-					}
-
-					netIn := jsonNumberToInt64(entry.Status.NetInTransfer)
-					netOut := jsonNumberToInt64(entry.Status.NetOutTransfer)
-					observed[id] = struct {
-						NetIn  int64
-						NetOut int64
-					}{NetIn: netIn, NetOut: netOut}
-				}
-			}
-		}
+		targets = append(targets, cfg)
 	}
 
-	// 如果 HTTP 接口失败或没有数据，尝试使用 WebSocket
-	if !httpSuccess {
-		wsObserved, wsErr := h.fetchNezhaV0TotalsViaWebSocket(ctx, base)
-		if wsErr != nil {
-			// WebSocket 也失败了，返回综合错误信息
-			if httpErr != nil {
-				return 0, 0, 0, fmt.Errorf("HTTP 接口失败: %w; WebSocket 接口也失败: %v", httpErr, wsErr)
-			}
-			return 0, 0, 0, fmt.Errorf("HTTP 接口未获取到数据; WebSocket 接口也失败: %v", wsErr)
+	if len(targets) == 0 {
+		if probeFilter != nil {
+			logger.Info("[Traffic Fetch] Probe filter applied but no matching servers found, returning zero traffic")
+			return nil, nil
 		}
-		observed = wsObserved
-		logger.Info("[Nezha V0] Using WebSocket data as HTTP API failed or returned no data")
+		return nil, errors.New("no probe servers configured")
 	}
 
-	var totalLimit int64
-	var totalUsed int64
-
-	logger.Info("[Nezha V0] 处理服务器流量", "count", len(cfg.Servers))
-
-	for _, srv := range cfg.Servers {
-		id := strings.TrimSpace(srv.ServerID)
-		if id == "" {
-			continue
-		}
-
-		totalLimit += srv.MonthlyTrafficBytes
-
-		entry, ok := observed[id]
-		if !ok {
-			logger.Info("[Nezha V0] 服务器未在探针数据中找到", "server_id", id)
+	if probeFilter != nil {
+		logger.Info("[流量获取] 根据绑定过滤探针服务器", "probe_count", len(targets))
+	}
+
+	type probeResult struct {
+		usages []probeServerUsage
+		err    error
+	}
+
+	// 并发获取各探针的流量并汇总
+	results := make([]probeResult, len(targets))
+	var wg sync.WaitGroup
+	for i, cfg := range targets {
+		wg.Add(1)
+		go func(i int, cfg storage.ProbeConfig) {
+			defer wg.Done()
+			usages, err := h.fetchProbeUsages(ctx, cfg)
+			results[i] = probeResult{usages: usages, err: err}
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	var (
+		usages []probeServerUsage
+		errs   []error
+	)
+	for i, res := range results {
+		if res.err != nil {
+			logger.Warn("[流量获取] 探针流量获取失败", "probe", targets[i].Name, "type", targets[i].ProbeType, "error", res.err)
+			errs = append(errs, fmt.Errorf("probe %s: %w", targets[i].Name, res.err))
 			continue
 		}
-
-		var used int64
-		switch strings.ToLower(strings.TrimSpace(srv.TrafficMethod)) {
-		case storage.TrafficMethodUp:
-			used = entry.NetOut
-		case storage.TrafficMethodDown:
-			used = entry.NetIn
-		default:
-			used = entry.NetIn + entry.NetOut
-		}
-
-		if used < 0 {
-			used = 0
-		}
-		if srv.MonthlyTrafficBytes > 0 && used > srv.MonthlyTrafficBytes {
-			used = srv.MonthlyTrafficBytes
-		}
-
-		logger.Info("[Nezha V0] 服务器流量",
-			"server_id", id,
-			"net_in_gb", bytesToGigabytes(entry.NetIn),
-			"net_out_gb", bytesToGigabytes(entry.NetOut),
-			"method", srv.TrafficMethod,
-			"used_gb", bytesToGigabytes(used),
-			"limit_gb", bytesToGigabytes(srv.MonthlyTrafficBytes))
-
-		totalUsed += used
+		usages = append(usages, res.usages...)
 	}
 
-	totalRemaining := totalLimit - totalUsed
-	if totalRemaining < 0 {
-		totalRemaining = 0
-	}
-
-	logger.Info("[Nezha V0] 总计流量",
-		"limit_gb", bytesToGigabytes(totalLimit),
-		"used_gb", bytesToGigabytes(totalUsed),
-		"remaining_gb", bytesToGigabytes(totalRemaining))
-
-	return totalLimit, totalRemaining, totalUsed, nil
-}
-
-func (h *TrafficSummaryHandler) fetchBatchSummary(ctx context.Context, address string, serverIDs []string) (int64, int64, int64, error) {
-	base, err := url.Parse(strings.TrimSpace(address))
-	if err != nil {
-		return 0, 0, 0, fmt.Errorf("invalid probe address: %w", err)
+	if len(errs) == len(results) {
+		return nil, errors.Join(errs...)
 	}
 
-	return h.fetchBatchTraffic(ctx, base, serverIDs)
+	return usages, nil
 }
 
-func (h *TrafficSummaryHandler) fetchKomariTotals(ctx context.Context, cfg storage.ProbeConfig) (int64, int64, int64, error) {
-	baseAddress := strings.TrimSpace(cfg.Address)
-	if baseAddress == "" {
-		return 0, 0, 0, errors.New("invalid probe address")
-	}
-
-	base, err := url.Parse(baseAddress)
-	if err != nil {
-		return 0, 0, 0, fmt.Errorf("invalid probe address: %w", err)
-	}
-
-	endpoint := &url.URL{Path: "/api/rpc2"}
-	target := base.ResolveReference(endpoint)
-
-	// Prepare JSON-RPC request
-	rpcRequest := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "common:getNodesLatestStatus",
-		"id":      3,
-	}
-
-	requestBody, err := json.Marshal(rpcRequest)
-	if err != nil {
-		return 0, 0, 0, fmt.Errorf("marshal komari request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(requestBody))
-	if err != nil {
-		return 0, 0, 0, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := h.client.Do(req)
-	if err != nil {
-		return 0, 0, 0, fmt.Errorf("komari request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, 0, 0, fmt.Errorf("komari request failed with status %s", resp.Status)
-	}
-
-	type komariResponse struct {
-		Result map[string]struct {
-			NetTotalUp   json.Number `json:"net_total_up"`
-			NetTotalDown json.Number `json:"net_total_down"`
-		} `json:"result"`
-	}
-
-	decoder := json.NewDecoder(resp.Body)
-	decoder.UseNumber()
-
-	var payload komariResponse
-	if err := decoder.Decode(&payload); err != nil {
-		return 0, 0, 0, fmt.Errorf("parse komari response: %w", err)
-	}
-
-	observed := make(map[string]struct {
-		Up   int64
-		Down int64
-	})
-	for id, info := range payload.Result {
-		cleanID := strings.TrimSpace(id)
-		if cleanID == "" {
-			continue
-		}
-
-		up := jsonNumberToInt64(info.NetTotalUp)
-		if up < 0 {
-			up = 0
-		}
-		down := jsonNumberToInt64(info.NetTotalDown)
-		if down < 0 {
-			down = 0
-		}
-
-		observed[cleanID] = struct {
-			Up   int64
-			Down int64
-		}{Up: up, Down: down}
-	}
-
-	var totalLimit int64
-	var totalUsed int64
-
-	logger.Info("[Komari] 处理服务器流量", "count", len(cfg.Servers))
-
+// fetchProbeUsages fetches the traffic of the servers of a single probe configuration.
+func (h *TrafficSummaryHandler) fetchProbeUsages(ctx context.Context, cfg storage.ProbeConfig) ([]probeServerUsage, error) {
+	serverIDs := make([]string, 0, len(cfg.Servers))
 	for _, srv := range cfg.Servers {
 		id := strings.TrimSpace(srv.ServerID)
 		if id == "" {
 			continue
 		}
-
-		totalLimit += srv.MonthlyTrafficBytes
-
-		usage, ok := observed[id]
-		if !ok {
-			logger.Info("[Komari] 服务器未在探针数据中找到", "server_id", id)
-			continue
-		}
-
-		var used int64
-		switch strings.ToLower(strings.TrimSpace(srv.TrafficMethod)) {
-		case storage.TrafficMethodUp:
-			used = usage.Up
-		case storage.TrafficMethodDown:
-			used = usage.Down
-		default:
-			used = usage.Up + usage.Down
-		}
-
-		if used < 0 {
-			used = 0
-		}
-		if srv.MonthlyTrafficBytes > 0 && used > srv.MonthlyTrafficBytes {
-			used = srv.MonthlyTrafficBytes
-		}
-
-		logger.Info("[Komari] 服务器流量",
-			"server_id", id,
-			"up_gb", bytesToGigabytes(usage.Up),
-			"down_gb", bytesToGigabytes(usage.Down),
-			"method", srv.TrafficMethod,
-			"used_gb", bytesToGigabytes(used),
-			"limit_gb", bytesToGigabytes(srv.MonthlyTrafficBytes))
-
-		totalUsed += used
-	}
-
-	totalRemaining := totalLimit - totalUsed
-	if totalRemaining < 0 {
-		totalRemaining = 0
+		serverIDs = append(serverIDs, id)
 	}
 
-	logger.Info("[Komari] 总计流量",
-		"limit_gb", bytesToGigabytes(totalLimit),
-		"used_gb", bytesToGigabytes(totalUsed),
-		"remaining_gb", bytesToGigabytes(totalRemaining))
-
-	return totalLimit, totalRemaining, totalUsed, nil
-}
-
-func (h *TrafficSummaryHandler) fetchBatchTraffic(ctx context.Context, base *url.URL, serverIDs []string) (int64, int64, int64, error) {
-	payload, err := json.Marshal(map[string][]string{"serverIds": serverIDs})
-	if err != nil {
-		return 0, 0, 0, err
+	if len(serverIDs) == 0 {
+		return nil, errors.New("no server ids configured")
 	}
 
-	endpoint := &url.URL{Path: "/stats/batch-traffic"}
-	target := base.ResolveReference(endpoint)
+	logger.Info("[流量获取] 探针信息",
+		"name", cfg.Name,
+		"type", cfg.ProbeType,
+		"address", cfg.Address,
+		"server_count", len(cfg.Servers),
+		"server_ids", serverIDs)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(payload))
+	adapter, err := probe.GetDefaultFactory().GetAdapter(cfg.ProbeType)
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "miaomiaowu/0.1")
 
-	resp, err := h.client.Do(req)
+	traffic, err := adapter.FetchTraffic(ctx, probe.Target{
+		Address:   cfg.Address,
+		Options:   cfg.Options,
+		ServerIDs: serverIDs,
+		Client:    h.client,
+	})
 	if err != nil {
-		return 0, 0, 0, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, 0, 0, errors.New("batch traffic request failed with status " + resp.Status)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
-	decoder.UseNumber()
-
-	var payloadResp batchTrafficResponse
-	if err := decoder.Decode(&payloadResp); err != nil {
-		return 0, 0, 0, err
-	}
-
-	if !payloadResp.Success {
-		if payloadResp.Message != "" {
-			return 0, 0, 0, errors.New(payloadResp.Message)
-		}
-		return 0, 0, 0, errors.New("batch traffic request unsuccessful")
-	}
-
-	var totalLimit int64
-	var totalRemaining int64
-	var totalUsed int64
-
-	logger.Info("[Dstatus] 处理服务器流量", "count", len(payloadResp.Data))
-
-	for serverID, entry := range payloadResp.Data {
-		limit := jsonNumberToInt64(entry.Monthly.Limit)
-		used := jsonNumberToInt64(entry.Monthly.Used)
-		remaining := jsonNumberToInt64(entry.Monthly.Remaining)
-
-		logger.Info("[Dstatus] 服务器流量",
-			"server_id", serverID,
-			"limit_gb", bytesToGigabytes(limit),
-			"used_gb", bytesToGigabytes(used),
-			"remaining_gb", bytesToGigabytes(remaining))
-
-		totalLimit += limit
-		totalRemaining += remaining
-		totalUsed += used
+		return nil, err
 	}
 
-	logger.Info("[Dstatus] 总计流量",
-		"limit_gb", bytesToGigabytes(totalLimit),
-		"used_gb", bytesToGigabytes(totalUsed),
-		"remaining_gb", bytesToGigabytes(totalRemaining))
+	usages := probeUsagesFromTraffic(cfg, traffic)
 
-	return totalLimit, totalRemaining, totalUsed, nil
-}
-
-func jsonNumberToInt64(n json.Number) int64 {
-	if n == "" {
-		return 0
-	}
-	if v, err := n.Int64(); err == nil {
-		return v
-	}
-	if f, err := n.Float64(); err == nil {
-		if f < 0 {
-			return int64(f - 0.5)
-		}
-		return int64(f + 0.5)
-	}
-	return 0
+	// 按账期、计费倍率换算已用流量
+	return h.applyProbeBilling(ctx, cfg, usages, time.Now()), nil
 }
 
 func roundUpTwoDecimals(value float64) float64 {
@@ -1049,12 +632,12 @@ func usagePercentage(used, limit int64) float64 {
 	return (float64(used) / float64(limit)) * 100
 }
 
-func (h *TrafficSummaryHandler) recordSnapshot(ctx context.Context, totalLimit, totalUsed, totalRemaining int64) error {
+func (h *TrafficSummaryHandler) recordSnapshot(ctx context.Context, date time.Time, totalLimit, totalUsed, totalRemaining int64) error {
 	if h.repo == nil {
 		return nil
 	}
 
-	return h.repo.RecordDaily(ctx, time.Now(), totalLimit, totalUsed, totalRemaining)
+	return h.repo.RecordDaily(ctx, date, totalLimit, totalUsed, totalRemaining)
 }
 
 func (h *TrafficSummaryHandler) loadHistory(ctx context.Context, days int) ([]trafficDailyUsage, error) {
@@ -1101,19 +684,19 @@ func (h *TrafficSummaryHandler) loadHistory(ctx context.Context, days int) ([]tr
 }
 
 // fetchExternalSubscriptionTraffic fetches traffic from external subscriptions that are actually used in subscription files
-// Returns totalLimit and totalUsed from non-expired subscriptions (or long-term subscriptions without expire date)
-func (h *TrafficSummaryHandler) fetchExternalSubscriptionTraffic(ctx context.Context, username string) (int64, int64) {
+// Returns totalLimit and totalUsed from non-expired subscriptions (or long-term subscriptions without expire date), along with those subscriptions
+func (h *TrafficSummaryHandler) fetchExternalSubscriptionTraffic(ctx context.Context, username string) (int64, int64, []storage.ExternalSubscription) {
 	// Check if sync_traffic is enabled
 	settings, err := h.repo.GetUserSettings(ctx, username)
 	if err != nil || !settings.SyncTraffic {
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	// Get all subscription files for this user
 	subscribeFiles, err := h.repo.ListSubscribeFiles(ctx)
 	if err != nil {
 		logger.Info("[流量] 获取订阅文件列表失败", "error", err)
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	// Collect all external subscription URLs used across all subscription files
@@ -1142,7 +725,7 @@ func (h *TrafficSummaryHandler) fetchExternalSubscriptionTraffic(ctx context.Con
 
 	if len(usedExternalURLs) == 0 {
 		logger.Info("[流量] 未找到使用中的外部订阅")
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	logger.Info("[流量] 找到使用中的外部订阅", "count", len(usedExternalURLs))
@@ -1151,11 +734,12 @@ func (h *TrafficSummaryHandler) fetchExternalSubscriptionTraffic(ctx context.Con
 	subs, err := h.repo.ListExternalSubscriptions(ctx, username)
 	if err != nil {
 		logger.Info("[流量] 获取外部订阅失败", "error", err)
-		return 0, 0
+		return 0, 0, nil
 	}
 
 	var totalLimit int64
 	var totalUsed int64
+	var included []storage.ExternalSubscription
 	now := time.Now()
 
 	for _, sub := range subs {
@@ -1174,6 +758,7 @@ func (h *TrafficSummaryHandler) fetchExternalSubscriptionTraffic(ctx context.Con
 		// Add traffic from this subscription
 		totalLimit += sub.Total
 		totalUsed += sub.Upload + sub.Download
+		included = append(included, sub)
 
 		if sub.Expire == nil {
 			logger.Info("[流量] 添加长期订阅流量", "name", sub.Name, "limit", sub.Total, "used", sub.Upload+sub.Download)
@@ -1187,131 +772,7 @@ func (h *TrafficSummaryHandler) fetchExternalSubscriptionTraffic(ctx context.Con
 	}
 
 	logger.Info("[流量] 外部订阅流量总计", "limit", totalLimit, "used", totalUsed)
-	return totalLimit, totalUsed
-}
-
-func (h *TrafficSummaryHandler) fetchNezhaV0TotalsViaWebSocket(ctx context.Context, base *url.URL) (map[string]struct {
-	NetIn  int64
-	NetOut int64
-}, error) {
-	// 转换 scheme 为 WebSocket
-	wsBase := *base // 复制以避免修改原始 URL
-	switch strings.ToLower(wsBase.Scheme) {
-	case "", "http":
-		wsBase.Scheme = "ws"
-	case "https":
-		wsBase.Scheme = "wss"
-	case "ws", "wss":
-		// keep as is
-	default:
-		wsBase.Scheme = "wss"
-	}
-
-	endpoint := &url.URL{Path: "/ws"}
-	target := wsBase.ResolveReference(endpoint)
-
-	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-
-	conn, resp, err := websocket.DefaultDialer.DialContext(dialCtx, target.String(), nil)
-	if err != nil {
-		if resp != nil {
-			resp.Body.Close()
-		}
-		return nil, fmt.Errorf("无法连接到 WebSocket 接口: %w", err)
-	}
-	defer conn.Close()
-
-	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
-		return nil, fmt.Errorf("set websocket deadline: %w", err)
-	}
-
-	_, message, err := conn.ReadMessage()
-	if err != nil {
-		return nil, fmt.Errorf("未在期望时间内收到服务器数据: %w", err)
-	}
-
-	message = bytes.TrimSpace(message)
-	if len(message) == 0 {
-		return nil, errors.New("empty probe websocket payload")
-	}
-
-	type nezhaServer struct {
-		ID     json.Number `json:"id"`
-		Status struct {
-			NetInTransfer  json.Number `json:"NetInTransfer"`
-			NetOutTransfer json.Number `json:"NetOutTransfer"`
-		} `json:"State"`
-	}
-
-	type nezhaSnapshot struct {
-		Servers []nezhaServer `json:"servers"`
-	}
-
-	decoder := json.NewDecoder(bytes.NewReader(message))
-	decoder.UseNumber()
-
-	var snapshot nezhaSnapshot
-
-	if message[0] == '[' {
-		var frames []nezhaSnapshot
-		if err := decoder.Decode(&frames); err != nil {
-			return nil, fmt.Errorf("解析探针返回数据失败: %w", err)
-		}
-		if len(frames) == 0 {
-			return nil, errors.New("探针未返回任何服务器数据")
-		}
-		snapshot = frames[len(frames)-1]
-	} else {
-		if err := decoder.Decode(&snapshot); err != nil {
-			return nil, fmt.Errorf("解析探针返回数据失败: %w", err)
-		}
-	}
-
-	if len(snapshot.Servers) == 0 {
-		return nil, errors.New("探针未返回任何服务器数据")
-	}
-
-	observed := make(map[string]struct {
-		NetIn  int64
-		NetOut int64
-	})
-
-	for _, entry := range snapshot.Servers {
-		var id string
-		if v, err := entry.ID.Int64(); err == nil {
-			id = strconv.FormatInt(v, 10)
-		} else {
-			raw := strings.TrimSpace(entry.ID.String())
-			if raw != "" {
-				if strings.ContainsAny(raw, ".eE") {
-					if f, err := entry.ID.Float64(); err == nil {
-						id = strconv.FormatInt(int64(math.Round(f)), 10)
-					} else {
-						id = raw
-					}
-				} else {
-					id = raw
-				}
-			} else if f, err := entry.ID.Float64(); err == nil {
-				id = strconv.FormatInt(int64(math.Round(f)), 10)
-			}
-		}
-
-		id = strings.TrimSpace(id)
-		if id == "" {
-			continue
-		}
-
-		netIn := jsonNumberToInt64(entry.Status.NetInTransfer)
-		netOut := jsonNumberToInt64(entry.Status.NetOutTransfer)
-		observed[id] = struct {
-			NetIn  int64
-			NetOut int64
-		}{NetIn: netIn, NetOut: netOut}
-	}
-
-	return observed, nil
+	return totalLimit, totalUsed, included
 }
 
 func writeError(w http.ResponseWriter, status int, err error) {
@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// upstreamMaxBodySize 上游订阅内容大小上限（解压后）
+const upstreamMaxBodySize = 64 << 20
+
+// upstreamMaxFetchTimeout 外部订阅可配置的拉取超时上限（秒）
+const upstreamMaxFetchTimeout = 600
+
+// upstreamReservedHeaders 由拉取逻辑自行设置的请求头，不允许通过自定义请求头覆盖
+var upstreamReservedHeaders = map[string]struct{}{
+	"User-Agent":        {},
+	"Accept-Encoding":   {},
+	"If-None-Match":     {},
+	"If-Modified-Since": {},
+	"Host":              {},
+	"Content-Length":    {},
+	"Connection":        {},
+}
+
+// upstreamTransportKey 出站 Transport 的缓存键
+type upstreamTransportKey struct {
+	proxy       string
+	insecure    bool
+	fingerprint string
+}
+
+// upstreamTransports 按出站设置复用 Transport，避免每次拉取都新建连接池
+var upstreamTransports sync.Map // upstreamTransportKey -> *http.Transport
+
+// upstreamFetchRepo 条件请求校验值的存储，未设置时退化为普通请求
+var upstreamFetchRepo atomic.Pointer[storage.TrafficRepository]
+
+// SetUpstreamFetchRepository 启用外部订阅的条件请求（ETag/If-Modified-Since）缓存
+func SetUpstreamFetchRepository(repo *storage.TrafficRepository) {
+	upstreamFetchRepo.Store(repo)
+}
+
+// upstreamFetchResult 上游订阅拉取结果
+type upstreamFetchResult struct {
+	StatusCode  int         // 上游返回 304 时为 200，Body 来自本地缓存
+	Header      http.Header // 响应头；304 未带 subscription-userinfo 时补上缓存的值
+	Body        []byte      // 已解压的响应内容
+	NotModified bool        // 上游返回 304
+}
+
+// fetchUpstreamSubscription 拉取外部订阅内容
+// - gzip 由 Transport 透明协商和解压；未声明 Content-Encoding 的 gzip 内容由 readUpstreamBody 识别
+// - sub 已保存（ID > 0）时发送 If-None-Match / If-Modified-Since，304 时复用缓存内容
+// 仅在网络错误时返回 error；非 200 响应通过 StatusCode 返回，由调用方处理
+func fetchUpstreamSubscription(ctx context.Context, client *http.Client, sub *storage.ExternalSubscription, userAgent string) (*upstreamFetchResult, error) {
+	client, err := upstreamClientFor(client, sub)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newUpstreamRequest(ctx, sub, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := upstreamFetchRepo.Load()
+	var cached *storage.SubscriptionHTTPCache
+	if repo != nil && sub.ID > 0 {
+		if c, err := repo.GetSubscriptionHTTPCache(ctx, sub.ID, userAgent); err == nil && c.URL == sub.URL && len(c.Body) > 0 {
+			cached = &c
+			if c.ETag != "" {
+				req.Header.Set("If-None-Match", c.ETag)
+			}
+			if c.LastModified != "" {
+				req.Header.Set("If-Modified-Since", c.LastModified)
+			}
+		} else if err != nil && !errors.Is(err, storage.ErrSubscriptionHTTPCacheNotFound) {
+			logger.Info("[上游订阅] 读取条件请求缓存失败", "url", sub.URL, "error", err)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		header := resp.Header.Clone()
+		if header.Get("subscription-userinfo") == "" && cached.UserInfo != "" {
+			header.Set("subscription-userinfo", cached.UserInfo)
+		}
+		logger.Info("[上游订阅] 内容未变化，使用缓存", "url", sub.URL, "size", len(cached.Body))
+		return &upstreamFetchResult{
+			StatusCode:  http.StatusOK,
+			Header:      header,
+			Body:        cached.Body,
+			NotModified: true,
+		}, nil
+	}
+
+	body, err := readUpstreamBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+
+	result := &upstreamFetchResult{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+
+	if resp.StatusCode == http.StatusOK && repo != nil && sub.ID > 0 {
+		etag := resp.Header.Get("ETag")
+		lastModified := resp.Header.Get("Last-Modified")
+		// 没有校验值时无需保存，下次仍是完整请求
+		if etag != "" || lastModified != "" {
+			if err := repo.SaveSubscriptionHTTPCache(context.WithoutCancel(ctx), storage.SubscriptionHTTPCache{
+				SubscriptionID: sub.ID,
+				UserAgent:      userAgent,
+				URL:            sub.URL,
+				ETag:           etag,
+				LastModified:   lastModified,
+				UserInfo:       resp.Header.Get("subscription-userinfo"),
+				Body:           body,
+				FetchedAt:      time.Now(),
+			}); err != nil {
+				logger.Info("[上游订阅] 保存条件请求缓存失败", "url", sub.URL, "error", err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// fetchUpstreamUserInfo 只请求订阅的 subscription-userinfo 响应头（用于获取流量信息），不读取订阅内容
+func fetchUpstreamUserInfo(ctx context.Context, client *http.Client, sub *storage.ExternalSubscription, userAgent string) (string, error) {
+	client, err := upstreamClientFor(client, sub)
+	if err != nil {
+		return "", err
+	}
+	req, err := newUpstreamRequest(ctx, sub, userAgent)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.Header.Get("subscription-userinfo"), nil
+}
+
+// newUpstreamRequest 创建拉取外部订阅的请求，附带自定义请求头和 User-Agent
+func newUpstreamRequest(ctx context.Context, sub *storage.ExternalSubscription, userAgent string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	for key, value := range sub.CustomHeaders {
+		if _, reserved := upstreamReservedHeaders[http.CanonicalHeaderKey(key)]; reserved {
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// upstreamClientFor 按外部订阅的出站代理、超时和 TLS 设置返回 HTTP 客户端
+// 订阅没有自定义设置时直接返回 base；base 已跳过证书校验（如节点预览）时保持跳过
+func upstreamClientFor(base *http.Client, sub *storage.ExternalSubscription) (*http.Client, error) {
+	if base == nil {
+		base = &http.Client{Timeout: 30 * time.Second}
+	}
+	hasTransportSettings := sub.UpstreamProxy != "" || sub.SkipCertVerify || sub.CertFingerprint != ""
+	if sub.FetchTimeout <= 0 && !hasTransportSettings {
+		return base, nil
+	}
+
+	client := *base
+	if sub.FetchTimeout > 0 {
+		client.Timeout = time.Duration(sub.FetchTimeout) * time.Second
+	}
+	if hasTransportSettings {
+		key := upstreamTransportKey{
+			proxy:       sub.UpstreamProxy,
+			insecure:    sub.SkipCertVerify,
+			fingerprint: sub.CertFingerprint,
+		}
+		if t, ok := base.Transport.(*http.Transport); ok && t.TLSClientConfig != nil && t.TLSClientConfig.InsecureSkipVerify {
+			key.insecure = true
+		}
+		transport, err := upstreamTransport(key)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = transport
+	}
+	return &client, nil
+}
+
+func upstreamTransport(key upstreamTransportKey) (*http.Transport, error) {
+	if cached, ok := upstreamTransports.Load(key); ok {
+		return cached.(*http.Transport), nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if key.proxy != "" {
+		proxyURL, err := parseUpstreamProxy(key.proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if key.insecure || key.fingerprint != "" {
+		// 固定指纹时不校验证书链（允许自签名证书），改为在握手后比对叶子证书指纹
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		if key.fingerprint != "" {
+			fingerprint := key.fingerprint
+			transport.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+				if len(cs.PeerCertificates) == 0 {
+					return errors.New("no peer certificate")
+				}
+				sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+				if got := hex.EncodeToString(sum[:]); got != fingerprint {
+					return fmt.Errorf("certificate fingerprint mismatch: got %s", got)
+				}
+				return nil
+			}
+		}
+	}
+
+	actual, _ := upstreamTransports.LoadOrStore(key, transport)
+	return actual.(*http.Transport), nil
+}
+
+// parseUpstreamProxy 解析出站代理地址，支持 http、https、socks5
+func parseUpstreamProxy(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream proxy: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported upstream proxy scheme %q (use http, https or socks5)", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("upstream proxy host is required")
+	}
+	return proxyURL, nil
+}
+
+// normalizeCertFingerprint 规范化 SHA-256 证书指纹：去掉冒号和空格并转为小写
+func normalizeCertFingerprint(raw string) (string, error) {
+	fingerprint := strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(raw)))
+	if fingerprint == "" {
+		return "", nil
+	}
+	if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+		return "", errors.New("cert_fingerprint must be a SHA-256 fingerprint (64 hex characters)")
+	}
+	return fingerprint, nil
+}
+
+// upstreamSettingsRequest 外部订阅出站设置的请求字段，未传的字段保留现有设置
+type upstreamSettingsRequest struct {
+	UpstreamProxy   *string           `json:"upstream_proxy"`   // 出站代理，如 socks5://127.0.0.1:1080，空字符串表示直连
+	FetchTimeout    *int              `json:"fetch_timeout"`    // 拉取超时（秒），0 表示默认
+	CustomHeaders   map[string]string `json:"custom_headers"`   // 额外请求头，传 {} 清空
+	SkipCertVerify  *bool             `json:"skip_cert_verify"` // 跳过 TLS 证书校验
+	CertFingerprint *string           `json:"cert_fingerprint"` // 固定证书 SHA-256 指纹，空字符串表示不固定
+}
+
+// applyTo 校验并写入出站设置
+func (p upstreamSettingsRequest) applyTo(sub *storage.ExternalSubscription) error {
+	if p.UpstreamProxy != nil {
+		proxy := strings.TrimSpace(*p.UpstreamProxy)
+		if proxy != "" {
+			if _, err := parseUpstreamProxy(proxy); err != nil {
+				return err
+			}
+		}
+		sub.UpstreamProxy = proxy
+	}
+	if p.FetchTimeout != nil {
+		if *p.FetchTimeout < 0 || *p.FetchTimeout > upstreamMaxFetchTimeout {
+			return fmt.Errorf("fetch_timeout must be between 0 and %d seconds", upstreamMaxFetchTimeout)
+		}
+		sub.FetchTimeout = *p.FetchTimeout
+	}
+	if p.CustomHeaders != nil {
+		headers := make(map[string]string, len(p.CustomHeaders))
+		for key, value := range p.CustomHeaders {
+			key = strings.TrimSpace(key)
+			if key == "" || strings.ContainsAny(key, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("invalid custom header %q", key)
+			}
+			if _, reserved := upstreamReservedHeaders[http.CanonicalHeaderKey(key)]; reserved {
+				return fmt.Errorf("custom header %q is not allowed", key)
+			}
+			headers[key] = value
+		}
+		sub.CustomHeaders = headers
+	}
+	if p.SkipCertVerify != nil {
+		sub.SkipCertVerify = *p.SkipCertVerify
+	}
+	if p.CertFingerprint != nil {
+		fingerprint, err := normalizeCertFingerprint(*p.CertFingerprint)
+		if err != nil {
+			return err
+		}
+		sub.CertFingerprint = fingerprint
+	}
+	return nil
+}
+
+// readUpstreamBody 读取响应体，按 Content-Encoding 或 gzip 魔数解压
+func readUpstreamBody(resp *http.Response) ([]byte, error) {
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, upstreamMaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	isGzip := encoding == "gzip" || encoding == "x-gzip" || (len(raw) > 2 && raw[0] == 0x1f && raw[1] == 0x8b)
+	if !isGzip {
+		if len(raw) > upstreamMaxBodySize {
+			return nil, fmt.Errorf("response exceeds %d bytes", upstreamMaxBodySize)
+		}
+		return raw, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	defer zr.Close()
+
+	body, err := io.ReadAll(io.LimitReader(zr, upstreamMaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	if len(body) > upstreamMaxBodySize {
+		return nil, fmt.Errorf("response exceeds %d bytes", upstreamMaxBodySize)
+	}
+	return body, nil
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"miaomiaowu/internal/storage"
+)
+
+const testUpstreamBody = "proxies:\n  - {name: a, type: ss, server: 1.1.1.1, port: 443}\n"
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTestUpstreamRepo(t *testing.T, url string) (*storage.TrafficRepository, storage.ExternalSubscription) {
+	t.Helper()
+	repo, err := storage.NewTrafficRepository(filepath.Join(t.TempDir(), "traffic.db"))
+	if err != nil {
+		t.Fatalf("NewTrafficRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	sub := storage.ExternalSubscription{Username: "alice", Name: "upstream", URL: url}
+	id, err := repo.CreateExternalSubscription(context.Background(), sub)
+	if err != nil {
+		t.Fatalf("CreateExternalSubscription: %v", err)
+	}
+	sub.ID = id
+	return repo, sub
+}
+
+func TestFetchUpstreamSubscriptionGzip(t *testing.T) {
+	tests := []struct {
+		name   string
+		header bool // 是否声明 Content-Encoding: gzip
+	}{
+		{"declared content-encoding", true},
+		{"undeclared gzip body", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header {
+					if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+						t.Errorf("Accept-Encoding = %q, want gzip negotiated by the transport", r.Header.Get("Accept-Encoding"))
+					}
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				w.Write(gzipBytes(t, testUpstreamBody))
+			}))
+			defer srv.Close()
+
+			sub := &storage.ExternalSubscription{URL: srv.URL}
+			result, err := fetchUpstreamSubscription(context.Background(), srv.Client(), sub, "clash-meta")
+			if err != nil {
+				t.Fatalf("fetchUpstreamSubscription: %v", err)
+			}
+			if result.StatusCode != http.StatusOK {
+				t.Fatalf("StatusCode = %d, want 200", result.StatusCode)
+			}
+			if string(result.Body) != testUpstreamBody {
+				t.Fatalf("Body = %q, want %q", result.Body, testUpstreamBody)
+			}
+		})
+	}
+}
+
+func TestFetchUpstreamSubscriptionConditional(t *testing.T) {
+	const etag = `"v1"`
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	const userInfo = "upload=1; download=2; total=3"
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == etag {
+			if r.Header.Get("If-Modified-Since") != lastModified {
+				t.Errorf("If-Modified-Since = %q, want %q", r.Header.Get("If-Modified-Since"), lastModified)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("subscription-userinfo", userInfo)
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipBytes(t, testUpstreamBody))
+	}))
+	defer srv.Close()
+
+	repo, sub := newTestUpstreamRepo(t, srv.URL)
+	SetUpstreamFetchRepository(repo)
+	t.Cleanup(func() { SetUpstreamFetchRepository(nil) })
+
+	first, err := fetchUpstreamSubscription(context.Background(), srv.Client(), &sub, "clash-meta")
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	if first.NotModified || string(first.Body) != testUpstreamBody {
+		t.Fatalf("first fetch = %+v, want full 200 body", first)
+	}
+
+	cached, err := repo.GetSubscriptionHTTPCache(context.Background(), sub.ID, "clash-meta")
+	if err != nil {
+		t.Fatalf("GetSubscriptionHTTPCache: %v", err)
+	}
+	if cached.ETag != etag || cached.LastModified != lastModified || string(cached.Body) != testUpstreamBody {
+		t.Fatalf("cached = %+v, want validators and decompressed body", cached)
+	}
+
+	second, err := fetchUpstreamSubscription(context.Background(), srv.Client(), &sub, "clash-meta")
+	if err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+	if !second.NotModified || second.StatusCode != http.StatusOK {
+		t.Fatalf("second fetch NotModified=%v StatusCode=%d, want cached 200", second.NotModified, second.StatusCode)
+	}
+	if string(second.Body) != testUpstreamBody {
+		t.Fatalf("second Body = %q, want cached body", second.Body)
+	}
+	if got := second.Header.Get("subscription-userinfo"); got != userInfo {
+		t.Fatalf("subscription-userinfo = %q, want cached %q", got, userInfo)
+	}
+
+	// 其他 User-Agent 没有缓存，发送完整请求
+	if _, err := fetchUpstreamSubscription(context.Background(), srv.Client(), &sub, "sing-box"); err != nil {
+		t.Fatalf("fetch with other user agent: %v", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Fatalf("requests = %d, want 3", got)
+	}
+}
+
+func TestFetchUpstreamSubscriptionNoValidatorsNotCached(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			t.Errorf("unexpected conditional request headers: %v", r.Header)
+		}
+		w.Write([]byte(testUpstreamBody))
+	}))
+	defer srv.Close()
+
+	repo, sub := newTestUpstreamRepo(t, srv.URL)
+	SetUpstreamFetchRepository(repo)
+	t.Cleanup(func() { SetUpstreamFetchRepository(nil) })
+
+	for range 2 {
+		result, err := fetchUpstreamSubscription(context.Background(), srv.Client(), &sub, "clash-meta")
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if result.NotModified {
+			t.Fatal("NotModified = true without validators")
+		}
+	}
+	if _, err := repo.GetSubscriptionHTTPCache(context.Background(), sub.ID, "clash-meta"); err != storage.ErrSubscriptionHTTPCacheNotFound {
+		t.Fatalf("GetSubscriptionHTTPCache err = %v, want not found", err)
+	}
+}
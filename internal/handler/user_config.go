@@ -20,6 +20,7 @@ type userConfigRequest struct {
 	CacheExpireMinutes      int     `json:"cache_expire_minutes"`
 	SyncTraffic             bool    `json:"sync_traffic"`
 	EnableProbeBinding      bool    `json:"enable_probe_binding"`
+	ExcludeOfflineNodes     *bool   `json:"exclude_offline_nodes"` // Optional; keeps the saved value when omitted
 	CustomRulesEnabled      bool    `json:"custom_rules_enabled"`
 	EnableShortLink         bool    `json:"enable_short_link"`
 	TemplateVersion         string  `json:"template_version"` // "v1", "v2", or "v3"
@@ -40,6 +41,7 @@ type userConfigResponse struct {
 	CacheExpireMinutes      int     `json:"cache_expire_minutes"`
 	SyncTraffic             bool    `json:"sync_traffic"`
 	EnableProbeBinding      bool    `json:"enable_probe_binding"`
+	ExcludeOfflineNodes     bool    `json:"exclude_offline_nodes"` // Exclude nodes whose bound probe server is offline
 	CustomRulesEnabled      bool    `json:"custom_rules_enabled"`
 	EnableShortLink         bool    `json:"enable_short_link"`
 	TemplateVersion         string  `json:"template_version"` // "v1", "v2", or "v3"
@@ -123,6 +125,7 @@ func handleGetUserConfig(w http.ResponseWriter, r *http.Request, repo *storage.T
 		CacheExpireMinutes:      settings.CacheExpireMinutes,
 		SyncTraffic:             settings.SyncTraffic,
 		EnableProbeBinding:      settings.EnableProbeBinding,
+		ExcludeOfflineNodes:     settings.ExcludeOfflineNodes,
 		CustomRulesEnabled:      true, // 自定义规则始终启用
 		EnableShortLink:         settings.EnableShortLink,
 		TemplateVersion:         settings.TemplateVersion,
@@ -190,6 +193,17 @@ func handleUpdateUserConfig(w http.ResponseWriter, r *http.Request, repo *storag
 		return
 	}
 
+	// 旧版前端不提交 exclude_offline_nodes，此时沿用已保存的设置
+	var excludeOfflineNodes bool
+	if payload.ExcludeOfflineNodes != nil {
+		excludeOfflineNodes = *payload.ExcludeOfflineNodes
+	} else if existing, err := repo.GetUserSettings(r.Context(), username); err == nil {
+		excludeOfflineNodes = existing.ExcludeOfflineNodes
+	} else if !errors.Is(err, storage.ErrUserSettingsNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	settings := storage.UserSettings{
 		Username:            username,
 		ForceSyncExternal:   payload.ForceSyncExternal,
@@ -199,6 +213,7 @@ func handleUpdateUserConfig(w http.ResponseWriter, r *http.Request, repo *storag
 		CacheExpireMinutes:  cacheExpireMinutes,
 		SyncTraffic:         payload.SyncTraffic,
 		EnableProbeBinding:  payload.EnableProbeBinding,
+		ExcludeOfflineNodes: excludeOfflineNodes,
 		CustomRulesEnabled:  true, // 自定义规则始终启用
 		EnableShortLink:     payload.EnableShortLink,
 		TemplateVersion:     templateVersion,
@@ -217,12 +232,16 @@ func handleUpdateUserConfig(w http.ResponseWriter, r *http.Request, repo *storag
 	if silentModeTimeout <= 0 {
 		silentModeTimeout = 15
 	}
-	systemConfig := storage.SystemConfig{
-		ProxyGroupsSourceURL:    proxyGroupsSourceURL,
-		ClientCompatibilityMode: payload.ClientCompatibilityMode,
-		SilentMode:              payload.SilentMode,
-		SilentModeTimeout:       silentModeTimeout,
+	// 先读取现有系统配置，避免覆盖本接口不管理的字段
+	systemConfig, err := repo.GetSystemConfig(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("get system config: %w", err))
+		return
 	}
+	systemConfig.ProxyGroupsSourceURL = proxyGroupsSourceURL
+	systemConfig.ClientCompatibilityMode = payload.ClientCompatibilityMode
+	systemConfig.SilentMode = payload.SilentMode
+	systemConfig.SilentModeTimeout = silentModeTimeout
 	if err := repo.UpdateSystemConfig(r.Context(), systemConfig); err != nil {
 		writeError(w, http.StatusInternalServerError, fmt.Errorf("update system config: %w", err))
 		return
@@ -236,6 +255,7 @@ func handleUpdateUserConfig(w http.ResponseWriter, r *http.Request, repo *storag
 		CacheExpireMinutes:      settings.CacheExpireMinutes,
 		SyncTraffic:             settings.SyncTraffic,
 		EnableProbeBinding:      settings.EnableProbeBinding,
+		ExcludeOfflineNodes:     settings.ExcludeOfflineNodes,
 		CustomRulesEnabled:      true, // 自定义规则始终启用
 		EnableShortLink:         settings.EnableShortLink,
 		TemplateVersion:         settings.TemplateVersion,
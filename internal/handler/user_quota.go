@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// 用户流量配额：按用户节点绑定的探针服务器（需开启探针服务器绑定）统计账期内用量，
+// 超出配额后订阅降级为流量用尽提示，或改为输出指定的降级订阅文件。
+// 探针只能统计服务器整体的流量，无法区分用户：多个用户绑定同一服务器时，
+// 各自的用量都是该服务器的全部流量，配额适用于独占服务器的用户。
+
+// 流量用尽时返回的提示订阅，可通过 data/quota_exceeded.yaml 自定义
+const quotaExceededFilename = "quota_exceeded.yaml"
+
+type userQuotaPayload struct {
+	Username         string  `json:"username"`
+	QuotaGB          float64 `json:"quota_gb"`
+	ExceededAction   string  `json:"exceeded_action"`
+	DegradedFilename string  `json:"degraded_filename"`
+}
+
+type userQuotaEntry struct {
+	Username         string    `json:"username"`
+	QuotaGB          float64   `json:"quota_gb"`
+	ExceededAction   string    `json:"exceeded_action"`
+	DegradedFilename string    `json:"degraded_filename,omitempty"`
+	UsedGB           float64   `json:"used_gb"`
+	UsagePercentage  float64   `json:"usage_percentage"`
+	Enforced         bool      `json:"enforced"` // 未开启探针服务器绑定时无法按用户统计，配额不生效
+	Exceeded         bool      `json:"exceeded"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type userQuotaHandler struct {
+	repo    *storage.TrafficRepository
+	summary *TrafficSummaryHandler
+}
+
+// NewUserQuotaHandler manages per-user traffic quotas.
+func NewUserQuotaHandler(repo *storage.TrafficRepository) http.Handler {
+	if repo == nil {
+		panic("user quota handler requires repository")
+	}
+
+	return &userQuotaHandler{repo: repo, summary: NewTrafficSummaryHandler(repo)}
+}
+
+func (h *userQuotaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleList(w, r)
+	case http.MethodPost, http.MethodPut:
+		h.handleSave(w, r)
+	case http.MethodDelete:
+		h.handleDelete(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (h *userQuotaHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	quotas, err := h.repo.ListUserTrafficQuotas(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 一次获取全部探针服务器的用量，再按各用户的绑定过滤
+	var usages []probeServerUsage
+	if len(quotas) > 0 {
+		if usages, err = h.summary.fetchServerUsages(r.Context(), "", nil); err != nil && !errors.Is(err, storage.ErrProbeConfigNotFound) {
+			logger.Warn("[用户配额] 获取探针流量失败", "error", err)
+		}
+	}
+
+	entries := make([]userQuotaEntry, 0, len(quotas))
+	for _, quota := range quotas {
+		bindings, enforced, err := userProbeBindings(r.Context(), h.repo, quota.Username)
+		if err != nil {
+			logger.Warn("[用户配额] 获取用户探针绑定失败", "user", quota.Username, "error", err)
+		}
+		var used int64
+		for _, usage := range usages {
+			if probeUsageBound(usage, bindings) {
+				used += usage.Used
+			}
+		}
+		entries = append(entries, newUserQuotaEntry(quota, used, enforced))
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"quotas": entries})
+}
+
+func (h *userQuotaHandler) handleSave(w http.ResponseWriter, r *http.Request) {
+	var payload userQuotaPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeBadRequest(w, "请求格式不正确")
+		return
+	}
+
+	username := strings.TrimSpace(payload.Username)
+	if username == "" {
+		writeBadRequest(w, "username 不能为空")
+		return
+	}
+	if payload.QuotaGB <= 0 || math.IsNaN(payload.QuotaGB) || math.IsInf(payload.QuotaGB, 0) {
+		writeBadRequest(w, "quota_gb 必须大于 0")
+		return
+	}
+
+	action := strings.TrimSpace(payload.ExceededAction)
+	if action == "" {
+		action = storage.QuotaActionNotice
+	}
+	filename := strings.TrimSpace(payload.DegradedFilename)
+	switch action {
+	case storage.QuotaActionNotice:
+	case storage.QuotaActionSubscribeFile:
+		if filename == "" {
+			writeBadRequest(w, "降级方式为 subscribe_file 时需指定 degraded_filename")
+			return
+		}
+		if _, err := h.repo.GetSubscribeFileByFilename(r.Context(), filename); err != nil {
+			if errors.Is(err, storage.ErrSubscribeFileNotFound) {
+				writeBadRequest(w, fmt.Sprintf("降级订阅文件 %s 不存在", filename))
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	default:
+		writeBadRequest(w, "exceeded_action 仅支持 notice、subscribe_file")
+		return
+	}
+
+	if _, err := h.repo.GetUser(r.Context(), username); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, errors.New("user not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	quota, err := h.repo.SaveUserTrafficQuota(r.Context(), storage.UserTrafficQuota{
+		Username:         username,
+		QuotaBytes:       int64(payload.QuotaGB * bytesPerGigabyte),
+		ExceededAction:   action,
+		DegradedFilename: filename,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	used, enforced, err := h.summary.userQuotaUsage(r.Context(), username)
+	if err != nil {
+		logger.Warn("[用户配额] 获取用户流量失败", "user", username, "error", err)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"quota": newUserQuotaEntry(quota, used, enforced)})
+}
+
+func (h *userQuotaHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	if username == "" {
+		writeBadRequest(w, "username 不能为空")
+		return
+	}
+
+	if err := h.repo.DeleteUserTrafficQuota(r.Context(), username); err != nil {
+		if errors.Is(err, storage.ErrUserTrafficQuotaNotFound) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func newUserQuotaEntry(quota storage.UserTrafficQuota, used int64, enforced bool) userQuotaEntry {
+	return userQuotaEntry{
+		Username:         quota.Username,
+		QuotaGB:          roundUpTwoDecimals(bytesToGigabytes(quota.QuotaBytes)),
+		ExceededAction:   quota.ExceededAction,
+		DegradedFilename: quota.DegradedFilename,
+		UsedGB:           roundUpTwoDecimals(bytesToGigabytes(used)),
+		UsagePercentage:  roundUpTwoDecimals(usagePercentage(used, quota.QuotaBytes)),
+		Enforced:         enforced,
+		Exceeded:         enforced && used >= quota.QuotaBytes,
+		UpdatedAt:        quota.UpdatedAt,
+	}
+}
+
+// userProbeBindings 返回用户节点绑定的探针服务器；未开启探针服务器绑定时 enforced 为 false
+func userProbeBindings(ctx context.Context, repo *storage.TrafficRepository, username string) (map[string]struct{}, bool, error) {
+	settings, err := repo.GetUserSettings(ctx, username)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserSettingsNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if !settings.EnableProbeBinding {
+		return nil, false, nil
+	}
+
+	nodes, err := repo.ListNodes(ctx, username)
+	if err != nil {
+		return nil, false, err
+	}
+	bindings := make(map[string]struct{})
+	for _, node := range nodes {
+		if binding := strings.TrimSpace(node.ProbeServer); binding != "" {
+			bindings[binding] = struct{}{}
+		}
+	}
+	return bindings, true, nil
+}
+
+// probeUsageBound 判断探针服务器是否被绑定；仅有服务器名称的旧绑定匹配任意探针上的同名服务器
+func probeUsageBound(usage probeServerUsage, bindings map[string]struct{}) bool {
+	_, scoped := bindings[usage.Binding()]
+	_, legacy := bindings[usage.Server.Name]
+	return scoped || legacy
+}
+
+// userQuotaUsage 统计用户绑定的探针服务器在当前账期的用量。
+// 返回的是这些服务器的全部流量，包含共用服务器的其他用户产生的流量。
+func (h *TrafficSummaryHandler) userQuotaUsage(ctx context.Context, username string) (int64, bool, error) {
+	usages, enforced, err := h.userQuotaUsages(ctx, username)
+	if err != nil {
+		return 0, enforced, err
+	}
+	var used int64
+	for _, usage := range usages {
+		used += usage.Used
+	}
+	return used, enforced, nil
+}
+
+// userQuotaUsages 获取用户绑定的探针服务器的用量明细
+func (h *TrafficSummaryHandler) userQuotaUsages(ctx context.Context, username string) ([]probeServerUsage, bool, error) {
+	bindings, enforced, err := userProbeBindings(ctx, h.repo, username)
+	if err != nil || !enforced || len(bindings) == 0 {
+		return nil, enforced, err
+	}
+
+	usages, err := h.fetchServerUsages(ctx, username, bindings)
+	if err != nil {
+		return nil, true, err
+	}
+	return usages, true, nil
+}
+
+// trafficQuotaCheck 订阅请求的配额检查结果。
+// 检查时已获取的探针用量随结果保留，生成 subscription-userinfo 时复用，避免同一请求重复查询探针。
+type trafficQuotaCheck struct {
+	quota    storage.UserTrafficQuota
+	used     int64
+	exceeded bool
+
+	usagesFetched bool // 是否已获取用户全部绑定服务器的用量（含获取失败）
+	usages        []probeServerUsage
+	usagesErr     error
+}
+
+// probeUsages 返回订阅文件中使用的探针服务器的用量；allowed 为 nil 时返回全部绑定服务器
+func (c trafficQuotaCheck) probeUsages(allowed map[string]struct{}) ([]probeServerUsage, error) {
+	if c.usagesErr != nil || allowed == nil {
+		return c.usages, c.usagesErr
+	}
+	trimmed := make(map[string]struct{}, len(allowed))
+	for binding := range allowed {
+		if binding = strings.TrimSpace(binding); binding != "" {
+			trimmed[binding] = struct{}{}
+		}
+	}
+	var usages []probeServerUsage
+	for _, usage := range c.usages {
+		if probeUsageBound(usage, trimmed) {
+			usages = append(usages, usage)
+		}
+	}
+	return usages, nil
+}
+
+// checkTrafficQuota 返回用户的配额及当前用量，exceeded 表示订阅需要降级。
+// 统计失败时不限制用户，避免探针不可用导致订阅被降级。
+func (h *SubscriptionHandler) checkTrafficQuota(ctx context.Context, username string) trafficQuotaCheck {
+	if username == "" || h.repo == nil {
+		return trafficQuotaCheck{}
+	}
+
+	quota, err := h.repo.GetUserTrafficQuota(ctx, username)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserTrafficQuotaNotFound) {
+			logger.Warn("[用户配额] 获取用户配额失败", "user", username, "error", err)
+		}
+		return trafficQuotaCheck{}
+	}
+
+	check := trafficQuotaCheck{quota: quota}
+	usages, enforced, err := h.summary.userQuotaUsages(ctx, username)
+	if err != nil {
+		logger.Warn("[用户配额] 统计用户流量失败，不限制订阅", "user", username, "error", err)
+		// 探针查询失败时同样记录，生成响应头时不再重试
+		check.usagesFetched = enforced
+		check.usagesErr = err
+		return check
+	}
+	if !enforced {
+		logger.Info("[用户配额] 用户未开启探针服务器绑定，配额不生效", "user", username)
+		return check
+	}
+
+	check.usagesFetched = true
+	check.usages = usages
+	for _, usage := range usages {
+		check.used += usage.Used
+	}
+	check.exceeded = check.used >= quota.QuotaBytes
+	return check
+}
+
+// serveQuotaExceededResponse 返回流量用尽提示订阅，并在 subscription-userinfo 中展示配额
+func (h *SubscriptionHandler) serveQuotaExceededResponse(w http.ResponseWriter, r *http.Request, quota storage.UserTrafficQuota, used int64) {
+	data := loadNoticeContent(quotaExceededFilename, quotaExceededYAML)
+	w.Header().Set("subscription-userinfo", buildSubscriptionHeader(quota.QuotaBytes, used, nil, nil))
+	h.serveNoticeSubscription(w, r, data, "流量已用尽")
+
+	logger.Info("⚠️⚠️⚠️ [SUB_QUOTA] 用户流量配额已用尽", "user", quota.Username,
+		"quota_gb", roundUpTwoDecimals(bytesToGigabytes(quota.QuotaBytes)),
+		"used_gb", roundUpTwoDecimals(bytesToGigabytes(used)))
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"miaomiaowu/internal/storage"
+)
+
+func TestCheckTrafficQuota(t *testing.T) {
+	const gb = int64(bytesPerGigabyte)
+	tests := []struct {
+		name         string
+		quota        *storage.UserTrafficQuota
+		probeBinding bool
+		probeStatus  int
+		up, down     int64
+		wantUsed     int64
+		wantExceeded bool
+	}{
+		{
+			name:        "no quota",
+			probeStatus: http.StatusOK, probeBinding: true, up: 20 * gb,
+		},
+		{
+			name:  "not enforced without probe binding",
+			quota: &storage.UserTrafficQuota{QuotaBytes: 10 * gb}, probeBinding: false,
+			probeStatus: http.StatusOK, up: 20 * gb,
+		},
+		{
+			// 探针不可用时不限制订阅
+			name:  "probe error fails open",
+			quota: &storage.UserTrafficQuota{QuotaBytes: 10 * gb}, probeBinding: true,
+			probeStatus: http.StatusInternalServerError,
+		},
+		{
+			name:  "within quota",
+			quota: &storage.UserTrafficQuota{QuotaBytes: 10 * gb}, probeBinding: true,
+			probeStatus: http.StatusOK, up: 2 * gb, down: 3 * gb,
+			wantUsed: 5 * gb,
+		},
+		{
+			name:  "exceeded with notice",
+			quota: &storage.UserTrafficQuota{QuotaBytes: 10 * gb, ExceededAction: storage.QuotaActionNotice}, probeBinding: true,
+			probeStatus: http.StatusOK, up: 4 * gb, down: 6 * gb,
+			wantUsed: 10 * gb, wantExceeded: true,
+		},
+		{
+			name: "exceeded with subscribe file",
+			quota: &storage.UserTrafficQuota{
+				QuotaBytes:       10 * gb,
+				ExceededAction:   storage.QuotaActionSubscribeFile,
+				DegradedFilename: "degraded.yaml",
+			},
+			probeBinding: true,
+			probeStatus:  http.StatusOK, up: 8 * gb, down: 4 * gb,
+			wantUsed: 12 * gb, wantExceeded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probeSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.probeStatus != http.StatusOK {
+					w.WriteHeader(tt.probeStatus)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `[{"id":"hk","name":"hk","up":%d,"down":%d}]`, tt.up, tt.down)
+			}))
+			defer probeSrv.Close()
+
+			h := newTestQuotaSubscriptionHandler(t, probeSrv.URL, tt.probeBinding)
+			ctx := context.Background()
+			if tt.quota != nil {
+				quota := *tt.quota
+				quota.Username = "alice"
+				if _, err := h.repo.SaveUserTrafficQuota(ctx, quota); err != nil {
+					t.Fatalf("SaveUserTrafficQuota: %v", err)
+				}
+			}
+
+			check := h.checkTrafficQuota(ctx, "alice")
+			if check.used != tt.wantUsed || check.exceeded != tt.wantExceeded {
+				t.Fatalf("checkTrafficQuota() used=%d exceeded=%v, want used=%d exceeded=%v", check.used, check.exceeded, tt.wantUsed, tt.wantExceeded)
+			}
+			if tt.quota == nil {
+				return
+			}
+			// 开启绑定时保留用量供响应头复用
+			if check.usagesFetched != tt.probeBinding {
+				t.Fatalf("usagesFetched = %v, want %v", check.usagesFetched, tt.probeBinding)
+			}
+			quota := check.quota
+			wantAction := tt.quota.ExceededAction
+			if wantAction == "" {
+				wantAction = storage.QuotaActionNotice
+			}
+			if quota.ExceededAction != wantAction || quota.DegradedFilename != tt.quota.DegradedFilename {
+				t.Fatalf("quota action=%q file=%q, want action=%q file=%q", quota.ExceededAction, quota.DegradedFilename, wantAction, tt.quota.DegradedFilename)
+			}
+		})
+	}
+}
+
+func newTestQuotaSubscriptionHandler(t *testing.T, probeAddress string, probeBinding bool) *SubscriptionHandler {
+	t.Helper()
+	ctx := context.Background()
+	repo, err := storage.NewTrafficRepository(filepath.Join(t.TempDir(), "traffic.db"))
+	if err != nil {
+		t.Fatalf("NewTrafficRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	if err := repo.CreateUser(ctx, "alice", "", "", "hash", storage.RoleUser, ""); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := repo.UpsertUserSettings(ctx, storage.UserSettings{Username: "alice", EnableProbeBinding: probeBinding}); err != nil {
+		t.Fatalf("UpsertUserSettings: %v", err)
+	}
+	if _, err := repo.CreateProbeConfig(ctx, storage.ProbeConfig{
+		Name:      "vps",
+		ProbeType: storage.ProbeTypeJSON,
+		Address:   probeAddress,
+		Options:   map[string]string{"up_field": "up", "down_field": "down"},
+		Servers:   []storage.ProbeServer{{ServerID: "hk", Name: "hk", TrafficMethod: storage.TrafficMethodBoth}},
+	}); err != nil {
+		t.Fatalf("CreateProbeConfig: %v", err)
+	}
+	node, err := repo.CreateNode(ctx, storage.Node{
+		Username:    "alice",
+		RawURL:      "ss://example",
+		NodeName:    "HK",
+		Protocol:    "ss",
+		ClashConfig: `{"name":"HK","type":"ss","server":"1.1.1.1","port":443}`,
+		Enabled:     true,
+	})
+	if err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if err := repo.UpdateNodeProbeServer(ctx, node.ID, "alice", storage.FormatProbeBinding("vps", "hk")); err != nil {
+		t.Fatalf("UpdateNodeProbeServer: %v", err)
+	}
+
+	return &SubscriptionHandler{repo: repo, summary: NewTrafficSummaryHandler(repo)}
+}
+
+func TestTrafficQuotaCheckProbeUsages(t *testing.T) {
+	check := trafficQuotaCheck{
+		usagesFetched: true,
+		usages: []probeServerUsage{
+			{Probe: "vps", Server: storage.ProbeServer{Name: "hk"}, Used: 1},
+			{Probe: "vps", Server: storage.ProbeServer{Name: "jp"}, Used: 2},
+			{Probe: "other", Server: storage.ProbeServer{Name: "us"}, Used: 4},
+		},
+	}
+	tests := []struct {
+		name    string
+		allowed map[string]struct{}
+		want    int64
+	}{
+		{"all bound servers", nil, 7},
+		{"scoped binding", map[string]struct{}{"vps/hk": {}}, 1},
+		{"legacy binding and padding", map[string]struct{}{" jp ": {}, "other/us": {}}, 6},
+		{"no match", map[string]struct{}{"vps/sg": {}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usages, err := check.probeUsages(tt.allowed)
+			if err != nil {
+				t.Fatalf("probeUsages: %v", err)
+			}
+			var used int64
+			for _, usage := range usages {
+				used += usage.Used
+			}
+			if used != tt.want {
+				t.Fatalf("used = %d, want %d", used, tt.want)
+			}
+		})
+	}
+
+	failed := trafficQuotaCheck{usagesFetched: true, usagesErr: errors.New("probe down")}
+	if _, err := failed.probeUsages(map[string]struct{}{"vps/hk": {}}); err == nil {
+		t.Fatal("probeUsages() error = nil, want the probe error")
+	}
+}
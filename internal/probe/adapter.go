@@ -0,0 +1,137 @@
+// Package probe talks to the probe panels (Nezha, DStatus, Komari, ...) that report
+// server traffic. Each panel type is implemented as a ProbeAdapter and registered
+// in an AdapterFactory.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target describes the probe panel an adapter talks to.
+type Target struct {
+	Address string
+	// Options holds adapter specific settings, e.g. PromQL queries or JSON field paths
+	Options map[string]string
+	// ServerIDs lists the servers whose traffic is requested; adapters that always
+	// report every server may ignore it
+	ServerIDs []string
+	// Client is used for HTTP requests; a client with a 15s timeout is used when nil
+	Client *http.Client
+}
+
+// Server is a server discovered on a probe panel.
+type Server struct {
+	ID                  string
+	Name                string
+	MonthlyTrafficBytes int64 // traffic quota configured on the panel, 0 when unknown
+}
+
+// Traffic is the traffic reported by a probe panel for a single server.
+type Traffic struct {
+	ServerID string
+	Up       int64 // cumulative outbound bytes
+	Down     int64 // cumulative inbound bytes
+	// Monthly is set by panels that account monthly traffic themselves (DStatus);
+	// its values are used as-is instead of the raw counters.
+	Monthly *MonthlyTraffic
+}
+
+// MonthlyTraffic is the monthly quota accounting reported by a probe panel.
+type MonthlyTraffic struct {
+	Limit     int64
+	Used      int64
+	Remaining int64
+}
+
+// ProbeAdapter reads servers and traffic from one type of probe panel.
+type ProbeAdapter interface {
+	// GetType returns the probe type (e.g., "nezha", "komari", etc.)
+	GetType() string
+
+	// ListServers discovers the servers available on the panel
+	ListServers(ctx context.Context, target Target) ([]Server, error)
+
+	// FetchTraffic returns the current traffic of the servers on the panel
+	FetchTraffic(ctx context.Context, target Target) ([]Traffic, error)
+}
+
+// AdapterFactory creates and manages probe adapters
+type AdapterFactory struct {
+	adapters map[string]ProbeAdapter
+	mu       sync.RWMutex
+}
+
+// NewAdapterFactory creates a new AdapterFactory
+func NewAdapterFactory() *AdapterFactory {
+	factory := &AdapterFactory{
+		adapters: make(map[string]ProbeAdapter),
+	}
+
+	// Register default adapters
+	factory.Register(NewNezhaAdapter())
+	factory.Register(NewNezhaV0Adapter())
+	factory.Register(NewDstatusAdapter())
+	factory.Register(NewKomariAdapter())
+	factory.Register(NewPrometheusAdapter())
+	factory.Register(NewJSONAdapter())
+
+	return factory
+}
+
+// Register registers an adapter
+func (f *AdapterFactory) Register(adapter ProbeAdapter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.adapters[adapter.GetType()] = adapter
+}
+
+// GetAdapter returns an adapter by probe type
+func (f *AdapterFactory) GetAdapter(probeType string) (ProbeAdapter, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	adapter, ok := f.adapters[probeType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported probe type: %s", probeType)
+	}
+	return adapter, nil
+}
+
+// GetSupportedTypes returns all supported probe types, sorted
+func (f *AdapterFactory) GetSupportedTypes() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	types := make([]string, 0, len(f.adapters))
+	for t := range f.adapters {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Default global factory instance
+var defaultFactory *AdapterFactory
+var once sync.Once
+
+// GetDefaultFactory returns the default global factory
+func GetDefaultFactory() *AdapterFactory {
+	once.Do(func() {
+		defaultFactory = NewAdapterFactory()
+	})
+	return defaultFactory
+}
+
+var defaultClient = &http.Client{Timeout: 15 * time.Second}
+
+func (t Target) httpClient() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return defaultClient
+}
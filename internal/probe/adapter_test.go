@@ -0,0 +1,54 @@
+package probe
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"miaomiaowu/internal/storage"
+)
+
+func TestAdapterFactory(t *testing.T) {
+	factory := NewAdapterFactory()
+
+	want := []string{
+		storage.ProbeTypeDstatus,
+		storage.ProbeTypeJSON,
+		storage.ProbeTypeKomari,
+		storage.ProbeTypeNezha,
+		storage.ProbeTypeNezhaV0,
+		storage.ProbeTypePrometheus,
+	}
+	if got := factory.GetSupportedTypes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSupportedTypes() = %v, want %v", got, want)
+	}
+
+	for _, probeType := range want {
+		adapter, err := factory.GetAdapter(probeType)
+		if err != nil {
+			t.Fatalf("GetAdapter(%q): %v", probeType, err)
+		}
+		if adapter.GetType() != probeType {
+			t.Errorf("GetAdapter(%q).GetType() = %q", probeType, adapter.GetType())
+		}
+	}
+
+	if _, err := factory.GetAdapter("unknown"); err == nil {
+		t.Error("GetAdapter(unknown) should fail")
+	}
+}
+
+func TestFormatServerID(t *testing.T) {
+	cases := map[string]string{
+		"1":     "1",
+		"1.0":   "1",
+		"2e0":   "2",
+		" 7 ":   "7",
+		"abc-1": "abc-1",
+	}
+	for in, want := range cases {
+		if got := formatServerID(json.Number(in)); got != want {
+			t.Errorf("formatServerID(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
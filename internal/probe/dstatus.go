@@ -0,0 +1,162 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// DstatusAdapter reads DStatus, which accounts monthly traffic per server itself
+// and exposes it through /stats/batch-traffic.
+type DstatusAdapter struct{}
+
+// NewDstatusAdapter creates a new DStatus adapter
+func NewDstatusAdapter() *DstatusAdapter {
+	return &DstatusAdapter{}
+}
+
+// GetType returns the probe type
+func (a *DstatusAdapter) GetType() string {
+	return storage.ProbeTypeDstatus
+}
+
+type dstatusBatchTraffic struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Data    map[string]struct {
+		Monthly struct {
+			Limit     json.Number `json:"limit"`
+			Remaining json.Number `json:"remaining"`
+			Used      json.Number `json:"used"`
+		} `json:"monthly"`
+	} `json:"data"`
+}
+
+func (a *DstatusAdapter) batchTraffic(ctx context.Context, target Target, base *url.URL, serverIDs []string) (dstatusBatchTraffic, error) {
+	var result dstatusBatchTraffic
+
+	payload, err := json.Marshal(map[string][]string{"serverIds": serverIDs})
+	if err != nil {
+		return result, err
+	}
+
+	endpoint := base.ResolveReference(&url.URL{Path: "/stats/batch-traffic"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(payload))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "miaomiaowu/0.1")
+
+	body, err := doRequest(target.httpClient(), req)
+	if err != nil {
+		return result, fmt.Errorf("batch traffic request failed: %w", err)
+	}
+	if err := decodeJSON(body, &result); err != nil {
+		return result, fmt.Errorf("parse batch traffic response: %w", err)
+	}
+	return result, nil
+}
+
+// ListServers discovers the servers available on the panel together with their monthly quota
+func (a *DstatusAdapter) ListServers(ctx context.Context, target Target) ([]Server, error) {
+	base, err := parseAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := base.ResolveReference(&url.URL{Path: "/api/servers"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := doRequest(target.httpClient(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload struct {
+		Data []struct {
+			ID   any    `json:"id"`
+			Name string `json:"name"`
+		} `json:"data"`
+	}
+	if err := decodeJSON(body, &payload); err != nil {
+		return nil, fmt.Errorf("parse servers response: %w", err)
+	}
+	if len(payload.Data) == 0 {
+		return nil, errors.New("未从面板获取到服务器列表")
+	}
+
+	servers := make([]Server, 0, len(payload.Data))
+	serverIDs := make([]string, 0, len(payload.Data))
+	for _, item := range payload.Data {
+		id := formatAnyID(item.ID)
+		if id == "" {
+			continue
+		}
+		serverIDs = append(serverIDs, id)
+		servers = append(servers, Server{ID: id, Name: strings.TrimSpace(item.Name)})
+	}
+	for i := range servers {
+		servers[i].Name = serverName(servers[i].Name, i)
+	}
+
+	// 月流量上限为可选信息，获取失败时不影响服务器列表
+	if len(serverIDs) > 0 {
+		stats, err := a.batchTraffic(ctx, target, base, serverIDs)
+		if err != nil {
+			logger.Info("[探针-Dstatus] 获取月流量上限失败", "error", err)
+		} else {
+			for i := range servers {
+				if entry, ok := stats.Data[servers[i].ID]; ok {
+					servers[i].MonthlyTrafficBytes = nonNegative(numberToInt64(entry.Monthly.Limit))
+				}
+			}
+		}
+	}
+
+	return servers, nil
+}
+
+// FetchTraffic returns the monthly traffic accounted by the panel for the requested servers
+func (a *DstatusAdapter) FetchTraffic(ctx context.Context, target Target) ([]Traffic, error) {
+	base, err := parseAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := a.batchTraffic(ctx, target, base, target.ServerIDs)
+	if err != nil {
+		return nil, err
+	}
+	if !stats.Success {
+		if stats.Message != "" {
+			return nil, errors.New(stats.Message)
+		}
+		return nil, errors.New("batch traffic request unsuccessful")
+	}
+
+	traffic := make([]Traffic, 0, len(stats.Data))
+	for serverID, entry := range stats.Data {
+		traffic = append(traffic, Traffic{
+			ServerID: strings.TrimSpace(serverID),
+			Monthly: &MonthlyTraffic{
+				Limit:     numberToInt64(entry.Monthly.Limit),
+				Used:      numberToInt64(entry.Monthly.Used),
+				Remaining: numberToInt64(entry.Monthly.Remaining),
+			},
+		})
+	}
+	return traffic, nil
+}
@@ -0,0 +1,61 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDstatusAdapter(t *testing.T) {
+	srv := newFixtureServer(t,
+		fixtureRoute{Method: http.MethodGet, Path: "/api/servers", Fixture: "dstatus_servers.json"},
+		fixtureRoute{Method: http.MethodPost, Path: "/stats/batch-traffic", Fixture: "dstatus_batch_traffic.json"},
+	)
+	adapter := NewDstatusAdapter()
+
+	servers, err := adapter.ListServers(context.Background(), Target{Address: srv.URL})
+	if err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+	wantServers := []Server{
+		{ID: "a1b2c3d4", Name: "HK-Akile", MonthlyTrafficBytes: 1099511627776},
+		{ID: "42", Name: "服务器 2", MonthlyTrafficBytes: 536870912000},
+	}
+	if !reflect.DeepEqual(servers, wantServers) {
+		t.Errorf("ListServers = %+v, want %+v", servers, wantServers)
+	}
+
+	traffic, err := adapter.FetchTraffic(context.Background(), Target{Address: srv.URL, ServerIDs: []string{"42", "a1b2c3d4"}})
+	if err != nil {
+		t.Fatalf("FetchTraffic: %v", err)
+	}
+	wantTraffic := []Traffic{
+		{ServerID: "42", Monthly: &MonthlyTraffic{Limit: 536870912000, Used: 107374182400, Remaining: 429496729600}},
+		{ServerID: "a1b2c3d4", Monthly: &MonthlyTraffic{Limit: 1099511627776, Used: 214748364800, Remaining: 884763262976}},
+	}
+	if got := sortTraffic(traffic); !reflect.DeepEqual(got, wantTraffic) {
+		t.Errorf("FetchTraffic = %+v, want %+v", got, wantTraffic)
+	}
+
+	requests := srv.Requests()
+	last := requests[len(requests)-1]
+	var body struct {
+		ServerIDs []string `json:"serverIds"`
+	}
+	if err := json.Unmarshal([]byte(last.Body), &body); err != nil {
+		t.Fatalf("decode batch traffic request: %v", err)
+	}
+	if !reflect.DeepEqual(body.ServerIDs, []string{"42", "a1b2c3d4"}) {
+		t.Errorf("batch traffic requested %v", body.ServerIDs)
+	}
+}
+
+func TestDstatusAdapterUnsuccessful(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{Path: "/stats/batch-traffic", Body: []byte(`{"success":false,"message":"服务器不存在"}`)})
+	_, err := NewDstatusAdapter().FetchTraffic(context.Background(), Target{Address: srv.URL, ServerIDs: []string{"x"}})
+	if err == nil || err.Error() != "服务器不存在" {
+		t.Fatalf("FetchTraffic error = %v, want panel message", err)
+	}
+}
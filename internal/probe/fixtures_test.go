@@ -0,0 +1,114 @@
+package probe
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// fixtureRoute replays a recorded panel response for a request path.
+type fixtureRoute struct {
+	Method    string // empty matches any method
+	Path      string
+	Fixture   string // file under testdata
+	Body      []byte // used instead of Fixture when set
+	Status    int    // defaults to 200
+	WebSocket bool   // push the payload as the first websocket message
+}
+
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   string
+}
+
+// fixtureServer is an httptest server that serves recorded panel responses and
+// records the requests it receives.
+type fixtureServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+func loadFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("load fixture %s: %v", name, err)
+	}
+	return data
+}
+
+func newFixtureServer(t *testing.T, routes ...fixtureRoute) *fixtureServer {
+	t.Helper()
+
+	payloads := make([][]byte, len(routes))
+	for i, route := range routes {
+		payloads[i] = route.Body
+		if payloads[i] == nil && route.Fixture != "" {
+			payloads[i] = loadFixture(t, route.Fixture)
+		}
+	}
+
+	srv := &fixtureServer{}
+	upgrader := websocket.Upgrader{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, recordedRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			Header: r.Header.Clone(),
+			Body:   string(body),
+		})
+		srv.mu.Unlock()
+
+		for i, route := range routes {
+			if route.Path != r.URL.Path || (route.Method != "" && route.Method != r.Method) {
+				continue
+			}
+			if route.WebSocket {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				_ = conn.WriteMessage(websocket.TextMessage, payloads[i])
+				return
+			}
+			status := route.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write(payloads[i])
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (s *fixtureServer) Requests() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recordedRequest(nil), s.requests...)
+}
+
+func sortTraffic(traffic []Traffic) []Traffic {
+	sort.Slice(traffic, func(i, j int) bool { return traffic[i].ServerID < traffic[j].ServerID })
+	return traffic
+}
@@ -0,0 +1,275 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"miaomiaowu/internal/storage"
+)
+
+// JSONAdapter reads traffic counters from any JSON endpoint using JSONPath-like field
+// mapping. Paths look like data.servers or stats[0].rx and may start with $.
+//
+// Options:
+//   - servers_path: path to the server list (an array, or an object keyed by server id); empty means the root
+//   - id_field: server id field, defaults to id; the object key is used when the list is an object and the field is missing
+//   - name_field: server name field, defaults to name
+//   - up_field / down_field: cumulative outbound / inbound bytes (at least one is required)
+//   - limit_field: optional monthly quota in bytes, only used for server discovery
+//   - bearer_token: sent as the Authorization header
+type JSONAdapter struct{}
+
+// NewJSONAdapter creates a new generic JSON adapter
+func NewJSONAdapter() *JSONAdapter {
+	return &JSONAdapter{}
+}
+
+// GetType returns the probe type
+func (a *JSONAdapter) GetType() string {
+	return storage.ProbeTypeJSON
+}
+
+type jsonServer struct {
+	Server
+	Up   int64
+	Down int64
+}
+
+// ListServers discovers the servers listed in the document
+func (a *JSONAdapter) ListServers(ctx context.Context, target Target) ([]Server, error) {
+	entries, err := a.fetch(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]Server, 0, len(entries))
+	for _, entry := range entries {
+		servers = append(servers, entry.Server)
+	}
+	return servers, nil
+}
+
+// FetchTraffic returns the mapped counters of the servers listed in the document
+func (a *JSONAdapter) FetchTraffic(ctx context.Context, target Target) ([]Traffic, error) {
+	entries, err := a.fetch(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := make([]Traffic, 0, len(entries))
+	for _, entry := range entries {
+		traffic = append(traffic, Traffic{ServerID: entry.ID, Up: entry.Up, Down: entry.Down})
+	}
+	return traffic, nil
+}
+
+func (a *JSONAdapter) fetch(ctx context.Context, target Target) ([]jsonServer, error) {
+	address := strings.TrimSpace(target.Address)
+	if _, err := parseAddress(address); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req, target)
+
+	body, err := doRequest(target.httpClient(), req)
+	if err != nil {
+		return nil, fmt.Errorf("json probe request failed: %w", err)
+	}
+
+	var document any
+	if err := decodeJSON(body, &document); err != nil {
+		return nil, fmt.Errorf("parse json probe response: %w", err)
+	}
+
+	options := target.Options
+	list, ok := resolveJSONPath(document, options["servers_path"])
+	if !ok {
+		return nil, fmt.Errorf("servers_path %q not found in response", options["servers_path"])
+	}
+
+	type entry struct {
+		key  string
+		item any
+	}
+	var entries []entry
+	switch typed := list.(type) {
+	case []any:
+		for _, item := range typed {
+			entries = append(entries, entry{item: item})
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entries = append(entries, entry{key: key, item: typed[key]})
+		}
+	default:
+		return nil, errors.New("servers_path must point to an array or object")
+	}
+
+	idField := options["id_field"]
+	if idField == "" {
+		idField = "id"
+	}
+	nameField := options["name_field"]
+	if nameField == "" {
+		nameField = "name"
+	}
+
+	servers := make([]jsonServer, 0, len(entries))
+	for _, e := range entries {
+		id := e.key
+		if value, ok := resolveJSONPath(e.item, idField); ok {
+			if text := anyToString(value); text != "" {
+				id = text
+			}
+		}
+		if id == "" {
+			continue
+		}
+
+		srv := jsonServer{Server: Server{ID: id, Name: id}}
+		if value, ok := resolveJSONPath(e.item, nameField); ok {
+			if text := anyToString(value); text != "" {
+				srv.Name = text
+			}
+		}
+		srv.Up = jsonField(e.item, options["up_field"])
+		srv.Down = jsonField(e.item, options["down_field"])
+		srv.MonthlyTrafficBytes = jsonField(e.item, options["limit_field"])
+		servers = append(servers, srv)
+	}
+
+	return servers, nil
+}
+
+func jsonField(item any, path string) int64 {
+	if path == "" {
+		return 0
+	}
+	value, ok := resolveJSONPath(item, path)
+	if !ok {
+		return 0
+	}
+	number, ok := anyToInt64(value)
+	if !ok {
+		return 0
+	}
+	return nonNegative(number)
+}
+
+// resolveJSONPath returns the value at a path such as a.b[0].c or $.a['b'];
+// an empty path or $ returns the root
+func resolveJSONPath(value any, path string) (any, bool) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+
+	current := value
+	for path != "" {
+		if strings.HasPrefix(path, "[") {
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, false
+			}
+			token := strings.Trim(strings.TrimSpace(path[1:end]), `'"`)
+			path = strings.TrimPrefix(path[end+1:], ".")
+
+			switch typed := current.(type) {
+			case []any:
+				idx, err := strconv.Atoi(token)
+				if err != nil || idx < 0 || idx >= len(typed) {
+					return nil, false
+				}
+				current = typed[idx]
+			case map[string]any:
+				next, ok := typed[token]
+				if !ok {
+					return nil, false
+				}
+				current = next
+			default:
+				return nil, false
+			}
+			continue
+		}
+
+		end := strings.IndexAny(path, ".[")
+		key := path
+		if end >= 0 {
+			key = path[:end]
+			path = strings.TrimPrefix(path[end:], ".")
+		} else {
+			path = ""
+		}
+
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		next, ok := object[key]
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+
+	return current, true
+}
+
+// anyToInt64 converts a JSON number or numeric string to whole bytes
+func anyToInt64(value any) (int64, bool) {
+	var f float64
+	switch typed := value.(type) {
+	case json.Number:
+		if v, err := typed.Int64(); err == nil {
+			return v, true
+		}
+		parsed, err := typed.Float64()
+		if err != nil {
+			return 0, false
+		}
+		f = parsed
+	case float64:
+		f = typed
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+		if err != nil {
+			return 0, false
+		}
+		f = parsed
+	default:
+		return 0, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return int64(math.Round(f)), true
+}
+
+func anyToString(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return strings.TrimSpace(typed)
+	case json.Number:
+		return typed.String()
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
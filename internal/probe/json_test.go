@@ -0,0 +1,113 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONAdapterVnstat(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{Path: "/vnstat.json", Fixture: "vnstat.json"})
+	target := Target{
+		Address: srv.URL + "/vnstat.json",
+		Options: map[string]string{
+			"servers_path": "$.interfaces",
+			"id_field":     "name",
+			"name_field":   "alias",
+			"up_field":     "traffic.total.tx",
+			"down_field":   "traffic['total'].rx",
+		},
+	}
+	adapter := NewJSONAdapter()
+
+	servers, err := adapter.ListServers(context.Background(), target)
+	if err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+	wantServers := []Server{{ID: "eth0", Name: "HK-Akile"}, {ID: "wg0", Name: "wg0"}}
+	if !reflect.DeepEqual(servers, wantServers) {
+		t.Errorf("ListServers = %+v, want %+v", servers, wantServers)
+	}
+
+	traffic, err := adapter.FetchTraffic(context.Background(), target)
+	if err != nil {
+		t.Fatalf("FetchTraffic: %v", err)
+	}
+	wantTraffic := []Traffic{
+		{ServerID: "eth0", Up: 107374182400, Down: 53687091200},
+		{ServerID: "wg0", Up: 2147483648, Down: 1073741824},
+	}
+	if !reflect.DeepEqual(traffic, wantTraffic) {
+		t.Errorf("FetchTraffic = %+v, want %+v", traffic, wantTraffic)
+	}
+}
+
+func TestJSONAdapterKeyedObject(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{Path: "/stats", Body: []byte(`{"data":{"nodes":{
+		"hk":{"label":"HK","rx":"300","tx":7,"quota":1073741824},
+		"jp":{"id":"jp-1","label":"JP","rx":1,"tx":2.6}
+	}}}`)})
+	target := Target{
+		Address: srv.URL + "/stats",
+		Options: map[string]string{
+			"servers_path": "data.nodes",
+			"name_field":   "label",
+			"up_field":     "tx",
+			"down_field":   "rx",
+			"limit_field":  "quota",
+		},
+	}
+
+	servers, err := NewJSONAdapter().ListServers(context.Background(), target)
+	if err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+	wantServers := []Server{{ID: "hk", Name: "HK", MonthlyTrafficBytes: 1073741824}, {ID: "jp-1", Name: "JP"}}
+	if !reflect.DeepEqual(servers, wantServers) {
+		t.Errorf("ListServers = %+v, want %+v", servers, wantServers)
+	}
+
+	traffic, err := NewJSONAdapter().FetchTraffic(context.Background(), target)
+	if err != nil {
+		t.Fatalf("FetchTraffic: %v", err)
+	}
+	wantTraffic := []Traffic{{ServerID: "hk", Up: 7, Down: 300}, {ServerID: "jp-1", Up: 3, Down: 1}}
+	if !reflect.DeepEqual(traffic, wantTraffic) {
+		t.Errorf("FetchTraffic = %+v, want %+v", traffic, wantTraffic)
+	}
+
+	target.Options["servers_path"] = "data.missing"
+	if _, err := NewJSONAdapter().FetchTraffic(context.Background(), target); err == nil {
+		t.Error("FetchTraffic should fail when servers_path is missing")
+	}
+}
+
+func TestResolveJSONPath(t *testing.T) {
+	var document any
+	if err := json.Unmarshal([]byte(`{"a":{"b":[{"c":1},{"c":2}],"d.e":3}}`), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path string
+		want any
+		ok   bool
+	}{
+		{"a.b[1].c", float64(2), true},
+		{"$.a.b[0].c", float64(1), true},
+		{"a['d.e']", float64(3), true},
+		{"a.b[2].c", nil, false},
+		{"a.x", nil, false},
+	}
+	for _, c := range cases {
+		got, ok := resolveJSONPath(document, c.path)
+		if ok != c.ok || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("resolveJSONPath(%q) = %v, %v; want %v, %v", c.path, got, ok, c.want, c.ok)
+		}
+	}
+
+	if root, ok := resolveJSONPath(document, "$"); !ok || !reflect.DeepEqual(root, document) {
+		t.Errorf("resolveJSONPath($) should return the root")
+	}
+}
@@ -0,0 +1,178 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"miaomiaowu/internal/storage"
+)
+
+// KomariAdapter reads the Komari panel: servers come from /api/nodes and traffic from
+// the common:getNodesLatestStatus JSON-RPC call.
+type KomariAdapter struct{}
+
+// NewKomariAdapter creates a new Komari adapter
+func NewKomariAdapter() *KomariAdapter {
+	return &KomariAdapter{}
+}
+
+// GetType returns the probe type
+func (a *KomariAdapter) GetType() string {
+	return storage.ProbeTypeKomari
+}
+
+// ListServers discovers the servers available on the panel together with their traffic limit
+func (a *KomariAdapter) ListServers(ctx context.Context, target Target) ([]Server, error) {
+	base, err := parseAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := base.ResolveReference(&url.URL{Path: "/api/nodes"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := doRequest(target.httpClient(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload struct {
+		Data []struct {
+			UUID         string      `json:"uuid"`
+			Name         string      `json:"name"`
+			TrafficLimit json.Number `json:"traffic_limit"`
+		} `json:"data"`
+	}
+	if err := decodeJSON(body, &payload); err != nil {
+		return nil, fmt.Errorf("parse nodes response: %w", err)
+	}
+	if len(payload.Data) == 0 {
+		return nil, errors.New("未从面板获取到服务器列表")
+	}
+
+	servers := make([]Server, 0, len(payload.Data))
+	for i, node := range payload.Data {
+		servers = append(servers, Server{
+			ID:                  strings.TrimSpace(node.UUID),
+			Name:                serverName(node.Name, i),
+			MonthlyTrafficBytes: nonNegative(numberToInt64(node.TrafficLimit)),
+		})
+	}
+	return servers, nil
+}
+
+type komariNodeStatus struct {
+	Time         string      `json:"time"`
+	CPU          json.Number `json:"cpu"`
+	RAM          json.Number `json:"ram"`
+	RAMTotal     json.Number `json:"ram_total"`
+	NetIn        json.Number `json:"net_in"`
+	NetOut       json.Number `json:"net_out"`
+	NetTotalUp   json.Number `json:"net_total_up"`
+	NetTotalDown json.Number `json:"net_total_down"`
+	Uptime       json.Number `json:"uptime"`
+	Online       bool        `json:"online"`
+}
+
+// latestStatus calls common:getNodesLatestStatus, keyed by node uuid
+func (a *KomariAdapter) latestStatus(ctx context.Context, target Target) (map[string]komariNodeStatus, error) {
+	base, err := parseAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	requestBody, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "common:getNodesLatestStatus",
+		"id":      3,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal komari request: %w", err)
+	}
+
+	endpoint := base.ResolveReference(&url.URL{Path: "/api/rpc2"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	body, err := doRequest(target.httpClient(), req)
+	if err != nil {
+		return nil, fmt.Errorf("komari request failed: %w", err)
+	}
+
+	var payload struct {
+		Result map[string]komariNodeStatus `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := decodeJSON(body, &payload); err != nil {
+		return nil, fmt.Errorf("parse komari response: %w", err)
+	}
+	if payload.Error != nil {
+		return nil, fmt.Errorf("komari rpc error: %s", payload.Error.Message)
+	}
+	return payload.Result, nil
+}
+
+// FetchTraffic returns the total up/down counters of all nodes
+func (a *KomariAdapter) FetchTraffic(ctx context.Context, target Target) ([]Traffic, error) {
+	result, err := a.latestStatus(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := make([]Traffic, 0, len(result))
+	for id, info := range result {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		traffic = append(traffic, Traffic{
+			ServerID: id,
+			Up:       nonNegative(numberToInt64(info.NetTotalUp)),
+			Down:     nonNegative(numberToInt64(info.NetTotalDown)),
+		})
+	}
+	return traffic, nil
+}
+
+// FetchStatus returns the live state of all nodes as reported by the panel's online flag
+func (a *KomariAdapter) FetchStatus(ctx context.Context, target Target) ([]ServerStatus, error) {
+	result, err := a.latestStatus(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]ServerStatus, 0, len(result))
+	for id, info := range result {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		statuses = append(statuses, ServerStatus{
+			ServerID:    id,
+			Online:      info.Online,
+			CPU:         numberToFloat64(info.CPU),
+			MemUsed:     nonNegative(numberToInt64(info.RAM)),
+			MemTotal:    nonNegative(numberToInt64(info.RAMTotal)),
+			NetInSpeed:  nonNegative(numberToInt64(info.NetIn)),
+			NetOutSpeed: nonNegative(numberToInt64(info.NetOut)),
+			Uptime:      nonNegative(numberToInt64(info.Uptime)),
+			LastActive:  parseLastActive(info.Time),
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,88 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestKomariAdapter(t *testing.T) {
+	srv := newFixtureServer(t,
+		fixtureRoute{Method: http.MethodGet, Path: "/api/nodes", Fixture: "komari_nodes.json"},
+		fixtureRoute{Method: http.MethodPost, Path: "/api/rpc2", Fixture: "komari_rpc2_latest_status.json"},
+	)
+	target := Target{Address: srv.URL}
+	adapter := NewKomariAdapter()
+
+	servers, err := adapter.ListServers(context.Background(), target)
+	if err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+	wantServers := []Server{
+		{ID: "5c6e1f2a-7d3b-4c8e-9a0f-1b2c3d4e5f60", Name: "HK-Akile", MonthlyTrafficBytes: 1099511627776},
+		{ID: "9f8e7d6c-5b4a-4392-8170-6f5e4d3c2b1a", Name: "服务器 2"},
+	}
+	if !reflect.DeepEqual(servers, wantServers) {
+		t.Errorf("ListServers = %+v, want %+v", servers, wantServers)
+	}
+
+	traffic, err := adapter.FetchTraffic(context.Background(), target)
+	if err != nil {
+		t.Fatalf("FetchTraffic: %v", err)
+	}
+	wantTraffic := []Traffic{
+		{ServerID: "5c6e1f2a-7d3b-4c8e-9a0f-1b2c3d4e5f60", Up: 322122547200, Down: 107374182400},
+		{ServerID: "9f8e7d6c-5b4a-4392-8170-6f5e4d3c2b1a", Up: 12000000000, Down: 8000000000},
+	}
+	if got := sortTraffic(traffic); !reflect.DeepEqual(got, wantTraffic) {
+		t.Errorf("FetchTraffic = %+v, want %+v", got, wantTraffic)
+	}
+
+	requests := srv.Requests()
+	var rpc struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal([]byte(requests[len(requests)-1].Body), &rpc); err != nil {
+		t.Fatalf("decode rpc request: %v", err)
+	}
+	if rpc.Method != "common:getNodesLatestStatus" {
+		t.Errorf("rpc method = %q", rpc.Method)
+	}
+}
+
+func TestKomariAdapterRPCError(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{Path: "/api/rpc2", Body: []byte(`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"Method not found"}}`)})
+	if _, err := NewKomariAdapter().FetchTraffic(context.Background(), Target{Address: srv.URL}); err == nil {
+		t.Fatal("FetchTraffic should surface JSON-RPC errors")
+	}
+}
+
+func TestKomariAdapterStatus(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{Path: "/api/rpc2", Body: []byte(`{"jsonrpc":"2.0","id":3,"result":{` +
+		`"a":{"time":"2025-06-10T16:00:00.123+08:00","cpu":3.2,"ram":312451072,"ram_total":1025400832,"net_in":1024,"net_out":2048,"uptime":1006400,"online":true},` +
+		`"b":{"time":"2025-06-09T16:00:00+08:00","cpu":0,"ram":0,"ram_total":25197707264,"net_in":0,"net_out":0,"uptime":0,"online":false}}}`)})
+
+	statuses, err := NewKomariAdapter().FetchStatus(context.Background(), Target{Address: srv.URL})
+	if err != nil {
+		t.Fatalf("FetchStatus: %v", err)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ServerID < statuses[j].ServerID })
+	if len(statuses) != 2 {
+		t.Fatalf("FetchStatus returned %d servers, want 2", len(statuses))
+	}
+
+	online := statuses[0]
+	if !online.Online || online.CPU != 3.2 || online.MemUsed != 312451072 || online.MemTotal != 1025400832 ||
+		online.NetInSpeed != 1024 || online.NetOutSpeed != 2048 || online.Uptime != 1006400 {
+		t.Errorf("node a = %+v", online)
+	}
+	if online.LastActive == nil || online.LastActive.UnixMilli() != 1749542400123 {
+		t.Errorf("node a last active = %v", online.LastActive)
+	}
+	if statuses[1].Online {
+		t.Errorf("node b should be offline")
+	}
+}
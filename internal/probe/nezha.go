@@ -0,0 +1,138 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"miaomiaowu/internal/storage"
+)
+
+// NezhaAdapter reads the Nezha V1 dashboard, which pushes all servers over /api/v1/ws/server.
+type NezhaAdapter struct{}
+
+// NewNezhaAdapter creates a new Nezha V1 adapter
+func NewNezhaAdapter() *NezhaAdapter {
+	return &NezhaAdapter{}
+}
+
+// GetType returns the probe type
+func (a *NezhaAdapter) GetType() string {
+	return storage.ProbeTypeNezha
+}
+
+type nezhaServer struct {
+	ID   json.Number `json:"id"`
+	Name string      `json:"name"`
+	Host struct {
+		MemTotal json.Number `json:"mem_total"`
+	} `json:"host"`
+	State struct {
+		CPU            json.Number `json:"cpu"`
+		MemUsed        json.Number `json:"mem_used"`
+		NetInTransfer  json.Number `json:"net_in_transfer"`
+		NetOutTransfer json.Number `json:"net_out_transfer"`
+		NetInSpeed     json.Number `json:"net_in_speed"`
+		NetOutSpeed    json.Number `json:"net_out_speed"`
+		Uptime         json.Number `json:"uptime"`
+	} `json:"state"`
+	LastActive string `json:"last_active"`
+}
+
+type nezhaSnapshot struct {
+	Now     json.Number   `json:"now"`
+	Servers []nezhaServer `json:"servers"`
+}
+
+func (a *NezhaAdapter) snapshot(ctx context.Context, target Target) (nezhaSnapshot, error) {
+	var snapshot nezhaSnapshot
+
+	base, err := parseAddress(target.Address)
+	if err != nil {
+		return snapshot, err
+	}
+
+	message, err := readWebSocketMessage(ctx, websocketURL(base, "/api/v1/ws/server"))
+	if err != nil {
+		return snapshot, err
+	}
+
+	if err := decodeSnapshot(message, &snapshot); err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
+
+// ListServers discovers the servers available on the panel
+func (a *NezhaAdapter) ListServers(ctx context.Context, target Target) ([]Server, error) {
+	snapshot, err := a.snapshot(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshot.Servers) == 0 {
+		return nil, errors.New("探针未返回任何服务器数据")
+	}
+
+	servers := make([]Server, 0, len(snapshot.Servers))
+	for i, entry := range snapshot.Servers {
+		servers = append(servers, Server{
+			ID:   formatServerID(entry.ID),
+			Name: serverName(entry.Name, i),
+		})
+	}
+	return servers, nil
+}
+
+// FetchTraffic returns the transfer counters of all servers
+func (a *NezhaAdapter) FetchTraffic(ctx context.Context, target Target) ([]Traffic, error) {
+	snapshot, err := a.snapshot(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := make([]Traffic, 0, len(snapshot.Servers))
+	for _, entry := range snapshot.Servers {
+		id := strings.TrimSpace(formatServerID(entry.ID))
+		if id == "" {
+			continue
+		}
+		traffic = append(traffic, Traffic{
+			ServerID: id,
+			Up:       numberToInt64(entry.State.NetOutTransfer),
+			Down:     numberToInt64(entry.State.NetInTransfer),
+		})
+	}
+	return traffic, nil
+}
+
+// FetchStatus returns the live state of all servers; a server is online when it
+// reported within offlineThreshold of the snapshot time
+func (a *NezhaAdapter) FetchStatus(ctx context.Context, target Target) ([]ServerStatus, error) {
+	snapshot, err := a.snapshot(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	now := snapshotTime(snapshot.Now)
+	statuses := make([]ServerStatus, 0, len(snapshot.Servers))
+	for _, entry := range snapshot.Servers {
+		id := strings.TrimSpace(formatServerID(entry.ID))
+		if id == "" {
+			continue
+		}
+		lastActive := parseLastActive(entry.LastActive)
+		statuses = append(statuses, ServerStatus{
+			ServerID:    id,
+			Online:      activeSince(lastActive, now),
+			CPU:         numberToFloat64(entry.State.CPU),
+			MemUsed:     numberToInt64(entry.State.MemUsed),
+			MemTotal:    numberToInt64(entry.Host.MemTotal),
+			NetInSpeed:  numberToInt64(entry.State.NetInSpeed),
+			NetOutSpeed: numberToInt64(entry.State.NetOutSpeed),
+			Uptime:      numberToInt64(entry.State.Uptime),
+			LastActive:  lastActive,
+		})
+	}
+	return statuses, nil
+}
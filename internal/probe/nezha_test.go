@@ -0,0 +1,98 @@
+package probe
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNezhaAdapter(t *testing.T) {
+	frame := loadFixture(t, "nezha_ws_server.json")
+
+	cases := map[string][]byte{
+		"snapshot": frame,
+		// 部分版本一次推送多帧，使用最后一帧
+		"frames": []byte(`[{"servers":[]},` + string(frame) + `]`),
+	}
+
+	for name, payload := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := newFixtureServer(t, fixtureRoute{Path: "/api/v1/ws/server", Body: payload, WebSocket: true})
+			target := Target{Address: srv.URL}
+			adapter := NewNezhaAdapter()
+
+			servers, err := adapter.ListServers(context.Background(), target)
+			if err != nil {
+				t.Fatalf("ListServers: %v", err)
+			}
+			wantServers := []Server{{ID: "1", Name: "HK-Akile"}, {ID: "2", Name: "JP-Oracle"}}
+			if !reflect.DeepEqual(servers, wantServers) {
+				t.Errorf("ListServers = %+v, want %+v", servers, wantServers)
+			}
+
+			traffic, err := adapter.FetchTraffic(context.Background(), target)
+			if err != nil {
+				t.Fatalf("FetchTraffic: %v", err)
+			}
+			wantTraffic := []Traffic{
+				{ServerID: "1", Up: 107374182400, Down: 53687091200},
+				{ServerID: "2", Up: 2500000000, Down: 1500000000},
+			}
+			if !reflect.DeepEqual(traffic, wantTraffic) {
+				t.Errorf("FetchTraffic = %+v, want %+v", traffic, wantTraffic)
+			}
+		})
+	}
+}
+
+func TestNezhaAdapterUnreachable(t *testing.T) {
+	srv := newFixtureServer(t)
+	if _, err := NewNezhaAdapter().FetchTraffic(context.Background(), Target{Address: srv.URL}); err == nil {
+		t.Fatal("FetchTraffic should fail when the websocket endpoint is missing")
+	}
+}
+
+func TestNezhaAdapterStatus(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{Path: "/api/v1/ws/server", Fixture: "nezha_ws_server.json", WebSocket: true})
+
+	statuses, err := NewNezhaAdapter().FetchStatus(context.Background(), Target{Address: srv.URL})
+	if err != nil {
+		t.Fatalf("FetchStatus: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("FetchStatus returned %d servers, want 2", len(statuses))
+	}
+
+	hk := statuses[0]
+	if hk.ServerID != "1" || !hk.Online {
+		t.Errorf("server 1 = %+v, want online", hk)
+	}
+	if hk.CPU != 1.503006012024048 || hk.MemUsed != 312451072 || hk.MemTotal != 1025400832 {
+		t.Errorf("server 1 load = cpu %v mem %d/%d", hk.CPU, hk.MemUsed, hk.MemTotal)
+	}
+	if hk.NetInSpeed != 1024 || hk.NetOutSpeed != 2048 || hk.Uptime != 1006400 {
+		t.Errorf("server 1 speed = in %d out %d uptime %d", hk.NetInSpeed, hk.NetOutSpeed, hk.Uptime)
+	}
+	if hk.LastActive == nil || hk.LastActive.Unix() != 1718006400 {
+		t.Errorf("server 1 last active = %v", hk.LastActive)
+	}
+}
+
+func TestNezhaAdapterStatusStale(t *testing.T) {
+	// 快照时间比最后上报晚一分钟，视为离线
+	payload := []byte(`{"now":1718006460123,"servers":[{"id":1,"name":"HK","state":{},"last_active":"2024-06-10T16:00:00+08:00"},{"id":2,"name":"JP","state":{},"last_active":"0001-01-01T00:00:00Z"}]}`)
+	srv := newFixtureServer(t, fixtureRoute{Path: "/api/v1/ws/server", Body: payload, WebSocket: true})
+
+	statuses, err := NewNezhaAdapter().FetchStatus(context.Background(), Target{Address: srv.URL})
+	if err != nil {
+		t.Fatalf("FetchStatus: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Online {
+			t.Errorf("server %s should be offline", status.ServerID)
+		}
+	}
+	if statuses[1].LastActive != nil {
+		t.Errorf("zero last_active should be nil, got %v", statuses[1].LastActive)
+	}
+}
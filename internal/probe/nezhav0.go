@@ -0,0 +1,225 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"miaomiaowu/internal/logger"
+	"miaomiaowu/internal/storage"
+)
+
+// NezhaV0Adapter reads the Nezha V0 dashboard through its /api/server endpoint and
+// falls back to the /ws push when the API is unavailable.
+type NezhaV0Adapter struct{}
+
+// NewNezhaV0Adapter creates a new Nezha V0 adapter
+func NewNezhaV0Adapter() *NezhaV0Adapter {
+	return &NezhaV0Adapter{}
+}
+
+// GetType returns the probe type
+func (a *NezhaV0Adapter) GetType() string {
+	return storage.ProbeTypeNezhaV0
+}
+
+type nezhaV0State struct {
+	CPU            json.Number `json:"CPU"`
+	MemUsed        json.Number `json:"MemUsed"`
+	NetInTransfer  json.Number `json:"NetInTransfer"`
+	NetOutTransfer json.Number `json:"NetOutTransfer"`
+	NetInSpeed     json.Number `json:"NetInSpeed"`
+	NetOutSpeed    json.Number `json:"NetOutSpeed"`
+	Uptime         json.Number `json:"Uptime"`
+}
+
+type nezhaV0Host struct {
+	MemTotal json.Number `json:"MemTotal"`
+}
+
+type nezhaV0Server struct {
+	ID         json.Number
+	Name       string
+	State      nezhaV0State
+	Host       nezhaV0Host
+	LastActive *time.Time
+	// Now is the panel time of a websocket snapshot; zero for the HTTP API
+	Now time.Time
+}
+
+func (a *NezhaV0Adapter) servers(ctx context.Context, target Target) ([]nezhaV0Server, error) {
+	base, err := parseAddress(target.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, httpErr := a.serversViaHTTP(ctx, target, base)
+	if httpErr == nil && len(entries) > 0 {
+		return entries, nil
+	}
+
+	// 如果 HTTP 接口失败或没有数据，尝试使用 WebSocket
+	logger.Info("[探针-NezhaV0] HTTP 接口失败，尝试使用 WebSocket 接口", "error", httpErr)
+	entries, wsErr := a.serversViaWebSocket(ctx, base)
+	if wsErr != nil {
+		// WebSocket 也失败了，返回综合错误信息
+		if httpErr != nil {
+			return nil, fmt.Errorf("HTTP 接口失败: %w; WebSocket 接口也失败: %v", httpErr, wsErr)
+		}
+		return nil, fmt.Errorf("HTTP 接口未获取到数据; WebSocket 接口也失败: %v", wsErr)
+	}
+	return entries, nil
+}
+
+func (a *NezhaV0Adapter) serversViaHTTP(ctx context.Context, target Target, base *url.URL) ([]nezhaV0Server, error) {
+	endpoint := base.ResolveReference(&url.URL{Path: "/api/server"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := doRequest(target.httpClient(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload struct {
+		Result []struct {
+			ID         json.Number  `json:"id"`
+			Name       string       `json:"name"`
+			LastActive json.Number  `json:"last_active"`
+			Host       nezhaV0Host  `json:"host"`
+			Status     nezhaV0State `json:"status"`
+		} `json:"result"`
+	}
+	if err := decodeJSON(body, &payload); err != nil {
+		return nil, fmt.Errorf("parse server response: %w", err)
+	}
+
+	entries := make([]nezhaV0Server, 0, len(payload.Result))
+	for _, item := range payload.Result {
+		entries = append(entries, nezhaV0Server{
+			ID:         item.ID,
+			Name:       item.Name,
+			State:      item.Status,
+			Host:       item.Host,
+			LastActive: parseLastActive(item.LastActive),
+		})
+	}
+	return entries, nil
+}
+
+func (a *NezhaV0Adapter) serversViaWebSocket(ctx context.Context, base *url.URL) ([]nezhaV0Server, error) {
+	message, err := readWebSocketMessage(ctx, websocketURL(base, "/ws"))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot struct {
+		Now     json.Number `json:"now"`
+		Servers []struct {
+			ID         json.Number  `json:"id"`
+			Name       string       `json:"name"`
+			LastActive string       `json:"LastActive"`
+			Host       nezhaV0Host  `json:"Host"`
+			State      nezhaV0State `json:"State"`
+		} `json:"servers"`
+	}
+	if err := decodeSnapshot(message, &snapshot); err != nil {
+		return nil, err
+	}
+	if len(snapshot.Servers) == 0 {
+		return nil, errors.New("探针未返回任何服务器数据")
+	}
+
+	now := snapshotTime(snapshot.Now)
+	entries := make([]nezhaV0Server, 0, len(snapshot.Servers))
+	for _, item := range snapshot.Servers {
+		entries = append(entries, nezhaV0Server{
+			ID:         item.ID,
+			Name:       item.Name,
+			State:      item.State,
+			Host:       item.Host,
+			LastActive: parseLastActive(item.LastActive),
+			Now:        now,
+		})
+	}
+	return entries, nil
+}
+
+// ListServers discovers the servers available on the panel
+func (a *NezhaV0Adapter) ListServers(ctx context.Context, target Target) ([]Server, error) {
+	entries, err := a.servers(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]Server, 0, len(entries))
+	for i, entry := range entries {
+		servers = append(servers, Server{
+			ID:   formatServerID(entry.ID),
+			Name: serverName(entry.Name, i),
+		})
+	}
+	return servers, nil
+}
+
+// FetchTraffic returns the transfer counters of all servers
+func (a *NezhaV0Adapter) FetchTraffic(ctx context.Context, target Target) ([]Traffic, error) {
+	entries, err := a.servers(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := make([]Traffic, 0, len(entries))
+	for _, entry := range entries {
+		id := strings.TrimSpace(formatServerID(entry.ID))
+		if id == "" {
+			continue
+		}
+		traffic = append(traffic, Traffic{
+			ServerID: id,
+			Up:       numberToInt64(entry.State.NetOutTransfer),
+			Down:     numberToInt64(entry.State.NetInTransfer),
+		})
+	}
+	return traffic, nil
+}
+
+// FetchStatus returns the live state of all servers; a server is online when it
+// reported within offlineThreshold
+func (a *NezhaV0Adapter) FetchStatus(ctx context.Context, target Target) ([]ServerStatus, error) {
+	entries, err := a.servers(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]ServerStatus, 0, len(entries))
+	for _, entry := range entries {
+		id := strings.TrimSpace(formatServerID(entry.ID))
+		if id == "" {
+			continue
+		}
+		now := entry.Now
+		if now.IsZero() {
+			now = timeNow()
+		}
+		statuses = append(statuses, ServerStatus{
+			ServerID:    id,
+			Online:      activeSince(entry.LastActive, now),
+			CPU:         numberToFloat64(entry.State.CPU),
+			MemUsed:     numberToInt64(entry.State.MemUsed),
+			MemTotal:    numberToInt64(entry.Host.MemTotal),
+			NetInSpeed:  numberToInt64(entry.State.NetInSpeed),
+			NetOutSpeed: numberToInt64(entry.State.NetOutSpeed),
+			Uptime:      numberToInt64(entry.State.Uptime),
+			LastActive:  entry.LastActive,
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,113 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNezhaV0Adapter(t *testing.T) {
+	wantServers := []Server{{ID: "1", Name: "HK-Akile"}, {ID: "3", Name: "US-LA"}}
+	wantTraffic := []Traffic{
+		{ServerID: "1", Up: 21474836480, Down: 10737418240},
+		{ServerID: "3", Up: 4294967296, Down: 3221225472},
+	}
+
+	cases := map[string][]fixtureRoute{
+		"http": {
+			{Method: http.MethodGet, Path: "/api/server", Fixture: "nezhav0_api_server.json"},
+		},
+		// API 需要令牌时回退到 WebSocket 推送
+		"websocket fallback": {
+			{Path: "/api/server", Body: []byte(`{"code":403,"message":"该页面需要登录"}`), Status: http.StatusForbidden},
+			{Path: "/ws", Fixture: "nezhav0_ws.json", WebSocket: true},
+		},
+	}
+
+	for name, routes := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := newFixtureServer(t, routes...)
+			target := Target{Address: srv.URL}
+			adapter := NewNezhaV0Adapter()
+
+			servers, err := adapter.ListServers(context.Background(), target)
+			if err != nil {
+				t.Fatalf("ListServers: %v", err)
+			}
+			if !reflect.DeepEqual(servers, wantServers) {
+				t.Errorf("ListServers = %+v, want %+v", servers, wantServers)
+			}
+
+			traffic, err := adapter.FetchTraffic(context.Background(), target)
+			if err != nil {
+				t.Fatalf("FetchTraffic: %v", err)
+			}
+			if !reflect.DeepEqual(traffic, wantTraffic) {
+				t.Errorf("FetchTraffic = %+v, want %+v", traffic, wantTraffic)
+			}
+		})
+	}
+}
+
+func TestNezhaV0AdapterBothEndpointsFail(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{Path: "/api/server", Body: []byte(`{}`), Status: http.StatusBadGateway})
+	if _, err := NewNezhaV0Adapter().FetchTraffic(context.Background(), Target{Address: srv.URL}); err == nil {
+		t.Fatal("FetchTraffic should fail when both HTTP and websocket fail")
+	}
+}
+
+func TestNezhaV0AdapterStatus(t *testing.T) {
+	cases := map[string]struct {
+		routes []fixtureRoute
+		now    time.Time
+		online bool
+	}{
+		"http": {
+			routes: []fixtureRoute{{Path: "/api/server", Fixture: "nezhav0_api_server.json"}},
+			now:    time.Unix(1718006410, 0),
+			online: true,
+		},
+		"http stale": {
+			routes: []fixtureRoute{{Path: "/api/server", Fixture: "nezhav0_api_server.json"}},
+			now:    time.Unix(1718006400, 0).Add(time.Hour),
+		},
+		// WebSocket 快照自带面板时间，不依赖本地时钟
+		"websocket": {
+			routes: []fixtureRoute{
+				{Path: "/api/server", Body: []byte(`{}`), Status: http.StatusForbidden},
+				{Path: "/ws", Fixture: "nezhav0_ws.json", WebSocket: true},
+			},
+			now:    time.Unix(1718006400, 0).Add(time.Hour),
+			online: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+			timeNow = func() time.Time { return tc.now }
+
+			srv := newFixtureServer(t, tc.routes...)
+			statuses, err := NewNezhaV0Adapter().FetchStatus(context.Background(), Target{Address: srv.URL})
+			if err != nil {
+				t.Fatalf("FetchStatus: %v", err)
+			}
+			if len(statuses) != 2 {
+				t.Fatalf("FetchStatus returned %d servers, want 2", len(statuses))
+			}
+
+			us := statuses[1]
+			if us.ServerID != "3" || us.Online != tc.online {
+				t.Errorf("server 3 = %+v, want online=%v", us, tc.online)
+			}
+			if us.CPU != 0.3 || us.MemUsed != 209715200 || us.MemTotal != 536870912 {
+				t.Errorf("server 3 load = cpu %v mem %d/%d", us.CPU, us.MemUsed, us.MemTotal)
+			}
+			if us.NetInSpeed != 100 || us.NetOutSpeed != 200 || us.Uptime != 2006400 {
+				t.Errorf("server 3 speed = in %d out %d uptime %d", us.NetInSpeed, us.NetOutSpeed, us.Uptime)
+			}
+		})
+	}
+}
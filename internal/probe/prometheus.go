@@ -0,0 +1,167 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"miaomiaowu/internal/storage"
+)
+
+// PrometheusAdapter reads traffic counters from a Prometheus server with configurable PromQL.
+//
+// Options:
+//   - up_query / down_query: PromQL returning one series per server (at least one is required), e.g.
+//     sum by (instance) (node_network_transmit_bytes_total{device="eth0"})
+//   - id_label: label used as the server id, defaults to instance
+//   - name_label: label used as the server name, defaults to id_label
+//   - bearer_token: sent as the Authorization header
+type PrometheusAdapter struct{}
+
+// NewPrometheusAdapter creates a new Prometheus adapter
+func NewPrometheusAdapter() *PrometheusAdapter {
+	return &PrometheusAdapter{}
+}
+
+// GetType returns the probe type
+func (a *PrometheusAdapter) GetType() string {
+	return storage.ProbeTypePrometheus
+}
+
+type prometheusSample struct {
+	Metric map[string]string
+	Value  int64
+}
+
+// ListServers discovers the servers returned by the configured queries
+func (a *PrometheusAdapter) ListServers(ctx context.Context, target Target) ([]Server, error) {
+	servers, _, err := a.collect(ctx, target)
+	return servers, err
+}
+
+// FetchTraffic returns the values of the configured queries per server
+func (a *PrometheusAdapter) FetchTraffic(ctx context.Context, target Target) ([]Traffic, error) {
+	_, traffic, err := a.collect(ctx, target)
+	return traffic, err
+}
+
+func (a *PrometheusAdapter) collect(ctx context.Context, target Target) ([]Server, []Traffic, error) {
+	idLabel := target.Options["id_label"]
+	if idLabel == "" {
+		idLabel = "instance"
+	}
+	nameLabel := target.Options["name_label"]
+	if nameLabel == "" {
+		nameLabel = idLabel
+	}
+
+	var (
+		servers []Server
+		traffic []Traffic
+		index   = make(map[string]int)
+	)
+	collect := func(query string, assign func(*Traffic, int64)) error {
+		if query == "" {
+			return nil
+		}
+		samples, err := a.query(ctx, target, query)
+		if err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			id := strings.TrimSpace(sample.Metric[idLabel])
+			if id == "" {
+				continue
+			}
+			idx, ok := index[id]
+			if !ok {
+				name := strings.TrimSpace(sample.Metric[nameLabel])
+				if name == "" {
+					name = id
+				}
+				idx = len(servers)
+				index[id] = idx
+				servers = append(servers, Server{ID: id, Name: name})
+				traffic = append(traffic, Traffic{ServerID: id})
+			}
+			// 同一服务器出现多条序列时累加
+			assign(&traffic[idx], sample.Value)
+		}
+		return nil
+	}
+
+	if err := collect(target.Options["up_query"], func(t *Traffic, v int64) { t.Up += v }); err != nil {
+		return nil, nil, err
+	}
+	if err := collect(target.Options["down_query"], func(t *Traffic, v int64) { t.Down += v }); err != nil {
+		return nil, nil, err
+	}
+
+	return servers, traffic, nil
+}
+
+// query runs an instant query; the result must be a vector with one series per server
+func (a *PrometheusAdapter) query(ctx context.Context, target Target, query string) ([]prometheusSample, error) {
+	base, err := parseAddress(strings.TrimRight(strings.TrimSpace(target.Address), "/"))
+	if err != nil {
+		return nil, err
+	}
+	endpoint := base.JoinPath("/api/v1/query")
+	endpoint.RawQuery = url.Values{"query": {query}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req, target)
+
+	body, err := doRequest(target.httpClient(), req)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus query failed: %w", err)
+	}
+
+	var payload struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Metric map[string]string `json:"metric"`
+				Value  []any             `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("parse prometheus response: %w", err)
+	}
+	if payload.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s", payload.Error)
+	}
+	if payload.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("unsupported prometheus result type: %s", payload.Data.ResultType)
+	}
+
+	samples := make([]prometheusSample, 0, len(payload.Data.Result))
+	for _, item := range payload.Data.Result {
+		if len(item.Value) != 2 {
+			continue
+		}
+		value, ok := anyToInt64(item.Value[1])
+		if !ok {
+			continue
+		}
+		samples = append(samples, prometheusSample{Metric: item.Metric, Value: value})
+	}
+	return samples, nil
+}
+
+// authorize sets the Authorization header from the bearer_token option
+func authorize(req *http.Request, target Target) {
+	req.Header.Set("Accept", "application/json")
+	if token := target.Options["bearer_token"]; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
@@ -0,0 +1,87 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPrometheusAdapter(t *testing.T) {
+	const (
+		upQuery   = `sum by (instance, nodename) (node_network_transmit_bytes_total{device="eth0"})`
+		downQuery = `sum by (instance, nodename) (node_network_receive_bytes_total{device="eth0"})`
+	)
+
+	fixtures := map[string]string{
+		upQuery:   "prometheus_transmit.json",
+		downQuery: "prometheus_receive.json",
+	}
+	payloads := make(map[string][]byte, len(fixtures))
+	for query, name := range fixtures {
+		payloads[query] = loadFixture(t, name)
+	}
+
+	// 查询参数决定返回哪份录制数据
+	var received []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prometheus/api/v1/query" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.NotFound(w, r)
+			return
+		}
+		received = append(received, r.URL.Query())
+		_, _ = w.Write(payloads[r.URL.Query().Get("query")])
+	}))
+	defer srv.Close()
+
+	target := Target{
+		Address: srv.URL + "/prometheus/",
+		Options: map[string]string{
+			"up_query":     upQuery,
+			"down_query":   downQuery,
+			"name_label":   "nodename",
+			"bearer_token": "secret",
+		},
+	}
+	adapter := NewPrometheusAdapter()
+
+	servers, err := adapter.ListServers(context.Background(), target)
+	if err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+	wantServers := []Server{{ID: "hk-akile:9100", Name: "HK-Akile"}, {ID: "jp-oracle:9100", Name: "JP-Oracle"}}
+	if !reflect.DeepEqual(servers, wantServers) {
+		t.Errorf("ListServers = %+v, want %+v", servers, wantServers)
+	}
+
+	traffic, err := adapter.FetchTraffic(context.Background(), target)
+	if err != nil {
+		t.Fatalf("FetchTraffic: %v", err)
+	}
+	// 多条序列累加，NaN 样本被忽略
+	wantTraffic := []Traffic{
+		{ServerID: "hk-akile:9100", Up: 107374182400, Down: 53687091200},
+		{ServerID: "jp-oracle:9100", Up: 3000000000},
+	}
+	if !reflect.DeepEqual(traffic, wantTraffic) {
+		t.Errorf("FetchTraffic = %+v, want %+v", traffic, wantTraffic)
+	}
+
+	if len(received) != 4 || received[0].Get("query") != upQuery || received[1].Get("query") != downQuery {
+		t.Errorf("unexpected queries: %v", received)
+	}
+}
+
+func TestPrometheusAdapterQueryError(t *testing.T) {
+	srv := newFixtureServer(t, fixtureRoute{
+		Path:   "/api/v1/query",
+		Body:   []byte(`{"status":"error","errorType":"bad_data","error":"invalid parameter \"query\": 1:5: parse error: unexpected <EOF>"}`),
+		Status: http.StatusBadRequest,
+	})
+	_, err := NewPrometheusAdapter().FetchTraffic(context.Background(), Target{Address: srv.URL, Options: map[string]string{"up_query": "sum("}})
+	if err == nil {
+		t.Fatal("FetchTraffic should fail on query errors")
+	}
+}
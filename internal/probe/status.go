@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// offlineThreshold is how long a server may go without reporting before it is considered offline.
+const offlineThreshold = 30 * time.Second
+
+// timeNow is replaced in tests to evaluate last-active timestamps deterministically.
+var timeNow = time.Now
+
+// ServerStatus is the live state of a server as reported by the panel.
+type ServerStatus struct {
+	ServerID    string
+	Online      bool
+	CPU         float64 // percent
+	MemUsed     int64
+	MemTotal    int64
+	NetInSpeed  int64 // inbound bytes per second
+	NetOutSpeed int64 // outbound bytes per second
+	Uptime      int64 // seconds
+	LastActive  *time.Time
+}
+
+// StatusAdapter is implemented by adapters whose panel exposes live server state
+// (online, CPU, memory and bandwidth) next to the traffic counters.
+type StatusAdapter interface {
+	FetchStatus(ctx context.Context, target Target) ([]ServerStatus, error)
+}
+
+// activeSince reports whether lastActive lies within offlineThreshold of now.
+func activeSince(lastActive *time.Time, now time.Time) bool {
+	return lastActive != nil && now.Sub(*lastActive) <= offlineThreshold
+}
+
+// parseLastActive parses RFC3339 timestamps and unix seconds; zero values yield nil.
+func parseLastActive(value any) *time.Time {
+	var parsed time.Time
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(v))
+		if err != nil {
+			return nil
+		}
+		parsed = t
+	case json.Number:
+		seconds, err := v.Int64()
+		if err != nil || seconds <= 0 {
+			return nil
+		}
+		parsed = time.Unix(seconds, 0)
+	default:
+		return nil
+	}
+	if parsed.IsZero() || parsed.Year() <= 1 {
+		return nil
+	}
+	return &parsed
+}
+
+// snapshotTime returns the panel time of a websocket snapshot (unix milliseconds),
+// falling back to the local clock.
+func snapshotTime(now json.Number) time.Time {
+	if ms, err := now.Int64(); err == nil && ms > 0 {
+		return time.UnixMilli(ms)
+	}
+	return timeNow()
+}
+
+func numberToFloat64(value json.Number) float64 {
+	f, err := value.Float64()
+	if err != nil {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,197 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const maxResponseBytes = 16 << 20
+
+func parseAddress(address string) (*url.URL, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, errors.New("invalid probe address")
+	}
+	base, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid probe address: %w", err)
+	}
+	return base, nil
+}
+
+// websocketURL resolves path against base with the scheme switched to ws/wss
+func websocketURL(base *url.URL, path string) string {
+	wsBase := *base // 复制以避免修改原始 URL
+	switch strings.ToLower(wsBase.Scheme) {
+	case "", "http":
+		wsBase.Scheme = "ws"
+	case "https":
+		wsBase.Scheme = "wss"
+	case "ws", "wss":
+		// keep as is
+	default:
+		wsBase.Scheme = "wss"
+	}
+	return wsBase.ResolveReference(&url.URL{Path: path}).String()
+}
+
+// readWebSocketMessage connects to target and returns the first message pushed by the panel
+func readWebSocketMessage(ctx context.Context, target string) ([]byte, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(dialCtx, target, nil)
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("无法连接到 WebSocket 接口: 状态码=%d: %w", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("无法连接到 WebSocket 接口: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, fmt.Errorf("set websocket deadline: %w", err)
+	}
+
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("未在期望时间内收到服务器数据: %w", err)
+	}
+
+	message = bytes.TrimSpace(message)
+	if len(message) == 0 {
+		return nil, errors.New("empty probe websocket payload")
+	}
+	return message, nil
+}
+
+// decodeSnapshot decodes a websocket payload that is either a single snapshot or an
+// array of frames, in which case the last frame is used
+func decodeSnapshot(message []byte, v any) error {
+	if message[0] == '[' {
+		var frames []json.RawMessage
+		if err := json.Unmarshal(message, &frames); err != nil {
+			return fmt.Errorf("解析探针返回数据失败: %w", err)
+		}
+		if len(frames) == 0 {
+			return errors.New("探针未返回任何服务器数据")
+		}
+		message = frames[len(frames)-1]
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("解析探针返回数据失败: %w", err)
+	}
+	return nil
+}
+
+// doRequest sends req and returns the response body, failing on non-200 statuses
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务器接口返回异常: 状态码=%d, 响应=%s", resp.StatusCode, preview(body))
+	}
+	return body, nil
+}
+
+func decodeJSON(body []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// preview truncates a payload for logs and error messages
+func preview(body []byte) string {
+	text := string(body)
+	if len(text) > 500 {
+		text = text[:500] + "...(截断)"
+	}
+	return text
+}
+
+// formatServerID normalizes numeric server ids such as 1, 1.0 or 1e0 to "1"
+func formatServerID(id json.Number) string {
+	if v, err := id.Int64(); err == nil {
+		return strconv.FormatInt(v, 10)
+	}
+	raw := strings.TrimSpace(id.String())
+	if strings.ContainsAny(raw, ".eE") {
+		if f, err := id.Float64(); err == nil {
+			return strconv.FormatInt(int64(math.Round(f)), 10)
+		}
+	}
+	return raw
+}
+
+// formatAnyID converts an id decoded into an interface value to a string
+func formatAnyID(id any) string {
+	switch v := id.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return strings.TrimSpace(v.String())
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return strings.TrimSpace(fmt.Sprintf("%v", v))
+	}
+}
+
+// numberToInt64 converts a JSON number to an integer, rounding fractions
+func numberToInt64(n json.Number) int64 {
+	if n == "" {
+		return 0
+	}
+	if v, err := n.Int64(); err == nil {
+		return v
+	}
+	if f, err := n.Float64(); err == nil {
+		if f < 0 {
+			return int64(f - 0.5)
+		}
+		return int64(f + 0.5)
+	}
+	return 0
+}
+
+func nonNegative(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+// serverName falls back to a positional name when the panel does not report one
+func serverName(name string, idx int) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Sprintf("服务器 %d", idx+1)
+	}
+	return name
+}
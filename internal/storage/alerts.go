@@ -0,0 +1,570 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alert rule types for external subscriptions
+const (
+	AlertRuleExpiry    = "expiry"     // subscription expires within Threshold days
+	AlertRuleQuota     = "quota"      // more than Threshold percent of the traffic quota is used
+	AlertRuleSyncStale = "sync_stale" // no successful sync for Threshold hours
+)
+
+// Alert notifier types
+const (
+	AlertNotifierWebhook  = "webhook"
+	AlertNotifierTelegram = "telegram"
+	AlertNotifierSMTP     = "smtp"
+)
+
+// Alert delivery statuses
+const (
+	AlertStatusPending = "pending"
+	AlertStatusSent    = "sent"
+	AlertStatusPartial = "partial" // at least one notifier failed
+	AlertStatusFailed  = "failed"
+)
+
+// maxAlertEvents limits how many alert history rows are kept
+const maxAlertEvents = 1000
+
+// Retry delays of alerts whose delivery failed on every notifier
+const (
+	alertRetryBase = 5 * time.Minute
+	alertRetryMax  = 6 * time.Hour
+)
+
+// alertClaimLease is how long a claimed alert stays pending before another evaluation may claim it again.
+// It outlasts one evaluation including delivery, so only alerts abandoned by a crash or restart are reclaimed.
+const alertClaimLease = 10 * time.Minute
+
+// AlertNotifier is a destination alerts are delivered to
+type AlertNotifier struct {
+	ID        int64
+	Name      string
+	Type      string            // webhook, telegram or smtp
+	BaseURL   string            // webhook URL, Telegram Bot API base URL or SMTP server (smtp://host:port, smtps://host:port)
+	Config    map[string]string // type specific settings, e.g. bot_token/chat_id or from/to/username/password
+	Enabled   bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AlertRule describes when an external subscription alert is raised
+type AlertRule struct {
+	ID             int64
+	Name           string
+	Type           string  // expiry, quota or sync_stale
+	Threshold      float64 // days, percent or hours depending on Type
+	SubscriptionID int64   // 0 applies the rule to every external subscription
+	NotifierIDs    []int64
+	Enabled        bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// AlertEvent is one deduplicated alert in the history
+type AlertEvent struct {
+	ID               int64
+	RuleID           int64
+	RuleName         string
+	RuleType         string
+	SubscriptionID   int64
+	SubscriptionName string
+	Username         string
+	DedupeKey        string // unique per rule, subscription and condition period
+	Message          string
+	Status           string
+	Error            string
+	CreatedAt        time.Time
+	SentAt           *time.Time
+}
+
+var (
+	ErrAlertNotifierNotFound = errors.New("alert notifier not found")
+	ErrAlertRuleNotFound     = errors.New("alert rule not found")
+)
+
+const alertNotifierColumns = `id, name, type, base_url, config, enabled, created_at, updated_at`
+
+const alertRuleColumns = `id, name, type, threshold, subscription_id, notifier_ids, enabled, created_at, updated_at`
+
+// ListAlertNotifiers returns all alert notifiers ordered by id
+func (r *TrafficRepository) ListAlertNotifiers(ctx context.Context) ([]AlertNotifier, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+alertNotifierColumns+` FROM alert_notifiers ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("list alert notifiers: %w", err)
+	}
+	defer rows.Close()
+
+	var notifiers []AlertNotifier
+	for rows.Next() {
+		n, err := scanAlertNotifier(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan alert notifier: %w", err)
+		}
+		notifiers = append(notifiers, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate alert notifiers: %w", err)
+	}
+
+	return notifiers, nil
+}
+
+// GetAlertNotifier retrieves an alert notifier by id
+func (r *TrafficRepository) GetAlertNotifier(ctx context.Context, id int64) (AlertNotifier, error) {
+	if r == nil || r.db == nil {
+		return AlertNotifier{}, errors.New("traffic repository not initialized")
+	}
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+alertNotifierColumns+` FROM alert_notifiers WHERE id = ?`, id)
+	n, err := scanAlertNotifier(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AlertNotifier{}, ErrAlertNotifierNotFound
+		}
+		return AlertNotifier{}, fmt.Errorf("get alert notifier: %w", err)
+	}
+	return n, nil
+}
+
+// CreateAlertNotifier stores a new alert notifier
+func (r *TrafficRepository) CreateAlertNotifier(ctx context.Context, n AlertNotifier) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("traffic repository not initialized")
+	}
+	if err := validateAlertNotifier(&n); err != nil {
+		return 0, err
+	}
+	configJSON, err := marshalAlertNotifierConfig(n.Config)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO alert_notifiers (name, type, base_url, config, enabled)
+		VALUES (?, ?, ?, ?, ?)
+	`, n.Name, n.Type, n.BaseURL, configJSON, boolToInt(n.Enabled))
+	if err != nil {
+		return 0, fmt.Errorf("create alert notifier: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// UpdateAlertNotifier updates an existing alert notifier
+func (r *TrafficRepository) UpdateAlertNotifier(ctx context.Context, n AlertNotifier) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+	if err := validateAlertNotifier(&n); err != nil {
+		return err
+	}
+	configJSON, err := marshalAlertNotifierConfig(n.Config)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE alert_notifiers
+		SET name = ?, type = ?, base_url = ?, config = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, n.Name, n.Type, n.BaseURL, configJSON, boolToInt(n.Enabled), n.ID)
+	if err != nil {
+		return fmt.Errorf("update alert notifier: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update alert notifier rows affected: %w", err)
+	}
+	if affected == 0 {
+		return ErrAlertNotifierNotFound
+	}
+	return nil
+}
+
+// DeleteAlertNotifier removes an alert notifier; rules keep working with their remaining notifiers
+func (r *TrafficRepository) DeleteAlertNotifier(ctx context.Context, id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM alert_notifiers WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete alert notifier: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete alert notifier rows affected: %w", err)
+	}
+	if affected == 0 {
+		return ErrAlertNotifierNotFound
+	}
+	return nil
+}
+
+// ListAlertRules returns all alert rules ordered by id
+func (r *TrafficRepository) ListAlertRules(ctx context.Context) ([]AlertRule, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("list alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []AlertRule
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan alert rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate alert rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// GetAlertRule retrieves an alert rule by id
+func (r *TrafficRepository) GetAlertRule(ctx context.Context, id int64) (AlertRule, error) {
+	if r == nil || r.db == nil {
+		return AlertRule{}, errors.New("traffic repository not initialized")
+	}
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = ?`, id)
+	rule, err := scanAlertRule(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AlertRule{}, ErrAlertRuleNotFound
+		}
+		return AlertRule{}, fmt.Errorf("get alert rule: %w", err)
+	}
+	return rule, nil
+}
+
+// CreateAlertRule stores a new alert rule
+func (r *TrafficRepository) CreateAlertRule(ctx context.Context, rule AlertRule) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("traffic repository not initialized")
+	}
+	if err := validateAlertRule(&rule); err != nil {
+		return 0, err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO alert_rules (name, type, threshold, subscription_id, notifier_ids, enabled)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, rule.Name, rule.Type, rule.Threshold, rule.SubscriptionID, formatAlertNotifierIDs(rule.NotifierIDs), boolToInt(rule.Enabled))
+	if err != nil {
+		return 0, fmt.Errorf("create alert rule: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// UpdateAlertRule updates an existing alert rule
+func (r *TrafficRepository) UpdateAlertRule(ctx context.Context, rule AlertRule) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+	if err := validateAlertRule(&rule); err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE alert_rules
+		SET name = ?, type = ?, threshold = ?, subscription_id = ?, notifier_ids = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rule.Name, rule.Type, rule.Threshold, rule.SubscriptionID, formatAlertNotifierIDs(rule.NotifierIDs), boolToInt(rule.Enabled), rule.ID)
+	if err != nil {
+		return fmt.Errorf("update alert rule: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update alert rule rows affected: %w", err)
+	}
+	if affected == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return nil
+}
+
+// DeleteAlertRule removes an alert rule; its history is kept
+func (r *TrafficRepository) DeleteAlertRule(ctx context.Context, id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete alert rule: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete alert rule rows affected: %w", err)
+	}
+	if affected == 0 {
+		return ErrAlertRuleNotFound
+	}
+	return nil
+}
+
+// ClaimAlertEvent records a pending alert unless one with the same dedupe key already exists.
+// A failed alert is claimed again once its retry time has passed, a pending one once its claim lease has expired.
+// Returns false when the alert was already raised (or is waiting to be retried) and must not be sent now.
+func (r *TrafficRepository) ClaimAlertEvent(ctx context.Context, event AlertEvent, now time.Time) (int64, bool, error) {
+	if r == nil || r.db == nil {
+		return 0, false, errors.New("traffic repository not initialized")
+	}
+	if strings.TrimSpace(event.DedupeKey) == "" {
+		return 0, false, errors.New("dedupe key is required")
+	}
+
+	// A pending row keeps its lease expiry in next_retry_at; rows claimed before leases existed have none
+	now = now.UTC()
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO alert_events (rule_id, rule_name, rule_type, subscription_id, subscription_name, username, dedupe_key, message, status, next_retry_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(dedupe_key) DO UPDATE SET
+			message = excluded.message,
+			status = excluded.status,
+			error = '',
+			attempts = alert_events.attempts + 1,
+			next_retry_at = excluded.next_retry_at
+		WHERE (alert_events.status = ? AND alert_events.next_retry_at <= ?)
+		   OR (alert_events.status = ? AND (alert_events.next_retry_at IS NULL OR alert_events.next_retry_at <= ?))
+		RETURNING id
+	`, event.RuleID, event.RuleName, event.RuleType, event.SubscriptionID, event.SubscriptionName, event.Username,
+		event.DedupeKey, event.Message, AlertStatusPending, now.Add(alertClaimLease),
+		AlertStatusFailed, now, AlertStatusPending, now).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("claim alert event: %w", err)
+	}
+	return id, true, nil
+}
+
+// RecordAlertEventResult stores the delivery outcome of an alert.
+// A failed alert is scheduled for retry with exponential backoff.
+func (r *TrafficRepository) RecordAlertEventResult(ctx context.Context, id int64, status, errMsg string, now time.Time) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	now = now.UTC()
+	var sentAt, nextRetryAt any
+	switch status {
+	case AlertStatusSent, AlertStatusPartial:
+		sentAt = now
+	case AlertStatusFailed:
+		var attempts int
+		if err := r.db.QueryRowContext(ctx, `SELECT attempts FROM alert_events WHERE id = ?`, id).Scan(&attempts); err != nil {
+			return fmt.Errorf("query alert event attempts: %w", err)
+		}
+		nextRetryAt = now.Add(alertRetryDelay(attempts))
+	}
+	if _, err := r.db.ExecContext(ctx, `UPDATE alert_events SET status = ?, error = ?, sent_at = ?, next_retry_at = ? WHERE id = ?`,
+		status, errMsg, sentAt, nextRetryAt, id); err != nil {
+		return fmt.Errorf("record alert event result: %w", err)
+	}
+	return nil
+}
+
+// alertRetryDelay returns the wait before retrying an alert that failed attempts times
+func alertRetryDelay(attempts int) time.Duration {
+	delay := alertRetryBase
+	for i := 1; i < attempts && delay < alertRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, alertRetryMax)
+}
+
+// PruneAlertEvents keeps the most recent alert history rows.
+// Rows of still active conditions are kept regardless of age: their dedupe keys stop the alert from firing again.
+func (r *TrafficRepository) PruneAlertEvents(ctx context.Context, activeDedupeKeys []string) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	if activeDedupeKeys == nil {
+		activeDedupeKeys = []string{} // null 会让 NOT IN 恒为 NULL
+	}
+	active, err := json.Marshal(activeDedupeKeys)
+	if err != nil {
+		return fmt.Errorf("encode active dedupe keys: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, `
+		DELETE FROM alert_events
+		WHERE id NOT IN (SELECT id FROM alert_events ORDER BY id DESC LIMIT ?)
+		  AND dedupe_key NOT IN (SELECT value FROM json_each(?))
+	`, maxAlertEvents, string(active)); err != nil {
+		return fmt.Errorf("prune alert events: %w", err)
+	}
+	return nil
+}
+
+// ListAlertEvents returns the most recent alerts, newest first
+func (r *TrafficRepository) ListAlertEvents(ctx context.Context, limit int) ([]AlertEvent, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+	if limit <= 0 || limit > maxAlertEvents {
+		limit = maxAlertEvents
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, rule_id, rule_name, rule_type, subscription_id, subscription_name, username, dedupe_key, message, status, error, created_at, sent_at
+		FROM alert_events
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list alert events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []AlertEvent
+	for rows.Next() {
+		var e AlertEvent
+		var sentAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.RuleID, &e.RuleName, &e.RuleType, &e.SubscriptionID, &e.SubscriptionName, &e.Username,
+			&e.DedupeKey, &e.Message, &e.Status, &e.Error, &e.CreatedAt, &sentAt); err != nil {
+			return nil, fmt.Errorf("scan alert event: %w", err)
+		}
+		if sentAt.Valid {
+			t := sentAt.Time
+			e.SentAt = &t
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate alert events: %w", err)
+	}
+
+	return events, nil
+}
+
+func validateAlertNotifier(n *AlertNotifier) error {
+	n.Name = strings.TrimSpace(n.Name)
+	n.BaseURL = strings.TrimSpace(n.BaseURL)
+	if n.Name == "" {
+		return errors.New("notifier name is required")
+	}
+	switch n.Type {
+	case AlertNotifierWebhook, AlertNotifierSMTP:
+		if n.BaseURL == "" {
+			return fmt.Errorf("base url is required for %s notifier", n.Type)
+		}
+	case AlertNotifierTelegram:
+	default:
+		return fmt.Errorf("unsupported notifier type %q", n.Type)
+	}
+	return nil
+}
+
+func validateAlertRule(rule *AlertRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return errors.New("rule name is required")
+	}
+	switch rule.Type {
+	case AlertRuleExpiry, AlertRuleSyncStale:
+		if rule.Threshold <= 0 {
+			return errors.New("threshold must be greater than 0")
+		}
+	case AlertRuleQuota:
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return errors.New("quota threshold must be between 0 and 100")
+		}
+	default:
+		return fmt.Errorf("unsupported alert rule type %q", rule.Type)
+	}
+	if rule.SubscriptionID < 0 {
+		return errors.New("subscription id must not be negative")
+	}
+	return nil
+}
+
+func marshalAlertNotifierConfig(config map[string]string) (string, error) {
+	if len(config) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("marshal notifier config: %w", err)
+	}
+	return string(data), nil
+}
+
+func formatAlertNotifierIDs(ids []int64) string {
+	parts := make([]string, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if id <= 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseAlertNotifierIDs(raw string) []int64 {
+	ids := make([]int64, 0)
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func scanAlertNotifier(scanner rowScanner) (AlertNotifier, error) {
+	var n AlertNotifier
+	var configJSON string
+	var enabled int
+	if err := scanner.Scan(&n.ID, &n.Name, &n.Type, &n.BaseURL, &configJSON, &enabled, &n.CreatedAt, &n.UpdatedAt); err != nil {
+		return n, err
+	}
+	n.Enabled = enabled != 0
+	n.Config = map[string]string{}
+	if configJSON != "" {
+		if err := json.Unmarshal([]byte(configJSON), &n.Config); err != nil {
+			return n, fmt.Errorf("decode notifier config: %w", err)
+		}
+	}
+	return n, nil
+}
+
+func scanAlertRule(scanner rowScanner) (AlertRule, error) {
+	var rule AlertRule
+	var notifierIDs string
+	var enabled int
+	if err := scanner.Scan(&rule.ID, &rule.Name, &rule.Type, &rule.Threshold, &rule.SubscriptionID, &notifierIDs, &enabled,
+		&rule.CreatedAt, &rule.UpdatedAt); err != nil {
+		return rule, err
+	}
+	rule.Enabled = enabled != 0
+	rule.NotifierIDs = parseAlertNotifierIDs(notifierIDs)
+	return rule, nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Node change actions recorded for an external subscription sync
+const (
+	NodeChangeAdded   = "added"   // new upstream node saved to the node table
+	NodeChangeMissing = "missing" // node from this subscription no longer present upstream; kept in the node table
+	NodeChangeRenamed = "renamed" // matched node whose name changed
+	NodeChangeUpdated = "updated" // matched node whose configuration changed
+	NodeChangeSkipped = "skipped" // new upstream node not saved (sync scope is saved_only)
+)
+
+// maxChangeSetsPerSubscription limits how many change sets are kept per subscription
+const maxChangeSetsPerSubscription = 50
+
+// NodeFieldChange is a single field difference of a node's Clash config
+type NodeFieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// NodeChange describes what happened to one node during a sync
+type NodeChange struct {
+	Action   string            `json:"action"`
+	NodeName string            `json:"node_name"`
+	OldName  string            `json:"old_name,omitempty"`
+	Fields   []NodeFieldChange `json:"fields,omitempty"`
+}
+
+// ExternalSubscriptionChangeSet is the node change set produced by one external subscription sync
+type ExternalSubscriptionChangeSet struct {
+	ID             int64
+	SubscriptionID int64
+	Username       string
+	Added          int
+	Missing        int
+	Renamed        int
+	Updated        int
+	Skipped        int
+	Unchanged      int
+	Changes        []NodeChange
+	CreatedAt      time.Time
+}
+
+// HasChanges reports whether the sync changed anything worth recording.
+// Skipped nodes are reported again on every sync and do not count.
+func (cs ExternalSubscriptionChangeSet) HasChanges() bool {
+	return cs.Added+cs.Missing+cs.Renamed+cs.Updated > 0
+}
+
+var ErrChangeSetNotFound = errors.New("change set not found")
+
+// CreateExternalSubscriptionChangeSet stores a change set and prunes old ones of the same subscription.
+func (r *TrafficRepository) CreateExternalSubscriptionChangeSet(ctx context.Context, cs ExternalSubscriptionChangeSet) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("traffic repository not initialized")
+	}
+	if cs.SubscriptionID <= 0 {
+		return 0, errors.New("subscription id is required")
+	}
+	username := strings.TrimSpace(cs.Username)
+	if username == "" {
+		return 0, errors.New("username is required")
+	}
+
+	changes := cs.Changes
+	if changes == nil {
+		changes = []NodeChange{}
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return 0, fmt.Errorf("marshal node changes: %w", err)
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO external_subscription_changes (subscription_id, username, added, missing, renamed, updated, skipped, unchanged, changes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cs.SubscriptionID, username, cs.Added, cs.Missing, cs.Renamed, cs.Updated, cs.Skipped, cs.Unchanged, string(changesJSON))
+	if err != nil {
+		return 0, fmt.Errorf("create external subscription change set: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("get last insert id: %w", err)
+	}
+
+	const pruneStmt = `DELETE FROM external_subscription_changes WHERE subscription_id = ? AND id NOT IN (
+		SELECT id FROM external_subscription_changes WHERE subscription_id = ? ORDER BY id DESC LIMIT ?)`
+	if _, err := r.db.ExecContext(ctx, pruneStmt, cs.SubscriptionID, cs.SubscriptionID, maxChangeSetsPerSubscription); err != nil {
+		return id, fmt.Errorf("prune external subscription change sets: %w", err)
+	}
+
+	return id, nil
+}
+
+// ListExternalSubscriptionChangeSets returns the most recent change sets of a subscription, newest first.
+func (r *TrafficRepository) ListExternalSubscriptionChangeSets(ctx context.Context, subscriptionID int64, username string, limit int) ([]ExternalSubscriptionChangeSet, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+	if limit <= 0 || limit > maxChangeSetsPerSubscription {
+		limit = maxChangeSetsPerSubscription
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, subscription_id, username, added, missing, renamed, updated, skipped, unchanged, changes, created_at
+		FROM external_subscription_changes
+		WHERE subscription_id = ? AND username = ?
+		ORDER BY id DESC LIMIT ?`, subscriptionID, username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list external subscription change sets: %w", err)
+	}
+	defer rows.Close()
+
+	var sets []ExternalSubscriptionChangeSet
+	for rows.Next() {
+		cs, err := scanExternalSubscriptionChangeSet(rows)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, cs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate external subscription change sets: %w", err)
+	}
+	return sets, nil
+}
+
+// GetExternalSubscriptionChangeSet returns a single change set owned by the user.
+func (r *TrafficRepository) GetExternalSubscriptionChangeSet(ctx context.Context, id int64, username string) (ExternalSubscriptionChangeSet, error) {
+	if r == nil || r.db == nil {
+		return ExternalSubscriptionChangeSet{}, errors.New("traffic repository not initialized")
+	}
+
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, subscription_id, username, added, missing, renamed, updated, skipped, unchanged, changes, created_at
+		FROM external_subscription_changes WHERE id = ? AND username = ?`, id, username)
+	cs, err := scanExternalSubscriptionChangeSet(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return cs, ErrChangeSetNotFound
+		}
+		return cs, err
+	}
+	return cs, nil
+}
+
+func scanExternalSubscriptionChangeSet(scanner rowScanner) (ExternalSubscriptionChangeSet, error) {
+	var cs ExternalSubscriptionChangeSet
+	var changesJSON string
+	if err := scanner.Scan(&cs.ID, &cs.SubscriptionID, &cs.Username, &cs.Added, &cs.Missing, &cs.Renamed, &cs.Updated, &cs.Skipped, &cs.Unchanged, &changesJSON, &cs.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return cs, err
+		}
+		return cs, fmt.Errorf("scan external subscription change set: %w", err)
+	}
+	if err := json.Unmarshal([]byte(changesJSON), &cs.Changes); err != nil {
+		return cs, fmt.Errorf("unmarshal node changes: %w", err)
+	}
+	return cs, nil
+}
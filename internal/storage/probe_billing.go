@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ProbeServerCycle tracks the raw probe counter of a server within its current billing cycle.
+// Used traffic is the delta since the cycle start: Accumulated + (LastRaw - Baseline).
+type ProbeServerCycle struct {
+	ConfigID           int64
+	ServerID           string
+	CycleStart         time.Time
+	Baseline           int64 // raw counter at cycle start, or 0 after the probe counter was reset
+	LastRaw            int64 // last observed raw counter
+	Accumulated        int64 // usage collected before the probe counter was reset within the cycle
+	PreviousCycleStart *time.Time
+	PreviousUsed       int64 // billed usage of the previous cycle, used for rollover
+	Partial            bool  // the cycle started before the first observation and no snapshot at CycleStart was found
+	UpdatedAt          time.Time
+}
+
+// GetProbeServerCycle returns the billing cycle state of a probe server; ok is false when none is stored yet.
+func (r *TrafficRepository) GetProbeServerCycle(ctx context.Context, configID int64, serverID string) (ProbeServerCycle, bool, error) {
+	if r == nil || r.db == nil {
+		return ProbeServerCycle{}, false, errors.New("traffic repository not initialized")
+	}
+
+	var (
+		cycle         ProbeServerCycle
+		previousStart sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx, `
+		SELECT config_id, server_id, cycle_start, baseline, last_raw, accumulated, previous_cycle_start, previous_used, partial, updated_at
+		FROM probe_server_cycles
+		WHERE config_id = ? AND server_id = ?
+	`, configID, serverID).Scan(&cycle.ConfigID, &cycle.ServerID, &cycle.CycleStart, &cycle.Baseline, &cycle.LastRaw,
+		&cycle.Accumulated, &previousStart, &cycle.PreviousUsed, &cycle.Partial, &cycle.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProbeServerCycle{}, false, nil
+		}
+		return ProbeServerCycle{}, false, fmt.Errorf("get probe server cycle: %w", err)
+	}
+	if previousStart.Valid {
+		t := previousStart.Time
+		cycle.PreviousCycleStart = &t
+	}
+
+	return cycle, true, nil
+}
+
+// SaveProbeServerCycle stores the billing cycle state of a probe server.
+func (r *TrafficRepository) SaveProbeServerCycle(ctx context.Context, cycle ProbeServerCycle) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	var previousStart any
+	if cycle.PreviousCycleStart != nil {
+		previousStart = *cycle.PreviousCycleStart
+	}
+
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO probe_server_cycles (config_id, server_id, cycle_start, baseline, last_raw, accumulated, previous_cycle_start, previous_used, partial, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(config_id, server_id) DO UPDATE SET
+			cycle_start = excluded.cycle_start,
+			baseline = excluded.baseline,
+			last_raw = excluded.last_raw,
+			accumulated = excluded.accumulated,
+			previous_cycle_start = excluded.previous_cycle_start,
+			previous_used = excluded.previous_used,
+			partial = excluded.partial,
+			updated_at = CURRENT_TIMESTAMP
+	`, cycle.ConfigID, cycle.ServerID, cycle.CycleStart, cycle.Baseline, cycle.LastRaw, cycle.Accumulated, previousStart, cycle.PreviousUsed, boolToInt(cycle.Partial)); err != nil {
+		return fmt.Errorf("save probe server cycle: %w", err)
+	}
+
+	return nil
+}
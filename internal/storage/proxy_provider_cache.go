@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ProxyProviderCacheSnapshot is the last successfully fetched node list of an MMW proxy provider
+type ProxyProviderCacheSnapshot struct {
+	ConfigID  int64
+	YAMLData  []byte
+	Prefix    string
+	NodeCount int
+	Interval  int
+	FetchedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SaveProxyProviderCacheSnapshot stores (or replaces) the cache snapshot of a proxy provider config.
+func (r *TrafficRepository) SaveProxyProviderCacheSnapshot(ctx context.Context, snap ProxyProviderCacheSnapshot) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+	if snap.ConfigID <= 0 {
+		return errors.New("proxy provider config id is required")
+	}
+	if snap.FetchedAt.IsZero() {
+		snap.FetchedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO proxy_provider_cache (config_id, yaml_data, prefix, node_count, interval, fetched_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(config_id) DO UPDATE SET
+			yaml_data = excluded.yaml_data,
+			prefix = excluded.prefix,
+			node_count = excluded.node_count,
+			interval = excluded.interval,
+			fetched_at = excluded.fetched_at,
+			updated_at = CURRENT_TIMESTAMP`,
+		snap.ConfigID, snap.YAMLData, snap.Prefix, snap.NodeCount, snap.Interval, snap.FetchedAt)
+	if err != nil {
+		return fmt.Errorf("save proxy provider cache snapshot: %w", err)
+	}
+	return nil
+}
+
+// ListProxyProviderCacheSnapshots returns all stored cache snapshots.
+func (r *TrafficRepository) ListProxyProviderCacheSnapshots(ctx context.Context) ([]ProxyProviderCacheSnapshot, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT config_id, yaml_data, prefix, node_count, interval, fetched_at, updated_at
+		FROM proxy_provider_cache ORDER BY config_id`)
+	if err != nil {
+		return nil, fmt.Errorf("list proxy provider cache snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snaps []ProxyProviderCacheSnapshot
+	for rows.Next() {
+		var snap ProxyProviderCacheSnapshot
+		if err := rows.Scan(&snap.ConfigID, &snap.YAMLData, &snap.Prefix, &snap.NodeCount, &snap.Interval, &snap.FetchedAt, &snap.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan proxy provider cache snapshot: %w", err)
+		}
+		snaps = append(snaps, snap)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate proxy provider cache snapshots: %w", err)
+	}
+	return snaps, nil
+}
+
+// DeleteProxyProviderCacheSnapshot removes the cache snapshot of a proxy provider config.
+func (r *TrafficRepository) DeleteProxyProviderCacheSnapshot(ctx context.Context, configID int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM proxy_provider_cache WHERE config_id = ?`, configID); err != nil {
+		return fmt.Errorf("delete proxy provider cache snapshot: %w", err)
+	}
+	return nil
+}
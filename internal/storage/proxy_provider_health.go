@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// proxyProviderHealthHistoryRetention 节点探测历史保留时长
+const proxyProviderHealthHistoryRetention = 7 * 24 * time.Hour
+
+// ProxyProviderNodeProbe is the outcome of probing one proxy provider node in a health-check round
+type ProxyProviderNodeProbe struct {
+	NodeKey  string // type|server:port, stable across renames
+	NodeName string
+	Probe    string // tcp or tls
+	Success  bool
+	Latency  float64 // milliseconds
+	Error    string
+}
+
+// ProxyProviderNodeHealth is the current health state of a proxy provider node
+type ProxyProviderNodeHealth struct {
+	ConfigID            int64
+	NodeKey             string
+	NodeName            string
+	Probe               string
+	ConsecutiveFailures int
+	TotalChecks         int
+	TotalFailures       int
+	LastLatency         float64
+	LastError           string
+	LastCheckedAt       *time.Time
+	LastSuccessAt       *time.Time
+}
+
+// ProxyProviderNodeHealthSample is one entry of a node's probe history
+type ProxyProviderNodeHealthSample struct {
+	Success   bool
+	Latency   float64
+	Error     string
+	CheckedAt time.Time
+}
+
+// RecordProxyProviderHealthRound stores one health-check round of a proxy provider.
+// Nodes missing from the round are considered gone and their state is removed.
+func (r *TrafficRepository) RecordProxyProviderHealthRound(ctx context.Context, configID int64, probes []ProxyProviderNodeProbe, checkedAt time.Time) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+	if configID <= 0 {
+		return errors.New("config id is required")
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin health round tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	upsert, err := tx.PrepareContext(ctx, `
+		INSERT INTO proxy_provider_node_health (
+			config_id, node_key, node_name, probe, consecutive_failures, total_checks, total_failures,
+			last_latency, last_error, last_checked_at, last_success_at
+		) VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?)
+		ON CONFLICT(config_id, node_key) DO UPDATE SET
+			node_name = excluded.node_name,
+			probe = excluded.probe,
+			consecutive_failures = CASE WHEN excluded.consecutive_failures = 0 THEN 0 ELSE consecutive_failures + 1 END,
+			total_checks = total_checks + 1,
+			total_failures = total_failures + excluded.total_failures,
+			last_latency = excluded.last_latency,
+			last_error = excluded.last_error,
+			last_checked_at = excluded.last_checked_at,
+			last_success_at = COALESCE(excluded.last_success_at, last_success_at)
+	`)
+	if err != nil {
+		return fmt.Errorf("prepare upsert node health: %w", err)
+	}
+	defer upsert.Close()
+
+	insertSample, err := tx.PrepareContext(ctx, `
+		INSERT INTO proxy_provider_node_health_history (config_id, node_key, success, latency, error, checked_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("prepare insert node health sample: %w", err)
+	}
+	defer insertSample.Close()
+
+	keys := make([]any, 0, len(probes)+1)
+	keys = append(keys, configID)
+	for _, probe := range probes {
+		failures := 1
+		var successAt any
+		if probe.Success {
+			failures = 0
+			successAt = checkedAt
+		}
+		if _, err := upsert.ExecContext(ctx, configID, probe.NodeKey, probe.NodeName, probe.Probe, failures, failures,
+			probe.Latency, probe.Error, checkedAt, successAt); err != nil {
+			return fmt.Errorf("upsert node health %s: %w", probe.NodeKey, err)
+		}
+		if _, err := insertSample.ExecContext(ctx, configID, probe.NodeKey, boolToInt(probe.Success), probe.Latency, probe.Error, checkedAt); err != nil {
+			return fmt.Errorf("insert node health sample %s: %w", probe.NodeKey, err)
+		}
+		keys = append(keys, probe.NodeKey)
+	}
+
+	// 清理本轮不再出现的节点及过期的探测历史
+	staleFilter := "config_id = ?"
+	if len(probes) > 0 {
+		staleFilter += " AND node_key NOT IN (?" + strings.Repeat(", ?", len(probes)-1) + ")"
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM proxy_provider_node_health WHERE `+staleFilter, keys...); err != nil {
+		return fmt.Errorf("delete stale node health: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM proxy_provider_node_health_history WHERE `+staleFilter, keys...); err != nil {
+		return fmt.Errorf("delete stale node health history: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM proxy_provider_node_health_history WHERE config_id = ? AND checked_at < ?
+	`, configID, checkedAt.Add(-proxyProviderHealthHistoryRetention)); err != nil {
+		return fmt.Errorf("prune node health history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit health round: %w", err)
+	}
+	return nil
+}
+
+// ListProxyProviderNodeHealth returns the current health state of all nodes of a proxy provider
+func (r *TrafficRepository) ListProxyProviderNodeHealth(ctx context.Context, configID int64) ([]ProxyProviderNodeHealth, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT config_id, node_key, node_name, probe, consecutive_failures, total_checks, total_failures,
+			last_latency, last_error, last_checked_at, last_success_at
+		FROM proxy_provider_node_health
+		WHERE config_id = ?
+		ORDER BY node_name
+	`, configID)
+	if err != nil {
+		return nil, fmt.Errorf("list proxy provider node health: %w", err)
+	}
+	defer rows.Close()
+
+	var states []ProxyProviderNodeHealth
+	for rows.Next() {
+		var h ProxyProviderNodeHealth
+		var checkedAt, successAt sql.NullTime
+		if err := rows.Scan(&h.ConfigID, &h.NodeKey, &h.NodeName, &h.Probe, &h.ConsecutiveFailures, &h.TotalChecks, &h.TotalFailures,
+			&h.LastLatency, &h.LastError, &checkedAt, &successAt); err != nil {
+			return nil, fmt.Errorf("scan proxy provider node health: %w", err)
+		}
+		if checkedAt.Valid {
+			t := checkedAt.Time
+			h.LastCheckedAt = &t
+		}
+		if successAt.Valid {
+			t := successAt.Time
+			h.LastSuccessAt = &t
+		}
+		states = append(states, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate proxy provider node health: %w", err)
+	}
+
+	return states, nil
+}
+
+// ListProxyProviderNodeHealthHistory returns the latest probe samples of a node, newest first
+func (r *TrafficRepository) ListProxyProviderNodeHealthHistory(ctx context.Context, configID int64, nodeKey string, limit int) ([]ProxyProviderNodeHealthSample, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT success, latency, error, checked_at
+		FROM proxy_provider_node_health_history
+		WHERE config_id = ? AND node_key = ?
+		ORDER BY checked_at DESC, id DESC
+		LIMIT ?
+	`, configID, nodeKey, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list node health history: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []ProxyProviderNodeHealthSample
+	for rows.Next() {
+		var s ProxyProviderNodeHealthSample
+		var success int
+		if err := rows.Scan(&success, &s.Latency, &s.Error, &s.CheckedAt); err != nil {
+			return nil, fmt.Errorf("scan node health history: %w", err)
+		}
+		s.Success = success != 0
+		samples = append(samples, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate node health history: %w", err)
+	}
+
+	return samples, nil
+}
+
+// deleteProxyProviderNodeHealth removes health state and history of a deleted proxy provider
+func (r *TrafficRepository) deleteProxyProviderNodeHealth(ctx context.Context, configID int64) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM proxy_provider_node_health WHERE config_id = ?`, configID); err != nil {
+		return fmt.Errorf("delete proxy provider node health: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM proxy_provider_node_health_history WHERE config_id = ?`, configID); err != nil {
+		return fmt.Errorf("delete proxy provider node health history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RuleSetMirror represents a rule-provider URL mirrored into local storage
+type RuleSetMirror struct {
+	ID            int64
+	URL           string
+	Name          string // rule-provider name as first seen in a template/config
+	Behavior      string // domain, ipcidr or classical
+	Format        string // yaml, text or mrs
+	LocalPath     string // path of the mirrored file on disk (empty until first fetch)
+	Size          int64
+	RuleCount     int // -1 when unknown (e.g. mrs binary)
+	ETag          string
+	LastModified  string
+	ContentHash   string
+	LastFetchedAt *time.Time
+	LastCheckedAt *time.Time
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// RuleSetFetchResult is the outcome of one mirror refresh
+type RuleSetFetchResult struct {
+	LocalPath    string
+	Size         int64
+	RuleCount    int
+	ETag         string
+	LastModified string
+	ContentHash  string
+	NotModified  bool // upstream answered 304, only last_checked_at is updated
+	Err          error
+}
+
+var ErrRuleSetMirrorNotFound = errors.New("rule set mirror not found")
+
+const ruleSetMirrorColumns = `id, url, name, behavior, format, local_path, size, rule_count, etag, last_modified,
+       content_hash, last_fetched_at, last_checked_at, last_error, created_at, updated_at`
+
+// UpsertRuleSetMirror registers a rule-provider URL for mirroring.
+// Existing rows keep their fetch state; name/behavior/format are refreshed.
+func (r *TrafficRepository) UpsertRuleSetMirror(ctx context.Context, m RuleSetMirror) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("traffic repository not initialized")
+	}
+
+	m.URL = strings.TrimSpace(m.URL)
+	if m.URL == "" {
+		return 0, errors.New("rule set url is required")
+	}
+	if m.Behavior == "" {
+		m.Behavior = "classical"
+	}
+	if m.Format == "" {
+		m.Format = "yaml"
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO rule_set_mirrors (url, name, behavior, format)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			name = excluded.name,
+			behavior = excluded.behavior,
+			format = excluded.format,
+			updated_at = CURRENT_TIMESTAMP
+	`, m.URL, m.Name, m.Behavior, m.Format)
+	if err != nil {
+		return 0, fmt.Errorf("upsert rule set mirror: %w", err)
+	}
+
+	var id int64
+	if err := r.db.QueryRowContext(ctx, `SELECT id FROM rule_set_mirrors WHERE url = ?`, m.URL).Scan(&id); err != nil {
+		return 0, fmt.Errorf("get rule set mirror id: %w", err)
+	}
+	return id, nil
+}
+
+// ListRuleSetMirrors returns all mirrored rule-sets ordered by id
+func (r *TrafficRepository) ListRuleSetMirrors(ctx context.Context) ([]RuleSetMirror, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+ruleSetMirrorColumns+` FROM rule_set_mirrors ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("list rule set mirrors: %w", err)
+	}
+	defer rows.Close()
+
+	var mirrors []RuleSetMirror
+	for rows.Next() {
+		m, err := scanRuleSetMirror(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan rule set mirror: %w", err)
+		}
+		mirrors = append(mirrors, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rule set mirrors: %w", err)
+	}
+
+	return mirrors, nil
+}
+
+// GetRuleSetMirror retrieves a mirrored rule-set by id
+func (r *TrafficRepository) GetRuleSetMirror(ctx context.Context, id int64) (RuleSetMirror, error) {
+	if r == nil || r.db == nil {
+		return RuleSetMirror{}, errors.New("traffic repository not initialized")
+	}
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+ruleSetMirrorColumns+` FROM rule_set_mirrors WHERE id = ?`, id)
+	m, err := scanRuleSetMirror(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RuleSetMirror{}, ErrRuleSetMirrorNotFound
+		}
+		return RuleSetMirror{}, fmt.Errorf("get rule set mirror: %w", err)
+	}
+	return m, nil
+}
+
+// GetRuleSetMirrorByURL retrieves a mirrored rule-set by its upstream URL
+func (r *TrafficRepository) GetRuleSetMirrorByURL(ctx context.Context, rawURL string) (RuleSetMirror, error) {
+	if r == nil || r.db == nil {
+		return RuleSetMirror{}, errors.New("traffic repository not initialized")
+	}
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+ruleSetMirrorColumns+` FROM rule_set_mirrors WHERE url = ?`, strings.TrimSpace(rawURL))
+	m, err := scanRuleSetMirror(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RuleSetMirror{}, ErrRuleSetMirrorNotFound
+		}
+		return RuleSetMirror{}, fmt.Errorf("get rule set mirror by url: %w", err)
+	}
+	return m, nil
+}
+
+// UpdateRuleSetMirrorFetchResult records the outcome of a mirror refresh.
+// On failure the previous local copy and metadata are kept.
+func (r *TrafficRepository) UpdateRuleSetMirrorFetchResult(ctx context.Context, id int64, result RuleSetFetchResult) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	var err error
+	switch {
+	case result.Err != nil:
+		_, err = r.db.ExecContext(ctx, `
+			UPDATE rule_set_mirrors
+			SET last_error = ?, last_checked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, result.Err.Error(), id)
+	case result.NotModified:
+		_, err = r.db.ExecContext(ctx, `
+			UPDATE rule_set_mirrors
+			SET last_error = '', last_checked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, id)
+	default:
+		_, err = r.db.ExecContext(ctx, `
+			UPDATE rule_set_mirrors
+			SET local_path = ?, size = ?, rule_count = ?, etag = ?, last_modified = ?, content_hash = ?,
+			    last_error = '', last_fetched_at = CURRENT_TIMESTAMP, last_checked_at = CURRENT_TIMESTAMP,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, result.LocalPath, result.Size, result.RuleCount, result.ETag, result.LastModified, result.ContentHash, id)
+	}
+	if err != nil {
+		return fmt.Errorf("update rule set mirror fetch result: %w", err)
+	}
+	return nil
+}
+
+// DeleteRuleSetMirror removes a mirrored rule-set record
+func (r *TrafficRepository) DeleteRuleSetMirror(ctx context.Context, id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM rule_set_mirrors WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete rule set mirror: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete rule set mirror rows affected: %w", err)
+	}
+	if affected == 0 {
+		return ErrRuleSetMirrorNotFound
+	}
+	return nil
+}
+
+func scanRuleSetMirror(scanner rowScanner) (RuleSetMirror, error) {
+	var m RuleSetMirror
+	var fetchedAt, checkedAt sql.NullTime
+	if err := scanner.Scan(&m.ID, &m.URL, &m.Name, &m.Behavior, &m.Format, &m.LocalPath, &m.Size, &m.RuleCount,
+		&m.ETag, &m.LastModified, &m.ContentHash, &fetchedAt, &checkedAt, &m.LastError, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return m, err
+	}
+	if fetchedAt.Valid {
+		t := fetchedAt.Time
+		m.LastFetchedAt = &t
+	}
+	if checkedAt.Valid {
+		t := checkedAt.Time
+		m.LastCheckedAt = &t
+	}
+	return m, nil
+}
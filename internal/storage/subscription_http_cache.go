@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SubscriptionHTTPCache holds the HTTP validators and last body of an external subscription fetch
+type SubscriptionHTTPCache struct {
+	SubscriptionID int64
+	UserAgent      string
+	URL            string // URL the validators belong to; ignored when the subscription URL changes
+	ETag           string
+	LastModified   string
+	UserInfo       string // last subscription-userinfo header, reused when a 304 omits it
+	Body           []byte
+	FetchedAt      time.Time
+}
+
+var ErrSubscriptionHTTPCacheNotFound = errors.New("subscription http cache not found")
+
+// GetSubscriptionHTTPCache returns the cached validators and body for a subscription and User-Agent.
+func (r *TrafficRepository) GetSubscriptionHTTPCache(ctx context.Context, subscriptionID int64, userAgent string) (SubscriptionHTTPCache, error) {
+	var c SubscriptionHTTPCache
+	if r == nil || r.db == nil {
+		return c, errors.New("traffic repository not initialized")
+	}
+
+	err := r.db.QueryRowContext(ctx, `
+		SELECT subscription_id, user_agent, url, etag, last_modified, userinfo, body, fetched_at
+		FROM external_subscription_http_cache WHERE subscription_id = ? AND user_agent = ?`, subscriptionID, userAgent).
+		Scan(&c.SubscriptionID, &c.UserAgent, &c.URL, &c.ETag, &c.LastModified, &c.UserInfo, &c.Body, &c.FetchedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c, ErrSubscriptionHTTPCacheNotFound
+		}
+		return c, fmt.Errorf("get subscription http cache: %w", err)
+	}
+	return c, nil
+}
+
+// SaveSubscriptionHTTPCache stores (or replaces) the validators and body for a subscription and User-Agent.
+func (r *TrafficRepository) SaveSubscriptionHTTPCache(ctx context.Context, c SubscriptionHTTPCache) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+	if c.SubscriptionID <= 0 {
+		return errors.New("subscription id is required")
+	}
+	if c.FetchedAt.IsZero() {
+		c.FetchedAt = time.Now()
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO external_subscription_http_cache (subscription_id, user_agent, url, etag, last_modified, userinfo, body, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(subscription_id, user_agent) DO UPDATE SET
+			url = excluded.url,
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			userinfo = excluded.userinfo,
+			body = excluded.body,
+			fetched_at = excluded.fetched_at`,
+		c.SubscriptionID, c.UserAgent, c.URL, c.ETag, c.LastModified, c.UserInfo, c.Body, c.FetchedAt)
+	if err != nil {
+		return fmt.Errorf("save subscription http cache: %w", err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -127,18 +128,28 @@ func scanSubscriptionLink(scanner rowScanner) (SubscriptionLink, error) {
 }
 
 func scanProbeConfig(scanner rowScanner) (ProbeConfig, error) {
-	var cfg ProbeConfig
-	if err := scanner.Scan(&cfg.ID, &cfg.ProbeType, &cfg.Address, &cfg.CreatedAt, &cfg.UpdatedAt); err != nil {
+	var (
+		cfg     ProbeConfig
+		options string
+	)
+	if err := scanner.Scan(&cfg.ID, &cfg.Name, &cfg.ProbeType, &cfg.Address, &options, &cfg.CreatedAt, &cfg.UpdatedAt); err != nil {
 		return ProbeConfig{}, err
 	}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &cfg.Options); err != nil {
+			return ProbeConfig{}, fmt.Errorf("decode probe options: %w", err)
+		}
+	}
 	return cfg, nil
 }
 
 func scanProbeServer(scanner rowScanner) (ProbeServer, error) {
 	var srv ProbeServer
-	if err := scanner.Scan(&srv.ID, &srv.ConfigID, &srv.ServerID, &srv.Name, &srv.TrafficMethod, &srv.MonthlyTrafficBytes, &srv.Position, &srv.CreatedAt, &srv.UpdatedAt); err != nil {
+	var rollover int
+	if err := scanner.Scan(&srv.ID, &srv.ConfigID, &srv.ServerID, &srv.Name, &srv.TrafficMethod, &srv.MonthlyTrafficBytes, &srv.ResetDay, &srv.TrafficMultiplier, &rollover, &srv.Position, &srv.CreatedAt, &srv.UpdatedAt); err != nil {
 		return ProbeServer{}, err
 	}
+	srv.Rollover = rollover != 0
 	return srv, nil
 }
 
@@ -150,6 +161,7 @@ var (
 	ErrSubscriptionNotFound         = errors.New("subscription link not found")
 	ErrSubscriptionExists           = errors.New("subscription link already exists")
 	ErrProbeConfigNotFound          = errors.New("probe configuration not found")
+	ErrProbeConfigExists            = errors.New("probe configuration already exists")
 	ErrNodeNotFound                 = errors.New("node not found")
 	ErrSubscribeFileNotFound        = errors.New("subscribe file not found")
 	ErrSubscribeFileExists          = errors.New("subscribe file already exists")
@@ -176,6 +188,9 @@ const (
 	ProbeTypeNezhaV0 = "nezhav0"
 	ProbeTypeDstatus = "dstatus"
 	ProbeTypeKomari  = "komari"
+	// 通用探针：从 Prometheus 查询或任意 JSON 接口读取流量计数，具体映射写在 Options 中
+	ProbeTypePrometheus = "prometheus"
+	ProbeTypeJSON       = "json"
 
 	TrafficMethodUp   = "up"
 	TrafficMethodDown = "down"
@@ -184,8 +199,10 @@ const (
 
 type ProbeConfig struct {
 	ID        int64
+	Name      string // unique, referenced by node probe bindings as "name/server"
 	ProbeType string
 	Address   string
+	Options   map[string]string // adapter specific settings, e.g. PromQL queries or JSON field paths
 	Servers   []ProbeServer
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -198,6 +215,9 @@ type ProbeServer struct {
 	Name                string
 	TrafficMethod       string
 	MonthlyTrafficBytes int64
+	ResetDay            int     // 流量重置日（1-31），0 表示直接使用探针的原始计数
+	TrafficMultiplier   float64 // 计费倍率，如仅计出站且按 2 倍计费
+	Rollover            bool    // 未用完的流量结转到下一周期
 	Position            int
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
@@ -247,6 +267,7 @@ type UserSettings struct {
 	CacheExpireMinutes  int        // Cache expiration time in minutes
 	SyncTraffic         bool       // Sync traffic info from external subscriptions
 	EnableProbeBinding  bool       // Enable probe server binding for nodes
+	ExcludeOfflineNodes bool       // Exclude nodes whose bound probe server is offline from subscriptions
 	CustomRulesEnabled  bool       // Enable custom rules feature
 	EnableShortLink     bool       // Enable short link feature for subscriptions
 	TemplateVersion     string     // Template version: "v1" (file-based), "v2" (database/ACL), "v3" (mihomo-style)
@@ -266,6 +287,13 @@ type SystemConfig struct {
 	ClientCompatibilityMode bool   // Auto-filter incompatible nodes for clients
 	SilentMode              bool   // Silent mode: return 404 for all requests except subscription
 	SilentModeTimeout       int    // Minutes to allow access after subscription fetch (default 15)
+	PublicBaseURL           string // Public base URL for generated absolute links (empty = derive from request)
+	RuleSetMirrorEnabled    bool   // Mirror referenced rule-provider URLs into local storage
+	RuleSetMirrorInterval   int    // Mirror refresh interval in hours (default 24)
+	RuleSetRewriteURL       bool   // Rewrite rule-providers[*].url in rendered subscriptions to the local mirror
+	RuleSetInlineMaxRules   int    // Inline mirrored rule-sets with at most N rules when requested (0 = disabled)
+	TrafficCollectTime      string // Daily traffic snapshot time "HH:MM" (default "23:55")
+	TrafficCollectTimezone  string // IANA timezone of TrafficCollectTime (empty = server local time)
 }
 
 // ExternalSubscription represents an external subscription URL imported by user.
@@ -284,6 +312,28 @@ type ExternalSubscription struct {
 	TrafficMode string     // 流量统计方式: "download", "upload", "both"
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+
+	SyncInterval      int        // 自动同步间隔（分钟），0 表示不自动同步
+	LastSyncStatus    string     // 最近一次同步结果: "success", "failed"
+	LastSyncError     string     // 最近一次同步失败的错误信息
+	LastSyncDelta     int        // 最近一次同步的节点数变化
+	LastSyncAttemptAt *time.Time // 最近一次尝试同步的时间（无论成功与否）
+
+	UpstreamProxy   string            // 拉取订阅使用的出站代理，如 socks5://127.0.0.1:1080、http://127.0.0.1:8080
+	FetchTimeout    int               // 拉取超时（秒），0 表示使用默认值
+	CustomHeaders   map[string]string // 额外请求头（User-Agent 之外）
+	SkipCertVerify  bool              // 跳过 TLS 证书校验
+	CertFingerprint string            // 固定的服务器证书 SHA-256 指纹（小写十六进制），设置后只校验指纹
+
+	NodeTransforms string // JSON: 节点处理流水线，同步和 MMW 代理集合拉取时应用
+}
+
+// ExternalSubscriptionSyncResult is the outcome of one external subscription sync
+type ExternalSubscriptionSyncResult struct {
+	Success   bool
+	Error     string
+	NodeDelta int
+	AttemptAt time.Time
 }
 
 // CustomRule represents a custom rule for DNS, rules, or rule-providers.
@@ -333,6 +383,11 @@ type ProxyProviderConfig struct {
 	GeoIPFilter               string // 地理位置过滤，国家代码如 "HK" 或 "HK,TW"（仅 MMW 模式生效）
 	Override                  string // JSON: 覆写配置
 	ProcessMode               string // 'client'=客户端处理, 'mmw'=妙妙屋处理
+	NodeTransforms            string // JSON: 节点处理流水线（仅 MMW 模式生效，在外部订阅的流水线之后执行）
+	ExtraSubscriptionIDs      string // 组合代理集合：额外合并的外部订阅 ID，逗号分隔
+	NodeTags                  string // 组合代理集合：合并的自有节点标签，逗号分隔
+	HealthCheckPrune          bool   // 妙妙屋健康检查：剔除连续失败的节点（仅 MMW 模式生效）
+	HealthCheckPruneFailures  int    // 连续失败多少轮后剔除节点
 	CreatedAt                 time.Time
 	UpdatedAt                 time.Time
 }
@@ -343,6 +398,9 @@ var (
 		ProbeTypeNezhaV0: {},
 		ProbeTypeDstatus: {},
 		ProbeTypeKomari:  {},
+
+		ProbeTypePrometheus: {},
+		ProbeTypeJSON:       {},
 	}
 	allowedTrafficMethods = map[string]struct{}{
 		TrafficMethodUp:   {},
@@ -417,6 +475,41 @@ CREATE TABLE IF NOT EXISTS traffic_records (
 		return fmt.Errorf("migrate traffic_records: %w", err)
 	}
 
+	const trafficSourceSchema = `
+CREATE TABLE IF NOT EXISTS traffic_source_records (
+    date TEXT NOT NULL,
+    source_type TEXT NOT NULL CHECK (source_type IN ('probe_server','external_subscription','user')),
+    source_key TEXT NOT NULL,
+    source_name TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    total_limit INTEGER NOT NULL,
+    total_used INTEGER NOT NULL,
+    total_remaining INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (date, source_type, source_key)
+);
+CREATE INDEX IF NOT EXISTS idx_traffic_source_records_source ON traffic_source_records(source_type, source_key, date);
+`
+
+	if _, err := r.db.Exec(trafficSourceSchema); err != nil {
+		return fmt.Errorf("migrate traffic_source_records: %w", err)
+	}
+
+	const trafficCollectRunSchema = `
+CREATE TABLE IF NOT EXISTS traffic_collect_runs (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    scheduled_at TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    succeeded_at TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT ''
+);
+`
+
+	if _, err := r.db.Exec(trafficCollectRunSchema); err != nil {
+		return fmt.Errorf("migrate traffic_collect_runs: %w", err)
+	}
+
 	const userTokenSchema = `
 CREATE TABLE IF NOT EXISTS user_tokens (
     username TEXT PRIMARY KEY,
@@ -521,6 +614,10 @@ CREATE TABLE IF NOT EXISTS rule_versions (
 		return fmt.Errorf("migrate rule_versions: %w", err)
 	}
 
+	if err := r.ensureRuleVersionColumn("note", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
 	const subscriptionSchema = `
 CREATE TABLE IF NOT EXISTS subscription_links (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -556,9 +653,11 @@ CREATE TABLE IF NOT EXISTS subscription_links (
 
 	const probeConfigSchema = `
 CREATE TABLE IF NOT EXISTS probe_configs (
-    id INTEGER PRIMARY KEY CHECK (id = 1),
-    probe_type TEXT NOT NULL CHECK (probe_type IN ('nezha','nezhav0','dstatus','komari')),
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    probe_type TEXT NOT NULL,
     address TEXT NOT NULL,
+    options TEXT NOT NULL DEFAULT '',
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
@@ -588,6 +687,61 @@ CREATE TABLE IF NOT EXISTS probe_servers (
 		return fmt.Errorf("migrate probe_servers: %w", err)
 	}
 
+	if err := r.ensureProbeServerColumn("reset_day", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := r.ensureProbeServerColumn("traffic_multiplier", "REAL NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := r.ensureProbeServerColumn("rollover", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	const probeServerCycleSchema = `
+CREATE TABLE IF NOT EXISTS probe_server_cycles (
+    config_id INTEGER NOT NULL,
+    server_id TEXT NOT NULL,
+    cycle_start TIMESTAMP NOT NULL,
+    baseline INTEGER NOT NULL DEFAULT 0,
+    last_raw INTEGER NOT NULL DEFAULT 0,
+    accumulated INTEGER NOT NULL DEFAULT 0,
+    previous_cycle_start TIMESTAMP,
+    previous_used INTEGER NOT NULL DEFAULT 0,
+    partial INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (config_id, server_id)
+);
+`
+
+	if _, err := r.db.Exec(probeServerCycleSchema); err != nil {
+		return fmt.Errorf("migrate probe_server_cycles: %w", err)
+	}
+
+	const userTrafficQuotaSchema = `
+CREATE TABLE IF NOT EXISTS user_traffic_quotas (
+    username TEXT PRIMARY KEY,
+    quota_bytes INTEGER NOT NULL DEFAULT 0,
+    exceeded_action TEXT NOT NULL DEFAULT 'notice',
+    degraded_filename TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+	if _, err := r.db.Exec(userTrafficQuotaSchema); err != nil {
+		return fmt.Errorf("migrate user_traffic_quotas: %w", err)
+	}
+
+	// Lift the single-row restriction so that multiple probes can be configured
+	if err := r.migrateProbeConfigsForMultiple(); err != nil {
+		return fmt.Errorf("migrate probe_configs for multiple probes: %w", err)
+	}
+
+	// Probe types are validated in code so that new adapters don't require a table rebuild
+	if err := r.migrateProbeConfigsForGenericTypes(); err != nil {
+		return fmt.Errorf("migrate probe_configs for generic probes: %w", err)
+	}
+
 	if err := r.ensureDefaultProbeConfig(); err != nil {
 		return err
 	}
@@ -758,6 +912,39 @@ CREATE INDEX IF NOT EXISTS idx_external_subscriptions_url ON external_subscripti
 	if err := r.ensureExternalSubscriptionColumn("traffic_mode", "TEXT NOT NULL DEFAULT 'both'"); err != nil {
 		return err
 	}
+	if err := r.ensureExternalSubscriptionColumn("sync_interval", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("last_sync_status", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("last_sync_error", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("last_sync_delta", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("last_sync_attempt_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("upstream_proxy", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("fetch_timeout", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("custom_headers", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("skip_cert_verify", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("cert_fingerprint", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := r.ensureExternalSubscriptionColumn("node_transforms", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
 
 	// Add custom_rules_enabled to user_settings table
 	if err := r.ensureUserSettingsColumn("custom_rules_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
@@ -807,6 +994,11 @@ CREATE INDEX IF NOT EXISTS idx_external_subscriptions_url ON external_subscripti
 		return err
 	}
 
+	// Add exclude_offline_nodes column to user_settings table if it doesn't exist
+	if err := r.ensureUserSettingsColumn("exclude_offline_nodes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
 	// Add file_short_code column to subscribe_files table (3-character code)
 	if err := r.ensureSubscribeFileColumn("file_short_code", "TEXT NOT NULL DEFAULT ''"); err != nil {
 		return err
@@ -865,6 +1057,36 @@ WHERE NOT EXISTS (SELECT 1 FROM system_config WHERE id = 1);
 		return err
 	}
 
+	// Rule-set mirroring settings
+	if err := r.ensureSystemConfigColumn("public_base_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := r.ensureSystemConfigColumn("rule_set_mirror_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := r.ensureSystemConfigColumn("rule_set_mirror_interval", "INTEGER NOT NULL DEFAULT 24"); err != nil {
+		return err
+	}
+	if err := r.ensureSystemConfigColumn("rule_set_rewrite_url", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := r.ensureSystemConfigColumn("rule_set_inline_max_rules", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Key for signing generated URLs (kept out of SystemConfig so it is never returned by config APIs)
+	if err := r.ensureSystemConfigColumn("url_signing_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// Traffic collection schedule
+	if err := r.ensureSystemConfigColumn("traffic_collect_time", "TEXT NOT NULL DEFAULT '23:55'"); err != nil {
+		return err
+	}
+	if err := r.ensureSystemConfigColumn("traffic_collect_timezone", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
 	const customRulesSchema = `
 CREATE TABLE IF NOT EXISTS custom_rules (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -989,6 +1211,186 @@ CREATE INDEX IF NOT EXISTS idx_proxy_provider_configs_external_subscription_id O
 		return fmt.Errorf("ensure geo_ip_filter column: %w", err)
 	}
 
+	// 节点处理流水线（JSON 数组）
+	if err := r.ensureProxyProviderConfigColumn("node_transforms", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("ensure node_transforms column: %w", err)
+	}
+	if err := r.ensureProxyProviderConfigColumn("extra_subscription_ids", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("ensure extra_subscription_ids column: %w", err)
+	}
+	if err := r.ensureProxyProviderConfigColumn("node_tags", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("ensure node_tags column: %w", err)
+	}
+	if err := r.ensureProxyProviderConfigColumn("health_check_prune", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("ensure health_check_prune column: %w", err)
+	}
+	if err := r.ensureProxyProviderConfigColumn("health_check_prune_failures", "INTEGER NOT NULL DEFAULT 3"); err != nil {
+		return fmt.Errorf("ensure health_check_prune_failures column: %w", err)
+	}
+
+	// 规则集镜像表：记录被引用的 rule-provider URL 及其本地副本
+	const ruleSetMirrorsSchema = `
+CREATE TABLE IF NOT EXISTS rule_set_mirrors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    behavior TEXT NOT NULL DEFAULT 'classical',
+    format TEXT NOT NULL DEFAULT 'yaml',
+    local_path TEXT NOT NULL DEFAULT '',
+    size INTEGER NOT NULL DEFAULT 0,
+    rule_count INTEGER NOT NULL DEFAULT -1,
+    etag TEXT NOT NULL DEFAULT '',
+    last_modified TEXT NOT NULL DEFAULT '',
+    content_hash TEXT NOT NULL DEFAULT '',
+    last_fetched_at TIMESTAMP,
+    last_checked_at TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(url)
+);
+`
+	if _, err := r.db.Exec(ruleSetMirrorsSchema); err != nil {
+		return fmt.Errorf("migrate rule_set_mirrors: %w", err)
+	}
+
+	// 代理集合缓存快照表：重启后恢复缓存，上游失败时提供旧数据
+	const proxyProviderCacheSchema = `
+CREATE TABLE IF NOT EXISTS proxy_provider_cache (
+    config_id INTEGER PRIMARY KEY,
+    yaml_data BLOB NOT NULL,
+    prefix TEXT NOT NULL DEFAULT '',
+    node_count INTEGER NOT NULL DEFAULT 0,
+    interval INTEGER NOT NULL DEFAULT 0,
+    fetched_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (config_id) REFERENCES proxy_provider_configs(id) ON DELETE CASCADE
+);
+`
+	if _, err := r.db.Exec(proxyProviderCacheSchema); err != nil {
+		return fmt.Errorf("migrate proxy_provider_cache: %w", err)
+	}
+
+	// 外部订阅同步变更记录表：每次同步的节点新增、上游缺失、重命名和配置变更
+	const externalSubscriptionChangesSchema = `
+CREATE TABLE IF NOT EXISTS external_subscription_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL,
+    username TEXT NOT NULL,
+    added INTEGER NOT NULL DEFAULT 0,
+    missing INTEGER NOT NULL DEFAULT 0,
+    renamed INTEGER NOT NULL DEFAULT 0,
+    updated INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    unchanged INTEGER NOT NULL DEFAULT 0,
+    changes TEXT NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (subscription_id) REFERENCES external_subscriptions(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_external_subscription_changes_subscription ON external_subscription_changes(subscription_id, created_at);
+`
+	if _, err := r.db.Exec(externalSubscriptionChangesSchema); err != nil {
+		return fmt.Errorf("migrate external_subscription_changes: %w", err)
+	}
+
+	// 外部订阅 HTTP 缓存表：保存 ETag/Last-Modified 校验值和最近一次的响应内容，用于条件请求
+	const subscriptionHTTPCacheSchema = `
+CREATE TABLE IF NOT EXISTS external_subscription_http_cache (
+    subscription_id INTEGER NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL,
+    etag TEXT NOT NULL DEFAULT '',
+    last_modified TEXT NOT NULL DEFAULT '',
+    userinfo TEXT NOT NULL DEFAULT '',
+    body BLOB NOT NULL,
+    fetched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (subscription_id, user_agent),
+    FOREIGN KEY (subscription_id) REFERENCES external_subscriptions(id) ON DELETE CASCADE
+);
+`
+	if _, err := r.db.Exec(subscriptionHTTPCacheSchema); err != nil {
+		return fmt.Errorf("migrate external_subscription_http_cache: %w", err)
+	}
+
+	// 外部订阅告警：通知渠道、告警规则和去重后的告警历史
+	const alertsSchema = `
+CREATE TABLE IF NOT EXISTS alert_notifiers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    base_url TEXT NOT NULL DEFAULT '',
+    config TEXT NOT NULL DEFAULT '{}',
+    enabled INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    threshold REAL NOT NULL DEFAULT 0,
+    subscription_id INTEGER NOT NULL DEFAULT 0,
+    notifier_ids TEXT NOT NULL DEFAULT '',
+    enabled INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS alert_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_id INTEGER NOT NULL,
+    rule_name TEXT NOT NULL DEFAULT '',
+    rule_type TEXT NOT NULL DEFAULT '',
+    subscription_id INTEGER NOT NULL DEFAULT 0,
+    subscription_name TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    dedupe_key TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending',
+    error TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 1,
+    next_retry_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    UNIQUE(dedupe_key)
+);
+`
+	if _, err := r.db.Exec(alertsSchema); err != nil {
+		return fmt.Errorf("migrate alerts: %w", err)
+	}
+
+	// 代理集合节点健康检查：每个节点的当前状态和探测历史
+	const proxyProviderNodeHealthSchema = `
+CREATE TABLE IF NOT EXISTS proxy_provider_node_health (
+    config_id INTEGER NOT NULL,
+    node_key TEXT NOT NULL,
+    node_name TEXT NOT NULL DEFAULT '',
+    probe TEXT NOT NULL DEFAULT 'tcp',
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    total_checks INTEGER NOT NULL DEFAULT 0,
+    total_failures INTEGER NOT NULL DEFAULT 0,
+    last_latency REAL NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    last_checked_at TIMESTAMP,
+    last_success_at TIMESTAMP,
+    PRIMARY KEY (config_id, node_key),
+    FOREIGN KEY (config_id) REFERENCES proxy_provider_configs(id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS proxy_provider_node_health_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    config_id INTEGER NOT NULL,
+    node_key TEXT NOT NULL,
+    success INTEGER NOT NULL DEFAULT 0,
+    latency REAL NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    checked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (config_id) REFERENCES proxy_provider_configs(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_proxy_provider_node_health_history_node ON proxy_provider_node_health_history(config_id, node_key, checked_at);
+`
+	if _, err := r.db.Exec(proxyProviderNodeHealthSchema); err != nil {
+		return fmt.Errorf("migrate proxy_provider_node_health: %w", err)
+	}
+
 	return nil
 }
 
@@ -1226,8 +1628,62 @@ func (r *TrafficRepository) CountSubscriptionsByFilename(ctx context.Context, fi
 	return count, nil
 }
 
-// GetProbeConfig returns the current probe configuration with associated servers.
-func (r *TrafficRepository) GetProbeConfig(ctx context.Context) (ProbeConfig, error) {
+// ProbeBindingSeparator separates the probe name and server name in a node probe binding ("probe/server").
+const ProbeBindingSeparator = "/"
+
+// FormatProbeBinding builds the node probe binding value naming both the probe and the server.
+func FormatProbeBinding(probeName, serverName string) string {
+	probeName = strings.TrimSpace(probeName)
+	serverName = strings.TrimSpace(serverName)
+	if probeName == "" || serverName == "" {
+		return serverName
+	}
+	return probeName + ProbeBindingSeparator + serverName
+}
+
+const probeConfigColumns = `id, name, probe_type, address, options, created_at, updated_at`
+
+// ListProbeConfigs returns all probe configurations with their servers, ordered by id.
+func (r *TrafficRepository) ListProbeConfigs(ctx context.Context) ([]ProbeConfig, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+probeConfigColumns+` FROM probe_configs ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("list probe configs: %w", err)
+	}
+	defer rows.Close()
+
+	var configs []ProbeConfig
+	for rows.Next() {
+		cfg, err := scanProbeConfig(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan probe config: %w", err)
+		}
+		configs = append(configs, cfg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate probe configs: %w", err)
+	}
+	rows.Close()
+
+	for i := range configs {
+		servers, err := r.listProbeServers(ctx, configs[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		configs[i].Servers = servers
+	}
+
+	return configs, nil
+}
+
+// GetProbeConfigByID returns a probe configuration with associated servers.
+func (r *TrafficRepository) GetProbeConfigByID(ctx context.Context, id int64) (ProbeConfig, error) {
 	var cfg ProbeConfig
 	if r == nil || r.db == nil {
 		return cfg, errors.New("traffic repository not initialized")
@@ -1236,7 +1692,7 @@ func (r *TrafficRepository) GetProbeConfig(ctx context.Context) (ProbeConfig, er
 		ctx = context.Background()
 	}
 
-	row := r.db.QueryRowContext(ctx, `SELECT id, probe_type, address, created_at, updated_at FROM probe_configs WHERE id = 1 LIMIT 1`)
+	row := r.db.QueryRowContext(ctx, `SELECT `+probeConfigColumns+` FROM probe_configs WHERE id = ? LIMIT 1`, id)
 	result, err := scanProbeConfig(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -1245,9 +1701,41 @@ func (r *TrafficRepository) GetProbeConfig(ctx context.Context) (ProbeConfig, er
 		return cfg, fmt.Errorf("get probe config: %w", err)
 	}
 
-	rows, err := r.db.QueryContext(ctx, `SELECT id, config_id, server_id, name, traffic_method, monthly_traffic_bytes, position, created_at, updated_at FROM probe_servers WHERE config_id = ? ORDER BY position ASC, id ASC`, result.ID)
+	servers, err := r.listProbeServers(ctx, result.ID)
 	if err != nil {
-		return cfg, fmt.Errorf("list probe servers: %w", err)
+		return cfg, err
+	}
+	result.Servers = servers
+
+	return result, nil
+}
+
+// GetProbeConfig returns the first probe configuration with associated servers.
+// Kept for callers that predate multiple probe configurations.
+func (r *TrafficRepository) GetProbeConfig(ctx context.Context) (ProbeConfig, error) {
+	var cfg ProbeConfig
+	if r == nil || r.db == nil {
+		return cfg, errors.New("traffic repository not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var id int64
+	if err := r.db.QueryRowContext(ctx, `SELECT id FROM probe_configs ORDER BY id ASC LIMIT 1`).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return cfg, ErrProbeConfigNotFound
+		}
+		return cfg, fmt.Errorf("get probe config: %w", err)
+	}
+
+	return r.GetProbeConfigByID(ctx, id)
+}
+
+func (r *TrafficRepository) listProbeServers(ctx context.Context, configID int64) ([]ProbeServer, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, config_id, server_id, name, traffic_method, monthly_traffic_bytes, reset_day, traffic_multiplier, rollover, position, created_at, updated_at FROM probe_servers WHERE config_id = ? ORDER BY position ASC, id ASC`, configID)
+	if err != nil {
+		return nil, fmt.Errorf("list probe servers: %w", err)
 	}
 	defer rows.Close()
 
@@ -1255,77 +1743,142 @@ func (r *TrafficRepository) GetProbeConfig(ctx context.Context) (ProbeConfig, er
 	for rows.Next() {
 		server, err := scanProbeServer(rows)
 		if err != nil {
-			return cfg, fmt.Errorf("scan probe server: %w", err)
+			return nil, fmt.Errorf("scan probe server: %w", err)
 		}
 		servers = append(servers, server)
 	}
 
 	if err := rows.Err(); err != nil {
-		return cfg, fmt.Errorf("iterate probe servers: %w", err)
+		return nil, fmt.Errorf("iterate probe servers: %w", err)
 	}
 
-	result.Servers = servers
-
-	return result, nil
+	return servers, nil
 }
 
-// UpsertProbeConfig updates the singleton probe configuration and replaces its server list.
-func (r *TrafficRepository) UpsertProbeConfig(ctx context.Context, cfg ProbeConfig) (ProbeConfig, error) {
-	if r == nil || r.db == nil {
-		return ProbeConfig{}, errors.New("traffic repository not initialized")
+// sanitizeProbeConfig validates a probe configuration and normalizes its fields in place.
+func sanitizeProbeConfig(cfg *ProbeConfig) error {
+	cfg.Name = strings.TrimSpace(cfg.Name)
+	if cfg.Name == "" {
+		return errors.New("probe name is required")
 	}
-	if ctx == nil {
-		ctx = context.Background()
-	}
-
-	type sanitizedServer struct {
-		ServerID            string
-		Name                string
-		TrafficMethod       string
-		MonthlyTrafficBytes int64
+	if strings.Contains(cfg.Name, ProbeBindingSeparator) {
+		return fmt.Errorf("probe name cannot contain %q", ProbeBindingSeparator)
 	}
 
 	cfg.ProbeType = strings.ToLower(strings.TrimSpace(cfg.ProbeType))
 	if _, ok := allowedProbeTypes[cfg.ProbeType]; !ok {
-		return ProbeConfig{}, errors.New("unsupported probe type")
+		return errors.New("unsupported probe type")
 	}
 
 	cfg.Address = strings.TrimSpace(cfg.Address)
 	if cfg.Address == "" {
-		return ProbeConfig{}, errors.New("probe address is required")
+		return errors.New("probe address is required")
+	}
+
+	options := make(map[string]string, len(cfg.Options))
+	for key, value := range cfg.Options {
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if key != "" && value != "" {
+			options[key] = value
+		}
+	}
+	cfg.Options = options
+
+	switch cfg.ProbeType {
+	case ProbeTypePrometheus:
+		if cfg.Options["up_query"] == "" && cfg.Options["down_query"] == "" {
+			return errors.New("prometheus probe requires up_query or down_query")
+		}
+	case ProbeTypeJSON:
+		if cfg.Options["up_field"] == "" && cfg.Options["down_field"] == "" {
+			return errors.New("json probe requires up_field or down_field")
+		}
 	}
 
 	if len(cfg.Servers) == 0 {
-		return ProbeConfig{}, errors.New("at least one server is required")
+		return errors.New("at least one server is required")
 	}
 
-	sanitized := make([]sanitizedServer, 0, len(cfg.Servers))
-	for idx, srv := range cfg.Servers {
-		serverID := strings.TrimSpace(srv.ServerID)
-		if serverID == "" {
-			return ProbeConfig{}, fmt.Errorf("server %d: server id is required", idx+1)
+	for idx := range cfg.Servers {
+		srv := &cfg.Servers[idx]
+		srv.ServerID = strings.TrimSpace(srv.ServerID)
+		if srv.ServerID == "" {
+			return fmt.Errorf("server %d: server id is required", idx+1)
 		}
 
-		name := strings.TrimSpace(srv.Name)
-		if name == "" {
-			return ProbeConfig{}, fmt.Errorf("server %d: server name is required", idx+1)
+		srv.Name = strings.TrimSpace(srv.Name)
+		if srv.Name == "" {
+			return fmt.Errorf("server %d: server name is required", idx+1)
 		}
 
-		method := strings.ToLower(strings.TrimSpace(srv.TrafficMethod))
-		if _, ok := allowedTrafficMethods[method]; !ok {
-			return ProbeConfig{}, fmt.Errorf("server %d: unsupported traffic method", idx+1)
+		srv.TrafficMethod = strings.ToLower(strings.TrimSpace(srv.TrafficMethod))
+		if _, ok := allowedTrafficMethods[srv.TrafficMethod]; !ok {
+			return fmt.Errorf("server %d: unsupported traffic method", idx+1)
 		}
 
 		if srv.MonthlyTrafficBytes < 0 {
-			return ProbeConfig{}, fmt.Errorf("server %d: monthly traffic cannot be negative", idx+1)
+			return fmt.Errorf("server %d: monthly traffic cannot be negative", idx+1)
 		}
 
-		sanitized = append(sanitized, sanitizedServer{
-			ServerID:            serverID,
-			Name:                name,
-			TrafficMethod:       method,
-			MonthlyTrafficBytes: srv.MonthlyTrafficBytes,
-		})
+		if srv.ResetDay < 0 || srv.ResetDay > 31 {
+			return fmt.Errorf("server %d: reset day must be between 1 and 31", idx+1)
+		}
+
+		if srv.TrafficMultiplier == 0 {
+			srv.TrafficMultiplier = 1
+		}
+		if srv.TrafficMultiplier < 0 {
+			return fmt.Errorf("server %d: traffic multiplier must be positive", idx+1)
+		}
+	}
+
+	return nil
+}
+
+func encodeProbeOptions(options map[string]string) (string, error) {
+	if len(options) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return "", fmt.Errorf("encode probe options: %w", err)
+	}
+	return string(data), nil
+}
+
+// replaceProbeServers replaces the server list of a probe configuration inside a transaction.
+func replaceProbeServers(ctx context.Context, tx *sql.Tx, configID int64, servers []ProbeServer) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_servers WHERE config_id = ?`, configID); err != nil {
+		return fmt.Errorf("clear probe servers: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO probe_servers (config_id, server_id, name, traffic_method, monthly_traffic_bytes, reset_day, traffic_multiplier, rollover, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("prepare insert probe server: %w", err)
+	}
+	defer stmt.Close()
+
+	for idx, srv := range servers {
+		if _, err := stmt.ExecContext(ctx, configID, srv.ServerID, srv.Name, srv.TrafficMethod, srv.MonthlyTrafficBytes, srv.ResetDay, srv.TrafficMultiplier, boolToInt(srv.Rollover), idx); err != nil {
+			return fmt.Errorf("insert probe server %d: %w", idx+1, err)
+		}
+	}
+
+	return nil
+}
+
+// CreateProbeConfig stores a new probe configuration with its server list.
+func (r *TrafficRepository) CreateProbeConfig(ctx context.Context, cfg ProbeConfig) (ProbeConfig, error) {
+	if r == nil || r.db == nil {
+		return ProbeConfig{}, errors.New("traffic repository not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err := sanitizeProbeConfig(&cfg); err != nil {
+		return ProbeConfig{}, err
 	}
 
 	tx, err := r.db.BeginTx(ctx, nil)
@@ -1334,34 +1887,166 @@ func (r *TrafficRepository) UpsertProbeConfig(ctx context.Context, cfg ProbeConf
 	}
 	defer tx.Rollback()
 
-	if _, err := tx.ExecContext(ctx, `INSERT INTO probe_configs (id, probe_type, address) VALUES (1, ?, ?) ON CONFLICT(id) DO UPDATE SET probe_type = excluded.probe_type, address = excluded.address, updated_at = CURRENT_TIMESTAMP`, cfg.ProbeType, cfg.Address); err != nil {
-		return ProbeConfig{}, fmt.Errorf("upsert probe config: %w", err)
+	options, err := encodeProbeOptions(cfg.Options)
+	if err != nil {
+		return ProbeConfig{}, err
 	}
 
-	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_servers WHERE config_id = 1`); err != nil {
-		return ProbeConfig{}, fmt.Errorf("clear probe servers: %w", err)
+	res, err := tx.ExecContext(ctx, `INSERT INTO probe_configs (name, probe_type, address, options) VALUES (?, ?, ?, ?)`, cfg.Name, cfg.ProbeType, cfg.Address, options)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return ProbeConfig{}, ErrProbeConfigExists
+		}
+		return ProbeConfig{}, fmt.Errorf("insert probe config: %w", err)
 	}
 
-	stmt, err := tx.PrepareContext(ctx, `INSERT INTO probe_servers (config_id, server_id, name, traffic_method, monthly_traffic_bytes, position) VALUES (1, ?, ?, ?, ?, ?)`)
+	id, err := res.LastInsertId()
 	if err != nil {
-		return ProbeConfig{}, fmt.Errorf("prepare insert probe server: %w", err)
+		return ProbeConfig{}, fmt.Errorf("probe config id: %w", err)
+	}
+
+	if err := replaceProbeServers(ctx, tx, id, cfg.Servers); err != nil {
+		return ProbeConfig{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ProbeConfig{}, fmt.Errorf("commit probe config: %w", err)
+	}
+
+	return r.GetProbeConfigByID(ctx, id)
+}
+
+// UpdateProbeConfig updates a probe configuration and replaces its server list.
+// Renaming the probe rewrites the node bindings that reference it.
+func (r *TrafficRepository) UpdateProbeConfig(ctx context.Context, cfg ProbeConfig) (ProbeConfig, error) {
+	if r == nil || r.db == nil {
+		return ProbeConfig{}, errors.New("traffic repository not initialized")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	existing, err := r.GetProbeConfigByID(ctx, cfg.ID)
+	if err != nil {
+		return ProbeConfig{}, err
+	}
+
+	if err := sanitizeProbeConfig(&cfg); err != nil {
+		return ProbeConfig{}, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ProbeConfig{}, fmt.Errorf("begin probe config tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	options, err := encodeProbeOptions(cfg.Options)
+	if err != nil {
+		return ProbeConfig{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE probe_configs SET name = ?, probe_type = ?, address = ?, options = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, cfg.Name, cfg.ProbeType, cfg.Address, options, cfg.ID); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return ProbeConfig{}, ErrProbeConfigExists
+		}
+		return ProbeConfig{}, fmt.Errorf("update probe config: %w", err)
+	}
+
+	if existing.Name != cfg.Name {
+		oldPrefix := existing.Name + ProbeBindingSeparator
+		// substr/length 按字符计数，前缀长度由 SQLite 计算，避免中文名称按字节长度截错
+		if _, err := tx.ExecContext(ctx, `UPDATE nodes SET probe_server = ? || substr(probe_server, length(?) + 1), updated_at = CURRENT_TIMESTAMP WHERE substr(probe_server, 1, length(?)) = ?`,
+			cfg.Name+ProbeBindingSeparator, oldPrefix, oldPrefix, oldPrefix); err != nil {
+			return ProbeConfig{}, fmt.Errorf("rename node probe bindings: %w", err)
+		}
+	}
+
+	if err := replaceProbeServers(ctx, tx, cfg.ID, cfg.Servers); err != nil {
+		return ProbeConfig{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ProbeConfig{}, fmt.Errorf("commit probe config: %w", err)
+	}
+
+	return r.GetProbeConfigByID(ctx, cfg.ID)
+}
+
+// UpsertProbeConfig updates the first probe configuration, creating it when none exists.
+// Kept for callers that predate multiple probe configurations.
+func (r *TrafficRepository) UpsertProbeConfig(ctx context.Context, cfg ProbeConfig) (ProbeConfig, error) {
+	existing, err := r.GetProbeConfig(ctx)
+	if err != nil {
+		if !errors.Is(err, ErrProbeConfigNotFound) {
+			return ProbeConfig{}, err
+		}
+		if strings.TrimSpace(cfg.Name) == "" {
+			cfg.Name = strings.TrimSpace(cfg.ProbeType)
+		}
+		return r.CreateProbeConfig(ctx, cfg)
+	}
+
+	cfg.ID = existing.ID
+	if strings.TrimSpace(cfg.Name) == "" {
+		cfg.Name = existing.Name
+	}
+	return r.UpdateProbeConfig(ctx, cfg)
+}
+
+// DeleteProbeConfigByID deletes a probe configuration and clears the node bindings that reference it.
+// Legacy bindings without a probe name are cleared once no probe is left.
+func (r *TrafficRepository) DeleteProbeConfigByID(ctx context.Context, id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	existing, err := r.GetProbeConfigByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin delete probe config tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	prefix := existing.Name + ProbeBindingSeparator
+	if _, err := tx.ExecContext(ctx, `UPDATE nodes SET probe_server = '' WHERE substr(probe_server, 1, length(?)) = ?`, prefix, prefix); err != nil {
+		return fmt.Errorf("clear node probe bindings: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_servers WHERE config_id = ?`, id); err != nil {
+		return fmt.Errorf("delete probe servers: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_server_cycles WHERE config_id = ?`, id); err != nil {
+		return fmt.Errorf("delete probe server cycles: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_configs WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete probe config: %w", err)
 	}
-	defer stmt.Close()
 
-	for idx, srv := range sanitized {
-		if _, err := stmt.ExecContext(ctx, srv.ServerID, srv.Name, srv.TrafficMethod, srv.MonthlyTrafficBytes, idx); err != nil {
-			return ProbeConfig{}, fmt.Errorf("insert probe server %d: %w", idx+1, err)
+	var remaining int64
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM probe_configs`).Scan(&remaining); err != nil {
+		return fmt.Errorf("count probe configs: %w", err)
+	}
+	if remaining == 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE nodes SET probe_server = '' WHERE probe_server != ''`); err != nil {
+			return fmt.Errorf("clear node probe bindings: %w", err)
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return ProbeConfig{}, fmt.Errorf("commit probe config: %w", err)
+		return fmt.Errorf("commit delete probe config: %w", err)
 	}
 
-	return r.GetProbeConfig(ctx)
+	return nil
 }
 
-// DeleteProbeConfig deletes the probe configuration and clears all node probe bindings.
+// DeleteProbeConfig deletes all probe configurations and clears all node probe bindings.
 func (r *TrafficRepository) DeleteProbeConfig(ctx context.Context) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -1374,13 +2059,15 @@ func (r *TrafficRepository) DeleteProbeConfig(ctx context.Context) error {
 		return fmt.Errorf("clear node probe bindings: %w", err)
 	}
 
-	// Delete probe_servers (will cascade from probe_configs deletion, but do it explicitly for clarity)
-	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_servers WHERE config_id = 1`); err != nil {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_servers`); err != nil {
 		return fmt.Errorf("delete probe servers: %w", err)
 	}
 
-	// Delete probe config
-	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_configs WHERE id = 1`); err != nil {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_server_cycles`); err != nil {
+		return fmt.Errorf("delete probe server cycles: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM probe_configs`); err != nil {
 		return fmt.Errorf("delete probe config: %w", err)
 	}
 
@@ -1422,7 +2109,7 @@ func (r *TrafficRepository) migrateProbeConfigsForNezhaV0() error {
 	}
 
 	// If old schema doesn't contain probe_type check, also skip (brand new table will be created correctly)
-	if !strings.Contains(schemaSql, "probe_type") {
+	if !strings.Contains(schemaSql, "CHECK (probe_type IN") {
 		return nil
 	}
 
@@ -1471,6 +2158,119 @@ CREATE TABLE probe_configs_new (
 	return nil
 }
 
+// migrateProbeConfigsForMultiple recreates the singleton probe_configs table (CHECK (id = 1))
+// with a unique probe name, and rewrites existing node bindings to the "probe/server" form.
+func (r *TrafficRepository) migrateProbeConfigsForMultiple() error {
+	var schemaSQL string
+	if err := r.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='probe_configs'`).Scan(&schemaSQL); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("query schema: %w", err)
+	}
+	if !strings.Contains(schemaSQL, "CHECK (id = 1)") {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+CREATE TABLE probe_configs_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    probe_type TEXT NOT NULL CHECK (probe_type IN ('nezha','nezhav0','dstatus','komari')),
+    address TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return fmt.Errorf("create new table: %w", err)
+	}
+
+	// The existing probe is named after its type
+	if _, err := tx.Exec(`INSERT INTO probe_configs_new (id, name, probe_type, address, created_at, updated_at) SELECT id, probe_type, probe_type, address, created_at, updated_at FROM probe_configs`); err != nil {
+		return fmt.Errorf("copy data: %w", err)
+	}
+
+	if _, err := tx.Exec(`DROP TABLE probe_configs`); err != nil {
+		return fmt.Errorf("drop old table: %w", err)
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE probe_configs_new RENAME TO probe_configs`); err != nil {
+		return fmt.Errorf("rename table: %w", err)
+	}
+
+	var hasBindings int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM pragma_table_info('nodes') WHERE name = 'probe_server'`).Scan(&hasBindings); err != nil {
+		return fmt.Errorf("inspect nodes table: %w", err)
+	}
+	if hasBindings > 0 {
+		if _, err := tx.Exec(`UPDATE nodes SET probe_server = (SELECT name FROM probe_configs ORDER BY id LIMIT 1) || '/' || probe_server WHERE probe_server != '' AND EXISTS (SELECT 1 FROM probe_configs)`); err != nil {
+			return fmt.Errorf("rewrite node probe bindings: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// migrateProbeConfigsForGenericTypes drops the probe_type CHECK constraint and adds the options column.
+func (r *TrafficRepository) migrateProbeConfigsForGenericTypes() error {
+	var schemaSQL string
+	if err := r.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='probe_configs'`).Scan(&schemaSQL); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("query schema: %w", err)
+	}
+	if !strings.Contains(schemaSQL, "CHECK (probe_type IN") {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+CREATE TABLE probe_configs_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    probe_type TEXT NOT NULL,
+    address TEXT NOT NULL,
+    options TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return fmt.Errorf("create new table: %w", err)
+	}
+
+	if _, err := tx.Exec(`INSERT INTO probe_configs_new (id, name, probe_type, address, created_at, updated_at) SELECT id, name, probe_type, address, created_at, updated_at FROM probe_configs`); err != nil {
+		return fmt.Errorf("copy data: %w", err)
+	}
+
+	if _, err := tx.Exec(`DROP TABLE probe_configs`); err != nil {
+		return fmt.Errorf("drop old table: %w", err)
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE probe_configs_new RENAME TO probe_configs`); err != nil {
+		return fmt.Errorf("rename table: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
 func (r *TrafficRepository) ensureUserColumn(name, definition string) error {
 	rows, err := r.db.Query(`PRAGMA table_info(users)`)
 	if err != nil {
@@ -1535,6 +2335,38 @@ func (r *TrafficRepository) ensureUserTokenColumn(name, definition string) error
 	return nil
 }
 
+func (r *TrafficRepository) ensureRuleVersionColumn(name, definition string) error {
+	rows, err := r.db.Query(`PRAGMA table_info(rule_versions)`)
+	if err != nil {
+		return fmt.Errorf("rule_versions table info: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			colName    string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return fmt.Errorf("scan table info: %w", err)
+		}
+		if strings.EqualFold(colName, name) {
+			return nil
+		}
+	}
+
+	alter := fmt.Sprintf("ALTER TABLE rule_versions ADD COLUMN %s %s", name, definition)
+	if _, err := r.db.Exec(alter); err != nil {
+		return fmt.Errorf("add column %s: %w", name, err)
+	}
+
+	return nil
+}
+
 func (r *TrafficRepository) ensureSubscriptionLinkColumn(name, definition string) error {
 	rows, err := r.db.Query(`PRAGMA table_info(subscription_links)`)
 	if err != nil {
@@ -1812,6 +2644,38 @@ func (r *TrafficRepository) ensureProxyProviderConfigColumn(name, definition str
 	return nil
 }
 
+func (r *TrafficRepository) ensureProbeServerColumn(name, definition string) error {
+	rows, err := r.db.Query(`PRAGMA table_info(probe_servers)`)
+	if err != nil {
+		return fmt.Errorf("probe_servers table info: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			colName    string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return fmt.Errorf("scan table info: %w", err)
+		}
+		if strings.EqualFold(colName, name) {
+			return nil
+		}
+	}
+
+	alter := fmt.Sprintf("ALTER TABLE probe_servers ADD COLUMN %s %s", name, definition)
+	if _, err := r.db.Exec(alter); err != nil {
+		return fmt.Errorf("add column %s: %w", name, err)
+	}
+
+	return nil
+}
+
 func (r *TrafficRepository) ensureSystemConfigColumn(name, definition string) error {
 	rows, err := r.db.Query(`PRAGMA table_info(system_config)`)
 	if err != nil {
@@ -2309,6 +3173,11 @@ func (r *TrafficRepository) GetUserShortCode(ctx context.Context, username strin
 
 // SaveRuleVersion persists a new rule version for the provided filename and returns the new version number.
 func (r *TrafficRepository) SaveRuleVersion(ctx context.Context, filename, content, createdBy string) (int64, error) {
+	return r.SaveRuleVersionWithNote(ctx, filename, content, createdBy, "")
+}
+
+// SaveRuleVersionWithNote persists a new rule version with an author note (e.g. "restored from v3").
+func (r *TrafficRepository) SaveRuleVersionWithNote(ctx context.Context, filename, content, createdBy, note string) (int64, error) {
 	if r == nil || r.db == nil {
 		return 0, errors.New("traffic repository not initialized")
 	}
@@ -2344,7 +3213,7 @@ func (r *TrafficRepository) SaveRuleVersion(ctx context.Context, filename, conte
 		newVersion = currentVersion.Int64 + 1
 	}
 
-	if _, err = tx.ExecContext(ctx, `INSERT INTO rule_versions (filename, version, content, created_by) VALUES (?, ?, ?, ?)`, filename, newVersion, content, createdBy); err != nil {
+	if _, err = tx.ExecContext(ctx, `INSERT INTO rule_versions (filename, version, content, created_by, note) VALUES (?, ?, ?, ?, ?)`, filename, newVersion, content, createdBy, strings.TrimSpace(note)); err != nil {
 		return 0, fmt.Errorf("insert rule version: %w", err)
 	}
 
@@ -2366,7 +3235,7 @@ func (r *TrafficRepository) ListRuleVersions(ctx context.Context, filename strin
 		limit = 10
 	}
 
-	rows, err := r.db.QueryContext(ctx, `SELECT version, content, created_by, created_at FROM rule_versions WHERE filename = ? ORDER BY version DESC LIMIT ?`, filename, limit)
+	rows, err := r.db.QueryContext(ctx, `SELECT version, content, created_by, note, created_at FROM rule_versions WHERE filename = ? ORDER BY version DESC LIMIT ?`, filename, limit)
 	if err != nil {
 		return nil, fmt.Errorf("query rule versions: %w", err)
 	}
@@ -2376,7 +3245,7 @@ func (r *TrafficRepository) ListRuleVersions(ctx context.Context, filename strin
 	for rows.Next() {
 		var rv RuleVersion
 		rv.Filename = filename
-		if err := rows.Scan(&rv.Version, &rv.Content, &rv.CreatedBy, &rv.CreatedAt); err != nil {
+		if err := rows.Scan(&rv.Version, &rv.Content, &rv.CreatedBy, &rv.Note, &rv.CreatedAt); err != nil {
 			return nil, fmt.Errorf("scan rule version: %w", err)
 		}
 		versions = append(versions, rv)
@@ -2401,12 +3270,36 @@ func (r *TrafficRepository) LatestRuleVersion(ctx context.Context, filename stri
 	return versions[0], nil
 }
 
+// GetRuleVersion returns a specific stored version of a rule file.
+func (r *TrafficRepository) GetRuleVersion(ctx context.Context, filename string, version int64) (RuleVersion, error) {
+	if r == nil || r.db == nil {
+		return RuleVersion{}, errors.New("traffic repository not initialized")
+	}
+
+	filename = strings.TrimSpace(filename)
+	if filename == "" {
+		return RuleVersion{}, errors.New("filename is required")
+	}
+
+	rv := RuleVersion{Filename: filename}
+	err := r.db.QueryRowContext(ctx, `SELECT version, content, created_by, note, created_at FROM rule_versions WHERE filename = ? AND version = ?`, filename, version).
+		Scan(&rv.Version, &rv.Content, &rv.CreatedBy, &rv.Note, &rv.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RuleVersion{}, ErrRuleVersionNotFound
+		}
+		return RuleVersion{}, fmt.Errorf("query rule version: %w", err)
+	}
+	return rv, nil
+}
+
 // RuleVersion represents an archived version of a YAML rule file.
 type RuleVersion struct {
 	Filename  string
 	Version   int64
 	Content   string
 	CreatedBy string
+	Note      string
 	CreatedAt time.Time
 }
 
@@ -2734,6 +3627,12 @@ func (r *TrafficRepository) DeleteUser(ctx context.Context, username string) err
 		return fmt.Errorf("delete user token: %w", err)
 	}
 
+	// Delete user's traffic quota
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_traffic_quotas WHERE username = ?`, username)
+	if err != nil {
+		return fmt.Errorf("delete user traffic quota: %w", err)
+	}
+
 	// Finally, delete the user
 	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE username = ?`, username)
 	if err != nil {
@@ -3173,11 +4072,11 @@ func (r *TrafficRepository) GetUserSettings(ctx context.Context, username string
 		return settings, errors.New("username is required")
 	}
 
-	const stmt = `SELECT username, force_sync_external, COALESCE(match_rule, 'node_name'), COALESCE(sync_scope, 'saved_only'), COALESCE(keep_node_name, 1), COALESCE(cache_expire_minutes, 0), COALESCE(sync_traffic, 0), COALESCE(enable_probe_binding, 0), COALESCE(custom_rules_enabled, 0), COALESCE(enable_short_link, 0), COALESCE(template_version, 'v2'), COALESCE(enable_proxy_provider, 0), COALESCE(node_order, '[]'), COALESCE(node_name_filter, '剩余|流量|到期|订阅|时间|重置'), COALESCE(debug_enabled, 0), COALESCE(debug_log_path, ''), debug_started_at, created_at, updated_at FROM user_settings WHERE username = ? LIMIT 1`
-	var forceSyncInt, keepNodeNameInt, syncTrafficInt, enableProbeBindingInt, customRulesEnabledInt, enableShortLinkInt, enableProxyProviderInt, debugEnabledInt int
+	const stmt = `SELECT username, force_sync_external, COALESCE(match_rule, 'node_name'), COALESCE(sync_scope, 'saved_only'), COALESCE(keep_node_name, 1), COALESCE(cache_expire_minutes, 0), COALESCE(sync_traffic, 0), COALESCE(enable_probe_binding, 0), COALESCE(custom_rules_enabled, 0), COALESCE(enable_short_link, 0), COALESCE(template_version, 'v2'), COALESCE(enable_proxy_provider, 0), COALESCE(node_order, '[]'), COALESCE(node_name_filter, '剩余|流量|到期|订阅|时间|重置'), COALESCE(debug_enabled, 0), COALESCE(debug_log_path, ''), debug_started_at, COALESCE(exclude_offline_nodes, 0), created_at, updated_at FROM user_settings WHERE username = ? LIMIT 1`
+	var forceSyncInt, keepNodeNameInt, syncTrafficInt, enableProbeBindingInt, customRulesEnabledInt, enableShortLinkInt, enableProxyProviderInt, debugEnabledInt, excludeOfflineNodesInt int
 	var nodeOrderJSON string
 	var debugStartedAt sql.NullTime
-	err := r.db.QueryRowContext(ctx, stmt, username).Scan(&settings.Username, &forceSyncInt, &settings.MatchRule, &settings.SyncScope, &keepNodeNameInt, &settings.CacheExpireMinutes, &syncTrafficInt, &enableProbeBindingInt, &customRulesEnabledInt, &enableShortLinkInt, &settings.TemplateVersion, &enableProxyProviderInt, &nodeOrderJSON, &settings.NodeNameFilter, &debugEnabledInt, &settings.DebugLogPath, &debugStartedAt, &settings.CreatedAt, &settings.UpdatedAt)
+	err := r.db.QueryRowContext(ctx, stmt, username).Scan(&settings.Username, &forceSyncInt, &settings.MatchRule, &settings.SyncScope, &keepNodeNameInt, &settings.CacheExpireMinutes, &syncTrafficInt, &enableProbeBindingInt, &customRulesEnabledInt, &enableShortLinkInt, &settings.TemplateVersion, &enableProxyProviderInt, &nodeOrderJSON, &settings.NodeNameFilter, &debugEnabledInt, &settings.DebugLogPath, &debugStartedAt, &excludeOfflineNodesInt, &settings.CreatedAt, &settings.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return settings, ErrUserSettingsNotFound
@@ -3189,6 +4088,7 @@ func (r *TrafficRepository) GetUserSettings(ctx context.Context, username string
 	settings.KeepNodeName = keepNodeNameInt == 1
 	settings.SyncTraffic = syncTrafficInt == 1
 	settings.EnableProbeBinding = enableProbeBindingInt == 1
+	settings.ExcludeOfflineNodes = excludeOfflineNodesInt == 1
 	settings.CustomRulesEnabled = customRulesEnabledInt == 1
 	settings.EnableShortLink = enableShortLinkInt == 1
 	settings.EnableProxyProvider = enableProxyProviderInt == 1
@@ -3243,6 +4143,11 @@ func (r *TrafficRepository) UpsertUserSettings(ctx context.Context, settings Use
 		enableProbeBindingInt = 1
 	}
 
+	excludeOfflineNodesInt := 0
+	if settings.ExcludeOfflineNodes {
+		excludeOfflineNodesInt = 1
+	}
+
 	customRulesEnabledInt := 0
 	if settings.CustomRulesEnabled {
 		customRulesEnabledInt = 1
@@ -3300,8 +4205,8 @@ func (r *TrafficRepository) UpsertUserSettings(ctx context.Context, settings Use
 	}
 
 	const stmt = `
-		INSERT INTO user_settings (username, force_sync_external, match_rule, sync_scope, keep_node_name, cache_expire_minutes, sync_traffic, enable_probe_binding, custom_rules_enabled, enable_short_link, template_version, enable_proxy_provider, node_order, node_name_filter, debug_enabled, debug_log_path, debug_started_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO user_settings (username, force_sync_external, match_rule, sync_scope, keep_node_name, cache_expire_minutes, sync_traffic, enable_probe_binding, custom_rules_enabled, enable_short_link, template_version, enable_proxy_provider, node_order, node_name_filter, debug_enabled, debug_log_path, debug_started_at, exclude_offline_nodes, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT(username) DO UPDATE SET
 			force_sync_external = excluded.force_sync_external,
 			match_rule = excluded.match_rule,
@@ -3319,10 +4224,11 @@ func (r *TrafficRepository) UpsertUserSettings(ctx context.Context, settings Use
 			debug_enabled = excluded.debug_enabled,
 			debug_log_path = excluded.debug_log_path,
 			debug_started_at = excluded.debug_started_at,
+			exclude_offline_nodes = excluded.exclude_offline_nodes,
 			updated_at = CURRENT_TIMESTAMP
 	`
 
-	if _, err := r.db.ExecContext(ctx, stmt, username, forceSyncInt, matchRule, syncScope, keepNodeNameInt, cacheExpireMinutes, syncTrafficInt, enableProbeBindingInt, customRulesEnabledInt, enableShortLinkInt, templateVersion, enableProxyProviderInt, nodeOrderJSON, nodeNameFilter, debugEnabledInt, settings.DebugLogPath, settings.DebugStartedAt); err != nil {
+	if _, err := r.db.ExecContext(ctx, stmt, username, forceSyncInt, matchRule, syncScope, keepNodeNameInt, cacheExpireMinutes, syncTrafficInt, enableProbeBindingInt, customRulesEnabledInt, enableShortLinkInt, templateVersion, enableProxyProviderInt, nodeOrderJSON, nodeNameFilter, debugEnabledInt, settings.DebugLogPath, settings.DebugStartedAt, excludeOfflineNodesInt); err != nil {
 		return fmt.Errorf("upsert user settings: %w", err)
 	}
 
@@ -3340,7 +4246,7 @@ func (r *TrafficRepository) ListExternalSubscriptions(ctx context.Context, usern
 		return nil, errors.New("username is required")
 	}
 
-	const stmt = `SELECT id, username, name, url, COALESCE(user_agent, 'clash-meta/2.4.0'), node_count, last_sync_at, COALESCE(upload, 0), COALESCE(download, 0), COALESCE(total, 0), expire, COALESCE(traffic_mode, 'both'), created_at, updated_at FROM external_subscriptions WHERE username = ? ORDER BY created_at DESC`
+	stmt := `SELECT ` + externalSubscriptionColumns + ` FROM external_subscriptions WHERE username = ? ORDER BY created_at DESC`
 	rows, err := r.db.QueryContext(ctx, stmt, username)
 	if err != nil {
 		return nil, fmt.Errorf("list external subscriptions: %w", err)
@@ -3349,17 +4255,10 @@ func (r *TrafficRepository) ListExternalSubscriptions(ctx context.Context, usern
 
 	var subs []ExternalSubscription
 	for rows.Next() {
-		var sub ExternalSubscription
-		var lastSyncAt, expire sql.NullTime
-		if err := rows.Scan(&sub.ID, &sub.Username, &sub.Name, &sub.URL, &sub.UserAgent, &sub.NodeCount, &lastSyncAt, &sub.Upload, &sub.Download, &sub.Total, &expire, &sub.TrafficMode, &sub.CreatedAt, &sub.UpdatedAt); err != nil {
+		sub, err := scanExternalSubscription(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan external subscription: %w", err)
 		}
-		if lastSyncAt.Valid {
-			sub.LastSyncAt = &lastSyncAt.Time
-		}
-		if expire.Valid {
-			sub.Expire = &expire.Time
-		}
 		subs = append(subs, sub)
 	}
 
@@ -3386,9 +4285,8 @@ func (r *TrafficRepository) GetExternalSubscription(ctx context.Context, id int6
 		return sub, errors.New("username is required")
 	}
 
-	const stmt = `SELECT id, username, name, url, COALESCE(user_agent, 'clash-meta/2.4.0'), node_count, last_sync_at, COALESCE(upload, 0), COALESCE(download, 0), COALESCE(total, 0), expire, COALESCE(traffic_mode, 'both'), created_at, updated_at FROM external_subscriptions WHERE id = ? AND username = ? LIMIT 1`
-	var lastSyncAt, expire sql.NullTime
-	err := r.db.QueryRowContext(ctx, stmt, id, username).Scan(&sub.ID, &sub.Username, &sub.Name, &sub.URL, &sub.UserAgent, &sub.NodeCount, &lastSyncAt, &sub.Upload, &sub.Download, &sub.Total, &expire, &sub.TrafficMode, &sub.CreatedAt, &sub.UpdatedAt)
+	stmt := `SELECT ` + externalSubscriptionColumns + ` FROM external_subscriptions WHERE id = ? AND username = ? LIMIT 1`
+	sub, err := scanExternalSubscription(r.db.QueryRowContext(ctx, stmt, id, username))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return sub, ErrExternalSubscriptionNotFound
@@ -3396,12 +4294,68 @@ func (r *TrafficRepository) GetExternalSubscription(ctx context.Context, id int6
 		return sub, fmt.Errorf("get external subscription: %w", err)
 	}
 
+	return sub, nil
+}
+
+const externalSubscriptionColumns = `id, username, name, url, COALESCE(user_agent, 'clash-meta/2.4.0'), node_count, last_sync_at, COALESCE(upload, 0), COALESCE(download, 0), COALESCE(total, 0), expire, COALESCE(traffic_mode, 'both'), created_at, updated_at,
+       sync_interval, last_sync_status, last_sync_error, last_sync_delta, last_sync_attempt_at,
+       upstream_proxy, fetch_timeout, custom_headers, skip_cert_verify, cert_fingerprint, node_transforms`
+
+func scanExternalSubscription(scanner rowScanner) (ExternalSubscription, error) {
+	var sub ExternalSubscription
+	var lastSyncAt, expire, lastAttemptAt sql.NullTime
+	var customHeaders string
+	var skipCertVerify int
+	if err := scanner.Scan(&sub.ID, &sub.Username, &sub.Name, &sub.URL, &sub.UserAgent, &sub.NodeCount, &lastSyncAt, &sub.Upload, &sub.Download, &sub.Total, &expire, &sub.TrafficMode, &sub.CreatedAt, &sub.UpdatedAt,
+		&sub.SyncInterval, &sub.LastSyncStatus, &sub.LastSyncError, &sub.LastSyncDelta, &lastAttemptAt,
+		&sub.UpstreamProxy, &sub.FetchTimeout, &customHeaders, &skipCertVerify, &sub.CertFingerprint, &sub.NodeTransforms); err != nil {
+		return sub, err
+	}
+	sub.SkipCertVerify = skipCertVerify != 0
+	if customHeaders != "" {
+		if err := json.Unmarshal([]byte(customHeaders), &sub.CustomHeaders); err != nil {
+			return sub, fmt.Errorf("unmarshal custom headers: %w", err)
+		}
+	}
 	if lastSyncAt.Valid {
 		sub.LastSyncAt = &lastSyncAt.Time
 	}
 	if expire.Valid {
 		sub.Expire = &expire.Time
 	}
+	if lastAttemptAt.Valid {
+		sub.LastSyncAttemptAt = &lastAttemptAt.Time
+	}
+	return sub, nil
+}
+
+// marshalCustomHeaders encodes custom request headers for storage; empty headers are stored as an empty string.
+func marshalCustomHeaders(headers map[string]string) (string, error) {
+	if len(headers) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return "", fmt.Errorf("marshal custom headers: %w", err)
+	}
+	return string(data), nil
+}
+
+// GetExternalSubscriptionByURL retrieves a user's external subscription by its URL.
+func (r *TrafficRepository) GetExternalSubscriptionByURL(ctx context.Context, username, url string) (ExternalSubscription, error) {
+	var sub ExternalSubscription
+	if r == nil || r.db == nil {
+		return sub, errors.New("traffic repository not initialized")
+	}
+
+	stmt := `SELECT ` + externalSubscriptionColumns + ` FROM external_subscriptions WHERE username = ? AND url = ? LIMIT 1`
+	sub, err := scanExternalSubscription(r.db.QueryRowContext(ctx, stmt, strings.TrimSpace(username), strings.TrimSpace(url)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sub, ErrExternalSubscriptionNotFound
+		}
+		return sub, fmt.Errorf("get external subscription by url: %w", err)
+	}
 
 	return sub, nil
 }
@@ -3437,8 +4391,19 @@ func (r *TrafficRepository) CreateExternalSubscription(ctx context.Context, sub
 		trafficMode = "both"
 	}
 
-	const stmt = `INSERT INTO external_subscriptions (username, name, url, user_agent, node_count, last_sync_at, upload, download, total, expire, traffic_mode) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	result, err := r.db.ExecContext(ctx, stmt, username, name, url, userAgent, sub.NodeCount, sub.LastSyncAt, sub.Upload, sub.Download, sub.Total, sub.Expire, trafficMode)
+	if sub.SyncInterval < 0 {
+		sub.SyncInterval = 0
+	}
+
+	customHeaders, err := marshalCustomHeaders(sub.CustomHeaders)
+	if err != nil {
+		return 0, err
+	}
+
+	const stmt = `INSERT INTO external_subscriptions (username, name, url, user_agent, node_count, last_sync_at, upload, download, total, expire, traffic_mode, sync_interval,
+		upstream_proxy, fetch_timeout, custom_headers, skip_cert_verify, cert_fingerprint, node_transforms) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := r.db.ExecContext(ctx, stmt, username, name, url, userAgent, sub.NodeCount, sub.LastSyncAt, sub.Upload, sub.Download, sub.Total, sub.Expire, trafficMode, sub.SyncInterval,
+		strings.TrimSpace(sub.UpstreamProxy), max(sub.FetchTimeout, 0), customHeaders, boolToInt(sub.SkipCertVerify), strings.TrimSpace(sub.CertFingerprint), sub.NodeTransforms)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 			return 0, ErrExternalSubscriptionExists
@@ -3489,8 +4454,19 @@ func (r *TrafficRepository) UpdateExternalSubscription(ctx context.Context, sub
 		trafficMode = "both"
 	}
 
-	const stmt = `UPDATE external_subscriptions SET name = ?, url = ?, user_agent = ?, node_count = ?, last_sync_at = ?, upload = ?, download = ?, total = ?, expire = ?, traffic_mode = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND username = ?`
-	result, err := r.db.ExecContext(ctx, stmt, name, url, userAgent, sub.NodeCount, sub.LastSyncAt, sub.Upload, sub.Download, sub.Total, sub.Expire, trafficMode, sub.ID, username)
+	if sub.SyncInterval < 0 {
+		sub.SyncInterval = 0
+	}
+
+	customHeaders, err := marshalCustomHeaders(sub.CustomHeaders)
+	if err != nil {
+		return err
+	}
+
+	const stmt = `UPDATE external_subscriptions SET name = ?, url = ?, user_agent = ?, node_count = ?, last_sync_at = ?, upload = ?, download = ?, total = ?, expire = ?, traffic_mode = ?, sync_interval = ?,
+		upstream_proxy = ?, fetch_timeout = ?, custom_headers = ?, skip_cert_verify = ?, cert_fingerprint = ?, node_transforms = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND username = ?`
+	result, err := r.db.ExecContext(ctx, stmt, name, url, userAgent, sub.NodeCount, sub.LastSyncAt, sub.Upload, sub.Download, sub.Total, sub.Expire, trafficMode, sub.SyncInterval,
+		strings.TrimSpace(sub.UpstreamProxy), max(sub.FetchTimeout, 0), customHeaders, boolToInt(sub.SkipCertVerify), strings.TrimSpace(sub.CertFingerprint), sub.NodeTransforms, sub.ID, username)
 	if err != nil {
 		return fmt.Errorf("update external subscription: %w", err)
 	}
@@ -3507,6 +4483,32 @@ func (r *TrafficRepository) UpdateExternalSubscription(ctx context.Context, sub
 	return nil
 }
 
+// RecordExternalSubscriptionSyncResult stores the outcome of the latest sync attempt.
+func (r *TrafficRepository) RecordExternalSubscriptionSyncResult(ctx context.Context, id int64, result ExternalSubscriptionSyncResult) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	if id <= 0 {
+		return errors.New("subscription id is required")
+	}
+
+	status := "failed"
+	if result.Success {
+		status = "success"
+	}
+	if result.AttemptAt.IsZero() {
+		result.AttemptAt = time.Now()
+	}
+
+	const stmt = `UPDATE external_subscriptions SET last_sync_status = ?, last_sync_error = ?, last_sync_delta = ?, last_sync_attempt_at = ? WHERE id = ?`
+	if _, err := r.db.ExecContext(ctx, stmt, status, result.Error, result.NodeDelta, result.AttemptAt, id); err != nil {
+		return fmt.Errorf("record external subscription sync result: %w", err)
+	}
+
+	return nil
+}
+
 // DeleteExternalSubscription deletes an external subscription.
 func (r *TrafficRepository) DeleteExternalSubscription(ctx context.Context, id int64, username string) error {
 	if r == nil || r.db == nil {
@@ -3528,6 +4530,14 @@ func (r *TrafficRepository) DeleteExternalSubscription(ctx context.Context, id i
 		return fmt.Errorf("delete related proxy provider configs: %w", err)
 	}
 
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM external_subscription_changes WHERE subscription_id = ? AND username = ?`, id, username); err != nil {
+		return fmt.Errorf("delete related change sets: %w", err)
+	}
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM external_subscription_http_cache WHERE subscription_id IN (SELECT id FROM external_subscriptions WHERE id = ? AND username = ?)`, id, username); err != nil {
+		return fmt.Errorf("delete related http cache: %w", err)
+	}
+
 	const stmt = `DELETE FROM external_subscriptions WHERE id = ? AND username = ?`
 	result, err := r.db.ExecContext(ctx, stmt, id, username)
 	if err != nil {
@@ -3932,7 +4942,7 @@ func (r *TrafficRepository) ListAllExternalSubscriptions(ctx context.Context) ([
 		return nil, errors.New("traffic repository not initialized")
 	}
 
-	const stmt = `SELECT id, username, name, url, COALESCE(user_agent, 'clash-meta/2.4.0'), node_count, last_sync_at, COALESCE(upload, 0), COALESCE(download, 0), COALESCE(total, 0), expire, COALESCE(traffic_mode, 'both'), created_at, updated_at FROM external_subscriptions ORDER BY created_at DESC`
+	stmt := `SELECT ` + externalSubscriptionColumns + ` FROM external_subscriptions ORDER BY created_at DESC`
 	rows, err := r.db.QueryContext(ctx, stmt)
 	if err != nil {
 		return nil, fmt.Errorf("list all external subscriptions: %w", err)
@@ -3941,18 +4951,10 @@ func (r *TrafficRepository) ListAllExternalSubscriptions(ctx context.Context) ([
 
 	var subs []ExternalSubscription
 	for rows.Next() {
-		var sub ExternalSubscription
-		var lastSyncAt sql.NullTime
-		var expire sql.NullTime
-		if err := rows.Scan(&sub.ID, &sub.Username, &sub.Name, &sub.URL, &sub.UserAgent, &sub.NodeCount, &lastSyncAt, &sub.Upload, &sub.Download, &sub.Total, &expire, &sub.TrafficMode, &sub.CreatedAt, &sub.UpdatedAt); err != nil {
+		sub, err := scanExternalSubscription(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan external subscription: %w", err)
 		}
-		if lastSyncAt.Valid {
-			sub.LastSyncAt = &lastSyncAt.Time
-		}
-		if expire.Valid {
-			sub.Expire = &expire.Time
-		}
 		subs = append(subs, sub)
 	}
 
@@ -4024,14 +5026,16 @@ func (r *TrafficRepository) CreateProxyProviderConfig(ctx context.Context, confi
 			username, external_subscription_id, name, type, interval, proxy, size_limit, header,
 			health_check_enabled, health_check_url, health_check_interval, health_check_timeout,
 			health_check_lazy, health_check_expected_status,
-			filter, exclude_filter, exclude_type, geo_ip_filter, override, process_mode
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			filter, exclude_filter, exclude_type, geo_ip_filter, override, process_mode, node_transforms,
+			extra_subscription_ids, node_tags, health_check_prune, health_check_prune_failures
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		config.Username, config.ExternalSubscriptionID, config.Name, config.Type,
 		config.Interval, config.Proxy, config.SizeLimit, config.Header,
 		healthCheckEnabled, config.HealthCheckURL, config.HealthCheckInterval, config.HealthCheckTimeout,
 		healthCheckLazy, config.HealthCheckExpectedStatus,
-		config.Filter, config.ExcludeFilter, config.ExcludeType, config.GeoIPFilter, config.Override, config.ProcessMode,
+		config.Filter, config.ExcludeFilter, config.ExcludeType, config.GeoIPFilter, config.Override, config.ProcessMode, config.NodeTransforms,
+		config.ExtraSubscriptionIDs, config.NodeTags, boolToInt(config.HealthCheckPrune), config.HealthCheckPruneFailures,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("create proxy provider config: %w", err)
@@ -4051,19 +5055,23 @@ func (r *TrafficRepository) GetProxyProviderConfig(ctx context.Context, id int64
 			COALESCE(header, ''), health_check_enabled, health_check_url, health_check_interval,
 			health_check_timeout, health_check_lazy, health_check_expected_status,
 			COALESCE(filter, ''), COALESCE(exclude_filter, ''), COALESCE(exclude_type, ''),
-			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, created_at, updated_at
+			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, COALESCE(node_transforms, ''),
+			COALESCE(extra_subscription_ids, ''), COALESCE(node_tags, ''), health_check_prune, health_check_prune_failures,
+			created_at, updated_at
 		FROM proxy_provider_configs WHERE id = ?
 	`, id)
 
 	var config ProxyProviderConfig
-	var healthCheckEnabled, healthCheckLazy int
+	var healthCheckEnabled, healthCheckLazy, healthCheckPrune int
 	err := row.Scan(
 		&config.ID, &config.Username, &config.ExternalSubscriptionID, &config.Name, &config.Type,
 		&config.Interval, &config.Proxy, &config.SizeLimit, &config.Header,
 		&healthCheckEnabled, &config.HealthCheckURL, &config.HealthCheckInterval,
 		&config.HealthCheckTimeout, &healthCheckLazy, &config.HealthCheckExpectedStatus,
 		&config.Filter, &config.ExcludeFilter, &config.ExcludeType,
-		&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.CreatedAt, &config.UpdatedAt,
+		&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.NodeTransforms,
+		&config.ExtraSubscriptionIDs, &config.NodeTags, &healthCheckPrune, &config.HealthCheckPruneFailures,
+		&config.CreatedAt, &config.UpdatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -4074,6 +5082,7 @@ func (r *TrafficRepository) GetProxyProviderConfig(ctx context.Context, id int64
 
 	config.HealthCheckEnabled = healthCheckEnabled != 0
 	config.HealthCheckLazy = healthCheckLazy != 0
+	config.HealthCheckPrune = healthCheckPrune != 0
 
 	return &config, nil
 }
@@ -4089,19 +5098,23 @@ func (r *TrafficRepository) GetProxyProviderConfigByName(ctx context.Context, na
 			COALESCE(header, ''), health_check_enabled, health_check_url, health_check_interval,
 			health_check_timeout, health_check_lazy, health_check_expected_status,
 			COALESCE(filter, ''), COALESCE(exclude_filter, ''), COALESCE(exclude_type, ''),
-			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, created_at, updated_at
+			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, COALESCE(node_transforms, ''),
+			COALESCE(extra_subscription_ids, ''), COALESCE(node_tags, ''), health_check_prune, health_check_prune_failures,
+			created_at, updated_at
 		FROM proxy_provider_configs WHERE name = ?
 	`, name)
 
 	var config ProxyProviderConfig
-	var healthCheckEnabled, healthCheckLazy int
+	var healthCheckEnabled, healthCheckLazy, healthCheckPrune int
 	err := row.Scan(
 		&config.ID, &config.Username, &config.ExternalSubscriptionID, &config.Name, &config.Type,
 		&config.Interval, &config.Proxy, &config.SizeLimit, &config.Header,
 		&healthCheckEnabled, &config.HealthCheckURL, &config.HealthCheckInterval,
 		&config.HealthCheckTimeout, &healthCheckLazy, &config.HealthCheckExpectedStatus,
 		&config.Filter, &config.ExcludeFilter, &config.ExcludeType,
-		&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.CreatedAt, &config.UpdatedAt,
+		&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.NodeTransforms,
+		&config.ExtraSubscriptionIDs, &config.NodeTags, &healthCheckPrune, &config.HealthCheckPruneFailures,
+		&config.CreatedAt, &config.UpdatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -4112,6 +5125,7 @@ func (r *TrafficRepository) GetProxyProviderConfigByName(ctx context.Context, na
 
 	config.HealthCheckEnabled = healthCheckEnabled != 0
 	config.HealthCheckLazy = healthCheckLazy != 0
+	config.HealthCheckPrune = healthCheckPrune != 0
 
 	return &config, nil
 }
@@ -4127,7 +5141,9 @@ func (r *TrafficRepository) ListProxyProviderConfigs(ctx context.Context, userna
 			COALESCE(header, ''), health_check_enabled, health_check_url, health_check_interval,
 			health_check_timeout, health_check_lazy, health_check_expected_status,
 			COALESCE(filter, ''), COALESCE(exclude_filter, ''), COALESCE(exclude_type, ''),
-			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, created_at, updated_at
+			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, COALESCE(node_transforms, ''),
+			COALESCE(extra_subscription_ids, ''), COALESCE(node_tags, ''), health_check_prune, health_check_prune_failures,
+			created_at, updated_at
 		FROM proxy_provider_configs WHERE username = ? ORDER BY id ASC
 	`, username)
 	if err != nil {
@@ -4138,20 +5154,23 @@ func (r *TrafficRepository) ListProxyProviderConfigs(ctx context.Context, userna
 	var configs []ProxyProviderConfig
 	for rows.Next() {
 		var config ProxyProviderConfig
-		var healthCheckEnabled, healthCheckLazy int
+		var healthCheckEnabled, healthCheckLazy, healthCheckPrune int
 		err := rows.Scan(
 			&config.ID, &config.Username, &config.ExternalSubscriptionID, &config.Name, &config.Type,
 			&config.Interval, &config.Proxy, &config.SizeLimit, &config.Header,
 			&healthCheckEnabled, &config.HealthCheckURL, &config.HealthCheckInterval,
 			&config.HealthCheckTimeout, &healthCheckLazy, &config.HealthCheckExpectedStatus,
 			&config.Filter, &config.ExcludeFilter, &config.ExcludeType,
-			&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.CreatedAt, &config.UpdatedAt,
+			&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.NodeTransforms,
+			&config.ExtraSubscriptionIDs, &config.NodeTags, &healthCheckPrune, &config.HealthCheckPruneFailures,
+			&config.CreatedAt, &config.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan proxy provider config: %w", err)
 		}
 		config.HealthCheckEnabled = healthCheckEnabled != 0
 		config.HealthCheckLazy = healthCheckLazy != 0
+		config.HealthCheckPrune = healthCheckPrune != 0
 		configs = append(configs, config)
 	}
 
@@ -4162,7 +5181,8 @@ func (r *TrafficRepository) ListProxyProviderConfigs(ctx context.Context, userna
 	return configs, nil
 }
 
-// ListProxyProviderConfigsBySubscription returns all proxy provider configs for an external subscription
+// ListProxyProviderConfigsBySubscription returns all proxy provider configs for an external subscription,
+// including composite configs that merge it as an extra source
 func (r *TrafficRepository) ListProxyProviderConfigsBySubscription(ctx context.Context, externalSubscriptionID int64) ([]ProxyProviderConfig, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("traffic repository not initialized")
@@ -4173,9 +5193,13 @@ func (r *TrafficRepository) ListProxyProviderConfigsBySubscription(ctx context.C
 			COALESCE(header, ''), health_check_enabled, health_check_url, health_check_interval,
 			health_check_timeout, health_check_lazy, health_check_expected_status,
 			COALESCE(filter, ''), COALESCE(exclude_filter, ''), COALESCE(exclude_type, ''),
-			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, created_at, updated_at
-		FROM proxy_provider_configs WHERE external_subscription_id = ? ORDER BY id ASC
-	`, externalSubscriptionID)
+			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, COALESCE(node_transforms, ''),
+			COALESCE(extra_subscription_ids, ''), COALESCE(node_tags, ''), health_check_prune, health_check_prune_failures,
+			created_at, updated_at
+		FROM proxy_provider_configs
+		WHERE external_subscription_id = ? OR (',' || COALESCE(extra_subscription_ids, '') || ',') LIKE ?
+		ORDER BY id ASC
+	`, externalSubscriptionID, fmt.Sprintf("%%,%d,%%", externalSubscriptionID))
 	if err != nil {
 		return nil, fmt.Errorf("list proxy provider configs by subscription: %w", err)
 	}
@@ -4184,20 +5208,23 @@ func (r *TrafficRepository) ListProxyProviderConfigsBySubscription(ctx context.C
 	var configs []ProxyProviderConfig
 	for rows.Next() {
 		var config ProxyProviderConfig
-		var healthCheckEnabled, healthCheckLazy int
+		var healthCheckEnabled, healthCheckLazy, healthCheckPrune int
 		err := rows.Scan(
 			&config.ID, &config.Username, &config.ExternalSubscriptionID, &config.Name, &config.Type,
 			&config.Interval, &config.Proxy, &config.SizeLimit, &config.Header,
 			&healthCheckEnabled, &config.HealthCheckURL, &config.HealthCheckInterval,
 			&config.HealthCheckTimeout, &healthCheckLazy, &config.HealthCheckExpectedStatus,
 			&config.Filter, &config.ExcludeFilter, &config.ExcludeType,
-			&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.CreatedAt, &config.UpdatedAt,
+			&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.NodeTransforms,
+			&config.ExtraSubscriptionIDs, &config.NodeTags, &healthCheckPrune, &config.HealthCheckPruneFailures,
+			&config.CreatedAt, &config.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan proxy provider config: %w", err)
 		}
 		config.HealthCheckEnabled = healthCheckEnabled != 0
 		config.HealthCheckLazy = healthCheckLazy != 0
+		config.HealthCheckPrune = healthCheckPrune != 0
 		configs = append(configs, config)
 	}
 
@@ -4220,7 +5247,9 @@ func (r *TrafficRepository) ListMMWProxyProviderConfigs(ctx context.Context) ([]
 			COALESCE(header, ''), health_check_enabled, health_check_url, health_check_interval,
 			health_check_timeout, health_check_lazy, health_check_expected_status,
 			COALESCE(filter, ''), COALESCE(exclude_filter, ''), COALESCE(exclude_type, ''),
-			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, created_at, updated_at
+			COALESCE(geo_ip_filter, ''), COALESCE(override, ''), process_mode, COALESCE(node_transforms, ''),
+			COALESCE(extra_subscription_ids, ''), COALESCE(node_tags, ''), health_check_prune, health_check_prune_failures,
+			created_at, updated_at
 		FROM proxy_provider_configs
 		WHERE process_mode = 'mmw'
 		ORDER BY id ASC
@@ -4233,20 +5262,23 @@ func (r *TrafficRepository) ListMMWProxyProviderConfigs(ctx context.Context) ([]
 	var configs []ProxyProviderConfig
 	for rows.Next() {
 		var config ProxyProviderConfig
-		var healthCheckEnabled, healthCheckLazy int
+		var healthCheckEnabled, healthCheckLazy, healthCheckPrune int
 		err := rows.Scan(
 			&config.ID, &config.Username, &config.ExternalSubscriptionID, &config.Name, &config.Type,
 			&config.Interval, &config.Proxy, &config.SizeLimit, &config.Header,
 			&healthCheckEnabled, &config.HealthCheckURL, &config.HealthCheckInterval,
 			&config.HealthCheckTimeout, &healthCheckLazy, &config.HealthCheckExpectedStatus,
 			&config.Filter, &config.ExcludeFilter, &config.ExcludeType,
-			&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.CreatedAt, &config.UpdatedAt,
+			&config.GeoIPFilter, &config.Override, &config.ProcessMode, &config.NodeTransforms,
+			&config.ExtraSubscriptionIDs, &config.NodeTags, &healthCheckPrune, &config.HealthCheckPruneFailures,
+			&config.CreatedAt, &config.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan mmw proxy provider config: %w", err)
 		}
 		config.HealthCheckEnabled = healthCheckEnabled != 0
 		config.HealthCheckLazy = healthCheckLazy != 0
+		config.HealthCheckPrune = healthCheckPrune != 0
 		configs = append(configs, config)
 	}
 
@@ -4278,14 +5310,16 @@ func (r *TrafficRepository) UpdateProxyProviderConfig(ctx context.Context, confi
 			health_check_enabled = ?, health_check_url = ?, health_check_interval = ?,
 			health_check_timeout = ?, health_check_lazy = ?, health_check_expected_status = ?,
 			filter = ?, exclude_filter = ?, exclude_type = ?, geo_ip_filter = ?, override = ?, process_mode = ?,
-			updated_at = CURRENT_TIMESTAMP
+			node_transforms = ?, extra_subscription_ids = ?, node_tags = ?,
+			health_check_prune = ?, health_check_prune_failures = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ? AND username = ?
 	`,
 		config.Name, config.Type, config.Interval, config.Proxy, config.SizeLimit, config.Header,
 		healthCheckEnabled, config.HealthCheckURL, config.HealthCheckInterval,
 		config.HealthCheckTimeout, healthCheckLazy, config.HealthCheckExpectedStatus,
 		config.Filter, config.ExcludeFilter, config.ExcludeType, config.GeoIPFilter, config.Override, config.ProcessMode,
-		config.ID, config.Username,
+		config.NodeTransforms, config.ExtraSubscriptionIDs, config.NodeTags,
+		boolToInt(config.HealthCheckPrune), config.HealthCheckPruneFailures, config.ID, config.Username,
 	)
 	if err != nil {
 		return fmt.Errorf("update proxy provider config: %w", err)
@@ -4321,25 +5355,66 @@ func (r *TrafficRepository) DeleteProxyProviderConfig(ctx context.Context, id in
 		return errors.New("proxy provider config not found or not owned by user")
 	}
 
+	if err := r.deleteProxyProviderNodeHealth(ctx, id); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// GetOrCreateURLSigningKey returns the server key used to sign generated URLs,
+// creating a random one on first use.
+func (r *TrafficRepository) GetOrCreateURLSigningKey(ctx context.Context) ([]byte, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	var key string
+	if err := r.db.QueryRowContext(ctx, `SELECT url_signing_key FROM system_config WHERE id = 1`).Scan(&key); err != nil {
+		return nil, fmt.Errorf("query url signing key: %w", err)
+	}
+	if key == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generate url signing key: %w", err)
+		}
+		// 并发生成时只有第一个写入生效，之后重新读取
+		if _, err := r.db.ExecContext(ctx, `UPDATE system_config SET url_signing_key = ? WHERE id = 1 AND url_signing_key = ''`, hex.EncodeToString(buf)); err != nil {
+			return nil, fmt.Errorf("save url signing key: %w", err)
+		}
+		if err := r.db.QueryRowContext(ctx, `SELECT url_signing_key FROM system_config WHERE id = 1`).Scan(&key); err != nil {
+			return nil, fmt.Errorf("query url signing key: %w", err)
+		}
+	}
+
+	decoded, err := hex.DecodeString(key)
+	if err != nil || len(decoded) == 0 {
+		return nil, errors.New("invalid url signing key")
+	}
+	return decoded, nil
+}
+
 // GetSystemConfig retrieves the global system configuration.
 // Returns an empty SystemConfig if the row doesn't exist (should not happen after migration).
 func (r *TrafficRepository) GetSystemConfig(ctx context.Context) (SystemConfig, error) {
 	const query = `
-SELECT proxy_groups_source_url, client_compatibility_mode, silent_mode, silent_mode_timeout
+SELECT proxy_groups_source_url, client_compatibility_mode, silent_mode, silent_mode_timeout,
+       public_base_url, rule_set_mirror_enabled, rule_set_mirror_interval, rule_set_rewrite_url, rule_set_inline_max_rules,
+       traffic_collect_time, traffic_collect_timezone
 FROM system_config
 WHERE id = 1
 `
 
 	var cfg SystemConfig
 	var compatibilityMode, silentMode, silentModeTimeout int
-	err := r.db.QueryRowContext(ctx, query).Scan(&cfg.ProxyGroupsSourceURL, &compatibilityMode, &silentMode, &silentModeTimeout)
+	var mirrorEnabled, rewriteURL int
+	err := r.db.QueryRowContext(ctx, query).Scan(&cfg.ProxyGroupsSourceURL, &compatibilityMode, &silentMode, &silentModeTimeout,
+		&cfg.PublicBaseURL, &mirrorEnabled, &cfg.RuleSetMirrorInterval, &rewriteURL, &cfg.RuleSetInlineMaxRules,
+		&cfg.TrafficCollectTime, &cfg.TrafficCollectTimezone)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			// Return empty config if row doesn't exist (defensive)
-			return SystemConfig{SilentModeTimeout: 15}, nil
+			return SystemConfig{SilentModeTimeout: 15, RuleSetMirrorInterval: 24, TrafficCollectTime: DefaultTrafficCollectTime}, nil
 		}
 		return SystemConfig{}, fmt.Errorf("query system config: %w", err)
 	}
@@ -4350,6 +5425,14 @@ WHERE id = 1
 	if cfg.SilentModeTimeout <= 0 {
 		cfg.SilentModeTimeout = 15
 	}
+	cfg.RuleSetMirrorEnabled = mirrorEnabled != 0
+	cfg.RuleSetRewriteURL = rewriteURL != 0
+	if cfg.RuleSetMirrorInterval <= 0 {
+		cfg.RuleSetMirrorInterval = 24
+	}
+	if strings.TrimSpace(cfg.TrafficCollectTime) == "" {
+		cfg.TrafficCollectTime = DefaultTrafficCollectTime
+	}
 	return cfg, nil
 }
 
@@ -4362,6 +5445,13 @@ SET proxy_groups_source_url = ?,
     client_compatibility_mode = ?,
     silent_mode = ?,
     silent_mode_timeout = ?,
+    public_base_url = ?,
+    rule_set_mirror_enabled = ?,
+    rule_set_mirror_interval = ?,
+    rule_set_rewrite_url = ?,
+    rule_set_inline_max_rules = ?,
+    traffic_collect_time = ?,
+    traffic_collect_timezone = ?,
     updated_at = CURRENT_TIMESTAMP
 WHERE id = 1
 `
@@ -4378,8 +5468,31 @@ WHERE id = 1
 	if silentModeTimeout <= 0 {
 		silentModeTimeout = 15
 	}
+	mirrorEnabled := 0
+	if cfg.RuleSetMirrorEnabled {
+		mirrorEnabled = 1
+	}
+	mirrorInterval := cfg.RuleSetMirrorInterval
+	if mirrorInterval <= 0 {
+		mirrorInterval = 24
+	}
+	rewriteURL := 0
+	if cfg.RuleSetRewriteURL {
+		rewriteURL = 1
+	}
+	inlineMaxRules := cfg.RuleSetInlineMaxRules
+	if inlineMaxRules < 0 {
+		inlineMaxRules = 0
+	}
+	publicBaseURL := strings.TrimRight(strings.TrimSpace(cfg.PublicBaseURL), "/")
+	collectTime := strings.TrimSpace(cfg.TrafficCollectTime)
+	if collectTime == "" {
+		collectTime = DefaultTrafficCollectTime
+	}
+	collectTimezone := strings.TrimSpace(cfg.TrafficCollectTimezone)
 
-	result, err := r.db.ExecContext(ctx, updateStmt, cfg.ProxyGroupsSourceURL, compatibilityMode, silentMode, silentModeTimeout)
+	result, err := r.db.ExecContext(ctx, updateStmt, cfg.ProxyGroupsSourceURL, compatibilityMode, silentMode, silentModeTimeout,
+		publicBaseURL, mirrorEnabled, mirrorInterval, rewriteURL, inlineMaxRules, collectTime, collectTimezone)
 	if err != nil {
 		return fmt.Errorf("update system config: %w", err)
 	}
@@ -4392,10 +5505,13 @@ WHERE id = 1
 	// If no rows were updated, insert the singleton row (defensive fallback)
 	if rowsAffected == 0 {
 		const insertStmt = `
-INSERT INTO system_config (id, proxy_groups_source_url, client_compatibility_mode, silent_mode, silent_mode_timeout)
-VALUES (1, ?, ?, ?, ?)
+INSERT INTO system_config (id, proxy_groups_source_url, client_compatibility_mode, silent_mode, silent_mode_timeout,
+    public_base_url, rule_set_mirror_enabled, rule_set_mirror_interval, rule_set_rewrite_url, rule_set_inline_max_rules,
+    traffic_collect_time, traffic_collect_timezone)
+VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
-		if _, err := r.db.ExecContext(ctx, insertStmt, cfg.ProxyGroupsSourceURL, compatibilityMode, silentMode, silentModeTimeout); err != nil {
+		if _, err := r.db.ExecContext(ctx, insertStmt, cfg.ProxyGroupsSourceURL, compatibilityMode, silentMode, silentModeTimeout,
+			publicBaseURL, mirrorEnabled, mirrorInterval, rewriteURL, inlineMaxRules, collectTime, collectTimezone); err != nil {
 			return fmt.Errorf("insert system config: %w", err)
 		}
 	}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultTrafficCollectTime is the daily traffic snapshot time used when none is configured.
+const DefaultTrafficCollectTime = "23:55"
+
+// TrafficCollectRun is the outcome of the latest scheduled traffic collection.
+type TrafficCollectRun struct {
+	ScheduledAt *time.Time // 本次运行对应的计划时间，补采时早于 StartedAt
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+	SucceededAt *time.Time // 最近一次成功完成的时间
+	LastError   string
+}
+
+// GetTrafficCollectRun returns the latest traffic collection run; a zero value means it never ran.
+func (r *TrafficRepository) GetTrafficCollectRun(ctx context.Context) (TrafficCollectRun, error) {
+	var run TrafficCollectRun
+	if r == nil || r.db == nil {
+		return run, errors.New("traffic repository not initialized")
+	}
+
+	var scheduledAt, startedAt, finishedAt, succeededAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+SELECT scheduled_at, started_at, finished_at, succeeded_at, last_error
+FROM traffic_collect_runs
+WHERE id = 1
+`).Scan(&scheduledAt, &startedAt, &finishedAt, &succeededAt, &run.LastError)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return run, nil
+		}
+		return run, fmt.Errorf("query traffic collect run: %w", err)
+	}
+
+	for _, field := range []struct {
+		value sql.NullTime
+		dest  **time.Time
+	}{
+		{scheduledAt, &run.ScheduledAt},
+		{startedAt, &run.StartedAt},
+		{finishedAt, &run.FinishedAt},
+		{succeededAt, &run.SucceededAt},
+	} {
+		if field.value.Valid {
+			t := field.value.Time
+			*field.dest = &t
+		}
+	}
+	return run, nil
+}
+
+// SaveTrafficCollectRun stores the latest traffic collection run.
+func (r *TrafficRepository) SaveTrafficCollectRun(ctx context.Context, run TrafficCollectRun) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	if _, err := r.db.ExecContext(ctx, `
+INSERT INTO traffic_collect_runs (id, scheduled_at, started_at, finished_at, succeeded_at, last_error)
+VALUES (1, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+    scheduled_at = excluded.scheduled_at,
+    started_at = excluded.started_at,
+    finished_at = excluded.finished_at,
+    succeeded_at = excluded.succeeded_at,
+    last_error = excluded.last_error;
+`, run.ScheduledAt, run.StartedAt, run.FinishedAt, run.SucceededAt, run.LastError); err != nil {
+		return fmt.Errorf("save traffic collect run: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 按来源拆分的每日流量快照：探针服务器、外部订阅和用户
+const (
+	TrafficSourceProbeServer          = "probe_server"
+	TrafficSourceExternalSubscription = "external_subscription"
+	TrafficSourceUser                 = "user"
+)
+
+var allowedTrafficSources = map[string]struct{}{
+	TrafficSourceProbeServer:          {},
+	TrafficSourceExternalSubscription: {},
+	TrafficSourceUser:                 {},
+}
+
+// TrafficSourceRecord is a daily traffic snapshot of a single probe server, external subscription or user.
+type TrafficSourceRecord struct {
+	Date           time.Time
+	SourceType     string
+	SourceKey      string // "probe/server" binding, external subscription id or username
+	SourceName     string
+	Username       string // owner of an external subscription, or the user itself
+	TotalLimit     int64
+	TotalUsed      int64
+	TotalRemaining int64
+}
+
+// TrafficSourceFilter selects per-source snapshots; zero values match everything.
+type TrafficSourceFilter struct {
+	SourceType string
+	SourceKey  string
+	Username   string
+	From       time.Time
+	To         time.Time
+}
+
+// IsValidTrafficSource reports whether the source type is supported.
+func IsValidTrafficSource(sourceType string) bool {
+	_, ok := allowedTrafficSources[sourceType]
+	return ok
+}
+
+// RecordTrafficSources upserts the per-source snapshots of a day.
+func (r *TrafficRepository) RecordTrafficSources(ctx context.Context, date time.Time, records []TrafficSourceRecord) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	normalized := date.UTC().Format("2006-01-02")
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin traffic source tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+INSERT INTO traffic_source_records (date, source_type, source_key, source_name, username, total_limit, total_used, total_remaining)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(date, source_type, source_key) DO UPDATE SET
+    source_name = excluded.source_name,
+    username = excluded.username,
+    total_limit = excluded.total_limit,
+    total_used = excluded.total_used,
+    total_remaining = excluded.total_remaining,
+    created_at = CURRENT_TIMESTAMP;
+`)
+	if err != nil {
+		return fmt.Errorf("prepare traffic source upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, record := range records {
+		if !IsValidTrafficSource(record.SourceType) {
+			return fmt.Errorf("unsupported traffic source %q", record.SourceType)
+		}
+		key := strings.TrimSpace(record.SourceKey)
+		if key == "" {
+			return errors.New("traffic source key is required")
+		}
+		if _, err := stmt.ExecContext(ctx, normalized, record.SourceType, key, record.SourceName, record.Username,
+			record.TotalLimit, record.TotalUsed, record.TotalRemaining); err != nil {
+			return fmt.Errorf("upsert traffic source %s/%s: %w", record.SourceType, key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit traffic sources: %w", err)
+	}
+	return nil
+}
+
+// ListTrafficSourceRecords returns per-source snapshots ordered by source and date.
+func (r *TrafficRepository) ListTrafficSourceRecords(ctx context.Context, filter TrafficSourceFilter) ([]TrafficSourceRecord, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	var (
+		conditions []string
+		args       []any
+	)
+	if filter.SourceType != "" {
+		conditions = append(conditions, "source_type = ?")
+		args = append(args, filter.SourceType)
+	}
+	if filter.SourceKey != "" {
+		conditions = append(conditions, "source_key = ?")
+		args = append(args, filter.SourceKey)
+	}
+	if filter.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, filter.From.UTC().Format("2006-01-02"))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, filter.To.UTC().Format("2006-01-02"))
+	}
+
+	query := `SELECT date, source_type, source_key, source_name, username, total_limit, total_used, total_remaining FROM traffic_source_records`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY source_type, source_key, date"
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list traffic source records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []TrafficSourceRecord
+	for rows.Next() {
+		var (
+			record  TrafficSourceRecord
+			dateStr string
+		)
+		if err := rows.Scan(&dateStr, &record.SourceType, &record.SourceKey, &record.SourceName, &record.Username,
+			&record.TotalLimit, &record.TotalUsed, &record.TotalRemaining); err != nil {
+			return nil, fmt.Errorf("scan traffic source record: %w", err)
+		}
+		parsed, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			return nil, fmt.Errorf("parse traffic source record date: %w", err)
+		}
+		record.Date = parsed
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate traffic source records: %w", err)
+	}
+
+	return records, nil
+}
+
+// ListTrafficRecordsBetween returns the global daily snapshots between from and to (inclusive), oldest first.
+func (r *TrafficRepository) ListTrafficRecordsBetween(ctx context.Context, from, to time.Time) ([]TrafficRecord, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+SELECT date, total_limit, total_used, total_remaining
+FROM traffic_records
+WHERE date >= ? AND date <= ?
+ORDER BY date ASC;
+`, from.UTC().Format("2006-01-02"), to.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("list traffic records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []TrafficRecord
+	for rows.Next() {
+		var (
+			record  TrafficRecord
+			dateStr string
+		)
+		if err := rows.Scan(&dateStr, &record.TotalLimit, &record.TotalUsed, &record.TotalRemaining); err != nil {
+			return nil, fmt.Errorf("scan traffic record: %w", err)
+		}
+		parsed, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			return nil, fmt.Errorf("parse traffic record date: %w", err)
+		}
+		record.Date = parsed
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate traffic records: %w", err)
+	}
+
+	return records, nil
+}
+
+// 导入流量历史时与已有快照冲突的处理方式
+const (
+	TrafficConflictKeep      = "keep"      // 保留已有快照
+	TrafficConflictOverwrite = "overwrite" // 使用导入的快照
+	TrafficConflictMax       = "max"       // 保留已用流量较大的快照
+)
+
+// IsValidTrafficConflictPolicy reports whether the import conflict policy is supported.
+func IsValidTrafficConflictPolicy(policy string) bool {
+	switch policy {
+	case TrafficConflictKeep, TrafficConflictOverwrite, TrafficConflictMax:
+		return true
+	}
+	return false
+}
+
+// TrafficImportResult counts the snapshots written by ImportTrafficHistory.
+type TrafficImportResult struct {
+	Inserted int
+	Updated  int
+	Skipped  int
+}
+
+// ImportTrafficHistory merges global and per-source snapshots by date in a single
+// transaction; existing snapshots are resolved with the given conflict policy.
+func (r *TrafficRepository) ImportTrafficHistory(ctx context.Context, totals []TrafficRecord, sources []TrafficSourceRecord, policy string) (TrafficImportResult, error) {
+	var result TrafficImportResult
+	if r == nil || r.db == nil {
+		return result, errors.New("traffic repository not initialized")
+	}
+	if !IsValidTrafficConflictPolicy(policy) {
+		return result, fmt.Errorf("unsupported conflict policy %q", policy)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("begin traffic import tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// resolve 决定是否写入导入的快照，并累计结果
+	resolve := func(exists bool, existingUsed, importedUsed int64) bool {
+		switch {
+		case !exists:
+			result.Inserted++
+			return true
+		case policy == TrafficConflictOverwrite,
+			policy == TrafficConflictMax && importedUsed > existingUsed:
+			result.Updated++
+			return true
+		default:
+			result.Skipped++
+			return false
+		}
+	}
+
+	for _, record := range totals {
+		date := record.Date.UTC().Format("2006-01-02")
+
+		var existingUsed int64
+		err := tx.QueryRowContext(ctx, `SELECT total_used FROM traffic_records WHERE date = ?`, date).Scan(&existingUsed)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return result, fmt.Errorf("query traffic record %s: %w", date, err)
+		}
+		if !resolve(err == nil, existingUsed, record.TotalUsed) {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO traffic_records (date, total_limit, total_used, total_remaining)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(date) DO UPDATE SET
+    total_limit = excluded.total_limit,
+    total_used = excluded.total_used,
+    total_remaining = excluded.total_remaining;
+`, date, record.TotalLimit, record.TotalUsed, record.TotalRemaining); err != nil {
+			return result, fmt.Errorf("import traffic record %s: %w", date, err)
+		}
+	}
+
+	for _, record := range sources {
+		if !IsValidTrafficSource(record.SourceType) {
+			return result, fmt.Errorf("unsupported traffic source %q", record.SourceType)
+		}
+		key := strings.TrimSpace(record.SourceKey)
+		if key == "" {
+			return result, errors.New("traffic source key is required")
+		}
+		date := record.Date.UTC().Format("2006-01-02")
+
+		var existingUsed int64
+		err := tx.QueryRowContext(ctx, `SELECT total_used FROM traffic_source_records WHERE date = ? AND source_type = ? AND source_key = ?`,
+			date, record.SourceType, key).Scan(&existingUsed)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return result, fmt.Errorf("query traffic source %s/%s %s: %w", record.SourceType, key, date, err)
+		}
+		if !resolve(err == nil, existingUsed, record.TotalUsed) {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO traffic_source_records (date, source_type, source_key, source_name, username, total_limit, total_used, total_remaining)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(date, source_type, source_key) DO UPDATE SET
+    source_name = excluded.source_name,
+    username = excluded.username,
+    total_limit = excluded.total_limit,
+    total_used = excluded.total_used,
+    total_remaining = excluded.total_remaining;
+`, date, record.SourceType, key, record.SourceName, record.Username,
+			record.TotalLimit, record.TotalUsed, record.TotalRemaining); err != nil {
+			return result, fmt.Errorf("import traffic source %s/%s %s: %w", record.SourceType, key, date, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("commit traffic import: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 用户流量配额超出后的订阅降级方式
+const (
+	QuotaActionNotice        = "notice"         // 返回流量用尽提示订阅
+	QuotaActionSubscribeFile = "subscribe_file" // 改为输出指定的降级订阅文件，如仅含低倍率节点
+)
+
+var ErrUserTrafficQuotaNotFound = errors.New("user traffic quota not found")
+
+// UserTrafficQuota limits the probe traffic a user may consume per billing cycle.
+type UserTrafficQuota struct {
+	Username         string
+	QuotaBytes       int64
+	ExceededAction   string
+	DegradedFilename string // subscribe file served when ExceededAction is QuotaActionSubscribeFile
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+const userTrafficQuotaColumns = "username, quota_bytes, exceeded_action, degraded_filename, created_at, updated_at"
+
+func scanUserTrafficQuota(scanner rowScanner) (UserTrafficQuota, error) {
+	var quota UserTrafficQuota
+	if err := scanner.Scan(&quota.Username, &quota.QuotaBytes, &quota.ExceededAction, &quota.DegradedFilename, &quota.CreatedAt, &quota.UpdatedAt); err != nil {
+		return UserTrafficQuota{}, err
+	}
+	return quota, nil
+}
+
+// GetUserTrafficQuota returns the traffic quota of a user.
+func (r *TrafficRepository) GetUserTrafficQuota(ctx context.Context, username string) (UserTrafficQuota, error) {
+	if r == nil || r.db == nil {
+		return UserTrafficQuota{}, errors.New("traffic repository not initialized")
+	}
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+userTrafficQuotaColumns+` FROM user_traffic_quotas WHERE username = ?`, strings.TrimSpace(username))
+	quota, err := scanUserTrafficQuota(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserTrafficQuota{}, ErrUserTrafficQuotaNotFound
+		}
+		return UserTrafficQuota{}, fmt.Errorf("get user traffic quota: %w", err)
+	}
+	return quota, nil
+}
+
+// ListUserTrafficQuotas returns all user traffic quotas ordered by username.
+func (r *TrafficRepository) ListUserTrafficQuotas(ctx context.Context) ([]UserTrafficQuota, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("traffic repository not initialized")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+userTrafficQuotaColumns+` FROM user_traffic_quotas ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("list user traffic quotas: %w", err)
+	}
+	defer rows.Close()
+
+	var quotas []UserTrafficQuota
+	for rows.Next() {
+		quota, err := scanUserTrafficQuota(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user traffic quota: %w", err)
+		}
+		quotas = append(quotas, quota)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate user traffic quotas: %w", err)
+	}
+
+	return quotas, nil
+}
+
+// SaveUserTrafficQuota creates or replaces the traffic quota of a user.
+func (r *TrafficRepository) SaveUserTrafficQuota(ctx context.Context, quota UserTrafficQuota) (UserTrafficQuota, error) {
+	if r == nil || r.db == nil {
+		return UserTrafficQuota{}, errors.New("traffic repository not initialized")
+	}
+
+	quota.Username = strings.TrimSpace(quota.Username)
+	quota.DegradedFilename = strings.TrimSpace(quota.DegradedFilename)
+	if quota.Username == "" {
+		return UserTrafficQuota{}, errors.New("username is required")
+	}
+	if quota.QuotaBytes <= 0 {
+		return UserTrafficQuota{}, errors.New("quota must be positive")
+	}
+	switch quota.ExceededAction {
+	case "":
+		quota.ExceededAction = QuotaActionNotice
+	case QuotaActionNotice, QuotaActionSubscribeFile:
+	default:
+		return UserTrafficQuota{}, fmt.Errorf("unsupported quota exceeded action: %s", quota.ExceededAction)
+	}
+	if quota.ExceededAction == QuotaActionSubscribeFile && quota.DegradedFilename == "" {
+		return UserTrafficQuota{}, errors.New("degraded subscribe file is required")
+	}
+	if quota.ExceededAction == QuotaActionNotice {
+		quota.DegradedFilename = ""
+	}
+
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO user_traffic_quotas (username, quota_bytes, exceeded_action, degraded_filename, created_at, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(username) DO UPDATE SET
+			quota_bytes = excluded.quota_bytes,
+			exceeded_action = excluded.exceeded_action,
+			degraded_filename = excluded.degraded_filename,
+			updated_at = CURRENT_TIMESTAMP
+	`, quota.Username, quota.QuotaBytes, quota.ExceededAction, quota.DegradedFilename); err != nil {
+		return UserTrafficQuota{}, fmt.Errorf("save user traffic quota: %w", err)
+	}
+
+	return r.GetUserTrafficQuota(ctx, quota.Username)
+}
+
+// DeleteUserTrafficQuota removes the traffic quota of a user.
+func (r *TrafficRepository) DeleteUserTrafficQuota(ctx context.Context, username string) error {
+	if r == nil || r.db == nil {
+		return errors.New("traffic repository not initialized")
+	}
+
+	res, err := r.db.ExecContext(ctx, `DELETE FROM user_traffic_quotas WHERE username = ?`, strings.TrimSpace(username))
+	if err != nil {
+		return fmt.Errorf("delete user traffic quota: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete user traffic quota rows affected: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserTrafficQuotaNotFound
+	}
+	return nil
+}
@@ -25,6 +25,9 @@ type ACLProxyGroup struct {
 	URL         string   // Health check URL
 	Interval    int      // Health check interval
 	Tolerance   int      // Tolerance for url-test
+
+	PolicyPath     string // Remote policy list URL (Surge policy-path), e.g. an MMW proxy provider
+	UpdateInterval int    // policy-path update interval in seconds
 }
 
 // ParseACLConfig parses ACL4SSR format configuration content
@@ -0,0 +1,444 @@
+package substore
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Node operator types, modelled on Sub-Store's node operators (script-free subset)
+const (
+	NodeOpRename = "rename" // 正则重命名
+	NodeOpFlag   = "flag"   // 按识别到的地区添加/移除国旗
+	NodeOpDedupe = "dedupe" // 按 server:port 去重
+	NodeOpSort   = "sort"   // 按地区或名称排序
+	NodeOpSet    = "set"    // 设置/删除字段
+	NodeOpFilter = "filter" // 按字段条件保留/删除节点
+)
+
+// NodeOperator is one step of a node transform pipeline.
+// Only the fields relevant to Type are used.
+type NodeOperator struct {
+	Type     string `json:"type"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	// rename: 节点名称匹配 Pattern 的部分替换为 Replacement（支持 $1 引用）
+	// set: Pattern 非空时只作用于名称匹配的节点
+	Pattern     string `json:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+
+	// flag: "prepend"（默认）、"append" 或 "remove"
+	Position string `json:"position,omitempty"`
+
+	// sort: "region"（默认）或 "name"
+	By   string `json:"by,omitempty"`
+	Desc bool   `json:"desc,omitempty"`
+
+	// set: 要设置的字段，值为 null 表示删除该字段
+	Fields map[string]any `json:"fields,omitempty"`
+
+	// filter: 字段（支持 "ws-opts.path" 形式的嵌套字段）、比较方式和比较值
+	// Op: eq, ne, contains, not_contains, regex, not_regex, in, not_in, gt, lt, exists, missing
+	Field string `json:"field,omitempty"`
+	Op    string `json:"op,omitempty"`
+	Value any    `json:"value,omitempty"`
+	// Action: "keep"（默认，只保留匹配的节点）或 "remove"（删除匹配的节点）
+	Action string `json:"action,omitempty"`
+}
+
+// NodePipeline is a validated, ready-to-run list of node operators.
+type NodePipeline struct {
+	ops []compiledNodeOperator
+}
+
+type compiledNodeOperator struct {
+	NodeOperator
+	re *regexp.Regexp // rename/set Pattern, filter regex Value
+}
+
+// ParseNodePipeline parses a JSON operator list; an empty string yields an empty pipeline.
+func ParseNodePipeline(raw string) (*NodePipeline, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return &NodePipeline{}, nil
+	}
+	var ops []NodeOperator
+	if err := json.Unmarshal([]byte(raw), &ops); err != nil {
+		return nil, fmt.Errorf("invalid node operators: %w", err)
+	}
+	return CompileNodeOperators(ops)
+}
+
+// CompileNodeOperators validates operators and compiles their regular expressions.
+func CompileNodeOperators(ops []NodeOperator) (*NodePipeline, error) {
+	p := &NodePipeline{ops: make([]compiledNodeOperator, 0, len(ops))}
+	for i, op := range ops {
+		c := compiledNodeOperator{NodeOperator: op}
+		var err error
+		switch op.Type {
+		case NodeOpRename:
+			if op.Pattern == "" {
+				return nil, fmt.Errorf("operator %d (rename): pattern is required", i+1)
+			}
+			c.re, err = regexp.Compile(op.Pattern)
+		case NodeOpFlag:
+			switch op.Position {
+			case "", "prepend", "append", "remove":
+			default:
+				return nil, fmt.Errorf("operator %d (flag): unknown position %q", i+1, op.Position)
+			}
+		case NodeOpDedupe:
+		case NodeOpSort:
+			switch op.By {
+			case "", "region", "name":
+			default:
+				return nil, fmt.Errorf("operator %d (sort): unknown sort key %q", i+1, op.By)
+			}
+		case NodeOpSet:
+			if len(op.Fields) == 0 {
+				return nil, fmt.Errorf("operator %d (set): fields are required", i+1)
+			}
+			if op.Pattern != "" {
+				c.re, err = regexp.Compile(op.Pattern)
+			}
+		case NodeOpFilter:
+			if op.Field == "" {
+				return nil, fmt.Errorf("operator %d (filter): field is required", i+1)
+			}
+			switch op.Action {
+			case "", "keep", "remove":
+			default:
+				return nil, fmt.Errorf("operator %d (filter): unknown action %q", i+1, op.Action)
+			}
+			switch op.Op {
+			case "regex", "not_regex":
+				c.re, err = regexp.Compile(fmt.Sprint(op.Value))
+			case "eq", "ne", "contains", "not_contains", "in", "not_in", "exists", "missing":
+			case "gt", "lt":
+				if _, ok := toFloat(op.Value); !ok {
+					return nil, fmt.Errorf("operator %d (filter): %s requires a numeric value", i+1, op.Op)
+				}
+			default:
+				return nil, fmt.Errorf("operator %d (filter): unknown op %q", i+1, op.Op)
+			}
+		default:
+			return nil, fmt.Errorf("operator %d: unknown type %q", i+1, op.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operator %d (%s): invalid regex: %w", i+1, op.Type, err)
+		}
+		p.ops = append(p.ops, c)
+	}
+	return p, nil
+}
+
+// Empty reports whether the pipeline has no enabled operators.
+func (p *NodePipeline) Empty() bool {
+	if p == nil {
+		return true
+	}
+	for _, op := range p.ops {
+		if !op.Disabled {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply runs the pipeline over Clash proxy maps. Maps are modified in place and the returned
+// slice only contains maps from the input (filtered and reordered), so callers can map results
+// back to their source by identity. Names are made unique after renaming.
+func (p *NodePipeline) Apply(proxies []map[string]any) []map[string]any {
+	if p.Empty() {
+		return proxies
+	}
+	result := proxies
+	for _, op := range p.ops {
+		if op.Disabled {
+			continue
+		}
+		switch op.Type {
+		case NodeOpRename:
+			for _, proxy := range result {
+				name := proxyName(proxy)
+				if renamed := strings.TrimSpace(op.re.ReplaceAllString(name, op.Replacement)); renamed != "" {
+					proxy["name"] = renamed
+				}
+			}
+		case NodeOpFlag:
+			for _, proxy := range result {
+				proxy["name"] = applyFlag(proxyName(proxy), op.Position)
+			}
+		case NodeOpDedupe:
+			result = dedupeByServerPort(result)
+		case NodeOpSort:
+			result = sortProxies(result, op.By, op.Desc)
+		case NodeOpSet:
+			for _, proxy := range result {
+				if op.re != nil && !op.re.MatchString(proxyName(proxy)) {
+					continue
+				}
+				for key, value := range op.Fields {
+					if value == nil {
+						delete(proxy, key)
+					} else {
+						proxy[key] = value
+					}
+				}
+			}
+		case NodeOpFilter:
+			kept := result[:0:0]
+			for _, proxy := range result {
+				if op.matches(proxy) == (op.Action != "remove") {
+					kept = append(kept, proxy)
+				}
+			}
+			result = kept
+		}
+	}
+	ensureUniqueProxyNames(result)
+	return result
+}
+
+func (op compiledNodeOperator) matches(proxy map[string]any) bool {
+	value, ok := lookupProxyField(proxy, op.Field)
+	switch op.Op {
+	case "exists":
+		return ok
+	case "missing":
+		return !ok
+	}
+	if !ok {
+		// 字段不存在时只有否定条件成立
+		return op.Op == "ne" || op.Op == "not_contains" || op.Op == "not_regex" || op.Op == "not_in"
+	}
+
+	actual := fmt.Sprint(value)
+	switch op.Op {
+	case "eq":
+		return actual == fmt.Sprint(op.Value)
+	case "ne":
+		return actual != fmt.Sprint(op.Value)
+	case "contains":
+		return strings.Contains(actual, fmt.Sprint(op.Value))
+	case "not_contains":
+		return !strings.Contains(actual, fmt.Sprint(op.Value))
+	case "regex":
+		return op.re.MatchString(actual)
+	case "not_regex":
+		return !op.re.MatchString(actual)
+	case "in", "not_in":
+		found := false
+		for _, candidate := range valueList(op.Value) {
+			if actual == candidate {
+				found = true
+				break
+			}
+		}
+		return found == (op.Op == "in")
+	case "gt", "lt":
+		a, okA := toFloat(value)
+		b, _ := toFloat(op.Value)
+		if !okA {
+			return false
+		}
+		if op.Op == "gt" {
+			return a > b
+		}
+		return a < b
+	}
+	return false
+}
+
+// lookupProxyField resolves a field, supporting dotted paths into nested maps
+func lookupProxyField(proxy map[string]any, field string) (any, bool) {
+	if value, ok := proxy[field]; ok {
+		return value, true
+	}
+	var current any = proxy
+	for _, part := range strings.Split(field, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// valueList accepts a JSON array or a comma separated string
+func valueList(value any) []string {
+	switch v := value.(type) {
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	case []string:
+		return v
+	default:
+		var list []string
+		for _, item := range strings.Split(fmt.Sprint(v), ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+		return list
+	}
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func proxyName(proxy map[string]any) string {
+	name, _ := proxy["name"].(string)
+	return name
+}
+
+// applyFlag 去掉名称中已有的国旗，再按位置添加识别到的地区国旗（已有国旗也参与识别）；识别不到地区时只返回去掉国旗后的名称
+func applyFlag(name, position string) string {
+	stripped := StripFlagEmoji(name)
+	if position == "remove" {
+		return stripped
+	}
+	_, flag := DetectRegion(name)
+	if flag == "" {
+		return stripped
+	}
+	if position == "append" {
+		return stripped + " " + flag
+	}
+	return flag + " " + stripped
+}
+
+// StripFlagEmoji removes regional indicator flag emoji from a node name
+func StripFlagEmoji(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r >= 0x1F1E6 && r <= 0x1F1FF {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+var (
+	regionMatchersOnce sync.Once
+	regionMatchers     []*regexp.Regexp
+	// lookbehind 等 RE2 不支持的语法，编译失败时去掉后重试
+	unsupportedRegexGroups = regexp.MustCompile(`\(\?<[!=][^)]*\)`)
+)
+
+// DetectRegion returns the index into RegionProxyGroups and the flag emoji of the first region
+// whose filter matches the node name, or (-1, "") when no region matches.
+func DetectRegion(name string) (int, string) {
+	regionMatchersOnce.Do(func() {
+		for _, region := range RegionProxyGroups[:len(RegionProxyGroups)-1] { // 最后一项是“其他地区”
+			re, err := regexp.Compile("(?i)" + region.Filter)
+			if err != nil {
+				re, err = regexp.Compile("(?i)" + unsupportedRegexGroups.ReplaceAllString(region.Filter, ""))
+			}
+			if err != nil {
+				re = nil
+			}
+			regionMatchers = append(regionMatchers, re)
+		}
+	})
+
+	for i, re := range regionMatchers {
+		if re != nil && re.MatchString(name) {
+			flag, _, _ := strings.Cut(RegionProxyGroups[i].Name, " ")
+			return i, flag
+		}
+	}
+	return -1, ""
+}
+
+func dedupeByServerPort(proxies []map[string]any) []map[string]any {
+	seen := make(map[string]struct{}, len(proxies))
+	result := proxies[:0:0]
+	for _, proxy := range proxies {
+		server := strings.ToLower(fmt.Sprint(proxy["server"]))
+		key := server + ":" + fmt.Sprint(proxy["port"])
+		if proxy["server"] == nil {
+			// 没有 server 的节点无法判断是否重复，全部保留
+			result = append(result, proxy)
+			continue
+		}
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, proxy)
+	}
+	return result
+}
+
+func sortProxies(proxies []map[string]any, by string, desc bool) []map[string]any {
+	sorted := append(proxies[:0:0], proxies...)
+	less := func(a, b map[string]any) bool {
+		return proxyName(a) < proxyName(b)
+	}
+	if by != "name" {
+		regionOrder := func(proxy map[string]any) int {
+			idx, _ := DetectRegion(proxyName(proxy))
+			if idx < 0 {
+				return len(RegionProxyGroups) // 未识别地区排在最后
+			}
+			return idx
+		}
+		less = func(a, b map[string]any) bool {
+			return regionOrder(a) < regionOrder(b)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if desc {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// ensureUniqueProxyNames appends " 2", " 3"... to repeated names
+func ensureUniqueProxyNames(proxies []map[string]any) {
+	used := make(map[string]struct{}, len(proxies))
+	for _, proxy := range proxies {
+		name := proxyName(proxy)
+		if _, dup := used[name]; !dup {
+			used[name] = struct{}{}
+			continue
+		}
+		for n := 2; ; n++ {
+			candidate := fmt.Sprintf("%s %d", name, n)
+			if _, dup := used[candidate]; !dup {
+				proxy["name"] = candidate
+				used[candidate] = struct{}{}
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package substore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testProxies() []map[string]any {
+	return []map[string]any{
+		{"name": "美国 01 | 1x", "type": "ss", "server": "us.example.com", "port": 443},
+		{"name": "🇭🇰 香港 01 | 1x", "type": "vmess", "server": "hk.example.com", "port": 443},
+		{"name": "HK 02", "type": "trojan", "server": "HK.example.com", "port": 443},
+		{"name": "剩余流量：10GB", "type": "ss", "server": "info.example.com", "port": 1},
+		{"name": "日本 01", "type": "ss", "server": "jp.example.com", "port": 8443, "udp": false},
+	}
+}
+
+func names(proxies []map[string]any) []string {
+	out := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		out = append(out, proxyName(p))
+	}
+	return out
+}
+
+func TestNodePipeline(t *testing.T) {
+	pipeline, err := CompileNodeOperators([]NodeOperator{
+		{Type: NodeOpFilter, Field: "name", Op: "regex", Value: "流量|到期", Action: "remove"},
+		{Type: NodeOpRename, Pattern: `\s*\|\s*1x`, Replacement: ""},
+		{Type: NodeOpDedupe},
+		{Type: NodeOpFlag},
+		{Type: NodeOpSort, By: "region"},
+		{Type: NodeOpSet, Fields: map[string]any{"udp": true, "skip-cert-verify": true}},
+		{Type: NodeOpSet, Pattern: "日本", Fields: map[string]any{"skip-cert-verify": nil}},
+	})
+	if err != nil {
+		t.Fatalf("CompileNodeOperators: %v", err)
+	}
+
+	input := testProxies()
+	got := pipeline.Apply(input)
+	want := []string{"🇭🇰 香港 01", "🇺🇸 美国 01", "🇯🇵 日本 01"}
+	if !reflect.DeepEqual(names(got), want) {
+		t.Fatalf("names = %v, want %v", names(got), want)
+	}
+	// 结果中的节点必须是输入中的同一个 map（调用方按身份映射回原始节点）
+	if reflect.ValueOf(got[0]).Pointer() != reflect.ValueOf(input[1]).Pointer() {
+		t.Fatalf("pipeline must reuse input maps")
+	}
+	if got[2]["udp"] != true {
+		t.Fatalf("set udp = %v", got[2]["udp"])
+	}
+	if _, ok := got[2]["skip-cert-verify"]; ok {
+		t.Fatalf("skip-cert-verify should be deleted for matched node")
+	}
+	if got[0]["skip-cert-verify"] != true {
+		t.Fatalf("skip-cert-verify = %v", got[0]["skip-cert-verify"])
+	}
+}
+
+func TestNodePipelineFilterOps(t *testing.T) {
+	cases := []struct {
+		op   NodeOperator
+		want []string
+	}{
+		{NodeOperator{Type: NodeOpFilter, Field: "type", Op: "in", Value: []any{"vmess", "trojan"}}, []string{"🇭🇰 香港 01 | 1x", "HK 02"}},
+		{NodeOperator{Type: NodeOpFilter, Field: "type", Op: "eq", Value: "ss", Action: "remove"}, []string{"🇭🇰 香港 01 | 1x", "HK 02"}},
+		{NodeOperator{Type: NodeOpFilter, Field: "port", Op: "gt", Value: 443}, []string{"日本 01"}},
+		{NodeOperator{Type: NodeOpFilter, Field: "udp", Op: "exists"}, []string{"日本 01"}},
+		{NodeOperator{Type: NodeOpFilter, Field: "udp", Op: "ne", Value: false}, []string{"美国 01 | 1x", "🇭🇰 香港 01 | 1x", "HK 02", "剩余流量：10GB"}},
+	}
+	for _, tc := range cases {
+		pipeline, err := CompileNodeOperators([]NodeOperator{tc.op})
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.op, err)
+		}
+		if got := names(pipeline.Apply(testProxies())); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %s: got %v, want %v", tc.op.Field, tc.op.Op, got, tc.want)
+		}
+	}
+}
+
+func TestNodePipelineUniqueNamesAndFlags(t *testing.T) {
+	pipeline, err := ParseNodePipeline(`[{"type":"rename","pattern":"\\d+","replacement":""},{"type":"flag","position":"append"}]`)
+	if err != nil {
+		t.Fatalf("ParseNodePipeline: %v", err)
+	}
+	got := names(pipeline.Apply([]map[string]any{
+		{"name": "香港 01"}, {"name": "香港 02"}, {"name": "🇯🇵 Tokyo"}, {"name": "Mars"},
+	}))
+	want := []string{"香港 🇭🇰", "香港 🇭🇰 2", "Tokyo 🇯🇵", "Mars"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("names = %v, want %v", got, want)
+	}
+}
+
+func TestCompileNodeOperatorsErrors(t *testing.T) {
+	bad := [][]NodeOperator{
+		{{Type: "script"}},
+		{{Type: NodeOpRename}},
+		{{Type: NodeOpRename, Pattern: "("}},
+		{{Type: NodeOpFilter, Field: "port", Op: "gt", Value: "abc"}},
+		{{Type: NodeOpSet}},
+		{{Type: NodeOpSort, By: "latency"}},
+	}
+	for _, ops := range bad {
+		if _, err := CompileNodeOperators(ops); err == nil {
+			t.Errorf("expected error for %+v", ops)
+		}
+	}
+	if p, err := ParseNodePipeline("  "); err != nil || !p.Empty() {
+		t.Fatalf("empty pipeline: %v %v", p, err)
+	}
+}
+
+func TestApplyFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		position string
+		want     string
+	}{
+		{"香港 01", "", "🇭🇰 香港 01"},
+		{"香港 01", "append", "香港 01 🇭🇰"},
+		{"🇺🇸 香港 01", "", "🇭🇰 香港 01"},
+		{"🇭🇰 香港 01", "remove", "香港 01"},
+		// 已有国旗本身也用于识别地区，移到指定位置
+		{"节点 🇺🇸 01", "", "🇺🇸 节点 01"},
+		// 识别不到地区时返回去掉国旗后的名称（多余空白一并规整）
+		{"未知  节点", "", "未知 节点"},
+		{"未知节点", "append", "未知节点"},
+	}
+	for _, tt := range tests {
+		if got := applyFlag(tt.name, tt.position); got != tt.want {
+			t.Errorf("applyFlag(%q, %q) = %q, want %q", tt.name, tt.position, got, tt.want)
+		}
+	}
+}
@@ -139,6 +139,12 @@ func GenerateSurgeProxyGroups(groups []ACLProxyGroup, enableIncludeAll bool) str
 			}
 		}
 
+		// Remote policy list: nodes come from policy-path, listed policies are kept alongside
+		if g.PolicyPath != "" {
+			lines = append(lines, surgePolicyPathGroupLine(g, normalProxies, regexFilters))
+			continue
+		}
+
 		var line string
 
 		if g.Type == "url-test" || g.Type == "fallback" || g.Type == "load-balance" {
@@ -215,3 +221,33 @@ func GenerateSurgeProxyGroups(groups []ACLProxyGroup, enableIncludeAll bool) str
 
 	return strings.Join(lines, "\n")
 }
+
+// surgePolicyPathGroupLine generates a Surge proxy group line that loads its policies from policy-path
+func surgePolicyPathGroupLine(g ACLProxyGroup, normalProxies, regexFilters []string) string {
+	parts := append([]string{g.Type}, normalProxies...)
+	parts = append(parts, "policy-path="+g.PolicyPath)
+	if g.UpdateInterval > 0 {
+		parts = append(parts, fmt.Sprintf("update-interval=%d", g.UpdateInterval))
+	}
+	if len(regexFilters) > 0 {
+		parts = append(parts, "policy-regex-filter="+ExtractSurgeRegexFilter(regexFilters))
+	}
+
+	if g.Type == "url-test" || g.Type == "fallback" || g.Type == "load-balance" {
+		url := g.URL
+		if url == "" {
+			url = "http://www.gstatic.com/generate_204"
+		}
+		interval := g.Interval
+		if interval <= 0 {
+			interval = 300
+		}
+		tolerance := g.Tolerance
+		if tolerance <= 0 {
+			tolerance = 150
+		}
+		parts = append(parts, "url="+url, fmt.Sprintf("interval=%d", interval), "timeout=5", fmt.Sprintf("tolerance=%d", tolerance))
+	}
+
+	return fmt.Sprintf("%s = %s", g.Name, strings.Join(parts, ", "))
+}
@@ -0,0 +1,71 @@
+package substore
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ClashProxyProvider represents a Clash proxy provider (remote node list)
+type ClashProxyProvider struct {
+	Type     string `yaml:"type"`
+	URL      string `yaml:"url"`
+	Path     string `yaml:"path"`
+	Interval int    `yaml:"interval"`
+}
+
+// ProxyProviderURLFunc returns the URL a converter should reference for a proxy-provider,
+// serving the node list in the target client's format.
+// Returning an empty string means the client cannot consume the provider and it is skipped.
+type ProxyProviderURLFunc func(name string, provider ClashProxyProvider) string
+
+// ProxyProviderURLForClient sets the client type (t=) on a proxy provider URL
+func ProxyProviderURLForClient(rawURL, clientType string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	if clientType == "" {
+		query.Del("t")
+	} else {
+		query.Set("t", clientType)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// clientUserAgents maps User-Agent keywords to producer types, checked in order
+// Clash-family clients (mihomo, Clash Verge, Stash...) read Clash YAML directly and are not listed
+var clientUserAgents = []struct {
+	keyword    string
+	clientType string
+}{
+	{"surge mac", "surgemac"},
+	{"surge", "surge"},
+	{"loon", "loon"},
+	{"quantumult", "qx"},
+	{"shadowrocket", "shadowrocket"},
+	{"surfboard", "surfboard"},
+	{"egern", "egern"},
+	{"sing-box", "sing-box"},
+	{"sfa/", "sing-box"},
+	{"sfi/", "sing-box"},
+	{"sfm/", "sing-box"},
+	{"sft/", "sing-box"},
+	{"v2rayn", "v2ray"},
+}
+
+// DetectClientType infers the producer type from a client User-Agent.
+// Returns an empty string for Clash-family or unknown clients.
+func DetectClientType(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" || strings.Contains(ua, "clash") || strings.Contains(ua, "mihomo") || strings.Contains(ua, "stash") {
+		return ""
+	}
+	for _, item := range clientUserAgents {
+		if strings.Contains(ua, item.keyword) {
+			return item.clientType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,463 @@
+package substore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule-provider payload formats (mihomo)
+const (
+	RuleProviderFormatYAML = "yaml"
+	RuleProviderFormatText = "text"
+	RuleProviderFormatMRS  = "mrs"
+)
+
+// ErrBinaryRuleProvider is returned when a rule-provider payload is in binary (mrs) format
+var ErrBinaryRuleProvider = errors.New("binary rule-provider payload (mrs) cannot be parsed")
+
+// ipRuleTypes are rule types that accept the no-resolve option
+var ipRuleTypes = map[string]bool{
+	"IP-CIDR":     true,
+	"IP-CIDR6":    true,
+	"IP-ASN":      true,
+	"GEOIP":       true,
+	"SRC-IP-CIDR": true,
+}
+
+// logicRuleTypes are rule types whose value is a parenthesized list of sub-rules
+var logicRuleTypes = map[string]bool{
+	"AND": true,
+	"OR":  true,
+	"NOT": true,
+}
+
+// DetectRuleProviderFormat returns the payload format of a rule-provider.
+// An explicit format wins; otherwise the URL/path extension is used (default yaml).
+func DetectRuleProviderFormat(location, format string) string {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case RuleProviderFormatMRS:
+		return RuleProviderFormatMRS
+	case RuleProviderFormatText:
+		return RuleProviderFormatText
+	case RuleProviderFormatYAML:
+		return RuleProviderFormatYAML
+	}
+
+	lower := strings.ToLower(location)
+	if idx := strings.IndexAny(lower, "?#"); idx >= 0 {
+		lower = lower[:idx]
+	}
+	switch {
+	case strings.HasSuffix(lower, ".mrs"):
+		return RuleProviderFormatMRS
+	case strings.HasSuffix(lower, ".list"), strings.HasSuffix(lower, ".txt"), strings.HasSuffix(lower, ".conf"):
+		return RuleProviderFormatText
+	default:
+		return RuleProviderFormatYAML
+	}
+}
+
+// ParseRuleProviderPayload parses rule-provider content into classical rule entries without policy,
+// e.g. "DOMAIN-SUFFIX,example.com" or "IP-CIDR,1.1.1.0/24".
+func ParseRuleProviderPayload(data []byte, behavior, format string) ([]string, error) {
+	if format == RuleProviderFormatMRS {
+		return nil, ErrBinaryRuleProvider
+	}
+
+	var items []string
+	if format == RuleProviderFormatYAML {
+		var doc struct {
+			Payload []string `yaml:"payload"`
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			// Some .yaml rule-sets are plain lists; fall back to text parsing
+			items = readPayloadLines(data)
+		} else if doc.Payload != nil {
+			items = doc.Payload
+		} else {
+			items = readPayloadLines(data)
+		}
+	} else {
+		items = readPayloadLines(data)
+	}
+
+	behavior = strings.ToLower(strings.TrimSpace(behavior))
+	entries := make([]string, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || strings.HasPrefix(item, "#") || strings.HasPrefix(item, "//") {
+			continue
+		}
+		item = strings.Trim(item, `'"`)
+
+		switch behavior {
+		case "domain":
+			entries = append(entries, domainPayloadToRule(item))
+		case "ipcidr":
+			if strings.Contains(item, ":") {
+				entries = append(entries, "IP-CIDR6,"+item)
+			} else {
+				entries = append(entries, "IP-CIDR,"+item)
+			}
+		default:
+			// classical: drop any trailing policy-free options we don't understand
+			if !strings.Contains(item, ",") {
+				continue
+			}
+			entries = append(entries, item)
+		}
+	}
+
+	return entries, nil
+}
+
+// ExpandRuleSetEntries appends the policy to each classical entry, keeping the entry's own options
+// (e.g. src) and the sub-rules of AND/OR/NOT entries.
+// no-resolve is only kept for IP based rule types, and for logic rules that carry it themselves.
+func ExpandRuleSetEntries(entries []string, policy string, noResolve bool) []string {
+	rules := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		payload, options, ok := splitClassicalEntry(strings.TrimSpace(entry))
+		if !ok {
+			continue
+		}
+		ruleType := strings.ToUpper(strings.TrimSpace(payload[:strings.Index(payload, ",")]))
+
+		hasNoResolve := false
+		kept := make([]string, 0, len(options))
+		for _, opt := range options {
+			opt = strings.TrimSpace(opt)
+			switch {
+			case opt == "":
+			case strings.EqualFold(opt, "no-resolve"):
+				hasNoResolve = true
+			default:
+				kept = append(kept, opt)
+			}
+		}
+
+		rule := payload + "," + policy
+		if len(kept) > 0 {
+			rule += "," + strings.Join(kept, ",")
+		}
+		if (ipRuleTypes[ruleType] && (noResolve || hasNoResolve)) || (logicRuleTypes[ruleType] && hasNoResolve) {
+			rule += ",no-resolve"
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// splitClassicalEntry splits a policy-free classical entry into "TYPE,VALUE" and its trailing options.
+// The value of AND/OR/NOT entries is the whole parenthesized sub-rule list.
+func splitClassicalEntry(entry string) (payload string, options []string, ok bool) {
+	ruleType, rest, found := strings.Cut(entry, ",")
+	ruleType = strings.TrimSpace(ruleType)
+	if !found || ruleType == "" {
+		return "", nil, false
+	}
+
+	if logicRuleTypes[strings.ToUpper(ruleType)] {
+		end := strings.LastIndex(rest, ")")
+		if !strings.HasPrefix(strings.TrimSpace(rest), "(") || end < 0 {
+			return "", nil, false
+		}
+		payload = ruleType + "," + strings.TrimSpace(rest[:end+1])
+		rest = strings.TrimPrefix(strings.TrimSpace(rest[end+1:]), ",")
+	} else {
+		value, more, _ := strings.Cut(rest, ",")
+		if strings.TrimSpace(value) == "" {
+			return "", nil, false
+		}
+		payload = ruleType + "," + strings.TrimSpace(value)
+		rest = more
+	}
+
+	if strings.TrimSpace(rest) != "" {
+		options = strings.Split(rest, ",")
+	}
+	return payload, options, true
+}
+
+// ParseRuleSetReference parses "RULE-SET,name,policy[,no-resolve]"
+func ParseRuleSetReference(rule string) (name, policy string, noResolve bool, err error) {
+	parts := strings.Split(rule, ",")
+	if len(parts) < 3 || !strings.EqualFold(strings.TrimSpace(parts[0]), "RULE-SET") {
+		return "", "", false, fmt.Errorf("not a RULE-SET rule: %s", rule)
+	}
+	name = strings.TrimSpace(parts[1])
+	policy = strings.TrimSpace(parts[2])
+	for _, p := range parts[3:] {
+		if strings.EqualFold(strings.TrimSpace(p), "no-resolve") {
+			noResolve = true
+		}
+	}
+	return name, policy, noResolve, nil
+}
+
+// domainPayloadToRule converts a mihomo domain-behavior entry to a classical rule
+func domainPayloadToRule(item string) string {
+	switch {
+	case strings.HasPrefix(item, "+."):
+		return "DOMAIN-SUFFIX," + item[2:]
+	case strings.HasPrefix(item, "."):
+		// ".example.com" only matches subdomains, not the apex
+		return "DOMAIN-WILDCARD,*" + item
+	case strings.Contains(item, "*"):
+		return "DOMAIN-WILDCARD," + item
+	default:
+		return "DOMAIN," + item
+	}
+}
+
+// readPayloadLines reads non-empty lines, accepting both plain lists and "- item" YAML-ish lines
+func readPayloadLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "payload:" {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "- "))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Rule list targets supported by ConvertRuleProviderEntries
+const (
+	RuleListTargetSurge   = "surge"
+	RuleListTargetLoon    = "loon"
+	RuleListTargetQX      = "qx"
+	RuleListTargetSingbox = "singbox"
+)
+
+// RuleSetURLFunc returns the URL a converter should emit for a rule-provider.
+// Returning an empty string keeps the provider's original URL.
+type RuleSetURLFunc func(name string, provider ClashRuleProvider) string
+
+// surgeRuleTypes maps mihomo rule types to Surge/Loon rule-set types
+var surgeRuleTypes = map[string]string{
+	"DOMAIN":          "DOMAIN",
+	"DOMAIN-SUFFIX":   "DOMAIN-SUFFIX",
+	"DOMAIN-KEYWORD":  "DOMAIN-KEYWORD",
+	"DOMAIN-WILDCARD": "DOMAIN-WILDCARD",
+	"IP-CIDR":         "IP-CIDR",
+	"IP-CIDR6":        "IP-CIDR6",
+	"IP-ASN":          "IP-ASN",
+	"GEOIP":           "GEOIP",
+	"SRC-IP-CIDR":     "SRC-IP",
+	"DST-PORT":        "DEST-PORT",
+	"SRC-PORT":        "SRC-PORT",
+	"PROCESS-NAME":    "PROCESS-NAME",
+}
+
+// loonUnsupportedRuleTypes are Surge rule types Loon rule-sets do not accept
+var loonUnsupportedRuleTypes = map[string]bool{
+	"DOMAIN-WILDCARD": true,
+	"SRC-PORT":        true,
+	"PROCESS-NAME":    true,
+}
+
+// qxRuleTypes maps mihomo rule types to Quantumult X filter types
+var qxRuleTypes = map[string]string{
+	"DOMAIN":          "host",
+	"DOMAIN-SUFFIX":   "host-suffix",
+	"DOMAIN-KEYWORD":  "host-keyword",
+	"DOMAIN-WILDCARD": "host-wildcard",
+	"IP-CIDR":         "ip-cidr",
+	"IP-CIDR6":        "ip6-cidr",
+	"IP-ASN":          "ip-asn",
+	"GEOIP":           "geoip",
+}
+
+// SingboxHeadlessRule is a sing-box source rule-set rule (no action/outbound)
+type SingboxHeadlessRule struct {
+	Domain        []string `json:"domain,omitempty"`
+	DomainSuffix  []string `json:"domain_suffix,omitempty"`
+	DomainKeyword []string `json:"domain_keyword,omitempty"`
+	DomainRegex   []string `json:"domain_regex,omitempty"`
+	IPCIDR        []string `json:"ip_cidr,omitempty"`
+	SourceIPCIDR  []string `json:"source_ip_cidr,omitempty"`
+	Port          []int    `json:"port,omitempty"`
+	PortRange     []string `json:"port_range,omitempty"`
+	SourcePort    []int    `json:"source_port,omitempty"`
+	ProcessName   []string `json:"process_name,omitempty"`
+}
+
+// SingboxSourceRuleSet is the JSON document of a sing-box source rule-set
+type SingboxSourceRuleSet struct {
+	Version int                   `json:"version"`
+	Rules   []SingboxHeadlessRule `json:"rules"`
+}
+
+// RuleProviderTextFallbackURL returns the text (.list) variant of a binary .mrs rule-provider URL.
+// meta-rules-dat publishes .mrs, .yaml and .list side by side.
+func RuleProviderTextFallbackURL(location string) (string, bool) {
+	base, query, _ := strings.Cut(location, "?")
+	if !strings.HasSuffix(strings.ToLower(base), ".mrs") {
+		return "", false
+	}
+	fallback := base[:len(base)-len(".mrs")] + ".list"
+	if query != "" {
+		fallback += "?" + query
+	}
+	return fallback, true
+}
+
+// ConvertRuleProviderEntries converts classical entries (see ParseRuleProviderPayload) into a rule list
+// for the target client. Entries the target cannot express are returned as skipped.
+// policy is only used by Quantumult X, whose filter lines require one.
+func ConvertRuleProviderEntries(entries []string, target, policy string) (string, []string, error) {
+	var skipped []string
+
+	switch target {
+	case RuleListTargetSurge, RuleListTargetLoon:
+		lines := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			parts := strings.Split(entry, ",")
+			ruleType := strings.ToUpper(strings.TrimSpace(parts[0]))
+			mapped, ok := surgeRuleTypes[ruleType]
+			if !ok || len(parts) < 2 || (target == RuleListTargetLoon && loonUnsupportedRuleTypes[ruleType]) {
+				skipped = append(skipped, entry)
+				continue
+			}
+			value := strings.TrimSpace(parts[1])
+			if ruleType == "DST-PORT" && strings.Contains(value, "/") {
+				// Surge DEST-PORT takes one port or range per line
+				for _, port := range strings.Split(value, "/") {
+					lines = append(lines, mapped+","+strings.TrimSpace(port))
+				}
+				continue
+			}
+			line := mapped + "," + value
+			if ipRuleTypes[ruleType] && ruleType != "SRC-IP-CIDR" && hasNoResolveOption(parts[2:]) {
+				line += ",no-resolve"
+			}
+			lines = append(lines, line)
+		}
+		return joinRuleLines(lines), skipped, nil
+
+	case RuleListTargetQX:
+		if policy == "" {
+			policy = "proxy"
+		}
+		lines := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			parts := strings.Split(entry, ",")
+			mapped, ok := qxRuleTypes[strings.ToUpper(strings.TrimSpace(parts[0]))]
+			if !ok || len(parts) < 2 {
+				skipped = append(skipped, entry)
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s, %s, %s", mapped, strings.TrimSpace(parts[1]), policy))
+		}
+		return joinRuleLines(lines), skipped, nil
+
+	case RuleListTargetSingbox:
+		// Fields of one headless rule are ANDed across categories (destination, port, source IP,
+		// source port, process), while rules of a rule-set are ORed, so each category gets its own rule.
+		var destination, port, sourceIP, sourcePort, process SingboxHeadlessRule
+		for _, entry := range entries {
+			parts := strings.Split(entry, ",")
+			if len(parts) < 2 {
+				skipped = append(skipped, entry)
+				continue
+			}
+			value := strings.TrimSpace(parts[1])
+			switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+			case "DOMAIN":
+				destination.Domain = append(destination.Domain, value)
+			case "DOMAIN-SUFFIX":
+				destination.DomainSuffix = append(destination.DomainSuffix, value)
+			case "DOMAIN-KEYWORD":
+				destination.DomainKeyword = append(destination.DomainKeyword, value)
+			case "DOMAIN-REGEX":
+				destination.DomainRegex = append(destination.DomainRegex, value)
+			case "DOMAIN-WILDCARD":
+				destination.DomainRegex = append(destination.DomainRegex, wildcardToRegex(value))
+			case "IP-CIDR", "IP-CIDR6":
+				destination.IPCIDR = append(destination.IPCIDR, value)
+			case "SRC-IP-CIDR":
+				sourceIP.SourceIPCIDR = append(sourceIP.SourceIPCIDR, value)
+			case "DST-PORT":
+				ports, ranges, ok := parseSingboxPorts(value)
+				if !ok {
+					skipped = append(skipped, entry)
+					continue
+				}
+				port.Port = append(port.Port, ports...)
+				port.PortRange = append(port.PortRange, ranges...)
+			case "SRC-PORT":
+				ports, ranges, ok := parseSingboxPorts(value)
+				if !ok || len(ranges) > 0 {
+					skipped = append(skipped, entry)
+					continue
+				}
+				sourcePort.SourcePort = append(sourcePort.SourcePort, ports...)
+			case "PROCESS-NAME":
+				process.ProcessName = append(process.ProcessName, value)
+			default:
+				skipped = append(skipped, entry)
+			}
+		}
+
+		doc := SingboxSourceRuleSet{Version: 2, Rules: []SingboxHeadlessRule{}}
+		for _, rule := range []SingboxHeadlessRule{destination, port, sourceIP, sourcePort, process} {
+			if !reflect.DeepEqual(rule, SingboxHeadlessRule{}) {
+				doc.Rules = append(doc.Rules, rule)
+			}
+		}
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return "", skipped, fmt.Errorf("marshal sing-box rule set: %w", err)
+		}
+		return string(data), skipped, nil
+	}
+
+	return "", nil, fmt.Errorf("unsupported rule list target: %s", target)
+}
+
+func hasNoResolveOption(options []string) bool {
+	for _, opt := range options {
+		if strings.EqualFold(strings.TrimSpace(opt), "no-resolve") {
+			return true
+		}
+	}
+	return false
+}
+
+func joinRuleLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// wildcardToRegex converts a DOMAIN-WILDCARD pattern (* and ?) into an anchored regex
+func wildcardToRegex(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
@@ -0,0 +1,215 @@
+package substore
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectRuleProviderFormat(t *testing.T) {
+	cases := []struct {
+		location, format, want string
+	}{
+		{"https://example.com/geosite/cn.mrs", "", RuleProviderFormatMRS},
+		{"https://example.com/Proxy.list?raw=1", "", RuleProviderFormatText},
+		{"https://example.com/rules.yaml", "", RuleProviderFormatYAML},
+		{"https://example.com/rules.yaml", "text", RuleProviderFormatText},
+	}
+	for _, c := range cases {
+		if got := DetectRuleProviderFormat(c.location, c.format); got != c.want {
+			t.Errorf("DetectRuleProviderFormat(%q, %q) = %q, want %q", c.location, c.format, got, c.want)
+		}
+	}
+}
+
+func TestParseRuleProviderPayload(t *testing.T) {
+	domain := []byte("payload:\n  - '+.google.com'\n  - 'example.org'\n  - '*.cdn.net'\n  - '.sub.net'\n")
+	got, err := ParseRuleProviderPayload(domain, "domain", RuleProviderFormatYAML)
+	if err != nil {
+		t.Fatalf("parse domain payload: %v", err)
+	}
+	want := []string{"DOMAIN-SUFFIX,google.com", "DOMAIN,example.org", "DOMAIN-WILDCARD,*.cdn.net", "DOMAIN-WILDCARD,*.sub.net"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("domain payload = %v, want %v", got, want)
+	}
+
+	ipcidr := []byte("# comment\n10.0.0.0/8\n2001:db8::/32\n")
+	got, err = ParseRuleProviderPayload(ipcidr, "ipcidr", RuleProviderFormatText)
+	if err != nil {
+		t.Fatalf("parse ipcidr payload: %v", err)
+	}
+	want = []string{"IP-CIDR,10.0.0.0/8", "IP-CIDR6,2001:db8::/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ipcidr payload = %v, want %v", got, want)
+	}
+
+	if _, err := ParseRuleProviderPayload([]byte{0x01}, "domain", RuleProviderFormatMRS); !errors.Is(err, ErrBinaryRuleProvider) {
+		t.Errorf("mrs payload should return ErrBinaryRuleProvider, got %v", err)
+	}
+}
+
+func TestExpandRuleSetEntries(t *testing.T) {
+	name, policy, noResolve, err := ParseRuleSetReference("RULE-SET,cn_ip,DIRECT,no-resolve")
+	if err != nil || name != "cn_ip" || policy != "DIRECT" || !noResolve {
+		t.Fatalf("ParseRuleSetReference = %q %q %v %v", name, policy, noResolve, err)
+	}
+
+	got := ExpandRuleSetEntries([]string{"IP-CIDR,10.0.0.0/8", "DOMAIN,example.org", "PROCESS-NAME,curl,no-resolve"}, policy, noResolve)
+	want := []string{"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve", "DOMAIN,example.org,DIRECT", "PROCESS-NAME,curl,DIRECT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandRuleSetEntries = %v, want %v", got, want)
+	}
+
+	got = ExpandRuleSetEntries([]string{
+		"AND,((DOMAIN,a.com),(NETWORK,UDP))",
+		"OR,((IP-CIDR,1.1.1.1/32),(DST-PORT,53)),no-resolve",
+		"SRC-IP-CIDR,192.168.1.0/24,src",
+		"IP-ASN,13335,no-resolve",
+		"DOMAIN",
+	}, "Proxy", false)
+	want = []string{
+		"AND,((DOMAIN,a.com),(NETWORK,UDP)),Proxy",
+		"OR,((IP-CIDR,1.1.1.1/32),(DST-PORT,53)),Proxy,no-resolve",
+		"SRC-IP-CIDR,192.168.1.0/24,Proxy,src",
+		"IP-ASN,13335,Proxy,no-resolve",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandRuleSetEntries with options = %v, want %v", got, want)
+	}
+}
+
+func TestConvertRuleProviderEntries(t *testing.T) {
+	entries := []string{
+		"DOMAIN-SUFFIX,google.com",
+		"DOMAIN-WILDCARD,*.cdn.net",
+		"IP-CIDR,10.0.0.0/8,no-resolve",
+		"DST-PORT,80/443",
+		"DOMAIN-REGEX,^ad\\.",
+	}
+
+	surge, skipped, err := ConvertRuleProviderEntries(entries, RuleListTargetSurge, "")
+	if err != nil {
+		t.Fatalf("surge conversion: %v", err)
+	}
+	wantSurge := "DOMAIN-SUFFIX,google.com\nDOMAIN-WILDCARD,*.cdn.net\nIP-CIDR,10.0.0.0/8,no-resolve\nDEST-PORT,80\nDEST-PORT,443\n"
+	if surge != wantSurge || len(skipped) != 1 {
+		t.Errorf("surge = %q (skipped %v), want %q", surge, skipped, wantSurge)
+	}
+
+	loon, skipped, _ := ConvertRuleProviderEntries(entries, RuleListTargetLoon, "")
+	if strings.Contains(loon, "DOMAIN-WILDCARD") || len(skipped) != 2 {
+		t.Errorf("loon should skip DOMAIN-WILDCARD and DOMAIN-REGEX, got %q (skipped %v)", loon, skipped)
+	}
+
+	qx, _, _ := ConvertRuleProviderEntries(entries[:1], RuleListTargetQX, "")
+	if qx != "host-suffix, google.com, proxy\n" {
+		t.Errorf("qx = %q", qx)
+	}
+
+	singbox, _, err := ConvertRuleProviderEntries(entries, RuleListTargetSingbox, "")
+	if err != nil {
+		t.Fatalf("singbox conversion: %v", err)
+	}
+	var doc SingboxSourceRuleSet
+	if err := json.Unmarshal([]byte(singbox), &doc); err != nil {
+		t.Fatalf("singbox output is not valid JSON: %v", err)
+	}
+	if doc.Version != 2 || len(doc.Rules) != 2 {
+		t.Fatalf("unexpected singbox rule set: %+v", doc)
+	}
+	rule := doc.Rules[0]
+	if len(rule.DomainSuffix) != 1 || len(rule.DomainRegex) != 2 || len(rule.IPCIDR) != 1 || len(rule.Port) != 0 {
+		t.Errorf("unexpected singbox destination rule: %+v", rule)
+	}
+	if !reflect.DeepEqual(doc.Rules[1], SingboxHeadlessRule{Port: []int{80, 443}}) {
+		t.Errorf("unexpected singbox port rule: %+v", doc.Rules[1])
+	}
+
+	if _, _, err := ConvertRuleProviderEntries(entries, "clash", ""); err == nil {
+		t.Errorf("unsupported target should fail")
+	}
+}
+
+func TestConvertRuleProviderEntriesSingboxCategories(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []SingboxHeadlessRule
+	}{
+		{
+			name:    "destination fields share one rule",
+			entries: []string{"DOMAIN,a.com", "DOMAIN-SUFFIX,b.com", "IP-CIDR,1.1.1.0/24"},
+			want:    []SingboxHeadlessRule{{Domain: []string{"a.com"}, DomainSuffix: []string{"b.com"}, IPCIDR: []string{"1.1.1.0/24"}}},
+		},
+		{
+			// 合并为一条规则会变成“域名且端口且进程”，几乎匹配不到
+			name: "mixed domain, port and process",
+			entries: []string{
+				"DOMAIN-SUFFIX,example.com",
+				"DST-PORT,22/1000-2000",
+				"PROCESS-NAME,curl",
+				"DOMAIN-KEYWORD,ads",
+				"PROCESS-NAME,wget",
+			},
+			want: []SingboxHeadlessRule{
+				{DomainSuffix: []string{"example.com"}, DomainKeyword: []string{"ads"}},
+				{Port: []int{22}, PortRange: []string{"1000:2000"}},
+				{ProcessName: []string{"curl", "wget"}},
+			},
+		},
+		{
+			name:    "source fields",
+			entries: []string{"SRC-IP-CIDR,192.168.0.0/16", "SRC-PORT,8080", "IP-CIDR,10.0.0.0/8"},
+			want: []SingboxHeadlessRule{
+				{IPCIDR: []string{"10.0.0.0/8"}},
+				{SourceIPCIDR: []string{"192.168.0.0/16"}},
+				{SourcePort: []int{8080}},
+			},
+		},
+		{
+			name:    "only unsupported entries",
+			entries: []string{"GEOIP,CN"},
+			want:    []SingboxHeadlessRule{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _, err := ConvertRuleProviderEntries(tt.entries, RuleListTargetSingbox, "")
+			if err != nil {
+				t.Fatalf("ConvertRuleProviderEntries: %v", err)
+			}
+			var doc SingboxSourceRuleSet
+			if err := json.Unmarshal([]byte(out), &doc); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if len(doc.Rules) != len(tt.want) {
+				t.Fatalf("got %d rules %+v, want %d", len(doc.Rules), doc.Rules, len(tt.want))
+			}
+			if len(tt.want) > 0 && !reflect.DeepEqual(doc.Rules, tt.want) {
+				t.Fatalf("rules = %+v, want %+v", doc.Rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertClashRulesToSurgeFormatWithURL(t *testing.T) {
+	providers := map[string]ClashRuleProvider{
+		"cn": {Type: "http", Behavior: "domain", URL: "https://example.com/cn.mrs", Format: "mrs"},
+	}
+	rules, err := ConvertClashRulesToSurgeFormatWithURL([]string{"RULE-SET,cn,DIRECT", "MATCH,Proxy"}, providers,
+		func(name string, provider ClashRuleProvider) string {
+			return "https://mmw.local/api/rule-set/convert?name=" + name
+		})
+	if err != nil {
+		t.Fatalf("convert rules: %v", err)
+	}
+	if rules[0] != "RULE-SET,https://mmw.local/api/rule-set/convert?name=cn,DIRECT" || rules[1] != "FINAL,Proxy" {
+		t.Errorf("unexpected rules: %v", rules)
+	}
+
+	if fallback, ok := RuleProviderTextFallbackURL("https://example.com/cn.mrs?v=1"); !ok || fallback != "https://example.com/cn.list?v=1" {
+		t.Errorf("RuleProviderTextFallbackURL = %q, %v", fallback, ok)
+	}
+}
@@ -0,0 +1,491 @@
+package substore
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleQuery describes a connection to run through the rules
+type RuleQuery struct {
+	Domain     string `json:"domain,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	IPResolved bool   `json:"ip_resolved,omitempty"` // IP came from DNS resolution of Domain (no-resolve rules skip it)
+	Port       int    `json:"port,omitempty"`
+	SrcIP      string `json:"src_ip,omitempty"`
+	SrcPort    int    `json:"src_port,omitempty"`
+	Process    string `json:"process,omitempty"`
+	Network    string `json:"network,omitempty"` // tcp or udp
+}
+
+// RuleSetLoader returns the classical entries of a rule-provider (see ParseRuleProviderPayload)
+type RuleSetLoader func(name string, provider ClashRuleProvider) ([]string, error)
+
+// RuleMatch is the rule line that matched a query
+type RuleMatch struct {
+	Index        int    `json:"index"`
+	Rule         string `json:"rule"`
+	Policy       string `json:"policy"`
+	RuleSetEntry string `json:"rule_set_entry,omitempty"` // matching entry inside a RULE-SET
+}
+
+// RuleSimulation is the result of simulating one query
+type RuleSimulation struct {
+	Query    RuleQuery  `json:"query"`
+	Match    *RuleMatch `json:"match,omitempty"`
+	Chain    []string   `json:"chain"`    // policy -> default member -> ... -> node/DIRECT/REJECT
+	Nodes    []string   `json:"nodes"`    // all concrete nodes reachable from the policy
+	Warnings []string   `json:"warnings"` // rules that could not be evaluated
+}
+
+// simProxyGroup is the subset of a proxy-group needed for simulation
+type simProxyGroup struct {
+	Name          string   `yaml:"name"`
+	Type          string   `yaml:"type"`
+	Proxies       []string `yaml:"proxies"`
+	Use           []string `yaml:"use"`
+	IncludeAll    bool     `yaml:"include-all"`
+	IncludeAllP   bool     `yaml:"include-all-proxies"`
+	Filter        string   `yaml:"filter"`
+	ExcludeFilter string   `yaml:"exclude-filter"`
+}
+
+// RuleSimulator evaluates Clash rules in order against queries
+type RuleSimulator struct {
+	rules     []string
+	providers map[string]ClashRuleProvider
+	groups    map[string]simProxyGroup
+	nodes     []string
+	loader    RuleSetLoader
+	ruleSets  map[string][]string
+	setErrors map[string]error
+}
+
+// NewRuleSimulator builds a simulator from a rendered Clash YAML config
+func NewRuleSimulator(content []byte, loader RuleSetLoader) (*RuleSimulator, error) {
+	var cfg struct {
+		Proxies []struct {
+			Name string `yaml:"name"`
+		} `yaml:"proxies"`
+		ProxyGroups   []simProxyGroup              `yaml:"proxy-groups"`
+		Rules         []string                     `yaml:"rules"`
+		RuleProviders map[string]ClashRuleProvider `yaml:"rule-providers"`
+	}
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	s := &RuleSimulator{
+		rules:     cfg.Rules,
+		providers: cfg.RuleProviders,
+		groups:    make(map[string]simProxyGroup, len(cfg.ProxyGroups)),
+		loader:    loader,
+		ruleSets:  make(map[string][]string),
+		setErrors: make(map[string]error),
+	}
+	for _, p := range cfg.Proxies {
+		s.nodes = append(s.nodes, p.Name)
+	}
+	for _, g := range cfg.ProxyGroups {
+		s.groups[g.Name] = g
+	}
+	return s, nil
+}
+
+// Simulate evaluates the rules in order and resolves the matched policy
+func (s *RuleSimulator) Simulate(q RuleQuery) RuleSimulation {
+	result := RuleSimulation{Query: q, Chain: []string{}, Nodes: []string{}, Warnings: []string{}}
+	warned := make(map[string]bool)
+	warn := func(msg string) {
+		if !warned[msg] {
+			warned[msg] = true
+			result.Warnings = append(result.Warnings, msg)
+		}
+	}
+
+	for i, line := range s.rules {
+		parts := splitRuleLine(line)
+		if len(parts) < 2 {
+			continue
+		}
+		ruleType := strings.ToUpper(parts[0])
+
+		if ruleType == "MATCH" || ruleType == "FINAL" {
+			result.Match = &RuleMatch{Index: i, Rule: line, Policy: parts[1]}
+			break
+		}
+		if len(parts) < 3 {
+			continue
+		}
+		policy := parts[2]
+		noResolve := hasNoResolveOption(strings.Split(line, ",")[1:])
+
+		if ruleType == "RULE-SET" {
+			entries, err := s.loadRuleSet(parts[1])
+			if err != nil {
+				warn(fmt.Sprintf("规则集 %s 无法加载: %v", parts[1], err))
+				continue
+			}
+			for _, entry := range entries {
+				matched, err := s.matchRule(splitRuleLine(entry), q, noResolve || hasNoResolveOption(strings.Split(entry, ",")[1:]))
+				if err != nil {
+					warn(fmt.Sprintf("规则集 %s: %v", parts[1], err))
+					continue
+				}
+				if matched {
+					result.Match = &RuleMatch{Index: i, Rule: line, Policy: policy, RuleSetEntry: entry}
+					break
+				}
+			}
+			if result.Match != nil {
+				break
+			}
+			continue
+		}
+
+		if ruleType == "AND" || ruleType == "OR" || ruleType == "NOT" {
+			payload, logicPolicy, ok := splitLogicRule(line)
+			if !ok {
+				warn("无法解析逻辑规则: " + line)
+				continue
+			}
+			matched, err := s.matchLogic(ruleType, payload, q)
+			if err != nil {
+				warn(err.Error())
+				continue
+			}
+			if matched {
+				result.Match = &RuleMatch{Index: i, Rule: line, Policy: logicPolicy}
+				break
+			}
+			continue
+		}
+
+		matched, err := s.matchRule(parts, q, noResolve)
+		if err != nil {
+			warn(err.Error())
+			continue
+		}
+		if matched {
+			result.Match = &RuleMatch{Index: i, Rule: line, Policy: policy}
+			break
+		}
+	}
+
+	if result.Match != nil {
+		result.Chain = s.resolveChain(result.Match.Policy)
+		result.Nodes = s.resolveNodes(result.Match.Policy)
+	}
+	return result
+}
+
+// matchRule evaluates a single non-logic rule (type,value[,...])
+func (s *RuleSimulator) matchRule(parts []string, q RuleQuery, noResolve bool) (bool, error) {
+	if len(parts) < 2 {
+		return false, nil
+	}
+	ruleType := strings.ToUpper(parts[0])
+	value := parts[1]
+	domain := strings.ToLower(strings.TrimSuffix(q.Domain, "."))
+
+	switch ruleType {
+	case "DOMAIN":
+		return domain != "" && domain == strings.ToLower(value), nil
+	case "DOMAIN-SUFFIX":
+		suffix := strings.ToLower(value)
+		return domain != "" && (domain == suffix || strings.HasSuffix(domain, "."+suffix)), nil
+	case "DOMAIN-KEYWORD":
+		return domain != "" && strings.Contains(domain, strings.ToLower(value)), nil
+	case "DOMAIN-WILDCARD":
+		if domain == "" {
+			return false, nil
+		}
+		matched, err := path.Match(strings.ToLower(value), domain)
+		if err != nil {
+			return false, fmt.Errorf("无效的通配符 %s", value)
+		}
+		return matched, nil
+	case "DOMAIN-REGEX":
+		if domain == "" {
+			return false, nil
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return false, fmt.Errorf("无效的正则 %s", value)
+		}
+		return re.MatchString(domain), nil
+	case "IP-CIDR", "IP-CIDR6":
+		if q.IP == "" || (noResolve && q.IPResolved) {
+			return false, nil
+		}
+		return ipInCIDR(q.IP, value)
+	case "SRC-IP-CIDR":
+		if q.SrcIP == "" {
+			return false, nil
+		}
+		return ipInCIDR(q.SrcIP, value)
+	case "DST-PORT":
+		return q.Port > 0 && portMatches(q.Port, value), nil
+	case "SRC-PORT":
+		return q.SrcPort > 0 && portMatches(q.SrcPort, value), nil
+	case "PROCESS-NAME":
+		return q.Process != "" && strings.EqualFold(q.Process, value), nil
+	case "NETWORK":
+		return q.Network != "" && strings.EqualFold(q.Network, value), nil
+	case "GEOIP":
+		if q.IP == "" || (noResolve && q.IPResolved) {
+			return false, nil
+		}
+		code := strings.ToLower(value)
+		if code == "private" || code == "lan" {
+			ip := net.ParseIP(q.IP)
+			return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()), nil
+		}
+		return false, fmt.Errorf("GEOIP,%s 需要 GeoIP 数据库，已跳过", value)
+	case "GEOSITE":
+		if domain == "" {
+			return false, nil
+		}
+		return false, fmt.Errorf("GEOSITE,%s 需要 GeoSite 数据库，已跳过", value)
+	}
+	return false, fmt.Errorf("不支持模拟的规则类型 %s", ruleType)
+}
+
+// matchLogic evaluates AND/OR/NOT rules with a payload like ((DOMAIN,a.com),(NETWORK,UDP))
+func (s *RuleSimulator) matchLogic(ruleType, payload string, q RuleQuery) (bool, error) {
+	subRules, err := splitLogicPayload(payload)
+	if err != nil {
+		return false, err
+	}
+	if ruleType == "NOT" && len(subRules) != 1 {
+		return false, fmt.Errorf("NOT 规则只能包含一个子规则: %s", payload)
+	}
+
+	for _, sub := range subRules {
+		subParts := splitRuleLine(sub)
+		if len(subParts) == 0 {
+			return false, fmt.Errorf("逻辑规则包含空的子规则: %s", payload)
+		}
+		var matched bool
+		subType := strings.ToUpper(subParts[0])
+		if subType == "AND" || subType == "OR" || subType == "NOT" {
+			inner := strings.TrimSpace(strings.TrimPrefix(sub[len(subParts[0]):], ","))
+			matched, err = s.matchLogic(subType, inner, q)
+		} else {
+			matched, err = s.matchRule(subParts, q, false)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch ruleType {
+		case "AND":
+			if !matched {
+				return false, nil
+			}
+		case "OR":
+			if matched {
+				return true, nil
+			}
+		case "NOT":
+			return !matched, nil
+		}
+	}
+	return ruleType == "AND", nil
+}
+
+func (s *RuleSimulator) loadRuleSet(name string) ([]string, error) {
+	if entries, ok := s.ruleSets[name]; ok {
+		return entries, nil
+	}
+	if err, ok := s.setErrors[name]; ok {
+		return nil, err
+	}
+
+	provider, ok := s.providers[name]
+	if !ok {
+		err := fmt.Errorf("rule-provider %s 未定义", name)
+		s.setErrors[name] = err
+		return nil, err
+	}
+	if s.loader == nil {
+		err := fmt.Errorf("未配置规则集加载器")
+		s.setErrors[name] = err
+		return nil, err
+	}
+	entries, err := s.loader(name, provider)
+	if err != nil {
+		s.setErrors[name] = err
+		return nil, err
+	}
+	s.ruleSets[name] = entries
+	return entries, nil
+}
+
+// resolveChain follows the default (first) member of each group until a non-group is reached
+func (s *RuleSimulator) resolveChain(policy string) []string {
+	chain := []string{policy}
+	seen := map[string]bool{policy: true}
+	current := policy
+	for {
+		members := s.groupMembers(current)
+		if len(members) == 0 {
+			return chain
+		}
+		next := members[0]
+		if seen[next] {
+			return append(chain, next+" (循环引用)")
+		}
+		seen[next] = true
+		chain = append(chain, next)
+		current = next
+	}
+}
+
+// resolveNodes returns all concrete nodes (and DIRECT/REJECT) reachable from the policy
+func (s *RuleSimulator) resolveNodes(policy string) []string {
+	var nodes []string
+	added := make(map[string]bool)
+	visited := make(map[string]bool)
+
+	var walk func(name string)
+	walk = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		members := s.groupMembers(name)
+		if members == nil {
+			if !added[name] {
+				added[name] = true
+				nodes = append(nodes, name)
+			}
+			return
+		}
+		for _, m := range members {
+			walk(m)
+		}
+	}
+	walk(policy)
+
+	if nodes == nil {
+		nodes = []string{}
+	}
+	return nodes
+}
+
+// groupMembers returns the members of a proxy-group, or nil if name is not a group
+func (s *RuleSimulator) groupMembers(name string) []string {
+	group, ok := s.groups[name]
+	if !ok {
+		return nil
+	}
+
+	members := make([]string, 0, len(group.Proxies))
+	members = append(members, group.Proxies...)
+	if group.IncludeAll || group.IncludeAllP {
+		candidates := s.nodes
+		if group.Filter != "" {
+			candidates = applyFilter(candidates, group.Filter)
+		}
+		if group.ExcludeFilter != "" {
+			candidates = applyExcludeFilter(candidates, group.ExcludeFilter)
+		}
+		for _, node := range candidates {
+			if !contains(members, node) {
+				members = append(members, node)
+			}
+		}
+	}
+	for _, provider := range group.Use {
+		members = append(members, "proxy-provider:"+provider)
+	}
+	return members
+}
+
+// splitLogicRule splits "AND,((A,a),(B,b)),POLICY" into payload and policy
+func splitLogicRule(line string) (payload, policy string, ok bool) {
+	start := strings.Index(line, "(")
+	end := strings.LastIndex(line, ")")
+	if start < 0 || end <= start {
+		return "", "", false
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[end+1:]), ","))
+	if rest == "" {
+		return "", "", false
+	}
+	policy = strings.TrimSpace(strings.Split(rest, ",")[0])
+	return line[start : end+1], policy, true
+}
+
+// splitLogicPayload splits "((A,a),(B,b))" into ["A,a", "B,b"]
+func splitLogicPayload(payload string) ([]string, error) {
+	payload = strings.TrimSpace(payload)
+	if !strings.HasPrefix(payload, "(") || !strings.HasSuffix(payload, ")") {
+		return nil, fmt.Errorf("无效的逻辑规则: %s", payload)
+	}
+	inner := payload[1 : len(payload)-1]
+
+	var items []string
+	depth := 0
+	start := -1
+	for i, r := range inner {
+		switch r {
+		case '(':
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && start >= 0 {
+				items = append(items, strings.TrimSpace(inner[start:i]))
+				start = -1
+			}
+			if depth < 0 {
+				return nil, fmt.Errorf("无效的逻辑规则: %s", payload)
+			}
+		}
+	}
+	if depth != 0 || len(items) == 0 {
+		return nil, fmt.Errorf("无效的逻辑规则: %s", payload)
+	}
+	return items, nil
+}
+
+func ipInCIDR(ipStr, cidr string) (bool, error) {
+	ip := net.ParseIP(strings.TrimSpace(ipStr))
+	if ip == nil {
+		return false, nil
+	}
+	_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return false, fmt.Errorf("无效的 CIDR %s", cidr)
+	}
+	return network.Contains(ip), nil
+}
+
+// portMatches checks a port against values like "443", "80/443" or "1000-2000"
+func portMatches(port int, value string) bool {
+	for _, item := range strings.Split(value, "/") {
+		item = strings.TrimSpace(item)
+		if lo, hi, ok := strings.Cut(item, "-"); ok {
+			l, err1 := strconv.Atoi(lo)
+			h, err2 := strconv.Atoi(hi)
+			if err1 == nil && err2 == nil && port >= l && port <= h {
+				return true
+			}
+			continue
+		}
+		if p, err := strconv.Atoi(item); err == nil && p == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package substore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const simulatorConfig = `
+proxies:
+  - {name: 香港 01, type: ss, server: hk.example.com, port: 443}
+  - {name: 美国 01, type: ss, server: us.example.com, port: 443}
+proxy-groups:
+  - name: 节点选择
+    type: select
+    proxies: [自动选择, DIRECT]
+  - name: 自动选择
+    type: url-test
+    include-all: true
+    filter: 香港
+  - name: 广告拦截
+    type: select
+    proxies: [REJECT, DIRECT]
+rule-providers:
+  ads:
+    type: http
+    behavior: domain
+    url: https://example.com/ads.yaml
+  broken:
+    type: http
+    behavior: domain
+    url: https://example.com/broken.yaml
+rules:
+  - RULE-SET,broken,DIRECT
+  - RULE-SET,ads,广告拦截
+  - AND,((DOMAIN-SUFFIX,example.org),(NETWORK,UDP)),REJECT
+  - DOMAIN-SUFFIX,google.com,节点选择
+  - IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+  - DST-PORT,22/8000-9000,DIRECT
+  - GEOIP,CN,DIRECT
+  - MATCH,节点选择
+`
+
+func TestRuleSimulator(t *testing.T) {
+	loader := func(name string, provider ClashRuleProvider) ([]string, error) {
+		if name == "broken" {
+			return nil, errors.New("fetch failed")
+		}
+		return ParseRuleProviderPayload([]byte("payload:\n  - '+.doubleclick.net'\n"), provider.Behavior, RuleProviderFormatYAML)
+	}
+	sim, err := NewRuleSimulator([]byte(simulatorConfig), loader)
+	if err != nil {
+		t.Fatalf("NewRuleSimulator: %v", err)
+	}
+
+	res := sim.Simulate(RuleQuery{Domain: "ad.doubleclick.net"})
+	if res.Match == nil || res.Match.Policy != "广告拦截" || res.Match.RuleSetEntry != "DOMAIN-SUFFIX,doubleclick.net" {
+		t.Fatalf("rule-set match = %+v", res.Match)
+	}
+	if !reflect.DeepEqual(res.Chain, []string{"广告拦截", "REJECT"}) {
+		t.Errorf("chain = %v", res.Chain)
+	}
+	if len(res.Warnings) != 1 {
+		t.Errorf("expected a warning for the broken rule-set, got %v", res.Warnings)
+	}
+
+	res = sim.Simulate(RuleQuery{Domain: "www.google.com"})
+	if res.Match == nil || res.Match.Index != 3 {
+		t.Fatalf("google match = %+v", res.Match)
+	}
+	if !reflect.DeepEqual(res.Chain, []string{"节点选择", "自动选择", "香港 01"}) {
+		t.Errorf("chain = %v", res.Chain)
+	}
+	if !reflect.DeepEqual(res.Nodes, []string{"香港 01", "DIRECT"}) {
+		t.Errorf("nodes = %v", res.Nodes)
+	}
+
+	res = sim.Simulate(RuleQuery{Domain: "a.example.org", Network: "udp"})
+	if res.Match == nil || res.Match.Policy != "REJECT" {
+		t.Errorf("logic rule match = %+v", res.Match)
+	}
+
+	// no-resolve IP rules do not apply to resolved domains
+	res = sim.Simulate(RuleQuery{Domain: "intranet.local", IP: "10.1.1.1", IPResolved: true})
+	if res.Match == nil || res.Match.Rule != "MATCH,节点选择" {
+		t.Errorf("resolved IP should skip no-resolve rule, got %+v", res.Match)
+	}
+	res = sim.Simulate(RuleQuery{IP: "10.1.1.1"})
+	if res.Match == nil || res.Match.Index != 4 {
+		t.Errorf("direct IP match = %+v", res.Match)
+	}
+
+	res = sim.Simulate(RuleQuery{Domain: "host.test", Port: 8080})
+	if res.Match == nil || res.Match.Index != 5 {
+		t.Errorf("port range match = %+v", res.Match)
+	}
+}
+
+func TestRuleSimulatorEmptyLogicSubRule(t *testing.T) {
+	config := "rules:\n  - AND,((DOMAIN,a.com),()),REJECT\n  - NOT,(()),REJECT\n  - MATCH,DIRECT\n"
+	sim, err := NewRuleSimulator([]byte(config), nil)
+	if err != nil {
+		t.Fatalf("NewRuleSimulator: %v", err)
+	}
+
+	res := sim.Simulate(RuleQuery{Domain: "a.com"})
+	if res.Match == nil || res.Match.Policy != "DIRECT" {
+		t.Fatalf("empty sub-rule should be skipped, got %+v", res.Match)
+	}
+	if len(res.Warnings) != 2 {
+		t.Errorf("expected warnings for both logic rules, got %v", res.Warnings)
+	}
+}
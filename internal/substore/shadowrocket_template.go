@@ -540,6 +540,18 @@ func (p *ShadowrocketTemplateProducer) generateRules(opts *ProduceOptions) strin
 		for name, provider := range providers {
 			if providerMap, ok := provider.(map[string]interface{}); ok {
 				if url := GetString(providerMap, "url"); url != "" {
+					if opts.RuleSetURL != nil {
+						rewritten := opts.RuleSetURL(name, ClashRuleProvider{
+							Type:     GetString(providerMap, "type"),
+							Behavior: GetString(providerMap, "behavior"),
+							URL:      url,
+							Format:   GetString(providerMap, "format"),
+						})
+						if rewritten != "" {
+							ruleProviders[name] = rewritten
+							continue
+						}
+					}
 					// 将 .mrs 转换为 .list
 					convertedURL := strings.Replace(url, ".mrs", ".list", 1)
 					ruleProviders[name] = convertedURL
@@ -0,0 +1,532 @@
+package substore
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sing-box rule-set formats
+const (
+	SingboxRuleSetFormatSource = "source"
+	SingboxRuleSetFormatBinary = "binary"
+)
+
+const (
+	singboxGeositeURL = "https://testingcf.jsdelivr.net/gh/SagerNet/sing-geosite@rule-set/geosite-%s.srs"
+	singboxGeoIPURL   = "https://testingcf.jsdelivr.net/gh/SagerNet/sing-geoip@rule-set/geoip-%s.srs"
+
+	singboxDirectTag = "direct"
+)
+
+// SingboxRuleSet represents a remote rule_set entry in sing-box route config
+type SingboxRuleSet struct {
+	Tag            string `json:"tag"`
+	Type           string `json:"type"`
+	Format         string `json:"format"`
+	URL            string `json:"url"`
+	DownloadDetour string `json:"download_detour,omitempty"`
+	UpdateInterval string `json:"update_interval,omitempty"`
+
+	// Behavior keeps the original Clash rule-provider behavior (domain/ipcidr/classical)
+	// so later stages can convert the payload; it is not part of sing-box config.
+	Behavior string `json:"-"`
+}
+
+// SingboxRouteRule represents a single sing-box route rule
+type SingboxRouteRule struct {
+	Domain        []string `json:"domain,omitempty"`
+	DomainSuffix  []string `json:"domain_suffix,omitempty"`
+	DomainKeyword []string `json:"domain_keyword,omitempty"`
+	DomainRegex   []string `json:"domain_regex,omitempty"`
+	IPCIDR        []string `json:"ip_cidr,omitempty"`
+	SourceIPCIDR  []string `json:"source_ip_cidr,omitempty"`
+	IPIsPrivate   bool     `json:"ip_is_private,omitempty"`
+	Port          []int    `json:"port,omitempty"`
+	PortRange     []string `json:"port_range,omitempty"`
+	SourcePort    []int    `json:"source_port,omitempty"`
+	ProcessName   []string `json:"process_name,omitempty"`
+	ProcessPath   []string `json:"process_path,omitempty"`
+	Network       []string `json:"network,omitempty"`
+	RuleSet       []string `json:"rule_set,omitempty"`
+	Action        string   `json:"action"`
+	Outbound      string   `json:"outbound,omitempty"`
+}
+
+// SingboxRoute represents the route section of a sing-box config
+type SingboxRoute struct {
+	Rules               []SingboxRouteRule `json:"rules"`
+	RuleSet             []SingboxRuleSet   `json:"rule_set,omitempty"`
+	Final               string             `json:"final,omitempty"`
+	AutoDetectInterface bool               `json:"auto_detect_interface"`
+}
+
+// SingboxOutboundGroup represents a selector/urltest outbound generated from a proxy group.
+// Filter fields are kept for node expansion and stripped from the JSON output.
+type SingboxOutboundGroup struct {
+	Type      string   `json:"type"`
+	Tag       string   `json:"tag"`
+	Outbounds []string `json:"outbounds,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Interval  string   `json:"interval,omitempty"`
+	Tolerance int      `json:"tolerance,omitempty"`
+	Providers []string `json:"providers,omitempty"`
+
+	IncludeAll    bool   `json:"-"`
+	Filter        string `json:"-"`
+	ExcludeFilter string `json:"-"`
+}
+
+// SingboxOutboundProvider represents a remote node list referenced by groups (outbound_providers)
+type SingboxOutboundProvider struct {
+	Type             string `json:"type"`
+	Tag              string `json:"tag"`
+	DownloadURL      string `json:"download_url"`
+	DownloadInterval string `json:"download_interval,omitempty"`
+	DownloadDetour   string `json:"download_detour,omitempty"`
+}
+
+// SingboxTemplate is the sing-box counterpart of TemplateV3Content
+type SingboxTemplate struct {
+	Outbounds         []SingboxOutboundGroup    `json:"outbounds"`
+	OutboundProviders []SingboxOutboundProvider `json:"outbound_providers,omitempty"`
+	Route             SingboxRoute              `json:"route"`
+
+	// Skipped records Clash rules that have no sing-box equivalent
+	Skipped []string `json:"-"`
+}
+
+// SingboxTemplateOptions controls template conversion
+type SingboxTemplateOptions struct {
+	// DownloadDetour is the outbound used to download remote rule-sets (default: direct)
+	DownloadDetour string
+	// RuleSetURL resolves a Clash yaml/text rule-provider, which sing-box cannot parse, to a URL serving
+	// a sing-box rule-set (e.g. the rule-provider conversion endpoint). It returns the URL and format
+	// (default source); providers it cannot resolve are skipped together with their rules.
+	// sing-box binaries (.srs and the MetaCubeX "sing" branch) are referenced directly.
+	RuleSetURL func(name string, provider RuleProviderConfig) (url string, format string)
+	// ProxyProviderURL resolves a proxy-provider used by a group (use:) to a sing-box node list URL.
+	// Providers it cannot resolve are dropped from the groups.
+	ProxyProviderURL ProxyProviderURLFunc
+}
+
+var singboxGroupTypes = map[string]string{
+	"select":       "selector",
+	"url-test":     "urltest",
+	"fallback":     "urltest",
+	"load-balance": "urltest",
+}
+
+// metaRulesDatPattern matches MetaCubeX meta-rules-dat mrs/yaml URLs which have .srs mirrors on the "sing" branch
+var metaRulesDatPattern = regexp.MustCompile(`(?i)^(.*meta-rules-dat(?:@|/raw/refs/heads/|/raw/|/))meta/(.*)\.(mrs|yaml|list)$`)
+
+// ParseTemplateV3Content parses a v3 YAML template into TemplateV3Content
+func ParseTemplateV3Content(content string) (*TemplateV3Content, error) {
+	var result TemplateV3Content
+	if err := yaml.Unmarshal([]byte(content), &result); err != nil {
+		return nil, fmt.Errorf("parse v3 template: %w", err)
+	}
+	return &result, nil
+}
+
+// ConvertV3ToSingbox converts a v3 template (proxy-groups, rules, rule-providers) to sing-box outbounds and route
+func ConvertV3ToSingbox(content *TemplateV3Content, opts *SingboxTemplateOptions) (*SingboxTemplate, error) {
+	if content == nil {
+		return nil, fmt.Errorf("template content is nil")
+	}
+	if opts == nil {
+		opts = &SingboxTemplateOptions{}
+	}
+	detour := opts.DownloadDetour
+	if detour == "" {
+		detour = singboxDirectTag
+	}
+
+	tpl := &SingboxTemplate{
+		Outbounds: make([]SingboxOutboundGroup, 0, len(content.ProxyGroups)),
+		Route: SingboxRoute{
+			Rules:               make([]SingboxRouteRule, 0, len(content.Rules)),
+			AutoDetectInterface: true,
+		},
+	}
+
+	// sing-box selectors cannot reject; groups defaulting to REJECT become reject actions
+	rejectGroups := singboxRejectGroups(content.ProxyGroups)
+
+	providerAdded := make(map[string]bool)
+	for _, group := range content.ProxyGroups {
+		if rejectGroups[group.Name] {
+			continue
+		}
+		out := convertGroupToSingbox(group, rejectGroups)
+		if opts.ProxyProviderURL != nil {
+			for _, name := range group.Use {
+				if !providerAdded[name] {
+					downloadURL := opts.ProxyProviderURL(name, ClashProxyProvider{})
+					if downloadURL == "" {
+						continue
+					}
+					providerAdded[name] = true
+					tpl.OutboundProviders = append(tpl.OutboundProviders, SingboxOutboundProvider{
+						Type:           "http",
+						Tag:            name,
+						DownloadURL:    downloadURL,
+						DownloadDetour: detour,
+					})
+				}
+				out.Providers = append(out.Providers, name)
+			}
+		}
+		tpl.Outbounds = append(tpl.Outbounds, out)
+	}
+
+	ruleSets := make(map[string]SingboxRuleSet)
+	var ruleSetOrder []string
+	addRuleSet := func(rs SingboxRuleSet) {
+		if _, ok := ruleSets[rs.Tag]; ok {
+			return
+		}
+		if rs.DownloadDetour == "" {
+			rs.DownloadDetour = detour
+		}
+		ruleSets[rs.Tag] = rs
+		ruleSetOrder = append(ruleSetOrder, rs.Tag)
+	}
+
+	for _, line := range content.Rules {
+		parts := splitRuleLine(line)
+		if len(parts) == 0 {
+			continue
+		}
+		ruleType := strings.ToUpper(parts[0])
+
+		if ruleType == "MATCH" || ruleType == "FINAL" {
+			if len(parts) >= 2 {
+				if isSingboxRejectPolicy(parts[1]) || rejectGroups[parts[1]] {
+					tpl.Skipped = append(tpl.Skipped, line)
+					continue
+				}
+				tpl.Route.Final = singboxOutboundTag(parts[1])
+			}
+			continue
+		}
+		if len(parts) < 3 {
+			tpl.Skipped = append(tpl.Skipped, line)
+			continue
+		}
+
+		value := parts[1]
+		rule := SingboxRouteRule{}
+		switch ruleType {
+		case "DOMAIN":
+			rule.Domain = []string{value}
+		case "DOMAIN-SUFFIX":
+			rule.DomainSuffix = []string{value}
+		case "DOMAIN-KEYWORD":
+			rule.DomainKeyword = []string{value}
+		case "DOMAIN-REGEX":
+			rule.DomainRegex = []string{value}
+		case "IP-CIDR", "IP-CIDR6":
+			rule.IPCIDR = []string{value}
+		case "SRC-IP-CIDR":
+			rule.SourceIPCIDR = []string{value}
+		case "DST-PORT", "SRC-PORT":
+			ports, ranges, ok := parseSingboxPorts(value)
+			if !ok {
+				tpl.Skipped = append(tpl.Skipped, line)
+				continue
+			}
+			if ruleType == "SRC-PORT" {
+				if len(ranges) > 0 {
+					tpl.Skipped = append(tpl.Skipped, line)
+					continue
+				}
+				rule.SourcePort = ports
+			} else {
+				rule.Port = ports
+				rule.PortRange = ranges
+			}
+		case "PROCESS-NAME":
+			rule.ProcessName = []string{value}
+		case "PROCESS-PATH":
+			rule.ProcessPath = []string{value}
+		case "NETWORK":
+			rule.Network = []string{strings.ToLower(value)}
+		case "GEOSITE":
+			tag := "geosite-" + strings.ToLower(value)
+			addRuleSet(SingboxRuleSet{
+				Tag:      tag,
+				Type:     "remote",
+				Format:   SingboxRuleSetFormatBinary,
+				URL:      fmt.Sprintf(singboxGeositeURL, strings.ToLower(value)),
+				Behavior: "domain",
+			})
+			rule.RuleSet = []string{tag}
+		case "GEOIP":
+			if strings.EqualFold(value, "private") || strings.EqualFold(value, "lan") {
+				rule.IPIsPrivate = true
+				break
+			}
+			tag := "geoip-" + strings.ToLower(value)
+			addRuleSet(SingboxRuleSet{
+				Tag:      tag,
+				Type:     "remote",
+				Format:   SingboxRuleSetFormatBinary,
+				URL:      fmt.Sprintf(singboxGeoIPURL, strings.ToLower(value)),
+				Behavior: "ipcidr",
+			})
+			rule.RuleSet = []string{tag}
+		case "RULE-SET":
+			provider, ok := content.RuleProviders[value]
+			if !ok {
+				tpl.Skipped = append(tpl.Skipped, line)
+				continue
+			}
+			rs, ok := ruleProviderToSingbox(value, provider, opts)
+			if !ok {
+				tpl.Skipped = append(tpl.Skipped, line)
+				continue
+			}
+			addRuleSet(rs)
+			rule.RuleSet = []string{value}
+		default:
+			tpl.Skipped = append(tpl.Skipped, line)
+			continue
+		}
+
+		applySingboxPolicy(&rule, parts[2], rejectGroups)
+		tpl.Route.Rules = append(tpl.Route.Rules, rule)
+	}
+
+	for _, tag := range ruleSetOrder {
+		tpl.Route.RuleSet = append(tpl.Route.RuleSet, ruleSets[tag])
+	}
+
+	return tpl, nil
+}
+
+// JSON renders the template as indented sing-box JSON
+func (t *SingboxTemplate) JSON() ([]byte, error) {
+	if t == nil {
+		return nil, fmt.Errorf("template is nil")
+	}
+	return json.MarshalIndent(t, "", "  ")
+}
+
+// ExpandNodes replaces ProxyNodesMarker in each group with node tags matching the group's filters
+// and appends the direct outbound used by DIRECT policies.
+func (t *SingboxTemplate) ExpandNodes(nodeTags []string) {
+	if t == nil {
+		return
+	}
+	for i := range t.Outbounds {
+		group := &t.Outbounds[i]
+		var expanded []string
+		for _, tag := range group.Outbounds {
+			if tag != ProxyNodesMarker {
+				expanded = append(expanded, tag)
+				continue
+			}
+			matched := nodeTags
+			if group.Filter != "" {
+				matched = applyFilter(matched, group.Filter)
+			}
+			if group.ExcludeFilter != "" {
+				matched = applyExcludeFilter(matched, group.ExcludeFilter)
+			}
+			expanded = append(expanded, matched...)
+		}
+		group.Outbounds = removeDuplicates(expanded)
+		if len(group.Outbounds) == 0 && len(group.Providers) == 0 {
+			group.Outbounds = []string{singboxDirectTag}
+		}
+	}
+
+	hasDirect := false
+	for _, group := range t.Outbounds {
+		if group.Tag == singboxDirectTag {
+			hasDirect = true
+			break
+		}
+	}
+	if !hasDirect {
+		t.Outbounds = append(t.Outbounds, SingboxOutboundGroup{Type: "direct", Tag: singboxDirectTag})
+	}
+}
+
+// convertGroupToSingbox converts a v3 proxy group to a sing-box selector/urltest outbound.
+// REJECT members and groups defaulting to REJECT have no outbound in sing-box and are left out.
+func convertGroupToSingbox(group ProxyGroupV3Config, rejectGroups map[string]bool) SingboxOutboundGroup {
+	groupType, ok := singboxGroupTypes[group.Type]
+	if !ok {
+		groupType = "selector"
+	}
+
+	out := SingboxOutboundGroup{
+		Type:          groupType,
+		Tag:           group.Name,
+		Filter:        group.Filter,
+		ExcludeFilter: group.ExcludeFilter,
+		IncludeAll:    group.IncludeAll || group.IncludeAllProxies,
+	}
+
+	for _, proxy := range group.Proxies {
+		if isSingboxRejectPolicy(proxy) || rejectGroups[proxy] {
+			continue
+		}
+		out.Outbounds = append(out.Outbounds, singboxOutboundTag(proxy))
+	}
+	if out.IncludeAll && !contains(group.Proxies, ProxyNodesMarker) {
+		out.Outbounds = append(out.Outbounds, ProxyNodesMarker)
+	}
+
+	if groupType == "urltest" {
+		out.URL = group.URL
+		if group.Interval > 0 {
+			out.Interval = strconv.Itoa(group.Interval) + "s"
+		}
+		out.Tolerance = group.Tolerance
+	}
+
+	return out
+}
+
+// ruleProviderToSingbox converts a Clash rule-provider to a sing-box remote rule_set.
+// ok is false when the provider is a Clash payload and opts.RuleSetURL cannot resolve it.
+func ruleProviderToSingbox(name string, provider RuleProviderConfig, opts *SingboxTemplateOptions) (SingboxRuleSet, bool) {
+	rs := SingboxRuleSet{
+		Tag:      name,
+		Type:     "remote",
+		URL:      provider.URL,
+		Format:   SingboxRuleSetFormatBinary,
+		Behavior: provider.Behavior,
+	}
+	if provider.Interval > 0 {
+		rs.UpdateInterval = strconv.Itoa(provider.Interval) + "s"
+	}
+
+	switch {
+	case strings.HasSuffix(strings.ToLower(provider.URL), ".srs"):
+		return rs, true
+	case metaRulesDatPattern.MatchString(provider.URL):
+		// MetaCubeX publishes sing-box binaries on the "sing" branch with the same layout
+		m := metaRulesDatPattern.FindStringSubmatch(provider.URL)
+		rs.URL = m[1] + "sing/" + m[2] + ".srs"
+		return rs, true
+	}
+
+	if opts.RuleSetURL == nil {
+		return rs, false
+	}
+	url, format := opts.RuleSetURL(name, provider)
+	if url == "" {
+		return rs, false
+	}
+	rs.URL = url
+	rs.Format = SingboxRuleSetFormatSource
+	if format != "" {
+		rs.Format = format
+	}
+	return rs, true
+}
+
+// applySingboxPolicy maps a Clash policy to sing-box rule action/outbound
+func applySingboxPolicy(rule *SingboxRouteRule, policy string, rejectGroups map[string]bool) {
+	if isSingboxRejectPolicy(policy) || rejectGroups[policy] {
+		rule.Action = "reject"
+		return
+	}
+	rule.Action = "route"
+	rule.Outbound = singboxOutboundTag(policy)
+}
+
+// isSingboxRejectPolicy reports whether a Clash policy rejects the connection
+func isSingboxRejectPolicy(name string) bool {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "REJECT", "REJECT-DROP", "REJECT-TINYGIF":
+		return true
+	}
+	return false
+}
+
+// singboxRejectGroups returns the groups whose default (first) member rejects, e.g. an ad-block
+// selector of REJECT and DIRECT. sing-box selectors cannot choose to reject, so rules using these
+// groups become reject actions and the remaining members are not selectable.
+func singboxRejectGroups(groups []ProxyGroupV3Config) map[string]bool {
+	rejectGroups := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, group := range groups {
+			if rejectGroups[group.Name] || len(group.Proxies) == 0 {
+				continue
+			}
+			if first := group.Proxies[0]; isSingboxRejectPolicy(first) || rejectGroups[first] {
+				rejectGroups[group.Name] = true
+				changed = true
+			}
+		}
+	}
+	return rejectGroups
+}
+
+// singboxOutboundTag maps Clash built-in policies to sing-box outbound tags
+func singboxOutboundTag(name string) string {
+	if strings.EqualFold(name, "DIRECT") {
+		return singboxDirectTag
+	}
+	return name
+}
+
+// splitRuleLine splits a Clash rule line and drops the trailing no-resolve/src options
+// that follow TYPE,VALUE,POLICY
+func splitRuleLine(line string) []string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	parts := strings.Split(line, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	for len(parts) > 3 {
+		last := parts[len(parts)-1]
+		if !strings.EqualFold(last, "no-resolve") && !strings.EqualFold(last, "src") {
+			break
+		}
+		parts = parts[:len(parts)-1]
+	}
+	return parts
+}
+
+// parseSingboxPorts parses Clash port values like "443", "80/443" or "1000-2000"
+func parseSingboxPorts(value string) ([]int, []string, bool) {
+	var ports []int
+	var ranges []string
+	for _, item := range strings.Split(value, "/") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "-") {
+			bounds := strings.SplitN(item, "-", 2)
+			if _, err := strconv.Atoi(bounds[0]); err != nil {
+				return nil, nil, false
+			}
+			if _, err := strconv.Atoi(bounds[1]); err != nil {
+				return nil, nil, false
+			}
+			ranges = append(ranges, bounds[0]+":"+bounds[1])
+			continue
+		}
+		port, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, nil, false
+		}
+		ports = append(ports, port)
+	}
+	return ports, ranges, len(ports) > 0 || len(ranges) > 0
+}
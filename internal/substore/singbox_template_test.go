@@ -0,0 +1,314 @@
+package substore
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConvertV3ToSingbox_FromACL(t *testing.T) {
+	acl := strings.Join([]string{
+		"ruleset=🎯 全球直连,[]GEOIP,private,no-resolve",
+		"ruleset=🎯 全球直连,clash-domain:https://testingcf.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@meta/geo/geosite/cn.mrs,28800",
+		"ruleset=🚀 节点选择,https://example.com/rules/Proxy.list",
+		"ruleset=🛑 广告拦截,[]DOMAIN-SUFFIX,ads.example.com",
+		"ruleset=🎯 全球直连,[]GEOSITE,cn",
+		"ruleset=🐟 漏网之鱼,[]FINAL",
+		"custom_proxy_group=🚀 节点选择`select`[]♻️ 自动选择`[]DIRECT`.*",
+		"custom_proxy_group=♻️ 自动选择`url-test`(香港|HK)`http://www.gstatic.com/generate_204`300,,50",
+		"custom_proxy_group=🎯 全球直连`select`[]DIRECT",
+		"custom_proxy_group=🛑 广告拦截`select`[]REJECT`[]DIRECT",
+		"custom_proxy_group=🐟 漏网之鱼`select`[]🚀 节点选择`[]DIRECT",
+	}, "\n")
+
+	v3, err := ConvertACLToV3(acl)
+	if err != nil {
+		t.Fatalf("ConvertACLToV3 failed: %v", err)
+	}
+
+	tpl, err := ConvertV3ToSingbox(v3, nil)
+	if err != nil {
+		t.Fatalf("ConvertV3ToSingbox failed: %v", err)
+	}
+
+	if tpl.Route.Final != "🐟 漏网之鱼" {
+		t.Errorf("final = %q, want 🐟 漏网之鱼", tpl.Route.Final)
+	}
+
+	// the Clash .list provider cannot be referenced without a converter
+	if len(tpl.Route.Rules) != 4 {
+		t.Fatalf("expected 4 route rules, got %d: %+v", len(tpl.Route.Rules), tpl.Route.Rules)
+	}
+	if len(tpl.Skipped) != 1 || !strings.HasPrefix(tpl.Skipped[0], "RULE-SET,Proxy,") {
+		t.Errorf("Clash list provider should be skipped, got %v", tpl.Skipped)
+	}
+	if !tpl.Route.Rules[0].IPIsPrivate {
+		t.Errorf("GEOIP,private should map to ip_is_private")
+	}
+	if got := tpl.Route.Rules[2].DomainSuffix; len(got) != 1 || got[0] != "ads.example.com" {
+		t.Errorf("unexpected domain_suffix rule: %+v", tpl.Route.Rules[2])
+	}
+
+	ruleSets := make(map[string]SingboxRuleSet)
+	for _, rs := range tpl.Route.RuleSet {
+		ruleSets[rs.Tag] = rs
+	}
+
+	cn, ok := ruleSets["cn_mrs"]
+	if !ok {
+		t.Fatalf("rule_set cn_mrs not found: %+v", tpl.Route.RuleSet)
+	}
+	if cn.Format != SingboxRuleSetFormatBinary || !strings.HasSuffix(cn.URL, "meta-rules-dat@sing/geo/geosite/cn.srs") {
+		t.Errorf("meta-rules-dat provider should map to srs binary, got %+v", cn)
+	}
+	if cn.UpdateInterval != "28800s" || cn.DownloadDetour != "direct" {
+		t.Errorf("unexpected interval/detour: %+v", cn)
+	}
+
+	if _, ok := ruleSets["Proxy"]; ok {
+		t.Errorf("Clash list provider should not be emitted as a sing-box rule_set")
+	}
+
+	ghProxy, _ := ruleProviderToSingbox("ads", RuleProviderConfig{
+		Behavior: "domain",
+		URL:      "https://gh-proxy.com/https://github.com/MetaCubeX/meta-rules-dat/raw/refs/heads/meta/geo/geosite/category-ads-all.mrs",
+	}, &SingboxTemplateOptions{})
+	if ghProxy.URL != "https://gh-proxy.com/https://github.com/MetaCubeX/meta-rules-dat/raw/refs/heads/sing/geo/geosite/category-ads-all.srs" {
+		t.Errorf("unexpected gh-proxy rewrite: %s", ghProxy.URL)
+	}
+
+	if _, ok := ruleSets["geosite-cn"]; !ok {
+		t.Errorf("GEOSITE,cn should add geosite-cn rule_set")
+	}
+
+	var selectGroup, urlTest *SingboxOutboundGroup
+	for i := range tpl.Outbounds {
+		switch tpl.Outbounds[i].Tag {
+		case "🚀 节点选择":
+			selectGroup = &tpl.Outbounds[i]
+		case "♻️ 自动选择":
+			urlTest = &tpl.Outbounds[i]
+		}
+	}
+	if selectGroup == nil || selectGroup.Type != "selector" {
+		t.Fatalf("selector group not converted: %+v", selectGroup)
+	}
+	if !contains(selectGroup.Outbounds, "direct") {
+		t.Errorf("DIRECT should map to direct outbound, got %v", selectGroup.Outbounds)
+	}
+	if urlTest == nil || urlTest.Type != "urltest" || urlTest.Interval != "300s" || urlTest.Tolerance != 50 {
+		t.Fatalf("urltest group not converted: %+v", urlTest)
+	}
+}
+
+func TestConvertV3ToSingbox_RuleSetURLRewrite(t *testing.T) {
+	content := &TemplateV3Content{
+		Rules: []string{"RULE-SET,Custom,DIRECT", "DST-PORT,80/8000-9000,Proxy", "MATCH,Proxy"},
+		RuleProviders: map[string]RuleProviderConfig{
+			"Custom": {Type: "http", Behavior: "domain", URL: "https://example.com/custom.yaml", Interval: 3600},
+		},
+	}
+
+	tpl, err := ConvertV3ToSingbox(content, &SingboxTemplateOptions{
+		RuleSetURL: func(name string, provider RuleProviderConfig) (string, string) {
+			return "https://mmw.local/rule-set/" + name + "?behavior=" + provider.Behavior, SingboxRuleSetFormatSource
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConvertV3ToSingbox failed: %v", err)
+	}
+
+	if len(tpl.Route.RuleSet) != 1 || tpl.Route.RuleSet[0].URL != "https://mmw.local/rule-set/Custom?behavior=domain" {
+		t.Fatalf("rule set URL not rewritten: %+v", tpl.Route.RuleSet)
+	}
+	if tpl.Route.RuleSet[0].Format != SingboxRuleSetFormatSource {
+		t.Errorf("converted rule set should use source format, got %q", tpl.Route.RuleSet[0].Format)
+	}
+	if tpl.Route.Rules[0].Outbound != "direct" {
+		t.Errorf("DIRECT policy should map to direct, got %q", tpl.Route.Rules[0].Outbound)
+	}
+	port := tpl.Route.Rules[1]
+	if len(port.Port) != 1 || port.Port[0] != 80 || len(port.PortRange) != 1 || port.PortRange[0] != "8000:9000" {
+		t.Errorf("unexpected port rule: %+v", port)
+	}
+}
+
+func TestConvertV3ToSingbox_NativeRuleSetNotRewritten(t *testing.T) {
+	content := &TemplateV3Content{
+		Rules: []string{"RULE-SET,Ads,REJECT", "RULE-SET,Custom,Proxy"},
+		RuleProviders: map[string]RuleProviderConfig{
+			"Ads":    {Type: "http", Behavior: "domain", URL: "https://example.com/ads.srs"},
+			"Custom": {Type: "http", Behavior: "classical", URL: "https://example.com/custom.yaml"},
+		},
+	}
+
+	var resolved []string
+	tpl, err := ConvertV3ToSingbox(content, &SingboxTemplateOptions{
+		RuleSetURL: func(name string, provider RuleProviderConfig) (string, string) {
+			resolved = append(resolved, name)
+			return "", ""
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConvertV3ToSingbox failed: %v", err)
+	}
+
+	if len(resolved) != 1 || resolved[0] != "Custom" {
+		t.Errorf("RuleSetURL should only be consulted for Clash payloads, got %v", resolved)
+	}
+	if len(tpl.Route.RuleSet) != 1 || tpl.Route.RuleSet[0].Format != SingboxRuleSetFormatBinary {
+		t.Fatalf("unexpected rule sets: %+v", tpl.Route.RuleSet)
+	}
+	if len(tpl.Route.Rules) != 1 || len(tpl.Skipped) != 1 {
+		t.Errorf("unresolved provider should be skipped with its rule, rules=%+v skipped=%v", tpl.Route.Rules, tpl.Skipped)
+	}
+}
+
+func TestSplitRuleLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve", []string{"IP-CIDR", "10.0.0.0/8", "DIRECT"}},
+		{"IP-CIDR,10.0.0.0/8,DIRECT,src,no-resolve", []string{"IP-CIDR", "10.0.0.0/8", "DIRECT"}},
+		{"RULE-SET,src,Proxy", []string{"RULE-SET", "src", "Proxy"}},
+		{"DOMAIN,no-resolve,Proxy", []string{"DOMAIN", "no-resolve", "Proxy"}},
+		{"MATCH,Proxy", []string{"MATCH", "Proxy"}},
+		{"# comment", nil},
+	}
+	for _, tt := range tests {
+		got := splitRuleLine(tt.line)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitRuleLine(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestConvertV3ToSingbox_ProxyProviders(t *testing.T) {
+	content := &TemplateV3Content{
+		ProxyGroups: []ProxyGroupV3Config{
+			{Name: "HK", Type: "url-test", Use: []string{"Airports", "Unknown"}},
+			{Name: "Proxy", Type: "select", Proxies: []string{"HK"}, Use: []string{"Airports"}},
+		},
+	}
+
+	tpl, err := ConvertV3ToSingbox(content, &SingboxTemplateOptions{
+		ProxyProviderURL: func(name string, _ ClashProxyProvider) string {
+			if name != "Airports" {
+				return ""
+			}
+			return ProxyProviderURLForClient("https://mmw.local/api/proxy-provider/1?token=abc", "sing-box")
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConvertV3ToSingbox failed: %v", err)
+	}
+
+	if len(tpl.OutboundProviders) != 1 || tpl.OutboundProviders[0].DownloadURL != "https://mmw.local/api/proxy-provider/1?t=sing-box&token=abc" {
+		t.Fatalf("unexpected outbound providers: %+v", tpl.OutboundProviders)
+	}
+	for _, group := range tpl.Outbounds {
+		if len(group.Providers) != 1 || group.Providers[0] != "Airports" {
+			t.Errorf("group %s providers = %v", group.Tag, group.Providers)
+		}
+	}
+
+	tpl.ExpandNodes(nil)
+	if len(tpl.Outbounds[0].Outbounds) != 0 {
+		t.Errorf("provider-only group should not fall back to direct: %v", tpl.Outbounds[0].Outbounds)
+	}
+}
+
+func TestConvertV3ToSingbox_RejectGroups(t *testing.T) {
+	content := &TemplateV3Content{
+		ProxyGroups: []ProxyGroupV3Config{
+			{Name: "Proxy", Type: "select", Proxies: []string{"DIRECT", "REJECT", "AdBlock"}, IncludeAll: true},
+			{Name: "AdBlock", Type: "select", Proxies: []string{"REJECT", "DIRECT"}},
+			{Name: "Tracker", Type: "select", Proxies: []string{"REJECT-DROP"}},
+			{Name: "Privacy", Type: "select", Proxies: []string{"Tracker", "Proxy"}},
+			{Name: "Final", Type: "select", Proxies: []string{"Proxy", "REJECT"}},
+		},
+		Rules: []string{
+			"DOMAIN-SUFFIX,ads.example.com,AdBlock",
+			"DOMAIN-SUFFIX,track.example.com,Privacy",
+			"DOMAIN,blocked.example.com,REJECT",
+			"DOMAIN-SUFFIX,example.com,Proxy",
+			"MATCH,Final",
+		},
+	}
+
+	tpl, err := ConvertV3ToSingbox(content, nil)
+	if err != nil {
+		t.Fatalf("ConvertV3ToSingbox failed: %v", err)
+	}
+	tpl.ExpandNodes([]string{"HK 01"})
+
+	tags := make(map[string]bool)
+	for _, group := range tpl.Outbounds {
+		tags[group.Tag] = true
+	}
+	for _, name := range []string{"AdBlock", "Tracker", "Privacy"} {
+		if tags[name] {
+			t.Errorf("reject group %s should not be emitted as an outbound", name)
+		}
+	}
+	// every member of every emitted group must be an emitted outbound or a node
+	for _, group := range tpl.Outbounds {
+		for _, member := range group.Outbounds {
+			if !tags[member] && member != "HK 01" {
+				t.Errorf("group %s references missing outbound %q", group.Tag, member)
+			}
+		}
+	}
+
+	wantActions := []string{"reject", "reject", "reject", "route"}
+	if len(tpl.Route.Rules) != len(wantActions) {
+		t.Fatalf("expected %d rules, got %+v", len(wantActions), tpl.Route.Rules)
+	}
+	for i, want := range wantActions {
+		if got := tpl.Route.Rules[i].Action; got != want {
+			t.Errorf("rule %d action = %q, want %q", i, got, want)
+		}
+	}
+	if tpl.Route.Rules[3].Outbound != "Proxy" {
+		t.Errorf("rule 3 outbound = %q, want Proxy", tpl.Route.Rules[3].Outbound)
+	}
+	if tpl.Route.Final != "Final" {
+		t.Errorf("final = %q, want Final", tpl.Route.Final)
+	}
+}
+
+func TestConvertV3ToSingbox_TemplateFile(t *testing.T) {
+	data, err := os.ReadFile("../../rule_templates/fake_ip__v3.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read template file: %v", err)
+	}
+
+	content, err := ParseTemplateV3Content(string(data))
+	if err != nil {
+		t.Fatalf("ParseTemplateV3Content failed: %v", err)
+	}
+
+	tpl, err := ConvertV3ToSingbox(content, nil)
+	if err != nil {
+		t.Fatalf("ConvertV3ToSingbox failed: %v", err)
+	}
+	tpl.ExpandNodes([]string{"🇭🇰 香港 01", "🇺🇸 美国 01"})
+
+	output, err := tpl.JSON()
+	if err != nil {
+		t.Fatalf("JSON failed: %v", err)
+	}
+	if strings.Contains(string(output), ProxyNodesMarker) {
+		t.Errorf("output still contains %s marker", ProxyNodesMarker)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(output, &decoded); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if len(tpl.Route.RuleSet) == 0 || tpl.Route.Final == "" {
+		t.Errorf("expected rule sets and final outbound, got %d rule sets, final=%q", len(tpl.Route.RuleSet), tpl.Route.Final)
+	}
+}
@@ -18,6 +18,7 @@ type ClashConfig struct {
 	ProxyGroups         []ClashProxyGroup         `yaml:"proxy-groups"`
 	Rules               []string                  `yaml:"rules"`
 	RuleProviders       map[string]ClashRuleProvider `yaml:"rule-providers"`
+	ProxyProviders      map[string]ClashProxyProvider `yaml:"proxy-providers"`
 }
 
 // ClashDNS represents Clash DNS configuration
@@ -44,6 +45,7 @@ type ClashProxyGroup struct {
 	Tolerance int      `yaml:"tolerance"`
 	Strategy  string   `yaml:"strategy"`
 	Lazy      bool     `yaml:"lazy"`
+	Use       []string `yaml:"use"`
 }
 
 // ClashRuleProvider represents a Clash rule provider
@@ -74,6 +76,13 @@ type SurgeTemplateConfig struct {
 	ExcludeSimpleHostnames bool
 	AllowWiFiAccess        bool
 	HTTPAPIWebDashboard    bool
+
+	// RuleSetURL optionally rewrites RULE-SET provider URLs (e.g. to the rule-provider conversion endpoint)
+	RuleSetURL RuleSetURLFunc
+
+	// ProxyProviderURL resolves proxy-providers used by groups to a Surge policy-path URL;
+	// groups referencing providers without a URL only keep their listed policies
+	ProxyProviderURL ProxyProviderURLFunc
 }
 
 // ConvertClashToSurgeConfig converts a Clash configuration to Surge format
@@ -281,6 +290,12 @@ func convertExternalController(controller string) string {
 
 // ConvertClashRulesToSurgeFormat converts Clash rules array to Surge rules
 func ConvertClashRulesToSurgeFormat(rules []string, ruleProviders map[string]ClashRuleProvider) ([]string, error) {
+	return ConvertClashRulesToSurgeFormatWithURL(rules, ruleProviders, nil)
+}
+
+// ConvertClashRulesToSurgeFormatWithURL converts Clash rules to Surge rules,
+// using ruleSetURL (when set) to pick the URL emitted for each RULE-SET
+func ConvertClashRulesToSurgeFormatWithURL(rules []string, ruleProviders map[string]ClashRuleProvider, ruleSetURL RuleSetURLFunc) ([]string, error) {
 	var surgeRules []string
 
 	for _, rule := range rules {
@@ -315,7 +330,13 @@ func ConvertClashRulesToSurgeFormat(rules []string, ruleProviders map[string]Cla
 					if len(parts) >= 4 && strings.TrimSpace(parts[3]) == "no-resolve" {
 						noResolve = ",no-resolve"
 					}
-					surgeRules = append(surgeRules, fmt.Sprintf("RULE-SET,%s,%s%s", provider.URL, policy, noResolve))
+					providerURL := provider.URL
+					if ruleSetURL != nil {
+						if rewritten := ruleSetURL(ruleSetName, provider); rewritten != "" {
+							providerURL = rewritten
+						}
+					}
+					surgeRules = append(surgeRules, fmt.Sprintf("RULE-SET,%s,%s%s", providerURL, policy, noResolve))
 				}
 			}
 
@@ -356,6 +377,24 @@ func ConvertClashProxyGroupsToSurge(groups []ClashProxyGroup) []ACLProxyGroup {
 	return aclGroups
 }
 
+// attachSurgePolicyPaths points groups that use proxy-providers at the provider's Surge policy-path.
+// Surge supports a single policy-path per group, so only the first resolvable provider is used.
+func attachSurgePolicyPaths(aclGroups []ACLProxyGroup, clashConfig *ClashConfig, providerURL ProxyProviderURLFunc) {
+	for i, g := range clashConfig.ProxyGroups {
+		for _, name := range g.Use {
+			provider, ok := clashConfig.ProxyProviders[name]
+			if !ok {
+				continue
+			}
+			if policyPath := providerURL(name, provider); policyPath != "" {
+				aclGroups[i].PolicyPath = policyPath
+				aclGroups[i].UpdateInterval = provider.Interval
+				break
+			}
+		}
+	}
+}
+
 // convertProxyGroupType converts Clash proxy group type to Surge type
 func convertProxyGroupType(clashType string) string {
 	switch strings.ToLower(clashType) {
@@ -402,6 +441,13 @@ func applyDefaultSurgeConfig(opts *SurgeTemplateConfig) {
 	opts.HTTPAPIWebDashboard = true
 }
 
+// DefaultSurgeTemplateConfig returns the template config used when none is given
+func DefaultSurgeTemplateConfig() *SurgeTemplateConfig {
+	opts := &SurgeTemplateConfig{}
+	applyDefaultSurgeConfig(opts)
+	return opts
+}
+
 // contains checks if a string slice contains a value
 func contains(slice []string, val string) bool {
 	for _, item := range slice {
@@ -458,11 +504,18 @@ func BuildCompleteSurgeConfig(
 
 	// 3. Build Proxy Group section
 	aclGroups := ConvertClashProxyGroupsToSurge(clashConfig.ProxyGroups)
+	if templateOpts != nil && templateOpts.ProxyProviderURL != nil {
+		attachSurgePolicyPaths(aclGroups, clashConfig, templateOpts.ProxyProviderURL)
+	}
 	proxyGroupSection := GenerateSurgeProxyGroups(aclGroups, false)
 	sections = append(sections, proxyGroupSection)
 
 	// 4. Build Rule section
-	surgeRules, err := ConvertClashRulesToSurgeFormat(clashConfig.Rules, clashConfig.RuleProviders)
+	var ruleSetURL RuleSetURLFunc
+	if templateOpts != nil {
+		ruleSetURL = templateOpts.RuleSetURL
+	}
+	surgeRules, err := ConvertClashRulesToSurgeFormatWithURL(clashConfig.Rules, clashConfig.RuleProviders, ruleSetURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to convert rules: %w", err)
 	}
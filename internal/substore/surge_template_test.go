@@ -316,6 +316,64 @@ func TestBuildCompleteSurgeConfig(t *testing.T) {
 	}
 }
 
+func TestBuildCompleteSurgeConfig_ProxyProviderPolicyPath(t *testing.T) {
+	clashConfig := &ClashConfig{
+		ProxyGroups: []ClashProxyGroup{
+			{Name: "HK", Type: "url-test", Use: []string{"Airports"}},
+			{Name: "Other", Type: "select", Proxies: []string{"DIRECT"}, Use: []string{"Client"}},
+		},
+		ProxyProviders: map[string]ClashProxyProvider{
+			"Airports": {Type: "http", URL: "https://mmw.local/api/proxy-provider/1?token=abc", Interval: 3600},
+			"Client":   {Type: "http", URL: "https://example.com/sub.yaml"},
+		},
+		Rules: []string{"MATCH,HK"},
+	}
+
+	templateOpts := DefaultSurgeTemplateConfig()
+	templateOpts.ProxyProviderURL = func(name string, provider ClashProxyProvider) string {
+		if !strings.Contains(provider.URL, "/api/proxy-provider/") {
+			return ""
+		}
+		return ProxyProviderURLForClient(provider.URL, "surge")
+	}
+
+	result, err := BuildCompleteSurgeConfig(clashConfig, nil, templateOpts, false)
+	if err != nil {
+		t.Fatalf("BuildCompleteSurgeConfig failed: %v", err)
+	}
+
+	want := "HK = url-test, policy-path=https://mmw.local/api/proxy-provider/1?t=surge&token=abc, update-interval=3600, url=http://www.gstatic.com/generate_204, interval=300, timeout=5, tolerance=150"
+	if !strings.Contains(result, want) {
+		t.Errorf("Expected policy-path group %q in result:\n%s", want, result)
+	}
+	if !strings.Contains(result, "Other = select, DIRECT") || strings.Contains(result, "example.com") {
+		t.Errorf("Provider without Surge URL should keep listed policies only:\n%s", result)
+	}
+}
+
+func TestDetectClientType(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  string
+	}{
+		{"Surge iOS/3016", "surge"},
+		{"Surge Mac/2623", "surgemac"},
+		{"Loon/752 CFNetwork/1494", "loon"},
+		{"Quantumult%20X/1.4.1", "qx"},
+		{"SFA/1.10.1 (sing-box 1.10.1)", "sing-box"},
+		{"clash-verge/v2.0.3", ""},
+		{"mihomo/1.18.10", ""},
+		{"Stash/2.6.1 Clash/1.9.0", ""},
+		{"Mozilla/5.0", ""},
+	}
+
+	for _, tt := range tests {
+		if result := DetectClientType(tt.userAgent); result != tt.expected {
+			t.Errorf("DetectClientType(%q) = %q, expected %q", tt.userAgent, result, tt.expected)
+		}
+	}
+}
+
 func TestApplyDefaultSurgeConfig(t *testing.T) {
 	opts := &SurgeTemplateConfig{}
 	applyDefaultSurgeConfig(opts)
@@ -25,6 +25,7 @@ type ProxyGroupV3Config struct {
 	URL               string   `yaml:"url,omitempty" json:"url,omitempty"`
 	Interval          int      `yaml:"interval,omitempty" json:"interval,omitempty"`
 	Tolerance         int      `yaml:"tolerance,omitempty" json:"tolerance,omitempty"`
+	Use               []string `yaml:"use,omitempty" json:"use,omitempty"`
 }
 
 // RuleProviderConfig represents a rule provider configuration
@@ -34,6 +35,7 @@ type RuleProviderConfig struct {
 	URL      string `yaml:"url" json:"url"`
 	Path     string `yaml:"path" json:"path"`
 	Interval int    `yaml:"interval" json:"interval"`
+	Format   string `yaml:"format,omitempty" json:"format,omitempty"`
 }
 
 // ConvertACLToV3 converts ACL4SSR format to v3 template format
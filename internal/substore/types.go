@@ -14,6 +14,8 @@ type ProduceOptions struct {
 	Nameserver              []string
 	// FullConfig contains the complete original config for producers that need to output full config (e.g., Stash)
 	FullConfig map[string]interface{}
+	// RuleSetURL optionally rewrites rule-provider URLs for producers that emit RULE-SET lines
+	RuleSetURL RuleSetURLFunc
 }
 
 // Producer is the interface for all proxy format producers